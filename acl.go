@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a video's ACL grants to a key
+const (
+	aclScopeRead   = "read"   // download the video
+	aclScopeDelete = "delete" // delete the video
+	aclScopeShare  = "share"  // change the video's ACL
+)
+
+// adminScope lets a principal bypass every video ACL
+const adminScope = "admin"
+
+// validACLScopes are the scopes an ACL may grant
+var validACLScopes = []string{aclScopeRead, aclScopeDelete, aclScopeShare}
+
+// normalizeACLScopes sorts and deduplicates scopes, rejecting unknown ones
+func normalizeACLScopes(scopes []string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(validACLScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, must be one of %v", scope, validACLScopes)
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// videoAccessAllowed reports whether principal may use scope on video. A
+// video without an ACL is open to every authenticated caller, as is every
+// video when authentication is disabled and there is no one to check.
+// Principals with the admin scope are never restricted.
+func videoAccessAllowed(principal *Principal, video *Video, scope string) bool {
+	if len(video.ACL) == 0 || principal == nil {
+		return true
+	}
+	if slices.Contains(principal.Scopes, adminScope) {
+		return true
+	}
+	return slices.Contains(video.ACL[principal.ID], scope)
+}
+
+// requireVideoAccess responds 403 unless the caller may use scope on video
+func requireVideoAccess(c *gin.Context, video *Video, scope string) bool {
+	if videoAccessAllowed(principalFrom(c), video, scope) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "access denied", "scope": scope})
+	return false
+}
+
+// setVideoACLHandler replaces a video's ACL. An empty ACL opens the video to
+// every authenticated caller again.
+func (s *Server) setVideoACLHandler(c *gin.Context) {
+	var req struct {
+		ACL map[string][]string `json:"acl"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	acl := make(map[string][]string, len(req.ACL))
+	for keyID, scopes := range req.ACL {
+		normalized, err := normalizeACLScopes(scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "key_id": keyID})
+			return
+		}
+		if len(normalized) > 0 {
+			acl[keyID] = normalized
+		}
+	}
+
+	s.updateVideoACL(c, func(map[string][]string) map[string][]string { return acl })
+}
+
+// patchVideoACLHandler grants and revokes scopes for a single key. A key
+// left without scopes is removed from the ACL.
+func (s *Server) patchVideoACLHandler(c *gin.Context) {
+	keyID := c.Param("key_id")
+
+	var req struct {
+		Grant  []string `json:"grant"`
+		Revoke []string `json:"revoke"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	grant, err := normalizeACLScopes(req.Grant)
+	if err == nil {
+		_, err = normalizeACLScopes(req.Revoke)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.updateVideoACL(c, func(existing map[string][]string) map[string][]string {
+		var scopes []string
+		for _, scope := range append(slices.Clone(existing[keyID]), grant...) {
+			if !slices.Contains(req.Revoke, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		scopes, _ = normalizeACLScopes(scopes)
+
+		acl := make(map[string][]string, len(existing)+1)
+		for id, scopes := range existing {
+			acl[id] = scopes
+		}
+		if len(scopes) > 0 {
+			acl[keyID] = scopes
+		} else {
+			delete(acl, keyID)
+		}
+		return acl
+	})
+}
+
+// errVideoAccessDenied fails an update the caller may not make
+var errVideoAccessDenied = errors.New("access denied")
+
+// updateVideoACL stores the ACL that change builds from the video's current
+// ACL. The video is read and written in one UpdateVideoFunc, so concurrent
+// changes are never lost.
+// Only callers allowed to share the video may change its ACL.
+func (s *Server) updateVideoACL(c *gin.Context, change func(map[string][]string) map[string][]string) {
+	videoID := c.Param("id")
+
+	var previous map[string][]string
+	updated, err := s.db.UpdateVideoFunc(videoID, func(video *Video) error {
+		if !videoAccessAllowed(principalFrom(c), video, aclScopeShare) {
+			return errVideoAccessDenied
+		}
+		previous = video.ACL
+		video.ACL = change(video.ACL)
+		if len(video.ACL) == 0 {
+			video.ACL = nil
+		}
+		video.UpdatedAt = time.Now()
+		return nil
+	})
+	switch {
+	case errors.Is(err, ErrVideoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	case errors.Is(err, errVideoAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied", "scope": aclScopeShare})
+		return
+	case err != nil:
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to update video ACL")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update video"})
+		return
+	}
+
+	s.recordVideoEvent(c, videoID, VideoEventUpdated, gin.H{"acl": previous}, gin.H{"acl": updated.ACL})
+
+	s.respondSuccess(c, http.StatusOK, gin.H{"id": videoID, "acl": updated.ACL})
+}
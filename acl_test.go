@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoACL(t *testing.T) {
+	server := newTestServer(t)
+	restricted := uploadTestVideo(t, server, "restricted.mp4", []byte("restricted video"))
+	open := uploadTestVideo(t, server, "open.mp4", []byte("open video"))
+
+	server.authenticator = CompositeAuthenticator{
+		NewAPIKeyAuthenticator([]string{"viewer-key", "other-key"}),
+		NewJWTAuthenticator("jwt-secret"),
+	}
+	admin := bearer(signHS256(t, "jwt-secret", map[string]interface{}{
+		"sub":   "ops",
+		"scope": "admin",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}))
+	viewer := map[string]string{apiKeyHeader: "viewer-key"}
+	other := map[string]string{apiKeyHeader: "other-key"}
+
+	request := func(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	download := func(video *Video, headers map[string]string) int {
+		return request(http.MethodGet, "/api/videos/"+video.ID, headers, "").Code
+	}
+
+	viewerID := apiKeyFingerprint("viewer-key")
+	w := request(http.MethodPut, "/api/videos/"+restricted.ID+"/acl", admin, `{"acl": {"`+viewerID+`": ["read", "read"]}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		ACL map[string][]string `json:"acl"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string][]string{viewerID: {"read"}}, resp.ACL)
+
+	t.Run("Global admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, download(restricted, admin))
+	})
+
+	t.Run("Key with read grant", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, download(restricted, viewer))
+		assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/videos/"+restricted.ID+"/download", viewer, "").Code)
+	})
+
+	t.Run("Key without grant", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, download(restricted, other))
+		assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/videos/"+restricted.ID+"/download", other, "").Code)
+		for _, derived := range []string{"preview", "sprite", "sprite.vtt"} {
+			assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/videos/"+restricted.ID+"/"+derived, other, "").Code, derived)
+		}
+		assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/api/videos/"+restricted.ID, viewer, "").Code, "read does not grant delete")
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "/api/videos/"+restricted.ID+"/acl", other, `{"acl": {}}`).Code, "changing the ACL needs share")
+	})
+
+	t.Run("Empty ACL", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, download(open, other))
+	})
+
+	t.Run("Grant and revoke", func(t *testing.T) {
+		otherID := apiKeyFingerprint("other-key")
+		w := request(http.MethodPatch, "/api/videos/"+restricted.ID+"/acl/"+otherID, admin, `{"grant": ["read", "share"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, http.StatusOK, download(restricted, other))
+
+		// other may now share the video, and revokes the viewer's access
+		w = request(http.MethodPatch, "/api/videos/"+restricted.ID+"/acl/"+viewerID, other, `{"revoke": ["read"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		resp.ACL = nil
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, map[string][]string{otherID: {"read", "share"}}, resp.ACL)
+		assert.Equal(t, http.StatusForbidden, download(restricted, viewer))
+
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, "/api/videos/"+restricted.ID+"/acl/"+viewerID, admin, `{"grant": ["write"]}`).Code)
+	})
+
+	t.Run("Concurrent writes", func(t *testing.T) {
+		// Slow reads leave time for another request to update the video
+		// between reading it and storing a change
+		store := server.db
+		server.db = slowReadStore{store}
+		defer func() { server.db = store }()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(keyID string) {
+				defer wg.Done()
+				assert.Equal(t, http.StatusOK, request(http.MethodPatch, "/api/videos/"+open.ID+"/acl/"+keyID, admin, `{"grant": ["read"]}`).Code)
+			}(fmt.Sprintf("key-%d", i))
+			// Other writers must not put back the ACL they read
+			wg.Add(1)
+			go func(tag string) {
+				defer wg.Done()
+				assert.Equal(t, http.StatusOK, request(http.MethodPatch, "/api/videos/"+open.ID+"/tags", admin, `{"add": ["`+tag+`"]}`).Code)
+			}(fmt.Sprintf("tag-%d", i))
+		}
+		wg.Wait()
+
+		stored, _ := server.db.GetVideoByID(open.ID)
+		assert.Len(t, stored.ACL, 20, "no grant may be lost")
+		assert.Len(t, stored.Tags, 20, "no tag may be lost")
+	})
+
+	t.Run("Clearing the ACL opens the video", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, request(http.MethodPut, "/api/videos/"+restricted.ID+"/acl", admin, `{"acl": {}}`).Code)
+		assert.Equal(t, http.StatusOK, download(restricted, viewer))
+
+		stored, _ := server.db.GetVideoByID(restricted.ID)
+		assert.Nil(t, stored.ACL)
+	})
+}
+
+// slowReadStore delays returning from every GetVideoByID, so the video
+// returned is stale by the time the caller uses it
+type slowReadStore struct {
+	VideoStore
+}
+
+func (s slowReadStore) GetVideoByID(id string) (*Video, bool) {
+	video, exists := s.VideoStore.GetVideoByID(id)
+	time.Sleep(5 * time.Millisecond)
+	return video, exists
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redeliverWebhookHandler re-fires a webhook event for an existing video,
+// e.g. for subscribers that registered after the event happened
+func (s *Server) redeliverWebhookHandler(c *gin.Context) {
+	var req struct {
+		Event string `json:"event" binding:"required"`
+		URL   string `json:"url" binding:"omitempty,url"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	videoID := c.Param("id")
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	payload, err := videoWebhookPayload(EventType(req.Event), video)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	urls, err := s.webhookMgr.RedeliverWebhook(req.Event, req.URL, payload)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("video_id", videoID).
+		Str("event", req.Event).
+		Int("urls", len(urls)).
+		Bool("is_redelivery", true).
+		Msg("webhook redelivery triggered")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success":  true,
+		"video_id": videoID,
+		"event":    req.Event,
+		"urls":     urls,
+	})
+}
+
+// preloadHandler asks a CDN to warm its cache for the given videos. Each item
+// of the result holds the video's preload job.
+func (s *Server) preloadHandler(c *gin.Context) {
+	var req struct {
+		VideoIDs []string `json:"video_ids" binding:"required,min=1"`
+		CDNURL   string   `json:"cdn_url" binding:"required,url"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.validateWebhookURL(req.CDNURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := newBatchResult(len(req.VideoIDs))
+	for _, videoID := range req.VideoIDs {
+		if _, exists := s.db.GetVideoByID(videoID); !exists {
+			result.fail(videoID, BatchErrorNotFound, "video not found")
+			continue
+		}
+		result.succeed(videoID, s.preloadMgr.Enqueue(req.CDNURL, videoID))
+	}
+
+	getLogger(c).Info().
+		Str("cdn_url", req.CDNURL).
+		Int("jobs", result.Succeeded).
+		Msg("CDN preload queued")
+
+	s.recordBatchResult(c, BatchTypePreload, result)
+	s.respondSuccess(c, http.StatusAccepted, result)
+}
+
+// getPreloadJobHandler returns the status of a CDN preload job
+func (s *Server) getPreloadJobHandler(c *gin.Context) {
+	job, exists := s.preloadMgr.GetJob(c.Param("job_id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "preload job not found"})
+		return
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success": true,
+		"job":     job,
+	})
+}
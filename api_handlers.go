@@ -14,17 +14,19 @@ import (
 func (s *Server) getLatestVideoHandler(c *gin.Context) {
 	video, exists := s.db.GetLatestVideo()
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "no videos found"})
+		respondNegotiated(c, http.StatusNotFound, gin.H{"error": "no videos found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	s.respondSuccess(c, http.StatusOK, gin.H{
 		"success": true,
 		"video":   video,
 	})
 }
 
-// getAllVideosHandler returns all videos with optional pagination
+// getAllVideosHandler returns all videos with optional pagination. ?fields
+// limits each video to the listed JSON fields. With resource hints enabled
+// the latest video's sprites are preloaded, see setResourceHints.
 func (s *Server) getAllVideosHandler(c *gin.Context) {
 	pageStr := c.DefaultQuery("page", "1")
 	limitStr := c.DefaultQuery("limit", "20")
@@ -39,7 +41,13 @@ func (s *Server) getAllVideosHandler(c *gin.Context) {
 		limit = 20
 	}
 
-	allVideos := s.db.GetAllVideos()
+	fields, err := parseVideoFields(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allVideos := s.readDB().GetAllVideos()
 	
 	// Calculate pagination
 	start := (page - 1) * limit
@@ -54,15 +62,27 @@ func (s *Server) getAllVideosHandler(c *gin.Context) {
 
 	paginatedVideos := allVideos[start:end]
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"videos":  paginatedVideos,
-		"total":   len(allVideos),
-		"page":    page,
-		"limit":   limit,
+	s.setResourceHints(c)
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success":          true,
+		"videos":           projectVideos(paginatedVideos, fields),
+		"total":            len(allVideos),
+		"total_size_bytes": sumVideoSizes(allVideos),
+		"page_size_bytes":  sumVideoSizes(paginatedVideos),
+		"page":             page,
+		"limit":            limit,
 	})
 }
 
+// sumVideoSizes returns the combined size of videos in bytes
+func sumVideoSizes(videos []*Video) int64 {
+	var total int64
+	for _, video := range videos {
+		total += video.Size
+	}
+	return total
+}
+
 // deleteVideoHandler deletes a video by ID
 func (s *Server) deleteVideoHandler(c *gin.Context) {
 	videoID := c.Param("id")
@@ -72,6 +92,9 @@ func (s *Server) deleteVideoHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
 		return
 	}
+	if !requireVideoAccess(c, video, aclScopeDelete) {
+		return
+	}
 
 	// Remove from database
 	deleted := s.db.DeleteVideo(videoID)
@@ -80,33 +103,169 @@ func (s *Server) deleteVideoHandler(c *gin.Context) {
 		return
 	}
 
-	// Remove file from disk
-	filePath := s.getFilePath(videoID, video.Name)
-	if err := os.Remove(filePath); err != nil {
-		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to delete video file from disk")
-		// Don't return error here since the video is already removed from DB
-	}
+	s.removeVideoFiles(video)
 
-	s.logger.Info().
+	getLogger(c).Info().
 		Str("video_id", videoID).
 		Str("filename", video.Name).
 		Msg("video deleted successfully")
 
+	s.recordVideoEvent(c, videoID, VideoEventDeleted, video, nil)
+
 	// Trigger webhook for video deletion event
-	go s.webhookMgr.NotifyWebhooks("video.deleted", gin.H{
-		"video_id":  videoID,
-		"filename":  video.Name,
-		"event":     "video.deleted",
-		"timestamp": time.Now().Unix(),
-	})
+	payload, _ := videoWebhookPayload(EventVideoDeleted, video)
+	s.webhookMgr.NotifyWebhooksContext(c.Request.Context(), EventVideoDeleted, payload)
+	s.publishEvent(EventVideoDeleted, payload)
 
-	c.JSON(http.StatusOK, gin.H{
+	s.respondSuccess(c, http.StatusOK, gin.H{
 		"success": true,
 		"message": "video deleted successfully",
 	})
 }
 
+// removeVideo deletes a video record and its files and notifies subscribers
+func (s *Server) removeVideo(video *Video) {
+	if !s.db.DeleteVideo(video.ID) {
+		return
+	}
+
+	s.removeVideoFiles(video)
+
+	s.logger.Info().
+		Str("video_id", video.ID).
+		Str("filename", video.Name).
+		Msg("video replaced")
+
+	s.recordVideoEvent(nil, video.ID, VideoEventDeleted, video, gin.H{"reason": "replaced"})
+
+	payload, _ := videoWebhookPayload(EventVideoDeleted, video)
+	s.webhookMgr.NotifyWebhooks(EventVideoDeleted, payload)
+}
+
+// removeVideoFiles deletes a video's file and everything derived from it
+func (s *Server) removeVideoFiles(video *Video) {
+	// Drop any cached hashes so a reused ID can't serve stale results
+	for _, algorithm := range []string{"sha256", "md5", "sha1"} {
+		s.hashCache.Delete(hashCacheKey{videoID: video.ID, algorithm: algorithm})
+	}
+
+	if s.segmentCache != nil {
+		s.segmentCache.RemoveVideo(video.ID)
+	}
+	s.removePreviews(video.ID)
+	s.removeSprites(video.ID)
+	s.removeHLSSegments(video.ID)
+
+	if err := s.comments.DeleteVideo(video.ID); err != nil {
+		s.logger.Error().Err(err).Str("video_id", video.ID).Msg("failed to delete video comments")
+	}
+
+	if store, _ := s.storageBackend(video.StorageBackend); store != nil {
+		if err := store.Remove(fileKey(video.ID, video.Name)); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Str("video_id", video.ID).Str("backend", video.StorageBackend).Msg("failed to delete video file from storage backend")
+		}
+		return
+	}
+
+	// Remove file from disk
+	filePath := s.getFilePath(video.ID, video.Name)
+	if err := os.Remove(filePath); err != nil {
+		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to delete video file from disk")
+		// Don't return error here since the video is already removed from DB
+	}
+
+	if s.mirrorFiles != nil {
+		if err := s.mirrorFiles.Remove(fileKey(video.ID, video.Name)); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Str("video_id", video.ID).Msg("failed to delete video file from mirror storage")
+		}
+	}
+
+	if s.fallbackFiles != nil {
+		if err := s.fallbackFiles.Remove(fileKey(video.ID, video.Name)); err != nil {
+			s.logger.Error().Err(err).Str("video_id", video.ID).Msg("failed to delete video file from fallback storage")
+		}
+	}
+}
+
+// getVideoHashHandler recomputes a video's hash from disk so clients can
+// verify their download and operators can detect in-place corruption
+func (s *Server) getVideoHashHandler(c *gin.Context) {
+	videoID := c.Param("id")
+	algorithm := c.DefaultQuery("algorithm", defaultHashAlgorithm)
+
+	if _, err := newHasher(algorithm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	filePath := s.getFilePath(videoID, video.Name)
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("video file not found on disk")
+		c.JSON(http.StatusNotFound, gin.H{"error": "video file not found"})
+		return
+	}
+
+	key := hashCacheKey{videoID: videoID, algorithm: algorithm}
+	entry, cached := s.cachedHash(key)
+	if !cached {
+		fileHash, err := computeFileHash(filePath, algorithm)
+		if err != nil {
+			getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to hash video file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash file"})
+			return
+		}
+
+		entry = hashCacheEntry{hash: fileHash, computedAt: time.Now()}
+		if s.config.HashCacheTTL > 0 {
+			s.hashCache.Store(key, entry)
+		}
+	}
+
+	response := gin.H{
+		"video_id":    videoID,
+		"algorithm":   algorithm,
+		"hash":        entry.hash,
+		"file_size":   stat.Size(),
+		"computed_at": entry.computedAt,
+	}
+
+	// Only the default algorithm is stored, so only it can be compared
+	if algorithm == defaultHashAlgorithm && video.Hash != "" && video.Hash != entry.hash {
+		getLogger(c).Error().
+			Str("video_id", videoID).
+			Str("expected_hash", video.Hash).
+			Str("actual_hash", entry.hash).
+			Msg("video file hash mismatch, file may be corrupted")
+		response["corrupted"] = true
+	}
+
+	s.respondSuccess(c, http.StatusOK, response)
+}
+
+// cachedHash returns a previously computed hash if it is still within the TTL
+func (s *Server) cachedHash(key hashCacheKey) (hashCacheEntry, bool) {
+	value, ok := s.hashCache.Load(key)
+	if !ok {
+		return hashCacheEntry{}, false
+	}
+
+	entry := value.(hashCacheEntry)
+	if time.Since(entry.computedAt) > s.config.HashCacheTTL {
+		s.hashCache.Delete(key)
+		return hashCacheEntry{}, false
+	}
+
+	return entry, true
+}
+
 // getFilePath constructs the file path for a video
 func (s *Server) getFilePath(videoID, filename string) string {
-	return filepath.Join(s.config.StoragePath, videoID+"_"+filename)
+	return filepath.Join(s.config.StoragePath, fileKey(videoID, filename))
 }
\ No newline at end of file
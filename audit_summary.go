@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditTopVideos is the number of most downloaded videos listed per period
+const auditTopVideos = 10
+
+// Periods an audit summary groups events by
+const (
+	AuditPeriodDay   = "day"
+	AuditPeriodWeek  = "week"
+	AuditPeriodMonth = "month"
+)
+
+// AuditTopVideo is one of the most downloaded videos of a period
+type AuditTopVideo struct {
+	VideoID       string         `json:"video_id"`
+	DownloadCount int            `json:"download_count"`
+	EventCounts   map[string]int `json:"event_counts"` // every recorded event of the video by type
+}
+
+// AuditSummary aggregates the video events of one period
+type AuditSummary struct {
+	Period           string          `json:"period"` // 2024-01-31, 2024-W05 or 2024-01
+	UploadCount      int             `json:"upload_count"`
+	DeleteCount      int             `json:"delete_count"`
+	DownloadCount    int             `json:"download_count"`
+	EventCounts      map[string]int  `json:"event_counts"`
+	UniqueIPs        int             `json:"unique_ips"`
+	UniqueIPsByEvent map[string]int  `json:"unique_ips_by_event"`
+	TopVideos        []AuditTopVideo `json:"top_videos"`
+
+	ips        map[string]bool
+	ipsByEvent map[string]map[string]bool
+	videos     map[string]map[string]int // video ID -> event type -> count
+}
+
+// auditPeriodKey returns the period t falls in, in UTC
+func auditPeriodKey(period string, t time.Time) string {
+	t = t.UTC()
+	switch period {
+	case AuditPeriodDay:
+		return t.Format("2006-01-02")
+	case AuditPeriodWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// auditPeriodStart returns the start of the period t falls in, in UTC.
+// Weeks start on Monday.
+func auditPeriodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case AuditPeriodDay:
+		return day
+	case AuditPeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// parseAuditTime accepts an RFC 3339 time or a YYYY-MM-DD date, taken as
+// midnight UTC
+func parseAuditTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// add counts an event into the summary
+func (summary *AuditSummary) add(event VideoEvent) {
+	summary.EventCounts[event.EventType]++
+	switch event.EventType {
+	case VideoEventUploaded:
+		summary.UploadCount++
+	case VideoEventDeleted:
+		summary.DeleteCount++
+	case VideoEventDownloaded:
+		summary.DownloadCount++
+	}
+
+	if event.ClientIP != "" {
+		summary.ips[event.ClientIP] = true
+		if summary.ipsByEvent[event.EventType] == nil {
+			summary.ipsByEvent[event.EventType] = make(map[string]bool)
+		}
+		summary.ipsByEvent[event.EventType][event.ClientIP] = true
+	}
+
+	if summary.videos[event.VideoID] == nil {
+		summary.videos[event.VideoID] = make(map[string]int)
+	}
+	summary.videos[event.VideoID][event.EventType]++
+}
+
+// finish works out the unique IP counts and top videos
+func (summary *AuditSummary) finish() {
+	summary.UniqueIPs = len(summary.ips)
+	for eventType, ips := range summary.ipsByEvent {
+		summary.UniqueIPsByEvent[eventType] = len(ips)
+	}
+
+	summary.TopVideos = []AuditTopVideo{}
+	for videoID, counts := range summary.videos {
+		if counts[VideoEventDownloaded] > 0 {
+			summary.TopVideos = append(summary.TopVideos, AuditTopVideo{VideoID: videoID, DownloadCount: counts[VideoEventDownloaded], EventCounts: counts})
+		}
+	}
+	sort.Slice(summary.TopVideos, func(i, j int) bool {
+		a, b := summary.TopVideos[i], summary.TopVideos[j]
+		if a.DownloadCount != b.DownloadCount {
+			return a.DownloadCount > b.DownloadCount
+		}
+		return a.VideoID < b.VideoID
+	})
+	if len(summary.TopVideos) > auditTopVideos {
+		summary.TopVideos = summary.TopVideos[:auditTopVideos]
+	}
+}
+
+// summarizeVideoEvents aggregates the events in the video event file from
+// from up to to by period, oldest period first. The file is streamed, so
+// memory grows with the number of periods, videos and IPs rather than
+// events. Lines that can't be decoded are skipped.
+func (s *Server) summarizeVideoEvents(period string, from, to time.Time) ([]*AuditSummary, error) {
+	summaries := make(map[string]*AuditSummary)
+
+	file, err := os.Open(filepath.Join(s.config.StoragePath, videoEventsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*AuditSummary{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		// The old and new values can be large and aren't summarized, so
+		// they are not decoded
+		var event struct {
+			VideoID   string    `json:"video_id"`
+			EventType string    `json:"event_type"`
+			ClientIP  string    `json:"client_ip"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if event.Timestamp.Before(from) || !event.Timestamp.Before(to) {
+			continue
+		}
+
+		key := auditPeriodKey(period, event.Timestamp)
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &AuditSummary{
+				Period:           key,
+				EventCounts:      make(map[string]int),
+				UniqueIPsByEvent: make(map[string]int),
+				ips:              make(map[string]bool),
+				ipsByEvent:       make(map[string]map[string]bool),
+				videos:           make(map[string]map[string]int),
+			}
+			summaries[key] = summary
+		}
+		summary.add(VideoEvent{VideoID: event.VideoID, EventType: event.EventType, ClientIP: event.ClientIP, Timestamp: event.Timestamp})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*AuditSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		summary.finish()
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Period < result[j].Period
+	})
+	return result, nil
+}
+
+// auditSummaryHandler summarizes the video event history for compliance
+// reports. Events from from (inclusive) to to (exclusive) are grouped by
+// period; to defaults to now and from to the start of the last period
+// before to.
+func (s *Server) auditSummaryHandler(c *gin.Context) {
+	period := c.DefaultQuery("period", AuditPeriodMonth)
+	if period != AuditPeriodDay && period != AuditPeriodWeek && period != AuditPeriodMonth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		parsed, err := parseAuditTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 time or a YYYY-MM-DD date"})
+			return
+		}
+		to = parsed
+	}
+	// to is exclusive, so a to at the start of a period covers the one before
+	from := auditPeriodStart(period, to.Add(-time.Nanosecond))
+	if value := c.Query("from"); value != "" {
+		parsed, err := parseAuditTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 time or a YYYY-MM-DD date"})
+			return
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	summaries, err := s.summarizeVideoEvents(period, from, to)
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to summarize video events")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to summarize video events"})
+		return
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"from":      from,
+		"to":        to,
+		"summaries": summaries,
+	})
+}
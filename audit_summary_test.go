@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeAuditEvents appends events to the server's video event file
+func writeAuditEvents(t *testing.T, server *Server, events []VideoEvent) {
+	t.Helper()
+
+	file, err := os.OpenFile(filepath.Join(server.config.StoragePath, videoEventsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, event := range events {
+		require.NoError(t, encoder.Encode(event))
+	}
+}
+
+func getAuditSummary(t *testing.T, server *Server, query string) (int, []AuditSummary) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/audit/summary?"+query, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var resp struct {
+		Summaries []AuditSummary `json:"summaries"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Summaries
+}
+
+func TestAuditSummary(t *testing.T) {
+	server := newTestServer(t)
+
+	jan := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 3, 8, 0, 0, 0, time.UTC)
+	event := func(videoID, eventType, ip string, at time.Time) VideoEvent {
+		return VideoEvent{VideoID: videoID, EventType: eventType, ClientIP: ip, Timestamp: at}
+	}
+
+	events := []VideoEvent{
+		// Before the window
+		event("old", VideoEventUploaded, "10.0.0.9", time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)),
+
+		event("a", VideoEventUploaded, "10.0.0.1", jan),
+		event("b", VideoEventUploaded, "10.0.0.1", jan),
+		event("a", VideoEventTagsChanged, "10.0.0.1", jan.Add(time.Hour)),
+		event("b", VideoEventDeleted, "10.0.0.2", jan.Add(2*time.Hour)),
+		event("c", VideoEventPurged, "", jan.Add(3*time.Hour)),
+		event("c", VideoEventUploaded, "10.0.0.3", feb),
+	}
+	for i := 0; i < 12; i++ {
+		video := "v" + strconv.Itoa(i)
+		for j := 0; j <= i; j++ {
+			events = append(events, event(video, VideoEventDownloaded, "192.168.0."+strconv.Itoa(j), jan.Add(time.Duration(j)*time.Minute)))
+		}
+	}
+	events = append(events, event("a", VideoEventDownloaded, "10.0.0.4", feb))
+
+	// At the end of the window, which is exclusive
+	events = append(events, event("late", VideoEventUploaded, "10.0.0.9", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+	writeAuditEvents(t, server, events)
+
+	// A line cut short by a crash
+	file, err := os.OpenFile(filepath.Join(server.config.StoragePath, videoEventsFile), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"video_id": "trunc`)
+	require.NoError(t, err)
+	file.Close()
+
+	code, summaries := getAuditSummary(t, server, "period=month&from=2024-01-01&to=2024-03-01")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, summaries, 2)
+
+	january := summaries[0]
+	assert.Equal(t, "2024-01", january.Period)
+	assert.Equal(t, 2, january.UploadCount)
+	assert.Equal(t, 1, january.DeleteCount)
+	assert.Equal(t, 78, january.DownloadCount, "1+2+...+12")
+	assert.Equal(t, map[string]int{
+		VideoEventUploaded:    2,
+		VideoEventTagsChanged: 1,
+		VideoEventDeleted:     1,
+		VideoEventPurged:      1,
+		VideoEventDownloaded:  78,
+	}, january.EventCounts)
+	assert.Equal(t, 14, january.UniqueIPs)
+	assert.Equal(t, 1, january.UniqueIPsByEvent[VideoEventUploaded])
+	assert.Equal(t, 12, january.UniqueIPsByEvent[VideoEventDownloaded])
+
+	require.Len(t, january.TopVideos, 10)
+	assert.Equal(t, "v11", january.TopVideos[0].VideoID)
+	assert.Equal(t, 12, january.TopVideos[0].DownloadCount)
+	assert.Equal(t, "v2", january.TopVideos[9].VideoID)
+
+	february := summaries[1]
+	assert.Equal(t, "2024-02", february.Period)
+	assert.Equal(t, 1, february.UploadCount)
+	assert.Equal(t, 1, february.DownloadCount)
+	assert.Equal(t, 2, february.UniqueIPs)
+	require.Len(t, february.TopVideos, 1)
+	assert.Equal(t, AuditTopVideo{VideoID: "a", DownloadCount: 1, EventCounts: map[string]int{VideoEventDownloaded: 1}}, february.TopVideos[0])
+
+	t.Run("Days and weeks", func(t *testing.T) {
+		code, days := getAuditSummary(t, server, "period=day&from=2024-02-01&to=2024-02-29")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, days, 1)
+		assert.Equal(t, "2024-02-03", days[0].Period)
+
+		code, weeks := getAuditSummary(t, server, "period=week&from=2024-01-15T00:00:00Z&to=2024-02-05T00:00:00Z")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, weeks, 2)
+		assert.Equal(t, "2024-W03", weeks[0].Period)
+		assert.Equal(t, "2024-W05", weeks[1].Period)
+	})
+
+	t.Run("Bad requests", func(t *testing.T) {
+		code, _ := getAuditSummary(t, server, "period=year")
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = getAuditSummary(t, server, "from=last-week")
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = getAuditSummary(t, server, "from=2024-03-01&to=2024-01-01")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Default from", func(t *testing.T) {
+		code, summaries := getAuditSummary(t, server, "to=2024-02-01")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, summaries, 1)
+		assert.Equal(t, "2024-01", summaries[0].Period)
+	})
+}
+
+func TestAuditPeriodStart(t *testing.T) {
+	sunday := time.Date(2024, 2, 4, 18, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC), auditPeriodStart(AuditPeriodDay, sunday))
+	assert.Equal(t, time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC), auditPeriodStart(AuditPeriodWeek, sunday))
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), auditPeriodStart(AuditPeriodMonth, sunday))
+	assert.Equal(t, "2024-W05", auditPeriodKey(AuditPeriodWeek, sunday))
+}
+
+func TestVideoEventClientIP(t *testing.T) {
+	server := newTestServer(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodDelete, "/api/videos/ip", nil)
+	server.recordVideoEvent(c, "ip", VideoEventDeleted, nil, nil)
+	server.recordVideoEvent(nil, "ip", VideoEventPurged, nil, nil)
+
+	events := server.videoEvents.List("ip", "")
+	require.Len(t, events, 2)
+	assert.Equal(t, "192.0.2.1", events[0].ClientIP)
+	assert.Empty(t, events[1].ClientIP, "no client for background events")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAuthTestServer returns a test server with API key auth enabled
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	server := newTestServer(t)
+	server.config.APIKeys = []string{"test-key"}
+	server.authenticator = NewAPIKeyAuthenticator(server.config.APIKeys)
+	server.config.NonceWindowSeconds = 300
+	server.nonceStore = NewNonceStore(600 * time.Second)
+	t.Cleanup(server.nonceStore.Close)
+
+	return server
+}
+
+// newNonce returns a fresh hex encoded upload nonce
+func newNonce(t *testing.T) string {
+	t.Helper()
+
+	b := make([]byte, nonceBytes)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return hex.EncodeToString(b)
+}
+
+// signedUpload uploads a small file with the given nonce and timestamp
+func signedUpload(t *testing.T, server *Server, nonce string, timestamp time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "nonce.mp4")
+	require.NoError(t, err)
+	part.Write([]byte("video data"))
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(apiKeyHeader, "test-key")
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(timestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	server := newAuthTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+	req.Header.Set(apiKeyHeader, "test-key")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Incoming webhooks are authenticated by signature, not API key
+	req = httptest.NewRequest(http.MethodPost, "/api/webhooks/receive", bytes.NewBufferString("{}"))
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "API key")
+}
+
+func TestUploadNonceValid(t *testing.T) {
+	server := newAuthTestServer(t)
+
+	w := signedUpload(t, server, newNonce(t), time.Now())
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// A timestamp inside the window is accepted too
+	w = signedUpload(t, server, newNonce(t), time.Now().Add(-4*time.Minute))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestUploadNonceExpiredTimestamp(t *testing.T) {
+	server := newAuthTestServer(t)
+
+	w := signedUpload(t, server, newNonce(t), time.Now().Add(-10*time.Minute))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = signedUpload(t, server, newNonce(t), time.Now().Add(10*time.Minute))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.Empty(t, server.db.GetAllVideos())
+}
+
+func TestUploadNonceDuplicate(t *testing.T) {
+	server := newAuthTestServer(t)
+	nonce := newNonce(t)
+
+	w := signedUpload(t, server, nonce, time.Now())
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = signedUpload(t, server, nonce, time.Now())
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, server.db.GetAllVideos(), 1)
+}
+
+func TestUploadNonceMalformed(t *testing.T) {
+	server := newAuthTestServer(t)
+
+	w := signedUpload(t, server, "not-hex", time.Now())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = signedUpload(t, server, "abcd", time.Now())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNonceStoreEviction(t *testing.T) {
+	store := NewNonceStore(time.Minute)
+	defer store.Close()
+
+	now := time.Now()
+	assert.True(t, store.Add("a", now))
+	assert.False(t, store.Add("a", now.Add(30*time.Second)))
+
+	store.evictExpired(now.Add(time.Minute))
+	assert.Equal(t, 0, store.Len())
+
+	// Once expired, a nonce may be seen again
+	assert.True(t, store.Add("a", now.Add(2*time.Minute)))
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	authModeAPIKey    = "api_key"
+	authModeJWT       = "jwt"
+	authModeOIDC      = "oidc"
+	authModeComposite = "composite"
+
+	// principalContextKey holds the *Principal of an authenticated request
+	principalContextKey = "principal"
+
+	// jwtClockSkew is how far token exp and nbf may be off the server clock
+	jwtClockSkew = time.Minute
+
+	// jwksRefreshInterval limits how often an unknown key ID refetches the
+	// OIDC provider's keys
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	// errNoCredentials is returned by authenticators that found no
+	// credentials of their kind on the request
+	errNoCredentials = errors.New("missing credentials")
+
+	errInvalidAPIKey = errors.New("invalid API key")
+	errInvalidToken  = errors.New("invalid token")
+)
+
+// Principal is the caller a request was authenticated as
+type Principal struct {
+	ID       string   `json:"id"`
+	Scopes   []string `json:"scopes,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"`
+}
+
+// Authenticator identifies the caller of a request. It returns
+// errNoCredentials when the request carries no credentials it understands.
+type Authenticator interface {
+	Authenticate(c *gin.Context) (*Principal, error)
+}
+
+// newAuthenticator builds the authenticator selected by Config.AuthMode. It
+// returns nil when authentication is disabled, which is only the case for
+// api_key mode without API keys.
+func newAuthenticator(config *Config) (Authenticator, error) {
+	switch config.AuthMode {
+	case "", authModeAPIKey:
+		if len(config.APIKeys) == 0 {
+			return nil, nil
+		}
+		return NewAPIKeyAuthenticator(config.APIKeys), nil
+
+	case authModeJWT:
+		if config.JWTSecret == "" {
+			return nil, errors.New("AUTH_MODE=jwt requires JWT_SECRET")
+		}
+		return NewJWTAuthenticator(config.JWTSecret), nil
+
+	case authModeOIDC:
+		if config.OIDCIssuer == "" {
+			return nil, errors.New("AUTH_MODE=oidc requires OIDC_ISSUER")
+		}
+		return NewOIDCAuthenticator(config.OIDCIssuer, config.OIDCAudience), nil
+
+	case authModeComposite:
+		var composite CompositeAuthenticator
+		if len(config.APIKeys) > 0 {
+			composite = append(composite, NewAPIKeyAuthenticator(config.APIKeys))
+		}
+		if config.JWTSecret != "" {
+			composite = append(composite, NewJWTAuthenticator(config.JWTSecret))
+		}
+		if config.OIDCIssuer != "" {
+			composite = append(composite, NewOIDCAuthenticator(config.OIDCIssuer, config.OIDCAudience))
+		}
+		if len(composite) == 0 {
+			return nil, errors.New("AUTH_MODE=composite requires API_KEYS, JWT_SECRET or OIDC_ISSUER")
+		}
+		return composite, nil
+	}
+
+	return nil, fmt.Errorf("unknown AUTH_MODE %q", config.AuthMode)
+}
+
+// failedAuthenticator rejects every request. It stands in for an
+// authenticator that could not be configured, so the API is never left open.
+type failedAuthenticator struct{}
+
+func (a failedAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	return nil, errors.New("authentication is misconfigured")
+}
+
+// principalFrom returns the caller of an authenticated request, nil when
+// authentication is disabled
+func principalFrom(c *gin.Context) *Principal {
+	if value, exists := c.Get(principalContextKey); exists {
+		return value.(*Principal)
+	}
+	return nil
+}
+
+// apiKeyFingerprint identifies an API key without revealing it
+func apiKeyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// APIKeyAuthenticator accepts the static keys of Config.APIKeys in the
+// X-API-Key header. The principal ID is a fingerprint of the key.
+type APIKeyAuthenticator struct {
+	keys []string
+}
+
+// NewAPIKeyAuthenticator creates an authenticator accepting keys
+func NewAPIKeyAuthenticator(keys []string) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	provided := c.GetHeader(apiKeyHeader)
+	if provided == "" {
+		return nil, errNoCredentials
+	}
+
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+			return &Principal{ID: apiKeyFingerprint(provided)}, nil
+		}
+	}
+	return nil, errInvalidAPIKey
+}
+
+// JWTAuthenticator accepts HS256 bearer tokens signed with a shared secret
+type JWTAuthenticator struct {
+	secret []byte
+}
+
+// NewJWTAuthenticator creates an authenticator for tokens signed with secret
+func NewJWTAuthenticator(secret string) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: []byte(secret)}
+}
+
+func (a *JWTAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, errNoCredentials
+	}
+
+	claims, err := verifyJWT(token, func(header jwtHeader, signingInput, signature []byte) error {
+		if header.Algorithm != "HS256" {
+			return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+		}
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims.principal(), nil
+}
+
+// OIDCAuthenticator accepts RS256 bearer tokens issued by an OpenID Connect
+// provider. The provider's keys are found through its discovery document
+// and cached, unknown key IDs trigger a refetch so key rotation is picked
+// up.
+type OIDCAuthenticator struct {
+	issuer   string
+	audience string // checked against the aud claim when set
+	client   *http.Client
+
+	keys      map[string]*rsa.PublicKey // kid -> key
+	fetchedAt time.Time
+	mutex     sync.Mutex
+}
+
+// NewOIDCAuthenticator creates an authenticator for tokens from issuer
+func NewOIDCAuthenticator(issuer, audience string) *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *OIDCAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, errNoCredentials
+	}
+
+	claims, err := verifyJWT(token, func(header jwtHeader, signingInput, signature []byte) error {
+		if header.Algorithm != "RS256" {
+			return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+		}
+		key, err := a.key(header.KeyID)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != a.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", errInvalidToken, claims.Issuer)
+	}
+	if a.audience != "" && !claims.Audience.contains(a.audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", errInvalidToken)
+	}
+	return claims.principal(), nil
+}
+
+// key returns the provider's signing key with the given ID
+func (a *OIDCAuthenticator) key(kid string) (*rsa.PublicKey, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if key, exists := a.keys[kid]; exists {
+		return key, nil
+	}
+
+	if time.Since(a.fetchedAt) >= jwksRefreshInterval {
+		keys, err := a.fetchKeys()
+		if err != nil {
+			return nil, fmt.Errorf("fetching OIDC provider keys: %w", err)
+		}
+		a.keys = keys
+		a.fetchedAt = time.Now()
+
+		if key, exists := a.keys[kid]; exists {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// fetchKeys reads the provider's RSA keys from the jwks_uri of its
+// discovery document
+func (a *OIDCAuthenticator) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := a.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// getJSON decodes the JSON document at url into v
+func (a *OIDCAuthenticator) getJSON(url string, v interface{}) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CompositeAuthenticator tries several authenticators in order and accepts
+// the first that authenticates the request, so credentials of an old and a
+// new scheme both work while clients migrate
+type CompositeAuthenticator []Authenticator
+
+func (a CompositeAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	err := errNoCredentials
+	for _, authenticator := range a {
+		principal, authErr := authenticator.Authenticate(c)
+		if authErr == nil {
+			return principal, nil
+		}
+
+		// Report why credentials were rejected over them being absent
+		if errors.Is(err, errNoCredentials) {
+			err = authErr
+		}
+	}
+	return nil, err
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jwtClaims are the token claims used for authentication
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	Scope     string      `json:"scope"` // space separated
+	TenantID  string      `json:"tenant_id"`
+}
+
+func (claims *jwtClaims) principal() *Principal {
+	return &Principal{
+		ID:       claims.Subject,
+		Scopes:   strings.Fields(claims.Scope),
+		TenantID: claims.TenantID,
+	}
+}
+
+// jwtAudience is an aud claim, which may be a string or a list of strings
+type jwtAudience []string
+
+func (aud *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = jwtAudience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(aud))
+}
+
+func (aud jwtAudience) contains(audience string) bool {
+	for _, value := range aud {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyJWT checks a compact serialized token's signature with verify and
+// its time claims, and returns its claims. Tokens must have a subject and
+// an expiry.
+func verifyJWT(token string, verify func(header jwtHeader, signingInput, signature []byte) error) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", errInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", errInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", errInvalidToken)
+	}
+	if err := verify(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", errInvalidToken)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing sub", errInvalidToken)
+	case claims.ExpiresAt == 0:
+		return nil, fmt.Errorf("%w: missing exp", errInvalidToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtClockSkew)):
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	case claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-jwtClockSkew)):
+		return nil, fmt.Errorf("%w: not yet valid", errInvalidToken)
+	}
+	return &claims, nil
+}
+
+// decodeJWTSegment decodes a base64url encoded JSON token segment into v
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
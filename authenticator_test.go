@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signTestJWT encodes claims as a token signed by sign
+func signTestJWT(t *testing.T, header map[string]string, claims map[string]interface{}, sign func(signingInput []byte) []byte) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(header)
+	require.NoError(t, err)
+	claimsJSON, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signingInput)))
+}
+
+// signHS256 returns a token signed with secret
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+
+	return signTestJWT(t, map[string]string{"alg": "HS256", "typ": "JWT"}, claims, func(signingInput []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	})
+}
+
+// authenticate runs authenticator against a request with the given headers
+func authenticate(authenticator Authenticator, headers map[string]string) (*Principal, error) {
+	req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	return authenticator.Authenticate(c)
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator([]string{"key-one", "key-two"})
+
+	principal, err := authenticate(authenticator, map[string]string{apiKeyHeader: "key-two"})
+	require.NoError(t, err)
+	assert.Equal(t, apiKeyFingerprint("key-two"), principal.ID)
+	assert.NotContains(t, principal.ID, "key-two")
+
+	_, err = authenticate(authenticator, map[string]string{apiKeyHeader: "wrong"})
+	assert.ErrorIs(t, err, errInvalidAPIKey)
+	_, err = authenticate(authenticator, nil)
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	authenticator := NewJWTAuthenticator("jwt-secret")
+	now := time.Now()
+	claims := func(exp time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"sub":       "user-1",
+			"exp":       exp.Unix(),
+			"scope":     "videos:read videos:write",
+			"tenant_id": "tenant-a",
+		}
+	}
+
+	principal, err := authenticate(authenticator, bearer(signHS256(t, "jwt-secret", claims(now.Add(time.Hour)))))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{ID: "user-1", Scopes: []string{"videos:read", "videos:write"}, TenantID: "tenant-a"}, principal)
+
+	_, err = authenticate(authenticator, bearer(signHS256(t, "other-secret", claims(now.Add(time.Hour)))))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	_, err = authenticate(authenticator, bearer(signHS256(t, "jwt-secret", claims(now.Add(-time.Hour)))))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	noExpiry := claims(now)
+	delete(noExpiry, "exp")
+	_, err = authenticate(authenticator, bearer(signHS256(t, "jwt-secret", noExpiry)))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// Unsigned tokens are never accepted
+	unsigned := signTestJWT(t, map[string]string{"alg": "none"}, claims(now.Add(time.Hour)), func([]byte) []byte { return nil })
+	_, err = authenticate(authenticator, bearer(unsigned))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	_, err = authenticate(authenticator, bearer("not-a-token"))
+	assert.ErrorIs(t, err, errInvalidToken)
+	_, err = authenticate(authenticator, map[string]string{"Authorization": "Basic dXNlcjpwYXNz"})
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+// oidcProvider is a mock OpenID Connect provider serving discovery and
+// JWKS documents
+type oidcProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	keyID     string
+	jwksCalls atomic.Int32
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &oidcProvider{key: key, keyID: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.server.URL,
+			"jwks_uri": p.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksCalls.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": p.keyID,
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// token returns an RS256 token signed by the provider's key
+func (p *oidcProvider) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	return signTestJWT(t, map[string]string{"alg": "RS256", "kid": kid}, claims, func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return signature
+	})
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	provider := newOIDCProvider(t)
+	authenticator := NewOIDCAuthenticator(provider.server.URL, "video-server")
+	claims := func(issuer string, audience interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "user-2",
+			"iss": issuer,
+			"aud": audience,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	principal, err := authenticate(authenticator, bearer(provider.token(t, "key-1", claims(provider.server.URL, "video-server"))))
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", principal.ID)
+
+	principal, err = authenticate(authenticator, bearer(provider.token(t, "key-1", claims(provider.server.URL, []string{"other", "video-server"}))))
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", principal.ID)
+	assert.Equal(t, int32(1), provider.jwksCalls.Load(), "keys should be cached")
+
+	_, err = authenticate(authenticator, bearer(provider.token(t, "key-1", claims("https://evil.example.com", "video-server"))))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	_, err = authenticate(authenticator, bearer(provider.token(t, "key-1", claims(provider.server.URL, "other-service"))))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// A token for a key the provider never published
+	_, err = authenticate(authenticator, bearer(provider.token(t, "key-2", claims(provider.server.URL, "video-server"))))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// HS256 tokens signed with the public modulus must not pass as RS256
+	forged := signHS256(t, string(provider.key.N.Bytes()), claims(provider.server.URL, "video-server"))
+	_, err = authenticate(authenticator, bearer(forged))
+	assert.ErrorIs(t, err, errInvalidToken)
+}
+
+func TestCompositeAuthenticator(t *testing.T) {
+	provider := newOIDCProvider(t)
+
+	server := newTestServer(t)
+	server.config.AuthMode = authModeComposite
+	server.config.APIKeys = []string{"legacy-key"}
+	server.config.JWTSecret = "jwt-secret"
+	server.config.OIDCIssuer = provider.server.URL
+	authenticator, err := newAuthenticator(server.config)
+	require.NoError(t, err)
+	require.Len(t, authenticator, 3)
+	server.authenticator = authenticator
+
+	get := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	assert.Equal(t, http.StatusOK, get(map[string]string{apiKeyHeader: "legacy-key"}))
+	assert.Equal(t, http.StatusOK, get(bearer(signHS256(t, "jwt-secret", map[string]interface{}{"sub": "a", "exp": exp}))))
+	assert.Equal(t, http.StatusOK, get(bearer(provider.token(t, "key-1", map[string]interface{}{"sub": "b", "iss": provider.server.URL, "exp": exp}))))
+
+	assert.Equal(t, http.StatusUnauthorized, get(nil))
+	assert.Equal(t, http.StatusUnauthorized, get(map[string]string{apiKeyHeader: "wrong"}))
+	assert.Equal(t, http.StatusUnauthorized, get(bearer(signHS256(t, "wrong-secret", map[string]interface{}{"sub": "a", "exp": exp}))))
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	authenticator, err := newAuthenticator(&Config{})
+	require.NoError(t, err)
+	assert.Nil(t, authenticator, "api_key mode without keys disables auth")
+
+	for _, config := range []*Config{
+		{AuthMode: authModeJWT},
+		{AuthMode: authModeOIDC},
+		{AuthMode: authModeComposite},
+		{AuthMode: "saml"},
+	} {
+		_, err := newAuthenticator(config)
+		assert.Error(t, err, config.AuthMode)
+	}
+
+	// A misconfigured server rejects API requests instead of allowing them
+	server := NewServer(&Config{StoragePath: t.TempDir(), AuthMode: authModeJWT}, NewInMemoryDB())
+	t.Cleanup(func() {
+		server.migrator.Stop()
+		close(server.retentionStop)
+	})
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/videos", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "misconfigured")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// batchErrorLogSize is how many errors BatchErrorLog keeps per batch type
+	batchErrorLogSize = 1000
+
+	// defaultBatchErrorLimit is how many errors the batch errors endpoint
+	// returns unless asked otherwise
+	defaultBatchErrorLimit = 50
+)
+
+// Batch types, recorded with each error in the BatchErrorLog
+const (
+	BatchTypeMetadataUpdate = "metadata_update"
+	BatchTypePreload        = "preload"
+)
+
+// Error codes of batch items
+const (
+	BatchErrorNotFound   = "not_found"
+	BatchErrorInternal   = "internal"
+	BatchErrorValidation = "validation_failed" // custom metadata does not match a schema
+)
+
+// APIError describes why an operation failed
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchItemResult is the outcome of a batch operation for one ID
+type BatchItemResult struct {
+	ID      string      `json:"id"`
+	Success bool        `json:"success"`
+	Error   *APIError   `json:"error,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// BatchResult is returned by every batch endpoint, with one item per ID in
+// the order they were requested
+type BatchResult struct {
+	Processed int               `json:"processed"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Items     []BatchItemResult `json:"items"`
+}
+
+// newBatchResult creates an empty result with room for n items
+func newBatchResult(n int) *BatchResult {
+	return &BatchResult{Items: make([]BatchItemResult, 0, n)}
+}
+
+// succeed adds a successful item
+func (r *BatchResult) succeed(id string, data interface{}) {
+	r.Items = append(r.Items, BatchItemResult{ID: id, Success: true, Data: data})
+	r.Processed++
+	r.Succeeded++
+}
+
+// fail adds a failed item
+func (r *BatchResult) fail(id, code, message string) {
+	r.Items = append(r.Items, BatchItemResult{ID: id, Error: &APIError{Code: code, Message: message}})
+	r.Processed++
+	r.Failed++
+}
+
+// BatchError is a failed batch item kept by the BatchErrorLog
+type BatchError struct {
+	BatchType string    `json:"batch_type"`
+	ID        string    `json:"id"`
+	Error     APIError  `json:"error"`
+	RequestID string    `json:"request_id,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// batchErrorRing holds the latest errors of one batch type, next is where
+// the following error goes once the ring is full
+type batchErrorRing struct {
+	errors []BatchError
+	next   int
+}
+
+// BatchErrorLog keeps the latest failed items of each batch type in memory
+// so operators can look into partial failures after the fact
+type BatchErrorLog struct {
+	mutex sync.Mutex
+	rings map[string]*batchErrorRing
+	size  int
+}
+
+// NewBatchErrorLog creates a log keeping size errors per batch type
+func NewBatchErrorLog(size int) *BatchErrorLog {
+	return &BatchErrorLog{rings: make(map[string]*batchErrorRing), size: size}
+}
+
+// Record adds the failed items of result, overwriting the oldest errors of
+// the batch type once it has size of them
+func (l *BatchErrorLog) Record(batchType, requestID string, result *BatchResult) {
+	if result.Failed == 0 {
+		return
+	}
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ring, exists := l.rings[batchType]
+	if !exists {
+		ring = &batchErrorRing{}
+		l.rings[batchType] = ring
+	}
+	for _, item := range result.Items {
+		if item.Error == nil {
+			continue
+		}
+		entry := BatchError{BatchType: batchType, ID: item.ID, Error: *item.Error, RequestID: requestID, Time: now}
+		if len(ring.errors) < l.size {
+			ring.errors = append(ring.errors, entry)
+			continue
+		}
+		ring.errors[ring.next] = entry
+		ring.next = (ring.next + 1) % l.size
+	}
+}
+
+// Query returns up to limit errors recorded from since on, newest first. An
+// empty batchType includes every type.
+func (l *BatchErrorLog) Query(batchType string, since time.Time, limit int) []BatchError {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var matches []BatchError
+	for name, ring := range l.rings {
+		if batchType != "" && name != batchType {
+			continue
+		}
+		for _, entry := range ring.errors {
+			if !entry.Time.Before(since) {
+				matches = append(matches, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Time.After(matches[j].Time)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// recordBatchResult logs the failed items of a batch request
+func (s *Server) recordBatchResult(c *gin.Context, batchType string, result *BatchResult) {
+	s.batchErrors.Record(batchType, requestIDFrom(c), result)
+
+	getLogger(c).Info().
+		Str("batch_type", batchType).
+		Int("processed", result.Processed).
+		Int("succeeded", result.Succeeded).
+		Int("failed", result.Failed).
+		Msg("batch processed")
+}
+
+// batchErrorsHandler lists recent failed batch items. since is a unix
+// timestamp, type limits the errors to one batch type.
+func (s *Server) batchErrorsHandler(c *gin.Context) {
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a unix timestamp"})
+			return
+		}
+		since = time.Unix(seconds, 0)
+	}
+
+	limit := defaultBatchErrorLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > batchErrorLogSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(batchErrorLogSize)})
+			return
+		}
+		limit = parsed
+	}
+
+	errors := s.batchErrors.Query(c.Query("type"), since, limit)
+	if errors == nil {
+		errors = []BatchError{}
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"errors": errors,
+		"count":  len(errors),
+	})
+}
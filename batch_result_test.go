@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchResultShape(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("a")))
+
+	code, resp := batchUpdate(t, server, `{"ids":["a","missing"],"updates":{"tags":{"add":["new"]}}}`)
+	require.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, 2, resp.Processed)
+	assert.Equal(t, 1, resp.Succeeded)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, resp.Processed, resp.Succeeded+resp.Failed)
+	require.Len(t, resp.Items, 2)
+
+	ok := resp.Items[0]
+	assert.Equal(t, "a", ok.ID)
+	assert.True(t, ok.Success)
+	assert.Nil(t, ok.Error)
+	assert.Equal(t, []interface{}{"new"}, ok.Data.(map[string]interface{})["tags"])
+
+	failed := resp.Items[1]
+	assert.Equal(t, "missing", failed.ID)
+	assert.False(t, failed.Success)
+	assert.Equal(t, &APIError{Code: BatchErrorNotFound, Message: "video not found"}, failed.Error)
+	assert.Nil(t, failed.Data)
+}
+
+func TestBatchErrorLog(t *testing.T) {
+	log := NewBatchErrorLog(3)
+
+	result := newBatchResult(5)
+	for i := 0; i < 5; i++ {
+		result.fail(fmt.Sprint(i), BatchErrorNotFound, "video not found")
+	}
+	result.succeed("ok", nil)
+	log.Record(BatchTypeMetadataUpdate, "req-1", result)
+
+	errors := log.Query("", time.Time{}, 10)
+	require.Len(t, errors, 3, "only the latest errors of a type are kept")
+	var ids []string
+	for _, entry := range errors {
+		ids = append(ids, entry.ID)
+		assert.Equal(t, "req-1", entry.RequestID)
+	}
+	assert.ElementsMatch(t, []string{"2", "3", "4"}, ids)
+
+	preload := newBatchResult(1)
+	preload.fail("p", BatchErrorNotFound, "video not found")
+	log.Record(BatchTypePreload, "req-2", preload)
+
+	assert.Len(t, log.Query("", time.Time{}, 10), 4, "each type has its own ring")
+	assert.Len(t, log.Query(BatchTypePreload, time.Time{}, 10), 1)
+	assert.Equal(t, "p", log.Query("", time.Time{}, 1)[0].ID, "newest first")
+	assert.Empty(t, log.Query("", time.Now().Add(time.Second), 10))
+}
+
+func TestBatchErrorsEndpoint(t *testing.T) {
+	server := newTestServer(t)
+	start := time.Now().Unix()
+
+	for i := 0; i < 3; i++ {
+		code, _ := batchUpdate(t, server, fmt.Sprintf(`{"ids":["missing-%d"],"updates":{"tags":{"add":["x"]}}}`, i))
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	get := func(query string) (int, []BatchError) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/batch-errors"+query, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		var resp struct {
+			Errors []BatchError `json:"errors"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp.Errors
+	}
+
+	code, errors := get(fmt.Sprintf("?since=%d", start))
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, errors, 3)
+	assert.Equal(t, BatchTypeMetadataUpdate, errors[0].BatchType)
+	assert.Equal(t, BatchErrorNotFound, errors[0].Error.Code)
+
+	_, errors = get("?limit=2")
+	assert.Len(t, errors, 2)
+
+	_, errors = get(fmt.Sprintf("?since=%d", time.Now().Add(time.Hour).Unix()))
+	assert.NotNil(t, errors)
+	assert.Empty(t, errors)
+
+	_, errors = get("?type=" + BatchTypePreload)
+	assert.Empty(t, errors)
+
+	for _, query := range []string{"?since=yesterday", "?limit=0", "?limit=1001"} {
+		code, _ := get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchUpdateIDs caps how many videos one batch update may touch
+const maxBatchUpdateIDs = 1000
+
+// VideoMetadataUpdate describes changes applied to every video in a batch
+type VideoMetadataUpdate struct {
+	Tags struct {
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	} `json:"tags"`
+	// CustomMetadata is merged into each video's metadata, a null value
+	// removes the key
+	CustomMetadata map[string]*string `json:"custom_metadata"`
+}
+
+// apply returns a copy of v with the update applied, leaving v untouched
+func (u *VideoMetadataUpdate) apply(v *Video, now time.Time) *Video {
+	updated := *v
+	updated.UpdatedAt = now
+
+	if len(u.Tags.Add) > 0 || len(u.Tags.Remove) > 0 {
+		removed := make(map[string]struct{})
+		for _, tag := range normalizeTags(u.Tags.Remove) {
+			removed[tag] = struct{}{}
+		}
+
+		var tags []string
+		for _, tag := range normalizeTags(append(append([]string(nil), v.Tags...), u.Tags.Add...)) {
+			if _, exists := removed[tag]; !exists {
+				tags = append(tags, tag)
+			}
+		}
+		updated.Tags = tags
+	}
+
+	if len(u.CustomMetadata) > 0 {
+		// Copied, since the stored video shares its map with v
+		metadata := make(map[string]string, len(v.CustomMetadata)+len(u.CustomMetadata))
+		for key, value := range v.CustomMetadata {
+			metadata[key] = value
+		}
+		for key, value := range u.CustomMetadata {
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = *value
+			}
+		}
+		if len(metadata) == 0 {
+			metadata = nil
+		}
+		updated.CustomMetadata = metadata
+	}
+
+	return &updated
+}
+
+// batchUpdater is implemented by stores that can update several videos at
+// once
+type batchUpdater interface {
+	UpdateVideosFunc(ids []string, update func(*Video) error) (map[string]*Video, map[string]error)
+}
+
+// updateVideos applies update to each video as UpdateVideoFunc does, in one
+// go when the store supports it. It returns the updated videos and the
+// errors of the videos that were not updated, by video ID.
+func updateVideos(db VideoStore, ids []string, update func(*Video) error) (map[string]*Video, map[string]error) {
+	if updater, ok := db.(batchUpdater); ok {
+		return updater.UpdateVideosFunc(ids, update)
+	}
+
+	updated := make(map[string]*Video, len(ids))
+	failed := make(map[string]error)
+	for _, id := range ids {
+		video, err := db.UpdateVideoFunc(id, update)
+		if err != nil {
+			failed[id] = err
+		} else {
+			updated[id] = video
+		}
+	}
+	return updated, failed
+}
+
+// UpdateVideosFunc updates several videos under a single lock acquisition.
+// Videos that no longer exist fail with ErrVideoNotFound.
+func (db *InMemoryDB) UpdateVideosFunc(ids []string, update func(*Video) error) (map[string]*Video, map[string]error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	updated := make(map[string]*Video, len(ids))
+	failed := make(map[string]error)
+	var changed []string
+	for _, id := range ids {
+		video, err := db.updateVideoFuncLocked(id, update)
+		if err != nil {
+			failed[id] = err
+			continue
+		}
+		updated[id] = video
+		changed = append(changed, id)
+	}
+
+	if len(changed) > 0 {
+		db.markDirty(changed...)
+	}
+	return updated, failed
+}
+
+// batchUpdateVideosHandler applies the same tag and custom metadata changes
+// to several videos. The videos are read, changed and stored in one go, so
+// no concurrent write is lost. Each item of the result holds the updated
+// video.
+// Videos whose new custom metadata does not match a metadata schema fail
+// with the first problem found.
+func (s *Server) batchUpdateVideosHandler(c *gin.Context) {
+	var req struct {
+		IDs     []string            `json:"ids" binding:"required,min=1"`
+		Updates VideoMetadataUpdate `json:"updates"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.IDs) > maxBatchUpdateIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d ids can be updated at once", maxBatchUpdateIDs)})
+		return
+	}
+
+	now := time.Now()
+	var ids []string
+	seen := make(map[string]struct{}, len(req.IDs))
+	for _, id := range req.IDs {
+		if _, exists := seen[id]; !exists {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	originals := make(map[string]*Video, len(ids))
+	invalid := make(map[string][]MetadataValidationError)
+	updates, failed := updateVideos(s.db, ids, func(video *Video) error {
+		original := *video
+		originals[video.ID] = &original
+		*video = *req.Updates.apply(&original, now)
+		if len(req.Updates.CustomMetadata) > 0 {
+			if errs := s.validateCustomMetadata(video); len(errs) > 0 {
+				invalid[video.ID] = errs
+				return errMetadataInvalid
+			}
+		}
+		return nil
+	})
+
+	result := newBatchResult(len(ids))
+	for _, id := range ids {
+		err, exists := failed[id]
+		switch {
+		case !exists:
+			video := updates[id]
+			s.recordMetadataEvents(c, originals[id], video)
+			s.notifyTagChanges(c.Request.Context(), originals[id], video)
+			result.succeed(id, video)
+		case errors.Is(err, ErrVideoNotFound):
+			result.fail(id, BatchErrorNotFound, "video not found")
+		case errors.Is(err, errMetadataInvalid):
+			errs := invalid[id]
+			result.fail(id, BatchErrorValidation, fmt.Sprintf("%s %s", errs[0].Field, errs[0].Message))
+		default:
+			getLogger(c).Error().Err(err).Str("video_id", id).Msg("failed to update video metadata")
+			result.fail(id, BatchErrorInternal, "failed to update video")
+		}
+	}
+
+	s.recordBatchResult(c, BatchTypeMetadataUpdate, result)
+	s.respondSuccess(c, http.StatusOK, result)
+}
+
+// recordMetadataEvents adds the changes a batch update made to a video to
+// its event history
+func (s *Server) recordMetadataEvents(c *gin.Context, old, updated *Video) {
+	if !reflect.DeepEqual(old.Tags, updated.Tags) {
+		s.recordVideoEvent(c, updated.ID, VideoEventTagsChanged, old.Tags, updated.Tags)
+	}
+	if !reflect.DeepEqual(old.CustomMetadata, updated.CustomMetadata) {
+		s.recordVideoEvent(c, updated.ID, VideoEventUpdated, old.CustomMetadata, updated.CustomMetadata)
+	}
+}
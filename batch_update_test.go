@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchUpdateResponse sorts the items of a batch update result by outcome
+type batchUpdateResponse struct {
+	BatchResult
+	Updated  []string
+	NotFound []string
+	Errors   []BatchItemResult
+}
+
+func batchUpdate(t *testing.T, server *Server, body string) (int, batchUpdateResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/videos", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var resp batchUpdateResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp.BatchResult))
+		for _, item := range resp.Items {
+			switch {
+			case item.Success:
+				resp.Updated = append(resp.Updated, item.ID)
+			case item.Error.Code == BatchErrorNotFound:
+				resp.NotFound = append(resp.NotFound, item.ID)
+			default:
+				resp.Errors = append(resp.Errors, item)
+			}
+		}
+	}
+	return w.Code, resp
+}
+
+func TestBatchUpdatePartialSuccess(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("a", "draft")))
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("b")))
+
+	code, resp := batchUpdate(t, server, `{"ids":["a","missing","b","a"],"updates":{"tags":{"add":["Reviewed"]}}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"a", "b"}, resp.Updated)
+	assert.Equal(t, []string{"missing"}, resp.NotFound)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, 3, resp.Processed)
+	assert.Equal(t, resp.Processed, resp.Succeeded+resp.Failed)
+
+	// The tag index follows the update
+	assert.Equal(t, []string{"a", "b"}, videoIDs(server.db.(*InMemoryDB).SearchByTags([]string{"reviewed"}, TagOperatorAnd)))
+
+	code, _ = batchUpdate(t, server, `{"ids":[],"updates":{}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestBatchUpdateTagMerge(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("a", "2024", "draft", "production")))
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("b", "staging")))
+
+	code, resp := batchUpdate(t, server, `{"ids":["a","b"],"updates":{"tags":{"add":["Final","2024"],"remove":["DRAFT","staging"]}}}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"a", "b"}, resp.Updated)
+
+	a, _ := server.db.GetVideoByID("a")
+	assert.Equal(t, []string{"2024", "final", "production"}, a.Tags)
+	b, _ := server.db.GetVideoByID("b")
+	assert.Equal(t, []string{"2024", "final"}, b.Tags)
+	assert.True(t, b.UpdatedAt.After(b.CreatedAt))
+}
+
+func TestBatchUpdateCustomMetadata(t *testing.T) {
+	server := newTestServer(t)
+	video := newTestVideo("a", 1)
+	video.CustomMetadata = map[string]string{"env": "staging", "owner": "video-team", "ticket": "123"}
+	require.NoError(t, server.db.AddVideo(video))
+	before, _ := server.db.GetVideoByID("a")
+
+	code, resp := batchUpdate(t, server, `{"ids":["a"],"updates":{"custom_metadata":{"env":"prod","region":"eu","ticket":null}}}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"a"}, resp.Updated)
+
+	after, _ := server.db.GetVideoByID("a")
+	assert.Equal(t, map[string]string{"env": "prod", "owner": "video-team", "region": "eu"}, after.CustomMetadata)
+	assert.Equal(t, "staging", before.CustomMetadata["env"], "earlier copies should not see the update")
+
+	// Removing the last keys leaves no metadata
+	code, _ = batchUpdate(t, server, `{"ids":["a"],"updates":{"custom_metadata":{"env":null,"owner":null,"region":null}}}`)
+	require.Equal(t, http.StatusOK, code)
+	after, _ = server.db.GetVideoByID("a")
+	assert.Nil(t, after.CustomMetadata)
+}
+
+func TestBatchUpdateWithoutBatchStore(t *testing.T) {
+	store := NewMockVideoStore()
+	store.Videos["a"] = newTaggedVideo("a", "old")
+	store.Videos["b"] = newTaggedVideo("b")
+
+	server := NewServer(&Config{StoragePath: t.TempDir(), MaxFileSize: 1024}, store)
+	defer close(server.retentionStop)
+	defer server.migrator.Stop()
+
+	code, resp := batchUpdate(t, server, `{"ids":["a","b","c"],"updates":{"tags":{"add":["new"],"remove":["old"]}}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"a", "b"}, resp.Updated)
+	assert.Equal(t, []string{"c"}, resp.NotFound)
+	assert.Equal(t, 3, store.CallCount("UpdateVideoFunc"), "one update per video, including the missing c")
+
+	a, _ := store.GetVideoByID("a")
+	assert.Equal(t, []string{"new"}, a.Tags)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// VideoMetadata describes a video's contents
+type VideoMetadata struct {
+	DurationSeconds float64 `json:"duration_seconds,omitempty"` // 0 when unknown
+
+	// Stream properties of the original file, zero when unknown
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	BitrateBps int64  `json:"bitrate_bps,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+}
+
+// storageMinutes is the billable video-minutes of keeping a video of the
+// given duration stored for period
+func storageMinutes(durationSeconds float64, period time.Duration) float64 {
+	return (durationSeconds / 60) * (period.Hours() * 60)
+}
+
+// billingLoop bills stored videos every BillingInterval until shutdown
+func (s *Server) billingLoop() {
+	ticker := time.NewTicker(s.config.BillingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.billingStop:
+			return
+		case now := <-ticker.C:
+			s.billVideos(now)
+		}
+	}
+}
+
+// billVideos sends video.billed for every video with a known duration and
+// returns the payloads sent. Videos billed less than a BillingInterval ago
+// are skipped, so running twice in the same window doesn't bill twice.
+func (s *Server) billVideos(now time.Time) []VideoBilledPayload {
+	s.billingMutex.Lock()
+	defer s.billingMutex.Unlock()
+
+	videos := s.db.GetAllVideos()
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].ID < videos[j].ID
+	})
+
+	var billed []VideoBilledPayload
+	for _, video := range videos {
+		if video.Metadata == nil || video.Metadata.DurationSeconds <= 0 {
+			continue
+		}
+
+		periodStart := video.CreatedAt
+		if video.LastBilledAt != nil {
+			periodStart = *video.LastBilledAt
+		}
+		period := now.Sub(periodStart)
+		if period <= 0 || (video.LastBilledAt != nil && period < s.config.BillingInterval) {
+			continue
+		}
+
+		// Record the billing before sending it, a failed update must not
+		// lead to the same period being billed again
+		billedAt := now
+		_, err := s.db.UpdateVideoFunc(video.ID, func(current *Video) error {
+			current.LastBilledAt = &billedAt
+			return nil
+		})
+		if err != nil {
+			s.logger.Error().Err(err).Str("video_id", video.ID).Msg("failed to record video billing")
+			continue
+		}
+
+		payload := VideoBilledPayload{
+			SchemaVersion:   WebhookPayloadSchemaVersion,
+			Event:           EventVideoBilled,
+			Timestamp:       now.Unix(),
+			VideoID:         video.ID,
+			Filename:        video.Name,
+			DurationSeconds: video.Metadata.DurationSeconds,
+			StorageMinutes:  storageMinutes(video.Metadata.DurationSeconds, period),
+			PeriodStart:     periodStart,
+			PeriodEnd:       now,
+		}
+		s.webhookMgr.NotifyWebhooks(EventVideoBilled, payload)
+		billed = append(billed, payload)
+	}
+
+	if len(billed) > 0 {
+		s.logger.Info().Int("videos", len(billed)).Msg("video billing events sent")
+	}
+	return billed
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageMinutes(t *testing.T) {
+	// A 2 minute video stored for an hour is 120 video-minutes
+	assert.InDelta(t, 120.0, storageMinutes(120, time.Hour), 1e-9)
+	assert.InDelta(t, 15.0, storageMinutes(30, 30*time.Minute), 1e-9)
+	assert.Zero(t, storageMinutes(90, 0))
+}
+
+func TestBillVideos(t *testing.T) {
+	server := newTestServer(t)
+	server.config.BillingInterval = time.Hour
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.billed", receiver.server.URL))
+
+	now := time.Now()
+	billable := newTestVideo("billable", 10)
+	billable.CreatedAt = now.Add(-2 * time.Hour)
+	billable.Metadata = &VideoMetadata{DurationSeconds: 90}
+	require.NoError(t, server.db.AddVideo(billable))
+	require.NoError(t, server.db.AddVideo(newTestVideo("no-duration", 10)))
+
+	billed := server.billVideos(now)
+	require.Len(t, billed, 1)
+	assert.Equal(t, "billable", billed[0].VideoID)
+	assert.InDelta(t, 180.0, billed[0].StorageMinutes, 1e-6)
+	assert.True(t, billed[0].PeriodStart.Equal(billable.CreatedAt))
+
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	require.Equal(t, 1, receiver.count())
+	payload := receiver.payloads[0]
+	assert.Equal(t, "video.billed", payload["event"])
+	assert.Equal(t, "billable", payload["video_id"])
+	assert.InDelta(t, 180.0, payload["storage_minutes"], 1e-6)
+
+	stored, _ := server.db.GetVideoByID("billable")
+	require.NotNil(t, stored.LastBilledAt)
+	assert.True(t, stored.LastBilledAt.Equal(now))
+
+	// Running again within the same interval bills nothing
+	assert.Empty(t, server.billVideos(now))
+	assert.Empty(t, server.billVideos(now.Add(30*time.Minute)))
+
+	// The next period starts where the last one ended
+	billed = server.billVideos(now.Add(time.Hour))
+	require.Len(t, billed, 1)
+	assert.InDelta(t, 90.0, billed[0].StorageMinutes, 1e-6)
+	assert.True(t, billed[0].PeriodStart.Equal(now))
+
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	assert.Equal(t, 2, receiver.count())
+}
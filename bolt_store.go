@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltVideosBucket = []byte("videos")    // video ID -> JSON(Video)
+	boltNamesBucket  = []byte("nameIndex") // name -> video ID
+	boltMetaBucket   = []byte("meta")      // bookkeeping such as the latest ID
+
+	boltLatestKey = []byte("latestID")
+)
+
+// BoltDBStore is a VideoStore persisted to a BoltDB file. Every write is a
+// single transaction, so a failed write never leaves partial state behind.
+type BoltDBStore struct {
+	db *bolt.DB
+}
+
+// NewBoltDBStore opens (or creates) the BoltDB file at path. BoltDB locks the
+// file itself; lockTimeout bounds the wait for another instance to release it.
+func NewBoltDBStore(path string, lockTimeout time.Duration) (*BoltDBStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltVideosBucket, boltNamesBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDBStore{db: db}, nil
+}
+
+// Close closes the underlying BoltDB file
+func (s *BoltDBStore) Close() error {
+	return s.db.Close()
+}
+
+// AddVideo stores a video and updates the indexes in one transaction
+func (s *BoltDBStore) AddVideo(v *Video) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltVideosBucket).Put([]byte(v.ID), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltNamesBucket).Put([]byte(v.Name), []byte(v.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltMetaBucket).Put(boltLatestKey, []byte(v.ID))
+	})
+}
+
+// UpdateVideo replaces an existing video record in one transaction
+func (s *BoltDBStore) UpdateVideo(v *Video) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getBoltVideo(tx, []byte(v.ID))
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrVideoNotFound
+		}
+		return putBoltUpdate(tx, existing, v, data)
+	})
+}
+
+// UpdateVideoFunc reads a video, applies update and stores the result in
+// one transaction
+func (s *BoltDBStore) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	var updated *Video
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getBoltVideo(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrVideoNotFound
+		}
+
+		v := *existing
+		if err := update(&v); err != nil {
+			return err
+		}
+		v.ID = id
+		data, err := json.Marshal(&v)
+		if err != nil {
+			return err
+		}
+		if err := putBoltUpdate(tx, existing, &v, data); err != nil {
+			return err
+		}
+		updated = &v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// putBoltUpdate replaces the stored existing video with v, encoded as data,
+// moving its name index entry when the name changed
+func putBoltUpdate(tx *bolt.Tx, existing, v *Video, data []byte) error {
+	if existing.Name != v.Name {
+		names := tx.Bucket(boltNamesBucket)
+		if err := names.Delete([]byte(existing.Name)); err != nil {
+			return err
+		}
+		if err := names.Put([]byte(v.Name), []byte(v.ID)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(boltVideosBucket).Put([]byte(v.ID), data)
+}
+
+// GetVideoByID retrieves a video by its ID
+func (s *BoltDBStore) GetVideoByID(id string) (*Video, bool) {
+	var video *Video
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		video, err = getBoltVideo(tx, []byte(id))
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Str("video_id", id).Msg("failed to read video from bolt store")
+		return nil, false
+	}
+
+	return video, video != nil
+}
+
+// GetVideoByName retrieves a video by its name
+func (s *BoltDBStore) GetVideoByName(name string) (*Video, bool) {
+	var video *Video
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltNamesBucket).Get([]byte(name))
+		if id == nil {
+			return nil
+		}
+
+		var err error
+		video, err = getBoltVideo(tx, id)
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Str("name", name).Msg("failed to read video from bolt store")
+		return nil, false
+	}
+
+	return video, video != nil
+}
+
+// GetLatestVideo returns the most recently added video
+func (s *BoltDBStore) GetLatestVideo() (*Video, bool) {
+	var video *Video
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltMetaBucket).Get(boltLatestKey)
+		if id == nil {
+			return nil
+		}
+
+		var err error
+		video, err = getBoltVideo(tx, id)
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read latest video from bolt store")
+		return nil, false
+	}
+
+	return video, video != nil
+}
+
+// DeleteVideo removes a video and its index entries in one transaction
+func (s *BoltDBStore) DeleteVideo(id string) bool {
+	deleted := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		video, err := getBoltVideo(tx, []byte(id))
+		if err != nil || video == nil {
+			return err
+		}
+
+		if err := tx.Bucket(boltVideosBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltNamesBucket).Delete([]byte(video.Name)); err != nil {
+			return err
+		}
+
+		// Update latestID if this was the latest video
+		meta := tx.Bucket(boltMetaBucket)
+		if string(meta.Get(boltLatestKey)) == id {
+			var latest *Video
+			err := tx.Bucket(boltVideosBucket).ForEach(func(_, data []byte) error {
+				var candidate Video
+				if err := json.Unmarshal(data, &candidate); err != nil {
+					return err
+				}
+				if latest == nil || candidate.CreatedAt.After(latest.CreatedAt) {
+					latest = &candidate
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if latest == nil {
+				err = meta.Delete(boltLatestKey)
+			} else {
+				err = meta.Put(boltLatestKey, []byte(latest.ID))
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Str("video_id", id).Msg("failed to delete video from bolt store")
+		return false
+	}
+
+	return deleted
+}
+
+// GetAllVideos returns all videos, iterating with a cursor
+func (s *BoltDBStore) GetAllVideos() []*Video {
+	return s.scan(func(*Video) bool { return true })
+}
+
+// SearchVideos returns all videos matching the query. BoltDB has no
+// secondary indexes, so this is a full cursor scan.
+func (s *BoltDBStore) SearchVideos(query SearchQuery) []*Video {
+	needle := strings.ToLower(query.Query)
+	return s.scan(func(v *Video) bool {
+		if needle != "" && !strings.Contains(strings.ToLower(v.Name), needle) {
+			return false
+		}
+		if query.MinSize > 0 && v.Size < query.MinSize {
+			return false
+		}
+		if query.MaxSize > 0 && v.Size > query.MaxSize {
+			return false
+		}
+		return true
+	})
+}
+
+// scan returns every video accepted by match
+func (s *BoltDBStore) scan(match func(*Video) bool) []*Video {
+	var videos []*Video
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltVideosBucket).Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			var video Video
+			if err := json.Unmarshal(data, &video); err != nil {
+				return err
+			}
+			if match(&video) {
+				videos = append(videos, &video)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to scan videos in bolt store")
+		return nil
+	}
+
+	return videos
+}
+
+// getBoltVideo decodes a video from the videos bucket, returning nil if absent
+func getBoltVideo(tx *bolt.Tx, id []byte) (*Video, error) {
+	data := tx.Bucket(boltVideosBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+
+	var video Video
+	if err := json.Unmarshal(data, &video); err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
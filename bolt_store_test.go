@@ -0,0 +1,137 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestBoltStore(t *testing.T, path string) *BoltDBStore {
+	t.Helper()
+
+	store, err := NewBoltDBStore(path, 5*time.Second)
+	require.NoError(t, err)
+	return store
+}
+
+func TestBoltDBStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "videos.db")
+	store := openTestBoltStore(t, path)
+
+	older := newTestVideo("older", 100)
+	older.CreatedAt = time.Now().Add(-time.Hour)
+	newer := newTestVideo("newer", 200)
+
+	require.NoError(t, store.AddVideo(older))
+	require.NoError(t, store.AddVideo(newer))
+	require.NoError(t, store.Close())
+
+	// Everything must survive a reopen
+	store = openTestBoltStore(t, path)
+	defer store.Close()
+
+	video, exists := store.GetVideoByID("older")
+	require.True(t, exists)
+	assert.Equal(t, older.Name, video.Name)
+
+	video, exists = store.GetVideoByName("newer.mp4")
+	require.True(t, exists)
+	assert.Equal(t, "newer", video.ID)
+
+	latest, exists := store.GetLatestVideo()
+	require.True(t, exists)
+	assert.Equal(t, "newer", latest.ID)
+
+	assert.Len(t, store.GetAllVideos(), 2)
+	assert.Len(t, store.SearchVideos(SearchQuery{MinSize: 150}), 1)
+	assert.Len(t, store.SearchVideos(SearchQuery{Query: "OLD"}), 1)
+
+	// Deleting the latest video falls back to the next newest
+	assert.True(t, store.DeleteVideo("newer"))
+	assert.False(t, store.DeleteVideo("newer"))
+
+	latest, exists = store.GetLatestVideo()
+	require.True(t, exists)
+	assert.Equal(t, "older", latest.ID)
+
+	_, exists = store.GetVideoByName("newer.mp4")
+	assert.False(t, exists)
+}
+
+func TestBoltDBStoreUpdateVideo(t *testing.T) {
+	store := openTestBoltStore(t, filepath.Join(t.TempDir(), "videos.db"))
+	defer store.Close()
+
+	require.NoError(t, store.AddVideo(newTestVideo("a", 100)))
+
+	updated := newTestVideo("a", 100)
+	updated.Name = "renamed.mp4"
+	updated.SpriteURL = "/api/videos/a/sprite"
+	require.NoError(t, store.UpdateVideo(updated))
+
+	_, exists := store.GetVideoByName("a.mp4")
+	assert.False(t, exists)
+	video, exists := store.GetVideoByName("renamed.mp4")
+	require.True(t, exists)
+	assert.Equal(t, "/api/videos/a/sprite", video.SpriteURL)
+
+	assert.ErrorIs(t, store.UpdateVideo(newTestVideo("missing", 1)), ErrVideoNotFound)
+}
+
+func TestBoltDBStoreAtomicity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "videos.db")
+
+	t.Run("Failed write rolls back", func(t *testing.T) {
+		store := openTestBoltStore(t, path)
+		defer store.Close()
+
+		// The video row is written first, then the empty name key is
+		// rejected by BoltDB, aborting the transaction mid-operation
+		video := newTestVideo("partial", 100)
+		video.Name = ""
+		assert.Error(t, store.AddVideo(video))
+
+		_, exists := store.GetVideoByID("partial")
+		assert.False(t, exists)
+		_, exists = store.GetLatestVideo()
+		assert.False(t, exists)
+	})
+
+	t.Run("Closed database", func(t *testing.T) {
+		store := openTestBoltStore(t, path)
+		require.NoError(t, store.Close())
+
+		assert.Error(t, store.AddVideo(newTestVideo("closed", 100)))
+
+		store = openTestBoltStore(t, path)
+		defer store.Close()
+
+		_, exists := store.GetVideoByID("closed")
+		assert.False(t, exists)
+		assert.Empty(t, store.GetAllVideos())
+	})
+}
+
+func TestNewVideoStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := newVideoStore(&Config{StoragePath: dir})
+	require.NoError(t, err)
+	assert.IsType(t, &InMemoryDB{}, store)
+
+	store, err = newVideoStore(&Config{StoragePath: dir, DBBackend: "bolt"})
+	require.NoError(t, err)
+	assert.IsType(t, &BoltDBStore{}, store)
+	require.NoError(t, store.(*BoltDBStore).Close())
+
+	store, err = newVideoStore(&Config{StoragePath: dir, DBBackend: "json"})
+	require.NoError(t, err)
+	require.NotNil(t, store.(*InMemoryDB).persist)
+	require.NoError(t, store.(*InMemoryDB).Close())
+
+	_, err = newVideoStore(&Config{StoragePath: dir, DBBackend: "mongo"})
+	assert.Error(t, err)
+}
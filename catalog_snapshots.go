@@ -0,0 +1,316 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// catalogSnapshotDir is the directory under StoragePath holding catalog
+// snapshots
+const catalogSnapshotDir = "snapshots"
+
+// CatalogSnapshot describes a saved snapshot of the video catalog
+type CatalogSnapshot struct {
+	Filename       string    `json:"filename"`
+	Timestamp      int64     `json:"timestamp"` // unix seconds, the :ts of GET /api/admin/catalog-snapshots/:ts
+	TakenAt        time.Time `json:"taken_at"`
+	VideoCount     int       `json:"video_count"`
+	CompressedSize int64     `json:"compressed_size"`
+}
+
+// catalogSnapshotFile is the gzipped JSON content of a snapshot.
+// VideoCount comes before Videos so listing can stop reading there.
+type catalogSnapshotFile struct {
+	TakenAt    time.Time `json:"taken_at"`
+	VideoCount int       `json:"video_count"`
+	Videos     []*Video  `json:"videos"`
+}
+
+// catalogSnapshotName returns the file name of the snapshot taken at ts
+func catalogSnapshotName(ts int64) string {
+	return fmt.Sprintf("catalog_%d.json.gz", ts)
+}
+
+// parseCatalogSnapshotName returns the timestamp of a snapshot file name
+func parseCatalogSnapshotName(name string) (int64, bool) {
+	var ts int64
+	if _, err := fmt.Sscanf(name, "catalog_%d.json.gz", &ts); err != nil || catalogSnapshotName(ts) != name {
+		return 0, false
+	}
+	return ts, true
+}
+
+func (s *Server) catalogSnapshotPath(ts int64) string {
+	return filepath.Join(s.config.StoragePath, catalogSnapshotDir, catalogSnapshotName(ts))
+}
+
+// takeCatalogSnapshot saves every video as a snapshot taken at now, then
+// removes the oldest snapshots beyond CatalogSnapshotCount. The file is
+// written under a temporary name first, so listing never sees it half
+// written.
+func (s *Server) takeCatalogSnapshot(now time.Time) (CatalogSnapshot, error) {
+	dir := filepath.Join(s.config.StoragePath, catalogSnapshotDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return CatalogSnapshot{}, err
+	}
+
+	videos := s.db.GetAllVideos()
+	content := catalogSnapshotFile{TakenAt: now.UTC(), VideoCount: len(videos), Videos: videos}
+
+	tmp, err := os.CreateTemp(dir, ".catalog-*.tmp")
+	if err != nil {
+		return CatalogSnapshot{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	err = json.NewEncoder(gz).Encode(content)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return CatalogSnapshot{}, err
+	}
+
+	path := s.catalogSnapshotPath(now.Unix())
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return CatalogSnapshot{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return CatalogSnapshot{}, err
+	}
+
+	s.pruneCatalogSnapshots()
+	return CatalogSnapshot{
+		Filename:       filepath.Base(path),
+		Timestamp:      now.Unix(),
+		TakenAt:        content.TakenAt,
+		VideoCount:     content.VideoCount,
+		CompressedSize: info.Size(),
+	}, nil
+}
+
+// pruneCatalogSnapshots removes the oldest snapshots until at most
+// CatalogSnapshotCount are left. 0 keeps them all.
+func (s *Server) pruneCatalogSnapshots() {
+	if s.config.CatalogSnapshotCount <= 0 {
+		return
+	}
+	snapshots, err := s.listCatalogSnapshots()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("failed to list catalog snapshots")
+		return
+	}
+	for i := s.config.CatalogSnapshotCount; i < len(snapshots); i++ {
+		if err := os.Remove(s.catalogSnapshotPath(snapshots[i].Timestamp)); err != nil {
+			s.logger.Error().Err(err).Str("file", snapshots[i].Filename).Msg("failed to remove catalog snapshot")
+		}
+	}
+}
+
+// readCatalogSnapshotHeader reads the time and video count of a snapshot,
+// without decoding its videos
+func readCatalogSnapshotHeader(path string) (time.Time, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	if _, err := decoder.Token(); err != nil {
+		return time.Time{}, 0, err
+	}
+	var takenAt time.Time
+	var count int
+	for i := 0; i < 2; i++ {
+		key, err := decoder.Token()
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		switch key {
+		case "taken_at":
+			err = decoder.Decode(&takenAt)
+		case "video_count":
+			err = decoder.Decode(&count)
+		default:
+			err = fmt.Errorf("unexpected snapshot field %v", key)
+		}
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+	return takenAt, count, nil
+}
+
+// listCatalogSnapshots returns the saved snapshots, newest first. Files
+// that can't be read are logged and left out.
+func (s *Server) listCatalogSnapshots() ([]CatalogSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(s.config.StoragePath, catalogSnapshotDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return []CatalogSnapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []CatalogSnapshot{}
+	for _, entry := range entries {
+		ts, ok := parseCatalogSnapshotName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		takenAt, count, err := readCatalogSnapshotHeader(s.catalogSnapshotPath(ts))
+		if err != nil {
+			s.logger.Warn().Err(err).Str("file", entry.Name()).Msg("skipping unreadable catalog snapshot")
+			continue
+		}
+		snapshots = append(snapshots, CatalogSnapshot{
+			Filename:       entry.Name(),
+			Timestamp:      ts,
+			TakenAt:        takenAt,
+			VideoCount:     count,
+			CompressedSize: info.Size(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp > snapshots[j].Timestamp
+	})
+	return snapshots, nil
+}
+
+// readCatalogSnapshot returns the videos of the snapshot taken at ts
+func (s *Server) readCatalogSnapshot(ts int64) (*catalogSnapshotFile, error) {
+	file, err := os.Open(s.catalogSnapshotPath(ts))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var content catalogSnapshotFile
+	if err := json.NewDecoder(gz).Decode(&content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// catalogSnapshotLoop takes a snapshot every CatalogSnapshotInterval until
+// shutdown
+func (s *Server) catalogSnapshotLoop() {
+	ticker := time.NewTicker(s.config.CatalogSnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.catalogSnapshotStop:
+			return
+		case now := <-ticker.C:
+			snapshot, err := s.takeCatalogSnapshot(now)
+			if err != nil {
+				s.logger.Error().Err(err).Msg("failed to take catalog snapshot")
+				continue
+			}
+			s.logger.Debug().Str("file", snapshot.Filename).Int("videos", snapshot.VideoCount).Msg("took catalog snapshot")
+		}
+	}
+}
+
+// createCatalogSnapshotHandler saves a snapshot of the current catalog
+func (s *Server) createCatalogSnapshotHandler(c *gin.Context) {
+	snapshot, err := s.takeCatalogSnapshot(time.Now())
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to take catalog snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to take catalog snapshot"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("file", snapshot.Filename).
+		Int("videos", snapshot.VideoCount).
+		Msg("catalog snapshot taken")
+
+	s.respondSuccess(c, http.StatusCreated, snapshot)
+}
+
+// listCatalogSnapshotsHandler lists the saved catalog snapshots, newest
+// first
+func (s *Server) listCatalogSnapshotsHandler(c *gin.Context) {
+	snapshots, err := s.listCatalogSnapshots()
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to list catalog snapshots")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list catalog snapshots"})
+		return
+	}
+	s.respondSuccess(c, http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// getCatalogSnapshotHandler returns a page of the videos in a snapshot,
+// paginated like GET /api/videos
+func (s *Server) getCatalogSnapshotHandler(c *gin.Context) {
+	ts, err := strconv.ParseInt(c.Param("ts"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ts must be a unix timestamp"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	content, err := s.readCatalogSnapshot(ts)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "catalog snapshot not found"})
+		return
+	}
+	if err != nil {
+		getLogger(c).Error().Err(err).Int64("timestamp", ts).Msg("failed to read catalog snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read catalog snapshot"})
+		return
+	}
+
+	start := min((page-1)*limit, len(content.Videos))
+	end := min(start+limit, len(content.Videos))
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"timestamp": ts,
+		"taken_at":  content.TakenAt,
+		"videos":    content.Videos[start:end],
+		"total":     len(content.Videos),
+		"page":      page,
+		"limit":     limit,
+	})
+}
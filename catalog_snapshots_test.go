@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogSnapshotCreate(t *testing.T) {
+	server := newTestServer(t)
+	uploadTestVideo(t, server, "first.mp4", []byte("first video"))
+	uploadTestVideo(t, server, "second.mp4", []byte("second video"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/catalog-snapshots", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var snapshot CatalogSnapshot
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snapshot))
+	assert.Equal(t, "catalog_"+strconv.FormatInt(snapshot.Timestamp, 10)+".json.gz", snapshot.Filename)
+	assert.Equal(t, 2, snapshot.VideoCount)
+
+	info, err := os.Stat(filepath.Join(server.config.StoragePath, catalogSnapshotDir, snapshot.Filename))
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), snapshot.CompressedSize)
+
+	content, err := server.readCatalogSnapshot(snapshot.Timestamp)
+	require.NoError(t, err)
+	assert.Len(t, content.Videos, 2)
+}
+
+func TestCatalogSnapshotListAndGet(t *testing.T) {
+	server := newTestServer(t)
+	base := time.Unix(1700000000, 0)
+
+	first := uploadTestVideo(t, server, "first.mp4", []byte("first video"))
+	_, err := server.takeCatalogSnapshot(base)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		uploadTestVideo(t, server, "later"+strconv.Itoa(i)+".mp4", []byte("later video"))
+	}
+	_, err = server.takeCatalogSnapshot(base.Add(time.Hour))
+	require.NoError(t, err)
+
+	// Not snapshots, so left out of the listing
+	dir := filepath.Join(server.config.StoragePath, catalogSnapshotDir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "catalog_1.json.gz"), []byte("not gzip"), 0644))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("/api/admin/catalog-snapshots")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list struct {
+		Snapshots []CatalogSnapshot `json:"snapshots"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Snapshots, 2)
+	assert.Equal(t, base.Add(time.Hour).Unix(), list.Snapshots[0].Timestamp, "newest first")
+	assert.Equal(t, 5, list.Snapshots[0].VideoCount)
+	assert.Equal(t, base.Unix(), list.Snapshots[1].Timestamp)
+	assert.Equal(t, 1, list.Snapshots[1].VideoCount)
+	assert.True(t, base.Equal(list.Snapshots[1].TakenAt))
+	assert.Greater(t, list.Snapshots[1].CompressedSize, int64(0))
+
+	t.Run("Point in time", func(t *testing.T) {
+		w := request("/api/admin/catalog-snapshots/" + strconv.FormatInt(base.Unix(), 10))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Videos []*Video `json:"videos"`
+			Total  int      `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 1, resp.Total)
+		require.Len(t, resp.Videos, 1)
+		assert.Equal(t, first.ID, resp.Videos[0].ID)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		w := request("/api/admin/catalog-snapshots/" + strconv.FormatInt(base.Add(time.Hour).Unix(), 10) + "?page=3&limit=2")
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Videos []*Video `json:"videos"`
+			Total  int      `json:"total"`
+			Page   int      `json:"page"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 5, resp.Total)
+		assert.Equal(t, 3, resp.Page)
+		assert.Len(t, resp.Videos, 1)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request("/api/admin/catalog-snapshots/42").Code)
+		assert.Equal(t, http.StatusBadRequest, request("/api/admin/catalog-snapshots/yesterday").Code)
+		assert.Equal(t, http.StatusInternalServerError, request("/api/admin/catalog-snapshots/1").Code)
+	})
+}
+
+func TestCatalogSnapshotRetention(t *testing.T) {
+	server := newTestServer(t)
+	server.config.CatalogSnapshotCount = 2
+	uploadTestVideo(t, server, "video.mp4", []byte("video"))
+
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 4; i++ {
+		_, err := server.takeCatalogSnapshot(base.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, err)
+	}
+
+	snapshots, err := server.listCatalogSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, base.Add(3*time.Minute).Unix(), snapshots[0].Timestamp)
+	assert.Equal(t, base.Add(2*time.Minute).Unix(), snapshots[1].Timestamp)
+
+	entries, err := os.ReadDir(filepath.Join(server.config.StoragePath, catalogSnapshotDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
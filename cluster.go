@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// virtualNodesPerNode is the number of ring positions per cluster node
+	virtualNodesPerNode = 128
+
+	// clusterForwardedHeader marks requests already proxied by another node
+	// so a misconfigured ring can never bounce a request back and forth
+	clusterForwardedHeader = "X-VidServer-Forwarded"
+)
+
+// ringEntry is one virtual node on the hash ring
+type ringEntry struct {
+	hash uint32
+	node string
+}
+
+// ConsistentHashRouter maps video IDs to cluster nodes using a hash ring
+// with virtual nodes, so adding or removing a node only remaps ~1/N of keys
+type ConsistentHashRouter struct {
+	ring []ringEntry // sorted by hash
+}
+
+// NewConsistentHashRouter builds a ring for the given node URLs
+func NewConsistentHashRouter(nodes []string) *ConsistentHashRouter {
+	ring := make([]ringEntry, 0, len(nodes)*virtualNodesPerNode)
+	for _, node := range nodes {
+		for i := 0; i < virtualNodesPerNode; i++ {
+			// The index leads the key: FNV spreads keys that differ only in
+			// their last bytes poorly, which skews the ring
+			ring = append(ring, ringEntry{
+				hash: ringHash(fmt.Sprintf("%d#%s", i, node)),
+				node: node,
+			})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].node < ring[j].node
+	})
+
+	return &ConsistentHashRouter{ring: ring}
+}
+
+// Route returns the node responsible for videoID, or "" if the ring is empty
+func (r *ConsistentHashRouter) Route(videoID string) string {
+	if len(r.ring) == 0 {
+		return ""
+	}
+
+	hash := ringHash(videoID)
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].hash >= hash
+	})
+	if i == len(r.ring) {
+		i = 0 // wrap around the ring
+	}
+	return r.ring[i].node
+}
+
+// ringHash hashes a key onto the ring
+func ringHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// proxyToOwner forwards the request to the node responsible for videoID when
+// that is not this node. It returns true if the request was proxied.
+func (s *Server) proxyToOwner(c *gin.Context, videoID string) bool {
+	if s.nodeRouter == nil || c.GetHeader(clusterForwardedHeader) != "" {
+		return false
+	}
+
+	owner := s.nodeRouter.Route(videoID)
+	if owner == "" || owner == s.config.NodeID {
+		return false
+	}
+
+	target, err := url.Parse(strings.TrimSuffix(owner, "/"))
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("node", owner).Msg("invalid cluster node URL")
+		return false
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		getLogger(c).Error().Err(err).Str("node", owner).Str("video_id", videoID).Msg("failed to proxy request to owning node")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "owning node unavailable"})
+	}
+
+	c.Request.Header.Set(clusterForwardedHeader, s.config.NodeID)
+	proxy.ServeHTTP(c.Writer, c.Request)
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistentHashDistribution(t *testing.T) {
+	nodes := []string{"http://node-a:8080", "http://node-b:8080", "http://node-c:8080"}
+	router := NewConsistentHashRouter(nodes)
+
+	const keys = 30000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[router.Route(fmt.Sprintf("video-%d", i))]++
+	}
+
+	require.Len(t, counts, len(nodes))
+	for _, node := range nodes {
+		share := float64(counts[node]) / keys
+		assert.InDelta(t, 1.0/3, share, 0.08, "share of %s", node)
+	}
+}
+
+func TestConsistentHashAddNodeRemapsFraction(t *testing.T) {
+	nodes := []string{"http://node-a:8080", "http://node-b:8080", "http://node-c:8080"}
+	before := NewConsistentHashRouter(nodes)
+	after := NewConsistentHashRouter(append(nodes, "http://node-d:8080"))
+
+	const keys = 30000
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("video-%d", i)
+		oldNode, newNode := before.Route(key), after.Route(key)
+		if oldNode != newNode {
+			moved++
+			// Keys only ever move to the new node
+			assert.Equal(t, "http://node-d:8080", newNode)
+		}
+	}
+
+	assert.InDelta(t, 0.25, float64(moved)/keys, 0.08)
+}
+
+func TestConsistentHashEmptyRing(t *testing.T) {
+	assert.Equal(t, "", NewConsistentHashRouter(nil).Route("video"))
+}
+
+func TestDownloadProxiesToOwningNode(t *testing.T) {
+	var forwardedBy string
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedBy = r.Header.Get(clusterForwardedHeader)
+		w.Write([]byte("from owner " + r.URL.Path))
+	}))
+	defer owner.Close()
+
+	server := newTestServer(t)
+	server.config.NodeID = "http://self:8080"
+	server.config.ClusterNodes = []string{server.config.NodeID, owner.URL}
+	server.nodeRouter = NewConsistentHashRouter(server.config.ClusterNodes)
+
+	// Find one video ID owned by each node
+	var remoteID, localID string
+	for i := 0; remoteID == "" || localID == ""; i++ {
+		id := fmt.Sprintf("video-%d", i)
+		if server.nodeRouter.Route(id) == owner.URL {
+			remoteID = id
+		} else {
+			localID = id
+		}
+	}
+
+	// The reverse proxy needs a real connection, httptest.ResponseRecorder
+	// does not implement http.CloseNotifier
+	local := httptest.NewServer(server.router)
+	defer local.Close()
+
+	resp, err := http.Get(local.URL + "/api/videos/" + remoteID)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "from owner /api/videos/"+remoteID, string(body))
+	assert.Equal(t, server.config.NodeID, forwardedBy)
+
+	// Videos owned by this node are served locally
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+localID, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Already forwarded requests are never proxied again
+	req = httptest.NewRequest(http.MethodGet, "/api/videos/"+remoteID, nil)
+	req.Header.Set(clusterForwardedHeader, "http://other:8080")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// commentsFile is where comments are saved in StoragePath
+const commentsFile = "comments.json"
+
+// ErrCommentNotFound is returned for comments that don't exist on a video
+var ErrCommentNotFound = errors.New("comment not found")
+
+// Comment is a review note on a video, optionally pinned to a position
+type Comment struct {
+	ID          string    `json:"id"`
+	VideoID     string    `json:"video_id"`
+	AuthorKey   string    `json:"author_key,omitempty"`   // ID of the author's Principal
+	Text        string    `json:"text"`
+	TimeSeconds *float64  `json:"time_seconds,omitempty"` // position in the video
+	CreatedAt   time.Time `json:"created_at"`
+	Resolved    bool      `json:"resolved"`
+}
+
+// CommentStore holds comments by video, saved to a JSON file after every
+// change
+type CommentStore struct {
+	path     string                // empty for a store that is not saved
+	comments map[string][]*Comment // video ID -> comments
+	mutex    sync.RWMutex
+}
+
+// NewCommentStore creates a comment store saved to path, loading the
+// comments already there. An empty path keeps comments in memory only.
+func NewCommentStore(path string) (*CommentStore, error) {
+	cs := &CommentStore{path: path, comments: make(map[string][]*Comment)}
+	if path == "" {
+		return cs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []*Comment
+	if err := json.Unmarshal(data, &comments); err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		cs.comments[comment.VideoID] = append(cs.comments[comment.VideoID], comment)
+	}
+	return cs, nil
+}
+
+// save writes every comment to disk. The caller must hold the write lock.
+func (cs *CommentStore) save() error {
+	if cs.path == "" {
+		return nil
+	}
+
+	comments := make([]*Comment, 0)
+	for _, videoComments := range cs.comments {
+		comments = append(comments, videoComments...)
+	}
+	data, err := json.Marshal(comments)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cs.path, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// Add stores a new comment
+func (cs *CommentStore) Add(comment *Comment) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.comments[comment.VideoID] = append(cs.comments[comment.VideoID], comment)
+	return cs.save()
+}
+
+// List returns copies of a video's comments in playback order: comments
+// pinned to a position come first, earliest position first, then the rest.
+// Ties are broken by creation time. A non-nil resolved filters by status.
+func (cs *CommentStore) List(videoID string, resolved *bool) []*Comment {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	comments := make([]*Comment, 0, len(cs.comments[videoID]))
+	for _, comment := range cs.comments[videoID] {
+		if resolved != nil && comment.Resolved != *resolved {
+			continue
+		}
+		commentCopy := *comment
+		comments = append(comments, &commentCopy)
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		a, b := comments[i].TimeSeconds, comments[j].TimeSeconds
+		switch {
+		case a != nil && b != nil && *a != *b:
+			return *a < *b
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	return comments
+}
+
+// Update changes a comment's text and/or resolved status, leaving nil
+// fields untouched, and returns the updated comment
+func (cs *CommentStore) Update(videoID, commentID string, text *string, resolved *bool) (*Comment, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	for i, comment := range cs.comments[videoID] {
+		if comment.ID != commentID {
+			continue
+		}
+
+		// Replaced rather than modified, List copies may be in use
+		updated := *comment
+		if text != nil {
+			updated.Text = *text
+		}
+		if resolved != nil {
+			updated.Resolved = *resolved
+		}
+		cs.comments[videoID][i] = &updated
+
+		result := updated
+		return &result, cs.save()
+	}
+	return nil, ErrCommentNotFound
+}
+
+// Delete removes a comment
+func (cs *CommentStore) Delete(videoID, commentID string) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	comments := cs.comments[videoID]
+	for i, comment := range comments {
+		if comment.ID != commentID {
+			continue
+		}
+
+		remaining := make([]*Comment, 0, len(comments)-1)
+		remaining = append(remaining, comments[:i]...)
+		remaining = append(remaining, comments[i+1:]...)
+		if len(remaining) == 0 {
+			delete(cs.comments, videoID)
+		} else {
+			cs.comments[videoID] = remaining
+		}
+		return cs.save()
+	}
+	return ErrCommentNotFound
+}
+
+// DeleteVideo removes every comment on a video
+func (cs *CommentStore) DeleteVideo(videoID string) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if _, exists := cs.comments[videoID]; !exists {
+		return nil
+	}
+	delete(cs.comments, videoID)
+	return cs.save()
+}
+
+// addCommentHandler adds a comment to a video
+func (s *Server) addCommentHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	var req struct {
+		Text        string   `json:"text" binding:"required"`
+		TimeSeconds *float64 `json:"time_seconds" binding:"omitempty,min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, exists := s.db.GetVideoByID(videoID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	var author string
+	if principal := principalFrom(c); principal != nil {
+		author = principal.ID
+	}
+
+	comment := &Comment{
+		ID:          newUUIDv7().String(),
+		VideoID:     videoID,
+		AuthorKey:   author,
+		Text:        req.Text,
+		TimeSeconds: req.TimeSeconds,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.comments.Add(comment); err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to save comments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save comment"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("video_id", videoID).
+		Str("comment_id", comment.ID).
+		Msg("comment added")
+
+	s.webhookMgr.NotifyWebhooksContext(c.Request.Context(), EventVideoCommentAdded, CommentAddedPayload{
+		SchemaVersion: WebhookPayloadSchemaVersion,
+		Event:         EventVideoCommentAdded,
+		Timestamp:     comment.CreatedAt.Unix(),
+		VideoID:       videoID,
+		Comment:       comment,
+	})
+
+	s.respondSuccess(c, http.StatusCreated, gin.H{
+		"success": true,
+		"comment": comment,
+	})
+}
+
+// getCommentsHandler returns a page of a video's comments in playback
+// order, optionally only resolved or unresolved ones
+func (s *Server) getCommentsHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var resolved *bool
+	if value := c.Query("resolved"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "resolved must be true or false"})
+			return
+		}
+		resolved = &parsed
+	}
+
+	if _, exists := s.db.GetVideoByID(videoID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	comments := s.comments.List(videoID, resolved)
+
+	start := (page - 1) * limit
+	if start > len(comments) {
+		start = len(comments)
+	}
+	end := start + limit
+	if end > len(comments) {
+		end = len(comments)
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success":  true,
+		"comments": comments[start:end],
+		"total":    len(comments),
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// updateCommentHandler changes a comment's text or resolved status
+func (s *Server) updateCommentHandler(c *gin.Context) {
+	videoID, commentID := c.Param("id"), c.Param("cid")
+
+	var req struct {
+		Text     *string `json:"text" binding:"omitempty,min=1"`
+		Resolved *bool   `json:"resolved"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Text == nil && req.Resolved == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text or resolved is required"})
+		return
+	}
+
+	comment, err := s.comments.Update(videoID, commentID, req.Text, req.Resolved)
+	if errors.Is(err, ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		return
+	}
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to save comments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save comment"})
+		return
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success": true,
+		"comment": comment,
+	})
+}
+
+// deleteCommentHandler removes a comment
+func (s *Server) deleteCommentHandler(c *gin.Context) {
+	videoID, commentID := c.Param("id"), c.Param("cid")
+
+	err := s.comments.Delete(videoID, commentID)
+	if errors.Is(err, ErrCommentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		return
+	}
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to save comments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete comment"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("video_id", videoID).
+		Str("comment_id", commentID).
+		Msg("comment deleted")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success": true,
+		"message": "comment deleted successfully",
+	})
+}
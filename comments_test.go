@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type commentsResponse struct {
+	Comments []Comment `json:"comments"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	Limit    int       `json:"limit"`
+}
+
+func commentRequest(t *testing.T, server *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func addTestComment(t *testing.T, server *Server, videoID, body string) Comment {
+	t.Helper()
+
+	w := commentRequest(t, server, http.MethodPost, "/api/videos/"+videoID+"/comments", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var resp struct {
+		Comment Comment `json:"comment"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Comment
+}
+
+func listTestComments(t *testing.T, server *Server, videoID, query string) commentsResponse {
+	t.Helper()
+
+	w := commentRequest(t, server, http.MethodGet, "/api/videos/"+videoID+"/comments"+query, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp commentsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestCommentCRUD(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "review.mp4", []byte("content"))
+	base := "/api/videos/" + video.ID + "/comments"
+
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.comment_added", receiver.server.URL))
+
+	comment := addTestComment(t, server, video.ID, `{"text": "audio drops out", "time_seconds": 12.5}`)
+	assert.NotEmpty(t, comment.ID)
+	assert.Equal(t, video.ID, comment.VideoID)
+	require.NotNil(t, comment.TimeSeconds)
+	assert.Equal(t, 12.5, *comment.TimeSeconds)
+	assert.False(t, comment.Resolved)
+
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	require.Equal(t, 1, receiver.count())
+	assert.Equal(t, "video.comment_added", receiver.payloads[0]["event"])
+	assert.Equal(t, video.ID, receiver.payloads[0]["video_id"])
+
+	assert.Equal(t, http.StatusBadRequest, commentRequest(t, server, http.MethodPost, base, `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, commentRequest(t, server, http.MethodPost, "/api/videos/missing/comments", `{"text": "x"}`).Code)
+
+	w := commentRequest(t, server, http.MethodPatch, base+"/"+comment.ID, `{"text": "audio drops out at the cut", "resolved": true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, commentRequest(t, server, http.MethodPatch, base+"/missing", `{"resolved": true}`).Code)
+	assert.Equal(t, http.StatusBadRequest, commentRequest(t, server, http.MethodPatch, base+"/"+comment.ID, `{}`).Code)
+
+	resp := listTestComments(t, server, video.ID, "")
+	require.Len(t, resp.Comments, 1)
+	assert.Equal(t, "audio drops out at the cut", resp.Comments[0].Text)
+	assert.True(t, resp.Comments[0].Resolved)
+	assert.Empty(t, listTestComments(t, server, video.ID, "?resolved=false").Comments)
+	assert.Len(t, listTestComments(t, server, video.ID, "?resolved=true").Comments, 1)
+
+	// Comments survive a restart
+	reloaded, err := NewCommentStore(filepath.Join(server.config.StoragePath, "comments.json"))
+	require.NoError(t, err)
+	assert.Len(t, reloaded.List(video.ID, nil), 1)
+
+	assert.Equal(t, http.StatusOK, commentRequest(t, server, http.MethodDelete, base+"/"+comment.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, commentRequest(t, server, http.MethodDelete, base+"/"+comment.ID, "").Code)
+	assert.Zero(t, listTestComments(t, server, video.ID, "").Total)
+
+	// Deleting the video deletes its comments
+	addTestComment(t, server, video.ID, `{"text": "orphan"}`)
+	assert.Equal(t, http.StatusOK, commentRequest(t, server, http.MethodDelete, "/api/videos/"+video.ID, "").Code)
+	assert.Empty(t, server.comments.List(video.ID, nil))
+}
+
+func TestCommentsPagination(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "paged.mp4", []byte("content"))
+
+	for i := 0; i < 25; i++ {
+		addTestComment(t, server, video.ID, fmt.Sprintf(`{"text": "comment %d", "time_seconds": %d}`, i, i))
+	}
+
+	first := listTestComments(t, server, video.ID, "?limit=10")
+	assert.Equal(t, 25, first.Total)
+	require.Len(t, first.Comments, 10)
+	assert.Equal(t, "comment 0", first.Comments[0].Text)
+
+	last := listTestComments(t, server, video.ID, "?page=3&limit=10")
+	require.Len(t, last.Comments, 5)
+	assert.Equal(t, "comment 24", last.Comments[4].Text)
+
+	assert.Empty(t, listTestComments(t, server, video.ID, "?page=4&limit=10").Comments)
+}
+
+func TestCommentsSortedByTime(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "sorted.mp4", []byte("content"))
+
+	addTestComment(t, server, video.ID, `{"text": "general"}`)
+	addTestComment(t, server, video.ID, `{"text": "late", "time_seconds": 90}`)
+	addTestComment(t, server, video.ID, `{"text": "early", "time_seconds": 3.5}`)
+	addTestComment(t, server, video.ID, `{"text": "middle", "time_seconds": 30}`)
+
+	var texts []string
+	for _, comment := range listTestComments(t, server, video.ID, "").Comments {
+		texts = append(texts, comment.Text)
+	}
+	assert.Equal(t, []string{"early", "middle", "late", "general"}, texts)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compactMinFileAge keeps recently written files out of compaction, so an
+// upload whose record has not been added yet is not removed
+const compactMinFileAge = time.Hour
+
+// CompactionResult reports the files removed from StoragePath by compaction
+type CompactionResult struct {
+	DryRun         bool     `json:"dry_run"`
+	Files          []string `json:"files"` // names of the removed files
+	FilesRemoved   int      `json:"files_removed"`
+	BytesReclaimed int64    `json:"bytes_reclaimed"`
+	DurationMs     int64    `json:"duration_ms"`
+}
+
+// isStorageMetadataFile reports whether name is one of the server's own
+// files in StoragePath rather than a video file
+func isStorageMetadataFile(name string) bool {
+	name = strings.TrimSuffix(name, ".lock")
+	// SQLite keeps its write-ahead log next to the database
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "-wal"), "-shm")
+	return name == jsonDatabaseFile || name == boltDatabaseFile || name == sqliteDatabaseFile || name == commentsFile || name == videoEventsFile
+}
+
+// compactStorage removes the files directly in StoragePath that belong to
+// no video record. Directories such as previews and sprites, hidden
+// temporary files and the server's metadata files are left alone. With
+// dryRun the files are only listed.
+func (s *Server) compactStorage(dryRun bool, now time.Time) (*CompactionResult, error) {
+	entries, err := os.ReadDir(s.config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]bool)
+	for _, video := range s.db.GetAllVideos() {
+		expected[fileKey(video.ID, video.Name)] = true
+	}
+
+	result := &CompactionResult{DryRun: dryRun, Files: []string{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || expected[name] || isStorageMetadataFile(name) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || now.Sub(info.ModTime()) < compactMinFileAge {
+			continue
+		}
+
+		removed, err := s.removeUnreferencedFile(name, dryRun)
+		if err != nil {
+			s.logger.Error().Err(err).Str("file", name).Msg("failed to remove unreferenced file")
+			continue
+		}
+		if !removed {
+			continue
+		}
+		if !dryRun {
+			s.logger.Info().Str("file", name).Int64("size", info.Size()).Msg("removed unreferenced file")
+		}
+
+		result.Files = append(result.Files, name)
+		result.FilesRemoved++
+		result.BytesReclaimed += info.Size()
+	}
+
+	sort.Strings(result.Files)
+	return result, nil
+}
+
+// removeUnreferencedFile removes a file in StoragePath unless a video now
+// refers to it, reporting whether it was unreferenced. A renamed file keeps
+// the upload time as its modification time, so the record is checked again
+// under the video's file lock rather than trusting the listing. With dryRun
+// the file is only checked.
+func (s *Server) removeUnreferencedFile(name string, dryRun bool) (bool, error) {
+	videoID, _, _ := strings.Cut(name, "_")
+	defer s.fileLocks.Lock(videoID)()
+
+	if video, exists := s.db.GetVideoByID(videoID); exists && fileKey(video.ID, video.Name) == name {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	return true, os.Remove(filepath.Join(s.config.StoragePath, name))
+}
+
+// compactStorageHandler removes files in StoragePath no video refers to,
+// such as leftovers of overwritten or deleted videos
+func (s *Server) compactStorageHandler(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return
+	}
+
+	start := time.Now()
+	result, err := s.compactStorage(dryRun, start)
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to compact storage")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compact storage"})
+		return
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	getLogger(c).Info().
+		Bool("dry_run", dryRun).
+		Int("files_removed", result.FilesRemoved).
+		Int64("bytes_reclaimed", result.BytesReclaimed).
+		Msg("storage compacted")
+
+	s.respondSuccess(c, http.StatusOK, result)
+}
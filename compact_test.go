@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactStorage(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "live.mp4", []byte("live"))
+
+	storage := server.config.StoragePath
+	old := time.Now().Add(-2 * compactMinFileAge)
+	writeFile := func(name, content string, modTime time.Time) {
+		path := filepath.Join(storage, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	writeFile("deleted-id_gone.mp4", "12345", old)
+	writeFile("stray.bin", "123", old)
+	writeFile("uploading-id_new.mp4", "in progress", time.Now())
+	writeFile(jsonDatabaseFile, "{}", old)
+	writeFile(commentsFile, "{}", old)
+	writeFile(".tmp-123", "partial", old)
+	require.NoError(t, os.Mkdir(filepath.Join(storage, previewDir), 0755))
+	require.NoError(t, os.Chtimes(server.getFilePath(video.ID, video.Name), old, old))
+
+	compact := func(query string) CompactionResult {
+		w := postJSON(server, "/api/admin/compact"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var result CompactionResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+	remaining := func() []string {
+		entries, err := os.ReadDir(storage)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+	before := remaining()
+
+	result := compact("?dry_run=true")
+	assert.True(t, result.DryRun)
+	assert.Equal(t, []string{"deleted-id_gone.mp4", "stray.bin"}, result.Files)
+	assert.Equal(t, 2, result.FilesRemoved)
+	assert.Equal(t, int64(8), result.BytesReclaimed)
+	assert.Equal(t, before, remaining(), "dry run must not delete")
+
+	result = compact("")
+	assert.False(t, result.DryRun)
+	assert.Equal(t, 2, result.FilesRemoved)
+	assert.Equal(t, int64(8), result.BytesReclaimed)
+	assert.ElementsMatch(t, []string{
+		fileKey(video.ID, video.Name),
+		"uploading-id_new.mp4",
+		jsonDatabaseFile,
+		commentsFile,
+		videoEventsFile,
+		".tmp-123",
+		previewDir,
+	}, remaining())
+
+	assert.Equal(t, 0, compact("").FilesRemoved)
+	assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/admin/compact?dry_run=maybe", "").Code)
+}
+
+func TestCompactStorageDuringRename(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "before.mp4", []byte("content"))
+	old := time.Now().Add(-2 * compactMinFileAge)
+
+	// Midway through a rename the file has moved, keeping its old
+	// modification time, but the record still has the old name
+	unlock := server.fileLocks.Lock(video.ID)
+	newPath := server.getFilePath(video.ID, "after.mp4")
+	require.NoError(t, renameVideoFile(server.getFilePath(video.ID, video.Name), newPath, old))
+
+	done := make(chan *CompactionResult)
+	go func() {
+		result, err := server.compactStorage(false, time.Now())
+		assert.NoError(t, err)
+		done <- result
+	}()
+
+	// Compaction waits for the rename before deciding about the file
+	require.Eventually(t, func() bool {
+		server.fileLocks.mutex.Lock()
+		defer server.fileLocks.mutex.Unlock()
+		lock := server.fileLocks.locks[video.ID]
+		return lock != nil && lock.refs == 2
+	}, 5*time.Second, time.Millisecond)
+	_, err := server.db.UpdateVideoFunc(video.ID, func(v *Video) error {
+		v.Name = "after.mp4"
+		return nil
+	})
+	require.NoError(t, err)
+	unlock()
+
+	result := <-done
+	assert.Zero(t, result.FilesRemoved)
+	assert.FileExists(t, newPath)
+}
@@ -1,38 +1,440 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// Config layers, from lowest to highest precedence
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "file"
+	ConfigSourceEnv     = "env"
+	ConfigSourceFlag    = "flag"
+)
+
+// configFieldIndex maps each config key to its Config field index
+var configFieldIndex = configKeyIndex(reflect.TypeOf(Config{}))
+
+// configKeyIndex maps the config tags of t's fields to their index
+func configKeyIndex(t reflect.Type) map[string]int {
+	index := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("config"); key != "" {
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// ConfigLayer is a source of config values, keyed like the environment
+// variables, e.g. SERVER_PORT
+type ConfigLayer interface {
+	// Name is recorded in Config.Source for the keys the layer sets
+	Name() string
+	// Get returns the value the layer sets for key, false when it sets none
+	Get(key string) (string, bool)
+}
+
+// envLayer reads environment variables. Empty variables are not set.
+type envLayer struct{}
+
+func (envLayer) Name() string {
+	return ConfigSourceEnv
+}
+
+func (envLayer) Get(key string) (string, bool) {
+	value := os.Getenv(key)
+	return value, value != ""
+}
+
+// mapLayer holds the values of a config file or the command line flags
+type mapLayer struct {
+	name   string
+	values map[string]string
+}
+
+func (l mapLayer) Name() string {
+	return l.name
+}
+
+func (l mapLayer) Get(key string) (string, bool) {
+	value, ok := l.values[key]
+	return value, ok
+}
+
+// defaultConfig returns the configuration used when no layer sets a key
+func defaultConfig() *Config {
+	config := &Config{
+		ServerPort:      "8080",
+		StoragePath:     "./storage",
+		DBBackend:       "memory",
+		DBLockTimeout:   5 * time.Second,
+		MaxFileSize:     1024 * 1024 * 500, // 500MB
+		LogFormat:       LogFormatConsole,
+		LogLevel:        "info",
+		ShutdownTimeout: 30 * time.Second,
+		HashCacheTTL:    5 * time.Minute,
+		StreamChunkSize: 256 * 1024, // 256KB
+
+		PersistenceBackend: PersistenceJSON,
+
+		SegmentCacheSize:   256 * 1024 * 1024, // 256MB
+		MaxCachableSegment: 2 * 1024 * 1024,   // 2MB
+
+		DuplicateNameStrategy: DuplicateNameAllow,
+
+		MaxWebhooksPerEvent: 50,
+		MaxTotalWebhooks:    500,
+		MaxEventsPerURL:     10,
+
+		WebhookSchemaVersion: "1",
+
+		WebhookHealthCheckTimeout: 2 * time.Second,
+
+		WebhookBurstPerURL: 10,
+		WebhookQueueSize:   100,
+
+		FFmpegPath:         "ffmpeg",
+		PreviewDuration:    30,
+		PreviewMaxDuration: 300,
+
+		SpriteInterval: 10,
+
+		PreloadConcurrency: 4,
+
+		ProbeMaxBytes: defaultProbeMaxBytes,
+
+		ReadAheadSize: defaultReadAheadSize,
+
+		IntegrityCheckWorkers: 8,
+
+		MigrationWorkers:  2,
+		MetadataCacheSize: 10000,
+		HashWorkers:       2,
+		HashQueueSize:     100,
+
+		RetentionPoliciesFile:  "retention_policies.json",
+		RetentionCheckInterval: time.Hour,
+
+		BillingInterval: time.Hour,
+
+		UploadJobTTL:             24 * time.Hour,
+		FailedJobTTL:             7 * 24 * time.Hour,
+		UploadJobCleanupInterval: time.Hour,
+
+		TusUploadExpiry:    24 * time.Hour,
+		TusCleanupInterval: 10 * time.Minute,
+
+		CatalogSnapshotCount: 24,
+
+		NonceWindowSeconds: defaultNonceWindowSeconds,
+		DownloadSessionTTL: time.Hour,
+
+		RateLimitWindow: time.Minute,
+
+		AuthMode: authModeAPIKey,
+
+		MessageQueueDriver: messageQueueNone,
+
+		CSPHeader: defaultCSPHeader,
+
+		ResponseEnvelopeStyle: ResponseEnvelopeFlat,
+	}
+
+	config.Source = make(map[string]string, len(configFieldIndex))
+	for key := range configFieldIndex {
+		config.Source[key] = ConfigSourceDefault
+	}
+	return config
+}
+
+// Apply returns a copy of base with the keys layer sets overridden. Values
+// that can't be parsed keep the value of base.
+func Apply(base *Config, layer ConfigLayer) *Config {
+	config := *base
+	config.Source = maps.Clone(base.Source)
+	if config.Source == nil {
+		config.Source = make(map[string]string)
+	}
+
+	fields := reflect.ValueOf(&config).Elem()
+	for key, index := range configFieldIndex {
+		value, ok := layer.Get(key)
+		if !ok {
+			continue
+		}
+		if err := setConfigField(fields.Field(index), key, value); err != nil {
+			fmt.Printf("Warning: Invalid value for %s from %s, ignoring: %v\n", key, layer.Name(), err)
+			continue
+		}
+		config.Source[key] = layer.Name()
+	}
+	return &config
+}
+
+// setConfigField parses value into a config field. Durations are given in
+// seconds, lists are comma-separated and booleans are true only for "true".
+func setConfigField(field reflect.Value, key, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(time.Duration(seconds) * time.Second))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		field.SetBool(value == "true")
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items := splitConfigList(value)
+		switch field.Type().Elem().Kind() {
+		case reflect.String:
+			field.Set(reflect.ValueOf(items))
+		case reflect.Int:
+			var ints []int
+			for _, item := range items {
+				n, err := strconv.Atoi(item)
+				if err != nil {
+					fmt.Printf("Warning: Invalid value %q in %s, ignoring\n", item, key)
+					continue
+				}
+				ints = append(ints, n)
+			}
+			field.Set(reflect.ValueOf(ints))
+		default:
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// splitConfigList splits a comma-separated value, dropping empty items
+func splitConfigList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // LoadConfig loads configuration from environment variables or uses defaults
 func LoadConfig() *Config {
-	config := &Config{
-		ServerPort:    getEnvOrDefault("SERVER_PORT", "8080"),
-		StoragePath:   getEnvOrDefault("STORAGE_PATH", "./storage"),
-		MaxFileSize:   parseInt64EnvOrDefault("MAX_FILE_SIZE", 1024*1024*500), // 500MB
-		EnableLogging: getEnvOrDefault("ENABLE_LOGGING", "true") == "true",
+	return loadConfigLayers(envLayer{})
+}
+
+// LoadConfigArgs loads configuration from the command line. Each layer
+// overrides the ones before it: the defaults, the JSON file given with
+// --config, environment variables and flags such as --server-port=9090.
+func LoadConfigArgs(args []string) (*Config, error) {
+	flags, configFile, err := parseConfigFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []ConfigLayer
+	if configFile != "" {
+		file, err := loadConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, file)
+	}
+	layers = append(layers, envLayer{}, flags)
+	return loadConfigLayers(layers...), nil
+}
+
+// loadConfigLayers applies layers over the defaults, then checks and
+// normalizes the result
+func loadConfigLayers(layers ...ConfigLayer) *Config {
+	config := defaultConfig()
+	for _, layer := range layers {
+		config = Apply(config, layer)
+	}
+
+	// ENABLE_LOGGING=false is still honoured when LOG_FORMAT is unset and
+	// selects json, the format it used to give
+	if config.Source["LOG_FORMAT"] == ConfigSourceDefault && os.Getenv("ENABLE_LOGGING") == "false" {
+		config.LogFormat = LogFormatJSON
+	}
+
+	if err := validateLogFormat(config.LogFormat); err != nil {
+		fmt.Printf("Warning: Invalid LOG_FORMAT, using %s: %v\n", LogFormatConsole, err)
+		config.LogFormat = LogFormatConsole
+	}
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		fmt.Printf("Warning: Invalid LOG_LEVEL, using info: %v\n", err)
+		config.LogLevel = "info"
+	}
+	// The nonce store evicts on a ticker of twice the window, which must
+	// be positive
+	if config.NonceWindowSeconds <= 0 {
+		fmt.Printf("Warning: Invalid NONCE_WINDOW_SECONDS %d, using %d\n", config.NonceWindowSeconds, defaultNonceWindowSeconds)
+		config.NonceWindowSeconds = defaultNonceWindowSeconds
+	}
+
+	for i, ext := range config.AllowedExtensions {
+		config.AllowedExtensions[i] = normalizeExtension(ext)
+	}
+	for i, contentType := range config.AllowedContentTypes {
+		config.AllowedContentTypes[i] = strings.ToLower(contentType)
 	}
-	
+
+	policies, err := loadRetentionPolicies(config.RetentionPoliciesFile)
+	if err != nil {
+		fmt.Printf("Warning: Invalid retention policies in %s, ignoring: %v\n", config.RetentionPoliciesFile, err)
+	}
+	config.RetentionPolicies = policies
+
 	return config
 }
 
-// getEnvOrDefault returns the value of an environment variable or a default value
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// configFlagName is the command line flag of a config key, e.g.
+// --server-port for SERVER_PORT
+func configFlagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
+
+// parseConfigFlags parses the command line into a layer of the flags that
+// were given and the --config file path
+func parseConfigFlags(args []string) (ConfigLayer, string, error) {
+	flags := flag.NewFlagSet("video-server", flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON config file, keyed like the environment variables")
+
+	keys := make([]string, 0, len(configFieldIndex))
+	for key := range configFieldIndex {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	flagKeys := make(map[string]string, len(keys))
+	for _, key := range keys {
+		flagKeys[configFlagName(key)] = key
+		flags.String(configFlagName(key), "", "overrides "+key)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if flags.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	values := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			values[key] = f.Value.String()
+		}
+	})
+	return mapLayer{name: ConfigSourceFlag, values: values}, *configFile, nil
+}
+
+// loadConfigFile reads a JSON object of config keys. Values may be strings,
+// numbers, booleans or arrays for lists; null leaves a key unset.
+func loadConfigFile(path string) (ConfigLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
-	return defaultValue
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if _, ok := configFieldIndex[key]; !ok {
+			return nil, fmt.Errorf("invalid config file %s: unknown key %q", path, key)
+		}
+		if value == nil {
+			continue
+		}
+		text, err := configFileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %s: %w", path, key, err)
+		}
+		values[key] = text
+	}
+	return mapLayer{name: ConfigSourceFile, values: values}, nil
 }
 
-// parseInt64EnvOrDefault returns the value of an environment variable parsed as int64 or a default value
-func parseInt64EnvOrDefault(key string, defaultValue int64) int64 {
-	if valueStr := os.Getenv(key); valueStr != "" {
-		if value, err := strconv.ParseInt(valueStr, 10, 64); err == nil {
-			return value
+// configFileValue formats a config file value the way it would be set in
+// the environment
+func configFileValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			if _, isList := item.([]interface{}); isList {
+				return "", errors.New("lists can't be nested")
+			}
+			text, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
 		}
-		fmt.Printf("Warning: Invalid value for %s, using default\n", key)
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("must be a string, number, boolean or list")
 	}
-	return defaultValue
-}
\ No newline at end of file
+}
+
+// overriddenSources returns the layer of each key not left at its default,
+// for the startup log
+func (c *Config) overriddenSources() map[string]string {
+	sources := make(map[string]string)
+	for key, source := range c.Source {
+		if source != ConfigSourceDefault {
+			sources[key] = source
+		}
+	}
+	return sources
+}
+
+// normalizeExtension lower-cases an extension and ensures it has a leading dot
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes a JSON config file and returns its path
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"SERVER_PORT": "7000",
+		"MAX_FILE_SIZE": 1024,
+		"SHUTDOWN_TIMEOUT_SECONDS": 5,
+		"ALLOWED_EXTENSIONS": ["MP4", "mov"],
+		"ENABLE_DEBUG_ROUTES": true
+	}`)
+	t.Setenv("SERVER_PORT", "8000")
+	t.Setenv("MAX_FILE_SIZE", "2048")
+
+	config, err := LoadConfigArgs([]string{"--config", path, "--server-port=9000"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "9000", config.ServerPort, "flags override everything")
+	assert.Equal(t, int64(2048), config.MaxFileSize, "env overrides the file")
+	assert.Equal(t, 5*time.Second, config.ShutdownTimeout, "the file overrides the default")
+	assert.Equal(t, []string{".mp4", ".mov"}, config.AllowedExtensions)
+	assert.True(t, config.EnableDebugRoutes)
+	assert.Equal(t, "./storage", config.StoragePath)
+
+	assert.Equal(t, ConfigSourceFlag, config.Source["SERVER_PORT"])
+	assert.Equal(t, ConfigSourceEnv, config.Source["MAX_FILE_SIZE"])
+	assert.Equal(t, ConfigSourceFile, config.Source["SHUTDOWN_TIMEOUT_SECONDS"])
+	assert.Equal(t, ConfigSourceDefault, config.Source["STORAGE_PATH"])
+	assert.Equal(t, map[string]string{
+		"SERVER_PORT":              ConfigSourceFlag,
+		"MAX_FILE_SIZE":            ConfigSourceEnv,
+		"SHUTDOWN_TIMEOUT_SECONDS": ConfigSourceFile,
+		"ALLOWED_EXTENSIONS":       ConfigSourceFile,
+		"ENABLE_DEBUG_ROUTES":      ConfigSourceFile,
+	}, config.overriddenSources())
+}
+
+func TestApplyConfigLayer(t *testing.T) {
+	base := defaultConfig()
+	layer := mapLayer{name: "test", values: map[string]string{
+		"HASH_WORKERS":             "not a number",
+		"WEBHOOK_ALLOWED_PORTS":    "443, x, 8443",
+		"PREVIEW_DURATION_SECONDS": "12.5",
+	}}
+
+	config := Apply(base, layer)
+	assert.Equal(t, 2, config.HashWorkers, "invalid values keep the previous one")
+	assert.Equal(t, ConfigSourceDefault, config.Source["HASH_WORKERS"])
+	assert.Equal(t, []int{443, 8443}, config.WebhookAllowedPorts)
+	assert.Equal(t, 12.5, config.PreviewDuration)
+	assert.Equal(t, "test", config.Source["PREVIEW_DURATION_SECONDS"])
+
+	assert.Equal(t, 30.0, base.PreviewDuration, "the base is left untouched")
+	assert.Equal(t, ConfigSourceDefault, base.Source["PREVIEW_DURATION_SECONDS"])
+}
+
+func TestNonceWindowFallback(t *testing.T) {
+	for _, value := range []string{"0", "-5"} {
+		t.Setenv("NONCE_WINDOW_SECONDS", value)
+		config := LoadConfig()
+		assert.Equal(t, defaultNonceWindowSeconds, config.NonceWindowSeconds, value)
+	}
+
+	t.Setenv("NONCE_WINDOW_SECONDS", "60")
+	assert.Equal(t, 60, LoadConfig().NonceWindowSeconds)
+}
+
+func TestLoadConfigArgsErrors(t *testing.T) {
+	_, err := LoadConfigArgs([]string{"--config", writeConfigFile(t, `{"SERVER_PROT": "1"}`)})
+	assert.ErrorContains(t, err, `unknown key "SERVER_PROT"`)
+
+	_, err = LoadConfigArgs([]string{"--config", writeConfigFile(t, `{"SERVER_PORT": {"value": 1}}`)})
+	assert.Error(t, err)
+
+	_, err = LoadConfigArgs([]string{"--config", filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+
+	_, err = LoadConfigArgs([]string{"--no-such-flag"})
+	assert.Error(t, err)
+
+	_, err = LoadConfigArgs([]string{"serve"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// inMemorySnapshot is the on-disk format of a persisted InMemoryDB
+type inMemorySnapshot struct {
+	// SchemaVersion is 0 for files saved before it was recorded, see Migrator
+	SchemaVersion int     `json:"schema_version"`
+	Videos        []Video `json:"videos"`
+	LatestID      string  `json:"latest_id"`
+}
+
+// persistenceBackend is where a persistent InMemoryDB keeps its records
+type persistenceBackend interface {
+	// Load returns the stored records, nil when nothing has been stored yet
+	Load() (*inMemorySnapshot, error)
+	// Save replaces the stored records with snapshot
+	Save(snapshot inMemorySnapshot) error
+	Close() error
+}
+
+// incrementalBackend is implemented by backends that can store only the
+// videos written since the last save rather than every record
+type incrementalBackend interface {
+	// SaveChanges stores the changed videos, removes the deleted ones and
+	// records latestID
+	SaveChanges(changed []Video, deleted []string, latestID string) error
+}
+
+// Persistence backends of the json database backend, see Config.PersistenceBackend
+const (
+	PersistenceJSON   = "json"
+	PersistenceSQLite = "sqlite"
+)
+
+// jsonBackend keeps the records in a JSON file, rewritten on every save
+type jsonBackend struct {
+	path string
+
+	// writeFile writes the serialized snapshot, replaceable in tests
+	writeFile func(path string, data []byte) error
+}
+
+// newJSONBackend creates a backend for the JSON file at path
+func newJSONBackend(path string) *jsonBackend {
+	return &jsonBackend{
+		path: path,
+		writeFile: func(path string, data []byte) error {
+			return writeFileAtomic(path, func(f *os.File) error {
+				_, err := f.Write(data)
+				return err
+			})
+		},
+	}
+}
+
+// Load reads the JSON file, nil when it does not exist
+func (b *jsonBackend) Load() (*inMemorySnapshot, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot inMemorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Save rewrites the JSON file
+func (b *jsonBackend) Save(snapshot inMemorySnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(b.path, data)
+}
+
+// Close is a no-op, the file is only open while it is written
+func (b *jsonBackend) Close() error {
+	return nil
+}
+
+// dbPersistence holds the state of an InMemoryDB backed by a persistenceBackend
+type dbPersistence struct {
+	path    string
+	lock    *FileLock
+	backend persistenceBackend
+	dirty   atomic.Bool
+
+	// changed holds the IDs of the videos written since the last save,
+	// saveAll is set by writes that may have touched any record
+	changed      map[string]struct{}
+	saveAll      bool
+	changesMutex sync.Mutex
+
+	saveRequests chan struct{} // buffered, coalesces bursts of writes
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewPersistentInMemoryDB creates an in-memory database that is loaded from
+// and saved to the JSON file at path. Saves run on a background writer. The
+// file is locked against other instances, waiting at most lockTimeout.
+func NewPersistentInMemoryDB(path string, lockTimeout time.Duration) (*InMemoryDB, error) {
+	return newPersistentInMemoryDB(path, lockTimeout, func() (persistenceBackend, error) {
+		return newJSONBackend(path), nil
+	})
+}
+
+// newPersistentInMemoryDB locks path, then loads the database from the
+// backend opened by open and starts the background writer
+func newPersistentInMemoryDB(path string, lockTimeout time.Duration, open func() (persistenceBackend, error)) (*InMemoryDB, error) {
+	lock, err := LockWithTimeout(path, lockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock database: %w", err)
+	}
+
+	backend, err := open()
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+
+	db := NewInMemoryDB()
+	schemaVersion, err := db.loadFrom(backend)
+	if err != nil {
+		backend.Close()
+		lock.Unlock()
+		return nil, err
+	}
+
+	db.persist = &dbPersistence{
+		path:         path,
+		lock:         lock,
+		backend:      backend,
+		changed:      make(map[string]struct{}),
+		saveRequests: make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go db.writerLoop()
+
+	// Save the upgraded records, so older files are only migrated once
+	if schemaVersion < currentSchemaVersion {
+		db.markDirty()
+	}
+
+	return db, nil
+}
+
+// loadFrom populates the database from the backend if it holds records,
+// migrating records saved with an older schema. It returns the schema
+// version the records were saved with.
+func (db *InMemoryDB) loadFrom(backend persistenceBackend) (int, error) {
+	snapshot, err := backend.Load()
+	if err != nil {
+		return 0, err
+	}
+	if snapshot == nil {
+		return currentSchemaVersion, nil
+	}
+	if snapshot.SchemaVersion > currentSchemaVersion {
+		return 0, fmt.Errorf("database schema version %d is newer than the supported version %d", snapshot.SchemaVersion, currentSchemaVersion)
+	}
+
+	// Oldest first, so the name index ends up pointing at the newest video
+	sort.Slice(snapshot.Videos, func(i, j int) bool {
+		return snapshot.Videos[i].CreatedAt.Before(snapshot.Videos[j].CreatedAt)
+	})
+	for i := range snapshot.Videos {
+		db.AddVideo(&snapshot.Videos[i])
+	}
+	if _, exists := db.videos[snapshot.LatestID]; exists {
+		db.latestID = snapshot.LatestID
+	}
+
+	if err := NewMigrator().Migrate(db, snapshot.SchemaVersion, currentSchemaVersion); err != nil {
+		return 0, err
+	}
+	// Uploads hashed in the background may have been left without a hash
+	// at any schema version
+	db.migrationCheck()
+
+	return snapshot.SchemaVersion, nil
+}
+
+// markDirty is called after each write with the write lock held, with the
+// IDs of the videos written, or none when any record may have changed. It
+// publishes a snapshot to read replicas and schedules a save, never
+// blocking on either.
+func (db *InMemoryDB) markDirty(ids ...string) {
+	db.publishSnapshotLocked()
+
+	if db.persist == nil {
+		return
+	}
+
+	db.persist.addChanges(ids, len(ids) == 0)
+	db.persist.dirty.Store(true)
+	select {
+	case db.persist.saveRequests <- struct{}{}:
+	default: // a save is already pending
+	}
+}
+
+// writerLoop is the background goroutine that performs all saves
+func (db *InMemoryDB) writerLoop() {
+	defer close(db.persist.done)
+
+	for {
+		select {
+		case <-db.persist.saveRequests:
+			db.saveToDisk()
+		case <-db.persist.stop:
+			db.saveToDisk()
+			return
+		}
+	}
+}
+
+// addChanges records videos to write with the next save
+func (p *dbPersistence) addChanges(ids []string, all bool) {
+	p.changesMutex.Lock()
+	defer p.changesMutex.Unlock()
+
+	for _, id := range ids {
+		p.changed[id] = struct{}{}
+	}
+	p.saveAll = p.saveAll || all
+}
+
+// takeChanges returns and clears the videos to write
+func (p *dbPersistence) takeChanges() ([]string, bool) {
+	p.changesMutex.Lock()
+	defer p.changesMutex.Unlock()
+
+	ids := make([]string, 0, len(p.changed))
+	for id := range p.changed {
+		ids = append(ids, id)
+	}
+	all := p.saveAll
+	p.changed = make(map[string]struct{})
+	p.saveAll = false
+	return ids, all
+}
+
+// saveToDisk writes the database to disk if it changed since the last save.
+// Backends that support it are sent only the videos written since then,
+// others the whole database. The read lock is only held while copying the
+// records; serialization and the disk write happen without any lock, so
+// reads are never blocked by I/O.
+func (db *InMemoryDB) saveToDisk() error {
+	if !db.persist.dirty.Swap(false) {
+		return nil
+	}
+
+	ids, all := db.persist.takeChanges()
+	var err error
+	if incremental, ok := db.persist.backend.(incrementalBackend); ok && !all {
+		changed, deleted, latestID := db.changedRecords(ids)
+		err = incremental.SaveChanges(changed, deleted, latestID)
+	} else {
+		err = db.persist.backend.Save(db.snapshot())
+	}
+	if err != nil {
+		// Retry with the next save
+		db.persist.addChanges(ids, all)
+		db.persist.dirty.Store(true)
+		log.Error().Err(err).Str("path", db.persist.path).Msg("failed to save database to disk")
+	}
+	return err
+}
+
+// changedRecords copies the videos with the given IDs under the read lock,
+// listing those no longer stored as deleted
+func (db *InMemoryDB) changedRecords(ids []string) ([]Video, []string, string) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var changed []Video
+	var deleted []string
+	for _, id := range ids {
+		if video, exists := db.videos[id]; exists {
+			changed = append(changed, *video)
+		} else {
+			deleted = append(deleted, id)
+		}
+	}
+	return changed, deleted, db.latestID
+}
+
+// snapshot copies the database contents under the read lock
+func (db *InMemoryDB) snapshot() inMemorySnapshot {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	snapshot := inMemorySnapshot{
+		SchemaVersion: currentSchemaVersion,
+		Videos:        make([]Video, 0, len(db.videos)),
+		LatestID:      db.latestID,
+	}
+	for _, video := range db.videos {
+		snapshot.Videos = append(snapshot.Videos, *video)
+	}
+	return snapshot
+}
+
+// Close stops the background writer after a final save. It is a no-op for
+// databases without persistence.
+func (db *InMemoryDB) Close() error {
+	if db.persist == nil {
+		return nil
+	}
+
+	select {
+	case <-db.persist.stop:
+	default:
+		close(db.persist.stop)
+	}
+	<-db.persist.done
+
+	saveFailed := db.persist.dirty.Load()
+	if err := db.persist.backend.Close(); err != nil {
+		db.persist.lock.Unlock()
+		return err
+	}
+	if err := db.persist.lock.Unlock(); err != nil {
+		return err
+	}
+	if saveFailed {
+		return errors.New("failed to save database to disk")
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentInMemoryDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+
+	db, err := NewPersistentInMemoryDB(path, time.Second)
+	require.NoError(t, err)
+
+	older := newTestVideo("older", 100)
+	older.CreatedAt = time.Now().Add(-time.Hour)
+	db.AddVideo(older)
+	db.AddVideo(newTestVideo("newer", 200))
+	db.AddVideo(newTestVideo("deleted", 300))
+	assert.True(t, db.DeleteVideo("deleted"))
+	require.NoError(t, db.Close())
+
+	db, err = NewPersistentInMemoryDB(path, time.Second)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Len(t, db.GetAllVideos(), 2)
+	_, exists := db.GetVideoByID("deleted")
+	assert.False(t, exists)
+
+	video, exists := db.GetVideoByName("older.mp4")
+	require.True(t, exists)
+	assert.Equal(t, "older", video.ID)
+
+	latest, exists := db.GetLatestVideo()
+	require.True(t, exists)
+	assert.Equal(t, "newer", latest.ID)
+
+	assert.Len(t, db.SearchVideos(SearchQuery{MinSize: 150}), 1)
+}
+
+func TestSaveToDiskDoesNotBlockReads(t *testing.T) {
+	db, err := NewPersistentInMemoryDB(filepath.Join(t.TempDir(), "database.json"), time.Second)
+	require.NoError(t, err)
+
+	// Hold the disk write open until the readers are done
+	writing := make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	db.persist.backend.(*jsonBackend).writeFile = func(path string, data []byte) error {
+		once.Do(func() { close(writing) })
+		<-unblock
+		return nil
+	}
+
+	for i := 0; i < 1000; i++ {
+		db.AddVideo(newTestVideo(fmt.Sprintf("video-%d", i), int64(i)))
+	}
+
+	select {
+	case <-writing:
+	case <-time.After(2 * time.Second):
+		t.Fatal("save never started")
+	}
+
+	// With the write stalled, reads and writes must still go through
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					db.GetVideoByID(fmt.Sprintf("video-%d", j))
+					db.SearchVideos(SearchQuery{MinSize: 100, MaxSize: 200})
+				}
+				db.AddVideo(newTestVideo(fmt.Sprintf("during-save-%d", i), 1))
+			}(i)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reads were blocked while the database was being written to disk")
+	}
+
+	close(unblock)
+	require.NoError(t, db.Close())
+	assert.False(t, db.persist.dirty.Load())
+}
+
+func TestPersistentInMemoryDBLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+
+	first, err := NewPersistentInMemoryDB(path, time.Second)
+	require.NoError(t, err)
+
+	// A second instance on the same files gives up after the timeout
+	start := time.Now()
+	_, err = NewPersistentInMemoryDB(path, 200*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLockHeld)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// Once the first instance closes, the lock is free again
+	require.NoError(t, first.Close())
+	second, err := NewPersistentInMemoryDB(path, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, second.Close())
+}
+
+func TestFileLockConcurrentInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	// Simulated instances race for the lock, exactly one may win
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var locks []*FileLock
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := Lock(path)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrLockHeld)
+				return
+			}
+			mutex.Lock()
+			locks = append(locks, lock)
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, locks, 1)
+	require.NoError(t, locks[0].Unlock())
+
+	lock, err := Lock(path)
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock())
+}
+
+func TestHashMigrationOnLoad(t *testing.T) {
+	storagePath := t.TempDir()
+	dbPath := filepath.Join(storagePath, "database.json")
+
+	// Videos saved before hashing existed have no hash
+	db, err := NewPersistentInMemoryDB(dbPath, time.Second)
+	require.NoError(t, err)
+	var legacyIDs []string
+	for i := 0; i < 5; i++ {
+		video := newTestVideo(fmt.Sprintf("legacy-%d", i), 7)
+		content := []byte(fmt.Sprintf("content-%d", i))
+		require.NoError(t, os.WriteFile(filepath.Join(storagePath, fileKey(video.ID, video.Name)), content, 0644))
+		db.AddVideo(video)
+		legacyIDs = append(legacyIDs, video.ID)
+	}
+	hashed := newTestVideo("hashed", 7)
+	hashed.Hash = "already-set"
+	db.AddVideo(hashed)
+	require.NoError(t, db.Close())
+
+	db, err = NewPersistentInMemoryDB(dbPath, time.Second)
+	require.NoError(t, err)
+	config := &Config{StoragePath: storagePath, MaxFileSize: 1024, MigrationWorkers: 2}
+	server := NewServer(config, db)
+
+	require.Eventually(t, func() bool {
+		return server.migrator.Status().Pending == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, MigrationStatus{Total: 5, Completed: 5}, server.migrator.Status())
+
+	for _, id := range legacyIDs {
+		video, exists := db.GetVideoByID(id)
+		require.True(t, exists)
+		expected, err := computeFileHash(filepath.Join(storagePath, fileKey(id, video.Name)), defaultHashAlgorithm)
+		require.NoError(t, err)
+		assert.Equal(t, expected, video.Hash, id)
+	}
+	video, _ := db.GetVideoByID("hashed")
+	assert.Equal(t, "already-set", video.Hash)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/migration/status", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"total":5,"pending":0,"completed":5,"failed":0}`, w.Body.String())
+
+	// The hashes are saved, so the next start has nothing to migrate
+	server.migrator.Stop()
+	require.NoError(t, db.Close())
+	db, err = NewPersistentInMemoryDB(dbPath, time.Second)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Empty(t, db.TakePendingHashes())
+}
+
+func TestSchemaMigration(t *testing.T) {
+	storagePath := t.TempDir()
+	dbPath := filepath.Join(storagePath, "database.json")
+
+	// A database.json saved before schema versions were recorded
+	v0 := `{"videos": [{"id": "legacy", "name": "legacy.mp4", "size": 6, "created_at": "2023-01-02T03:04:05Z"}], "latest_id": "legacy"}`
+	require.NoError(t, os.WriteFile(dbPath, []byte(v0), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(storagePath, fileKey("legacy", "legacy.mp4")), []byte("legacy"), 0644))
+
+	db, err := NewPersistentInMemoryDB(dbPath, time.Second)
+	require.NoError(t, err)
+
+	video, exists := db.GetVideoByID("legacy")
+	require.True(t, exists)
+	assert.Equal(t, int64(0), video.DownloadCount)
+	assert.Equal(t, []string{"legacy"}, db.TakePendingHashes())
+
+	t.Run("Upgraded file is saved", func(t *testing.T) {
+		require.NoError(t, db.Close())
+
+		data, err := os.ReadFile(dbPath)
+		require.NoError(t, err)
+		var saved struct {
+			SchemaVersion int                      `json:"schema_version"`
+			Videos        []map[string]interface{} `json:"videos"`
+		}
+		require.NoError(t, json.Unmarshal(data, &saved))
+		assert.Equal(t, currentSchemaVersion, saved.SchemaVersion)
+		require.Len(t, saved.Videos, 1)
+		assert.Equal(t, float64(0), saved.Videos[0]["download_count"])
+	})
+
+	t.Run("Downloads are counted", func(t *testing.T) {
+		db, err := NewPersistentInMemoryDB(dbPath, time.Second)
+		require.NoError(t, err)
+		defer db.Close()
+		server := NewServer(&Config{StoragePath: storagePath, MaxFileSize: 1024}, db)
+		defer server.migrator.Stop()
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/legacy/download", nil)
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		video, _ := db.GetVideoByID("legacy")
+		assert.Equal(t, int64(2), video.DownloadCount)
+	})
+
+	t.Run("Newer schema is refused", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "database.json")
+		require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{"schema_version": %d, "videos": []}`, currentSchemaVersion+1)), 0644))
+
+		_, err := NewPersistentInMemoryDB(path, time.Second)
+		assert.ErrorContains(t, err, "newer than the supported version")
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVideo builds a video record for DB tests
+func newTestVideo(id string, size int64) *Video {
+	return &Video{
+		ID:          id,
+		Name:        id + ".mp4",
+		Size:        size,
+		ContentType: "video/mp4",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		URL:         "/api/videos/" + id,
+	}
+}
+
+// assertSizeIndexConsistent checks the size index is sorted and matches the videos map
+func assertSizeIndexConsistent(t *testing.T, db *InMemoryDB) {
+	t.Helper()
+
+	require.Len(t, db.sizeIndex, len(db.videos))
+	assert.True(t, sort.SliceIsSorted(db.sizeIndex, func(i, j int) bool {
+		a, b := db.sizeIndex[i], db.sizeIndex[j]
+		return a.Size < b.Size || (a.Size == b.Size && a.ID < b.ID)
+	}))
+
+	for _, entry := range db.sizeIndex {
+		video, exists := db.videos[entry.ID]
+		require.True(t, exists, "index references deleted video %s", entry.ID)
+		assert.Equal(t, video.Size, entry.Size)
+	}
+}
+
+func TestSizeIndexConsistency(t *testing.T) {
+	db := NewInMemoryDB()
+	rng := rand.New(rand.NewSource(1))
+
+	var ids []string
+	for i := 0; i < 500; i++ {
+		if len(ids) > 0 && rng.Intn(3) == 0 {
+			n := rng.Intn(len(ids))
+			assert.True(t, db.DeleteVideo(ids[n]))
+			ids = append(ids[:n], ids[n+1:]...)
+		} else {
+			id := fmt.Sprintf("video-%d", i)
+			db.AddVideo(newTestVideo(id, rng.Int63n(100)))
+			ids = append(ids, id)
+		}
+	}
+	assertSizeIndexConsistent(t, db)
+
+	// Re-adding an existing ID with a new size must not leave a stale entry
+	db.AddVideo(newTestVideo(ids[0], 1000))
+	assertSizeIndexConsistent(t, db)
+}
+
+func TestInMemoryDBUpdateVideo(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newTestVideo("a", 100))
+
+	updated := newTestVideo("a", 500)
+	updated.Name = "renamed.mp4"
+	require.NoError(t, db.UpdateVideo(updated))
+	assertSizeIndexConsistent(t, db)
+
+	_, exists := db.GetVideoByName("a.mp4")
+	assert.False(t, exists)
+	video, exists := db.GetVideoByName("renamed.mp4")
+	require.True(t, exists)
+	assert.Equal(t, int64(500), video.Size)
+
+	assert.ErrorIs(t, db.UpdateVideo(newTestVideo("missing", 1)), ErrVideoNotFound)
+}
+
+func TestGetVideoByIDRefConcurrent(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newTestVideo("shared", 100))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				video, release, exists := db.GetVideoByIDRef("shared")
+				if assert.True(t, exists) {
+					_ = video.Name + video.ContentType
+				}
+				release()
+			}
+		}()
+
+		// Writers replace the record concurrently; the race detector flags
+		// any read of a borrowed video that is not covered by the lock
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, db.UpdateVideo(newTestVideo("shared", int64(i*100+j))))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, release, exists := db.GetVideoByIDRef("missing")
+	assert.False(t, exists)
+	release()
+
+	// All read locks were released, so writes still go through
+	db.AddVideo(newTestVideo("after", 1))
+	_, exists = db.GetVideoByID("after")
+	assert.True(t, exists)
+}
+
+func TestSearchVideosBySize(t *testing.T) {
+	db := NewInMemoryDB()
+	for i, size := range []int64{10, 20, 20, 30, 40} {
+		db.AddVideo(newTestVideo(fmt.Sprintf("video-%d", i), size))
+	}
+
+	sizes := func(videos []*Video) []int64 {
+		result := make([]int64, 0, len(videos))
+		for _, v := range videos {
+			result = append(result, v.Size)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+		return result
+	}
+
+	assert.Equal(t, []int64{20, 20, 30}, sizes(db.SearchVideos(SearchQuery{MinSize: 20, MaxSize: 30})))
+	assert.Equal(t, []int64{30, 40}, sizes(db.SearchVideos(SearchQuery{MinSize: 25})))
+	assert.Equal(t, []int64{10, 20, 20}, sizes(db.SearchVideos(SearchQuery{MaxSize: 20})))
+	assert.Equal(t, []int64{10, 20, 20, 30, 40}, sizes(db.SearchVideos(SearchQuery{})))
+	assert.Equal(t, []int64{30}, sizes(db.SearchVideos(SearchQuery{Query: "VIDEO-3", MinSize: 1})))
+	assert.Empty(t, db.SearchVideos(SearchQuery{MinSize: 41}))
+}
+
+func newBenchmarkDB(n int) *InMemoryDB {
+	db := NewInMemoryDB()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		db.AddVideo(newTestVideo(fmt.Sprintf("video-%d", i), rng.Int63n(1<<30)))
+	}
+	return db
+}
+
+func BenchmarkSizeFilterFullScan(b *testing.B) {
+	db := newBenchmarkDB(10000)
+	minSize, maxSize := int64(1<<20), int64(1<<22)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var matches []*Video
+		for _, video := range db.GetAllVideos() {
+			if video.Size >= minSize && video.Size <= maxSize {
+				matches = append(matches, video)
+			}
+		}
+		_ = matches
+	}
+}
+
+func BenchmarkSizeFilterIndex(b *testing.B) {
+	db := newBenchmarkDB(10000)
+	query := SearchQuery{MinSize: 1 << 20, MaxSize: 1 << 22}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = db.SearchVideos(query)
+	}
+}
+
+func TestFindVideoByFilePrefix(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newNamedVideo("3f2a", "holiday_beach.mp4"))
+
+	video, exists := db.FindVideoByFilePrefix("3f2a_holiday_beach.mp4")
+	require.True(t, exists)
+	assert.Equal(t, "3f2a", video.ID)
+
+	// A file named after the video's name alone is not the video's file
+	_, exists = db.FindVideoByFilePrefix("holiday_beach.mp4")
+	assert.False(t, exists)
+
+	// Files without a record are not found, even if they exist on disk
+	_, exists = db.FindVideoByFilePrefix("9c1d_holiday_beach.mp4")
+	assert.False(t, exists)
+	_, exists = db.FindVideoByFilePrefix("3f2a_other.mp4")
+	assert.False(t, exists)
+	_, exists = db.FindVideoByFilePrefix("3f2a")
+	assert.False(t, exists)
+}
+
+func TestFindVideosByIDPrefix(t *testing.T) {
+	db := NewInMemoryDB()
+	for _, id := range []string{"ab12", "ab34", "abc9", "b001"} {
+		db.AddVideo(newTestVideo(id, 1))
+	}
+
+	assert.Equal(t, []string{"ab12", "ab34", "abc9"}, videoIDs(db.FindVideosByIDPrefix("ab")))
+	assert.Equal(t, []string{"ab34"}, videoIDs(db.FindVideosByIDPrefix("ab3")))
+	assert.Equal(t, []string{"abc9"}, videoIDs(db.FindVideosByIDPrefix("abc9")))
+	assert.Empty(t, db.FindVideosByIDPrefix("c"))
+	assert.Len(t, db.FindVideosByIDPrefix(""), 4)
+
+	db.DeleteVideo("ab12")
+	assert.Equal(t, []string{"ab34", "abc9"}, videoIDs(db.FindVideosByIDPrefix("ab")))
+}
+
+func TestUpdateVideoFunc(t *testing.T) {
+	stores := map[string]func(t *testing.T) VideoStore{
+		"memory": func(t *testing.T) VideoStore { return NewInMemoryDB() },
+		"bolt": func(t *testing.T) VideoStore {
+			store := openTestBoltStore(t, filepath.Join(t.TempDir(), "videos.db"))
+			t.Cleanup(func() { store.Close() })
+			return newCachedVideoStore(store, 10)
+		},
+		"sqlite": func(t *testing.T) VideoStore { return newTestSQLiteDB(t) },
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			db := open(t)
+			require.NoError(t, db.AddVideo(newTestVideo("a", 0)))
+
+			updated, err := db.UpdateVideoFunc("a", func(video *Video) error {
+				video.Name = "renamed.mp4"
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "renamed.mp4", updated.Name)
+			_, exists := db.GetVideoByName("renamed.mp4")
+			assert.True(t, exists)
+
+			failed := errors.New("rejected")
+			_, err = db.UpdateVideoFunc("a", func(video *Video) error {
+				video.Name = "discarded.mp4"
+				return failed
+			})
+			assert.ErrorIs(t, err, failed)
+			stored, _ := db.GetVideoByID("a")
+			assert.Equal(t, "renamed.mp4", stored.Name, "nothing is stored when update fails")
+
+			_, err = db.UpdateVideoFunc("missing", func(*Video) error { return nil })
+			assert.ErrorIs(t, err, ErrVideoNotFound)
+
+			// Each update sees the result of the one before
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := db.UpdateVideoFunc("a", func(video *Video) error {
+						video.Size++
+						return nil
+					})
+					assert.NoError(t, err)
+				}()
+			}
+			wg.Wait()
+			stored, _ = db.GetVideoByID("a")
+			assert.Equal(t, int64(20), stored.Size)
+		})
+	}
+}
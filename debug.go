@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubscriberCount returns how many streams are subscribed to changes
+func (b *webhookBroadcaster) SubscriberCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers)
+}
+
+// QueueDepths returns how many deliveries wait behind each URL's rate limit
+func (wm *WebhookManager) QueueDepths() map[string]int {
+	wm.limiterMutex.Lock()
+	defer wm.limiterMutex.Unlock()
+
+	depths := make(map[string]int, len(wm.limiters))
+	for url, limiter := range wm.limiters {
+		depths[url] = len(limiter.queue)
+	}
+	return depths
+}
+
+// IndexSizes returns the number of entries in each index
+func (db *InMemoryDB) IndexSizes() map[string]int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return map[string]int{
+		"videos":  len(db.videos),
+		"name":    len(db.nameIndex),
+		"size":    len(db.sizeIndex),
+		"tag":     len(db.tagIndex),
+		"trigram": len(db.trigramIndex),
+	}
+}
+
+// openFileDescriptors counts the files this process has open, from
+// /proc/self/fd on Linux and lsof on macOS
+func openFileDescriptors() (int, error) {
+	switch runtime.GOOS {
+	case "linux":
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			return 0, err
+		}
+		return len(entries), nil
+	case "darwin":
+		out, err := exec.Command("lsof", "-n", "-p", strconv.Itoa(os.Getpid())).Output()
+		if err != nil {
+			return 0, err
+		}
+		// The first line is the header
+		return strings.Count(string(out), "\n") - 1, nil
+	}
+	return 0, fmt.Errorf("counting open files is not supported on %s", runtime.GOOS)
+}
+
+// debugState collects internal state for troubleshooting
+func (s *Server) debugState() gin.H {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	state := gin.H{
+		"goroutines": runtime.NumGoroutine(),
+		"memory": gin.H{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"num_gc":            mem.NumGC,
+			"gc_pause_total":    time.Duration(mem.PauseTotalNs).String(),
+		},
+		"uploads": gin.H{
+			"in_flight": s.uploadDrainer.InFlight(),
+		},
+		"webhooks": gin.H{
+			"queue_depths":       s.webhookMgr.QueueDepths(),
+			"stream_connections": s.webhookMgr.broadcaster.SubscriberCount(),
+		},
+	}
+
+	if fds, err := openFileDescriptors(); err != nil {
+		state["open_files"] = gin.H{"error": err.Error()}
+	} else {
+		state["open_files"] = fds
+	}
+
+	if s.hashQueue != nil {
+		state["uploads"].(gin.H)["hash_queue_depth"] = len(s.hashQueue)
+		state["uploads"].(gin.H)["hash_queue_capacity"] = cap(s.hashQueue)
+	}
+
+	store := s.db
+	if cached, ok := store.(*cachedVideoStore); ok {
+		hits, misses := cached.cache.Hits(), cached.cache.Misses()
+		hitRate := 0.0
+		if hits+misses > 0 {
+			hitRate = float64(hits) / float64(hits+misses)
+		}
+		state["metadata_cache"] = gin.H{
+			"entries":  cached.cache.Len(),
+			"hits":     hits,
+			"misses":   misses,
+			"hit_rate": hitRate,
+		}
+		store = cached.VideoStore
+	}
+	if db, ok := store.(*InMemoryDB); ok {
+		state["db_indexes"] = db.IndexSizes()
+	}
+	if len(s.readReplicas) > 0 {
+		state["read_replicas"] = len(s.readReplicas)
+	}
+	return state
+}
+
+// debugStateHandler dumps internal server state
+func (s *Server) debugStateHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, s.debugState())
+}
+
+// debugPprofHandler redirects to the profiling endpoints served on
+// DebugPort
+func (s *Server) debugPprofHandler(c *gin.Context) {
+	if s.config.DebugPort == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profiling is disabled, set DEBUG_PORT to enable it"})
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, "http://"+net.JoinHostPort("localhost", s.config.DebugPort)+"/debug/pprof/")
+}
+
+// debugHandler serves the net/http/pprof endpoints
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startDebugServer serves the profiling endpoints on localhost:DebugPort,
+// kept off the public listener. It returns nil when no port is configured.
+func (s *Server) startDebugServer() *http.Server {
+	if !s.config.EnableDebugRoutes || s.config.DebugPort == "" {
+		return nil
+	}
+
+	srv := &http.Server{
+		Addr:              net.JoinHostPort("localhost", s.config.DebugPort),
+		Handler:           debugHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		s.logger.Info().Str("addr", srv.Addr).Msg("serving profiling endpoints")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error().Err(err).Msg("debug server failed")
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugState(t *testing.T) {
+	server := newTestServer(t)
+	server.config.EnableDebugRoutes = true
+	server.setupRoutes()
+	uploadTestVideo(t, server, "debug.mp4", []byte("debug video"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/debug/state", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var state map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	for _, key := range []string{"goroutines", "memory", "open_files", "db_indexes", "uploads", "webhooks"} {
+		assert.Contains(t, state, key)
+	}
+	assert.Greater(t, state["goroutines"], float64(0))
+	assert.Contains(t, state["memory"], "heap_alloc_bytes")
+	assert.Equal(t, float64(1), state["db_indexes"].(map[string]interface{})["videos"])
+	assert.Contains(t, state["webhooks"], "stream_connections")
+
+	t.Run("Profiling redirect", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/debug/pprof", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, "no DEBUG_PORT")
+
+		server.config.DebugPort = "6060"
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "http://localhost:6060/debug/pprof/", w.Header().Get("Location"))
+	})
+
+	t.Run("Profiling endpoints", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		debugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "goroutine profile")
+	})
+}
+
+func TestDebugRoutesDisabled(t *testing.T) {
+	server := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/debug/state", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
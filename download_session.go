@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadSession lets a client resume a download, from the same IP, until
+// it expires
+type DownloadSession struct {
+	VideoID   string
+	ClientIP  string
+	ExpiresAt time.Time
+}
+
+// DownloadSessionStore holds download sessions by token. Sessions expire
+// after the TTL and are evicted by a background ticker.
+type DownloadSessionStore struct {
+	sessions map[string]DownloadSession // token -> session
+	mutex    sync.Mutex
+	ttl      time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewDownloadSessionStore creates a session store and starts its eviction
+// ticker
+func NewDownloadSessionStore(ttl time.Duration) *DownloadSessionStore {
+	ds := &DownloadSessionStore{
+		sessions: make(map[string]DownloadSession),
+		ttl:      ttl,
+		stop:     make(chan struct{}),
+	}
+
+	go ds.evictLoop()
+
+	return ds
+}
+
+// Create starts a session for videoID and clientIP, returning its token
+func (ds *DownloadSessionStore) Create(videoID, clientIP string, now time.Time) (string, DownloadSession, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", DownloadSession{}, err
+	}
+	token := hex.EncodeToString(buf)
+
+	session := DownloadSession{VideoID: videoID, ClientIP: clientIP, ExpiresAt: now.Add(ds.ttl)}
+
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.sessions[token] = session
+	return token, session, nil
+}
+
+// Get returns the session for token unless it is unknown or has expired
+func (ds *DownloadSessionStore) Get(token string, now time.Time) (DownloadSession, bool) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	session, exists := ds.sessions[token]
+	if !exists || !now.Before(session.ExpiresAt) {
+		return DownloadSession{}, false
+	}
+	return session, true
+}
+
+// Close stops the eviction ticker
+func (ds *DownloadSessionStore) Close() {
+	ds.once.Do(func() { close(ds.stop) })
+}
+
+// evictLoop periodically removes expired sessions
+func (ds *DownloadSessionStore) evictLoop() {
+	ticker := time.NewTicker(ds.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			ds.evictExpired(now)
+		case <-ds.stop:
+			return
+		}
+	}
+}
+
+// evictExpired removes sessions whose expiry is not after now
+func (ds *DownloadSessionStore) evictExpired(now time.Time) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	for token, session := range ds.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(ds.sessions, token)
+		}
+	}
+}
+
+// createDownloadSessionHandler starts a download session for a video, which
+// the caller passes as ?session= to resume the download from the same IP
+func (s *Server) createDownloadSessionHandler(c *gin.Context) {
+	videoID := c.Param("id")
+	if _, exists := s.db.GetVideoByID(videoID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	token, session, err := s.downloadSessions.Create(videoID, c.ClientIP(), time.Now())
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to create download session")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create download session"})
+		return
+	}
+
+	s.respondSuccess(c, http.StatusCreated, gin.H{
+		"session_token": token,
+		"expires_at":    session.ExpiresAt,
+	})
+}
+
+// checkDownloadSession validates the ?session= token of a download, if one
+// was sent. It writes an error response and returns false when the token is
+// unknown, expired, for another video or used from another IP.
+func (s *Server) checkDownloadSession(c *gin.Context, videoID string) bool {
+	// The node the client connected to checked the session before
+	// forwarding the request, and holds it
+	token := c.Query("session")
+	if token == "" || c.GetHeader(clusterForwardedHeader) != "" {
+		return true
+	}
+
+	session, ok := s.downloadSessions.Get(token, time.Now())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired download session"})
+		return false
+	}
+	if session.VideoID != videoID || session.ClientIP != c.ClientIP() {
+		getLogger(c).Warn().
+			Str("video_id", videoID).
+			Str("session_ip", session.ClientIP).
+			Msg("download session used for another video or from another IP")
+		c.JSON(http.StatusForbidden, gin.H{"error": "download session does not match this request"})
+		return false
+	}
+	return true
+}
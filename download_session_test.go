@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadSession(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "resumable.mp4", []byte("0123456789"))
+
+	const clientAddr = "198.51.100.7:40000"
+	download := func(token, remoteAddr, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/download?session="+token, nil)
+		req.RemoteAddr = remoteAddr
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID+"/download-session", nil)
+	req.RemoteAddr = clientAddr
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp struct {
+		SessionToken string    `json:"session_token"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotEmpty(t, resp.SessionToken)
+	assert.True(t, resp.ExpiresAt.After(time.Now()))
+
+	t.Run("Resume", func(t *testing.T) {
+		w := download(resp.SessionToken, clientAddr, "bytes=4-")
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "456789", w.Body.String())
+	})
+
+	t.Run("Other IP", func(t *testing.T) {
+		w := download(resp.SessionToken, "203.0.113.9:40000", "bytes=4-")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	forwarded := func(proxyAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/download?session="+resp.SessionToken, nil)
+		req.RemoteAddr = proxyAddr
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Forged X-Forwarded-For", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, forwarded("203.0.113.9:40000"))
+	})
+
+	t.Run("Trusted proxy", func(t *testing.T) {
+		server.config.TrustedProxies = []string{"10.0.0.0/8"}
+		server.setupRoutes()
+		assert.Equal(t, http.StatusOK, forwarded("10.1.2.3:40000"))
+		assert.Equal(t, http.StatusForbidden, forwarded("203.0.113.9:40000"))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, _, err := server.downloadSessions.Create(video.ID, "198.51.100.7", time.Now().Add(-2*time.Hour))
+		require.NoError(t, err)
+
+		w := download(token, clientAddr, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/videos/missing/download-session", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Strategies for uploads whose filename is already taken
+const (
+	DuplicateNameAllow     = "allow"     // store a separate record under a new ID
+	DuplicateNameReject    = "reject"    // refuse the upload with 409
+	DuplicateNameOverwrite = "overwrite" // replace the existing video
+	DuplicateNameVersion   = "version"   // store as name_v2.ext, name_v3.ext, ...
+)
+
+// versionedName inserts a _vN suffix before the extension of name
+func versionedName(name string, version int) string {
+	if version <= 1 {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s_v%d%s", strings.TrimSuffix(name, ext), version, ext)
+}
+
+// reserveVersion returns the filename and version the next upload of name
+// should be stored under, recording it in the base name index
+func (s *Server) reserveVersion(name string) (string, int) {
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
+	latest, seen := s.baseNameIndex[name]
+	if !seen {
+		// Seed from the store so versions continue after a restart
+		if _, exists := s.db.GetVideoByName(name); exists {
+			latest = 1
+			for {
+				if _, exists := s.db.GetVideoByName(versionedName(name, latest+1)); !exists {
+					break
+				}
+				latest++
+			}
+		}
+	}
+
+	next := latest + 1
+	s.baseNameIndex[name] = next
+	return versionedName(name, next), next
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postVideo uploads data under filename and returns the raw response
+func postVideo(t *testing.T, server *Server, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	part.Write(data)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestVersionedName(t *testing.T) {
+	assert.Equal(t, "clip.mp4", versionedName("clip.mp4", 1))
+	assert.Equal(t, "clip_v2.mp4", versionedName("clip.mp4", 2))
+	assert.Equal(t, "clip_v10", versionedName("clip", 10))
+}
+
+func TestDuplicateNameStrategies(t *testing.T) {
+	t.Run("allow", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.DuplicateNameStrategy = DuplicateNameAllow
+
+		first := uploadTestVideo(t, server, "same.mp4", []byte("one"))
+		second := uploadTestVideo(t, server, "same.mp4", []byte("two"))
+
+		assert.NotEqual(t, first.ID, second.ID)
+		assert.Len(t, server.db.GetAllVideos(), 2)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.DuplicateNameStrategy = DuplicateNameReject
+
+		first := uploadTestVideo(t, server, "same.mp4", []byte("one"))
+
+		w := postVideo(t, server, "same.mp4", []byte("two"))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), first.ID)
+		assert.Len(t, server.db.GetAllVideos(), 1)
+
+		// Other names are unaffected
+		uploadTestVideo(t, server, "other.mp4", []byte("three"))
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.DuplicateNameStrategy = DuplicateNameOverwrite
+
+		first := uploadTestVideo(t, server, "same.mp4", []byte("one"))
+		second := uploadTestVideo(t, server, "same.mp4", []byte("two"))
+
+		_, exists := server.db.GetVideoByID(first.ID)
+		assert.False(t, exists)
+		assert.NoFileExists(t, server.getFilePath(first.ID, first.Name))
+
+		video, exists := server.db.GetVideoByName("same.mp4")
+		require.True(t, exists)
+		assert.Equal(t, second.ID, video.ID)
+
+		data, err := os.ReadFile(server.getFilePath(second.ID, second.Name))
+		require.NoError(t, err)
+		assert.Equal(t, "two", string(data))
+		assert.Len(t, server.db.GetAllVideos(), 1)
+	})
+
+	t.Run("version", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.DuplicateNameStrategy = DuplicateNameVersion
+
+		first := uploadTestVideo(t, server, "same.mp4", []byte("one"))
+		second := uploadTestVideo(t, server, "same.mp4", []byte("two"))
+		third := uploadTestVideo(t, server, "same.mp4", []byte("three"))
+
+		assert.Equal(t, "same.mp4", first.Name)
+		assert.Equal(t, 1, first.Version)
+		assert.Equal(t, "same_v2.mp4", second.Name)
+		assert.Equal(t, 2, second.Version)
+		assert.Equal(t, "same_v3.mp4", third.Name)
+		assert.Equal(t, 3, third.Version)
+	})
+
+	t.Run("version continues from stored videos", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.AddVideo(&Video{ID: "a", Name: "same.mp4"})
+		db.AddVideo(&Video{ID: "b", Name: "same_v2.mp4", Version: 2})
+
+		server := NewServer(&Config{
+			StoragePath:           t.TempDir(),
+			MaxFileSize:           1024,
+			DuplicateNameStrategy: DuplicateNameVersion,
+		}, db)
+
+		video := uploadTestVideo(t, server, "same.mp4", []byte("next"))
+		assert.Equal(t, "same_v3.mp4", video.Name)
+		assert.Equal(t, 3, video.Version)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response envelope styles, see Config.ResponseEnvelopeStyle
+const (
+	// ResponseEnvelopeFlat sends handler responses as they are:
+	// {"success": true, "video": {...}}
+	ResponseEnvelopeFlat = "flat"
+	// ResponseEnvelopeData wraps them: {"data": {...}, "error": null}
+	ResponseEnvelopeData = "data"
+	// ResponseEnvelopeJSONAPI sends videos as JSON:API resource objects:
+	// {"data": {"id": "...", "type": "video", "attributes": {...}}}
+	ResponseEnvelopeJSONAPI = "jsonapi"
+)
+
+// requestIDContextKey holds the request's ID, see contextLoggerMiddleware
+const requestIDContextKey = "request_id"
+
+// jsonAPIResourceFields are the flat response fields holding JSON:API
+// resources, with their resource type
+var jsonAPIResourceFields = map[string]string{
+	"video":  "video",
+	"videos": "video",
+}
+
+// requestIDFrom returns the ID of the request
+func requestIDFrom(c *gin.Context) string {
+	if requestID := c.GetString(requestIDContextKey); requestID != "" {
+		return requestID
+	}
+	return c.Writer.Header().Get(requestIDHeader)
+}
+
+// respondSuccess writes a successful response in the configured envelope
+// style. obj is the flat style response: usually a gin.H whose fields are
+// spread over the envelope, or a value sent as the response's data. Every
+// style carries the request ID; in the flat style only gin.H responses can,
+// others keep their shape and rely on the X-Request-ID header.
+func (s *Server) respondSuccess(c *gin.Context, status int, obj interface{}) {
+	requestID := requestIDFrom(c)
+
+	switch s.config.ResponseEnvelopeStyle {
+	case ResponseEnvelopeData:
+		data := obj
+		if fields, ok := obj.(gin.H); ok {
+			// Success is implied by the null error
+			data = withoutFields(fields, "success")
+		}
+		respondNegotiated(c, status, gin.H{"data": data, "error": nil, "request_id": requestID})
+
+	case ResponseEnvelopeJSONAPI:
+		respondNegotiated(c, status, jsonAPIDocument(obj, requestID))
+
+	default:
+		if fields, ok := obj.(gin.H); ok {
+			body := withoutFields(fields)
+			body["request_id"] = requestID
+			obj = body
+		}
+		respondNegotiated(c, status, obj)
+	}
+}
+
+// withoutFields copies fields, leaving out the named ones
+func withoutFields(fields gin.H, names ...string) gin.H {
+	copied := make(gin.H, len(fields))
+	for key, value := range fields {
+		copied[key] = value
+	}
+	for _, name := range names {
+		delete(copied, name)
+	}
+	return copied
+}
+
+// jsonAPIDocument builds a JSON:API document from a flat style response.
+// Videos become the primary data, every other field goes into meta.
+func jsonAPIDocument(obj interface{}, requestID string) gin.H {
+	meta := gin.H{}
+	doc := gin.H{"meta": meta}
+
+	switch value := obj.(type) {
+	case *Video:
+		doc["data"] = jsonAPIResources("video", value)
+	case gin.H:
+		for key, field := range value {
+			if key == "success" {
+				continue
+			}
+			if resourceType, ok := jsonAPIResourceFields[key]; ok {
+				doc["data"] = jsonAPIResources(resourceType, field)
+				continue
+			}
+			meta[key] = field
+		}
+	default:
+		// Anything else has no resources, its fields are only metadata
+		if fields, ok := toJSONValue(obj).(map[string]interface{}); ok {
+			for key, field := range fields {
+				meta[key] = field
+			}
+		} else {
+			meta["result"] = obj
+		}
+	}
+
+	meta["request_id"] = requestID
+	return doc
+}
+
+// jsonAPIResources converts a value, or a list of them, to JSON:API resource
+// objects of resourceType. The "id" field becomes the resource ID and the
+// other fields its attributes.
+func jsonAPIResources(resourceType string, value interface{}) interface{} {
+	toResource := func(value interface{}) interface{} {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		return gin.H{
+			"id":         fields["id"],
+			"type":       resourceType,
+			"attributes": withoutFields(fields, "id"),
+		}
+	}
+
+	switch decoded := toJSONValue(value).(type) {
+	case []interface{}:
+		resources := make([]interface{}, len(decoded))
+		for i, item := range decoded {
+			resources[i] = toResource(item)
+		}
+		return resources
+	default:
+		return toResource(decoded)
+	}
+}
+
+// toJSONValue returns the generic JSON representation of v, nil if it
+// cannot be encoded
+func toJSONValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
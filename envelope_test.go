@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEnvelopeStyles(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "enveloped.mp4", []byte("enveloped video"))
+
+	// get returns the decoded body of a request carrying a known request ID
+	get := func(path string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(requestIDHeader, "req-123")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	// The video as a generic JSON object, for comparison with the bodies
+	var expected map[string]interface{}
+	data, err := json.Marshal(video)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &expected))
+
+	t.Run("Flat", func(t *testing.T) {
+		server.config.ResponseEnvelopeStyle = ResponseEnvelopeFlat
+
+		body := get("/api/videos/latest")
+		assert.Equal(t, true, body["success"])
+		assert.Equal(t, "req-123", body["request_id"])
+		assert.Equal(t, expected, body["video"])
+
+		body = get("/api/videos")
+		assert.Equal(t, []interface{}{expected}, body["videos"])
+		assert.Equal(t, float64(1), body["total"])
+	})
+
+	t.Run("Data", func(t *testing.T) {
+		server.config.ResponseEnvelopeStyle = ResponseEnvelopeData
+
+		body := get("/api/videos/latest")
+		assert.Len(t, body, 3)
+		assert.Contains(t, body, "error")
+		assert.Nil(t, body["error"])
+		assert.Equal(t, "req-123", body["request_id"])
+		assert.Equal(t, map[string]interface{}{"video": expected}, body["data"])
+
+		body = get("/api/videos")
+		listing := body["data"].(map[string]interface{})
+		assert.Equal(t, []interface{}{expected}, listing["videos"])
+		assert.Equal(t, float64(1), listing["total"])
+		assert.NotContains(t, listing, "success")
+	})
+
+	t.Run("JSON:API", func(t *testing.T) {
+		server.config.ResponseEnvelopeStyle = ResponseEnvelopeJSONAPI
+
+		attributes := make(map[string]interface{})
+		for key, value := range expected {
+			if key != "id" {
+				attributes[key] = value
+			}
+		}
+		resource := map[string]interface{}{"id": video.ID, "type": "video", "attributes": attributes}
+
+		body := get("/api/videos/latest")
+		assert.Equal(t, resource, body["data"])
+		assert.Equal(t, map[string]interface{}{"request_id": "req-123"}, body["meta"])
+
+		body = get("/api/videos")
+		assert.Equal(t, []interface{}{resource}, body["data"])
+		meta := body["meta"].(map[string]interface{})
+		assert.Equal(t, "req-123", meta["request_id"])
+		assert.Equal(t, float64(1), meta["total"])
+
+		// Responses without videos only have metadata
+		body = get("/api/videos/" + video.ID + "/manifest")
+		assert.NotContains(t, body, "data")
+		meta = body["meta"].(map[string]interface{})
+		assert.Equal(t, video.ID, meta["video_id"])
+		assert.Equal(t, "req-123", meta["request_id"])
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+// EventType names a webhook event. Events are also published to the message
+// queue under the same name.
+type EventType string
+
+// Events the server sends. Subscribers may register any event name, these
+// are the ones that are fired.
+const (
+	EventVideoUploaded      EventType = "video.uploaded"
+	EventVideoDeleted       EventType = "video.deleted"
+	EventVideoExpired       EventType = "video.expired"
+	EventVideoPurged        EventType = "video.purged"
+	EventVideoBilled        EventType = "video.billed"
+	EventVideoCommentAdded  EventType = "video.comment_added"
+	EventVideoTagsAdded     EventType = "video.tags_added"
+	EventVideoTagsRemoved   EventType = "video.tags_removed"
+	EventStorageFileMissing EventType = "storage.file_missing"
+	EventDiskWarning        EventType = "disk.warning"
+)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ErrFFmpegUnavailable is returned when the configured ffmpeg binary cannot be found
+var ErrFFmpegUnavailable = errors.New("ffmpeg is not available")
+
+// runFFmpeg runs ffmpeg with the given arguments. On failure the returned
+// error includes the tail of ffmpeg's stderr to make it diagnosable.
+func runFFmpeg(ctx context.Context, ffmpegPath string, args ...string) error {
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFFmpegUnavailable, err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > 512 {
+			output = output[len(output)-512:]
+		}
+		if output == "" {
+			return fmt.Errorf("ffmpeg failed: %w", err)
+		}
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, output)
+	}
+	return nil
+}
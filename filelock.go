@@ -0,0 +1,63 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrLockHeld is returned when another process holds a file lock
+var ErrLockHeld = errors.New("file is locked by another process")
+
+// lockRetryInterval is how often LockWithTimeout retries a held lock
+const lockRetryInterval = 50 * time.Millisecond
+
+// FileLock is an exclusive advisory lock on <path>.lock, held until Unlock.
+// It keeps two server instances from writing the same database files.
+type FileLock struct {
+	file *os.File
+}
+
+// Lock acquires the lock for path without waiting. It returns an error
+// wrapping ErrLockHeld if another instance holds it.
+func Lock(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLockHeld, path)
+		}
+		return nil, err
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// LockWithTimeout retries Lock until it succeeds or timeout elapses
+func LockWithTimeout(path string, timeout time.Duration) (*FileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := Lock(path)
+		if !errors.Is(err, ErrLockHeld) || !time.Now().Before(deadline) {
+			return lock, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,29 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLockHeld is returned when another process holds a file lock
+var ErrLockHeld = errors.New("file is locked by another process")
+
+// FileLock is a no-op on platforms without flock
+type FileLock struct{}
+
+// Lock does not lock anything on this platform
+func Lock(path string) (*FileLock, error) {
+	return &FileLock{}, nil
+}
+
+// LockWithTimeout does not lock anything on this platform
+func LockWithTimeout(path string, timeout time.Duration) (*FileLock, error) {
+	return &FileLock{}, nil
+}
+
+// Unlock is a no-op on this platform
+func (l *FileLock) Unlock() error {
+	return nil
+}
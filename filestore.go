@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileStore stores video files by key, where the key is the file name used
+// under StoragePath (<videoID>_<name>)
+type FileStore interface {
+	Open(key string) (io.ReadCloser, error)
+	// Put stores the contents of r under key, replacing any existing file
+	Put(key string, r io.Reader) error
+	Exists(key string) (bool, error)
+	// Stat describes the file stored under key, failing with an error
+	// matching os.ErrNotExist when there is none
+	Stat(key string) (os.FileInfo, error)
+	Remove(key string) error
+}
+
+// LocalFileStore is a FileStore backed by a directory on the local disk
+type LocalFileStore struct {
+	root string
+}
+
+// NewLocalFileStore creates a file store rooted at dir
+func NewLocalFileStore(dir string) *LocalFileStore {
+	return &LocalFileStore{root: dir}
+}
+
+// path returns the on-disk path for key, refusing keys that escape the root
+func (fs *LocalFileStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) {
+		return "", fmt.Errorf("invalid file key: %q", key)
+	}
+	return filepath.Join(fs.root, key), nil
+}
+
+// Open opens the file stored under key
+func (fs *LocalFileStore) Open(key string) (io.ReadCloser, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Put writes r to key through a temporary file, so readers never see a
+// partially written file
+func (fs *LocalFileStore) Put(key string, r io.Reader) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fs.root, 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, func(f *os.File) error {
+		_, err := io.Copy(f, r)
+		return err
+	})
+}
+
+// Exists reports whether a file is stored under key
+func (fs *LocalFileStore) Exists(key string) (bool, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stat describes the file stored under key
+func (fs *LocalFileStore) Stat(key string) (os.FileInfo, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+// Remove deletes the file stored under key
+func (fs *LocalFileStore) Remove(key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// FallbackFileStore spreads a file over a chain of stores. Reads are served
+// by the first store that has the file and writes go to every store that
+// accepts them, so the chain keeps working while some stores are down.
+type FallbackFileStore struct {
+	stores []FileStore
+}
+
+// NewFallbackFileStore creates a file store trying stores in order
+func NewFallbackFileStore(stores ...FileStore) *FallbackFileStore {
+	return &FallbackFileStore{stores: stores}
+}
+
+// Open opens the file from the first store that can open it
+func (fs *FallbackFileStore) Open(key string) (io.ReadCloser, error) {
+	var errs []error
+	for _, store := range fs.stores {
+		file, err := store.Open(key)
+		if err == nil {
+			return file, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Put stores r in every store that accepts it, failing only if none do. r
+// is spooled to a temporary file first since each store reads it in full.
+func (fs *FallbackFileStore) Put(key string, r io.Reader) error {
+	spool, err := os.CreateTemp("", "fallback-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if _, err := io.Copy(spool, r); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, store := range fs.stores {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := store.Put(key, spool); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(fs.stores) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Exists reports whether any store has the file. Errors are only returned
+// when no store could answer.
+func (fs *FallbackFileStore) Exists(key string) (bool, error) {
+	var errs []error
+	for _, store := range fs.stores {
+		exists, err := store.Exists(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if exists {
+			return true, nil
+		}
+	}
+
+	if len(errs) == len(fs.stores) {
+		return false, errors.Join(errs...)
+	}
+	return false, nil
+}
+
+// Stat describes the file in the first store that has it
+func (fs *FallbackFileStore) Stat(key string) (os.FileInfo, error) {
+	var errs []error
+	for _, store := range fs.stores {
+		info, err := store.Stat(key)
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Remove deletes the file from every store, ignoring stores that don't
+// have it
+func (fs *FallbackFileStore) Remove(key string) error {
+	var errs []error
+	for _, store := range fs.stores {
+		if err := store.Remove(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newFileStore creates a file store from a backend spec. A spec is either a
+// local directory or "local:<dir>".
+func newFileStore(spec string) (FileStore, error) {
+	if dir, ok := strings.CutPrefix(spec, "local:"); ok {
+		spec = dir
+	} else if strings.Contains(spec, "://") {
+		return nil, fmt.Errorf("unsupported storage backend: %s", spec)
+	}
+
+	if spec == "" {
+		return nil, fmt.Errorf("storage backend directory is empty")
+	}
+	return NewLocalFileStore(spec), nil
+}
+
+// fileKey returns the FileStore key of a video's file
+func fileKey(videoID, filename string) string {
+	return videoID + "_" + filename
+}
+
+// copyToStore copies a video file from primary storage to store
+func (s *Server) copyToStore(store FileStore, videoID, filename string) error {
+	key := fileKey(videoID, filename)
+	src, err := os.Open(s.getFilePath(videoID, filename))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return store.Put(key, src)
+}
+
+// replicateToFallbacks copies a stored video file to the fallback storage
+// backends. The primary copy already exists, so failures are only logged.
+func (s *Server) replicateToFallbacks(videoID, filename string) {
+	if s.fallbackFiles == nil {
+		return
+	}
+
+	if err := s.copyToStore(s.fallbackFiles, videoID, filename); err != nil {
+		s.logger.Error().Err(err).Str("video_id", videoID).Msg("failed to copy video file to fallback storage")
+	}
+}
+
+// recoverMissingFile restores a video file that is missing from primary
+// storage by copying it from the fallback backends or the backup store. If
+// that is not possible it notifies storage.file_missing subscribers and
+// returns false.
+func (s *Server) recoverMissingFile(videoID, filename string) bool {
+	restoreErr := errors.New("no backup storage configured")
+	for _, source := range []FileStore{s.fallbackFiles, s.backupFiles} {
+		if source == nil {
+			continue
+		}
+		if restoreErr = s.restoreFrom(source, fileKey(videoID, filename)); restoreErr == nil {
+			break
+		}
+	}
+
+	if restoreErr == nil {
+		s.logger.Info().Str("video_id", videoID).Msg("restored missing video file from backup")
+		return true
+	}
+
+	s.logger.Error().Err(restoreErr).Str("video_id", videoID).Msg("failed to restore missing video file")
+	s.webhookMgr.NotifyWebhooks(EventStorageFileMissing, StorageFileMissingPayload{
+		SchemaVersion: WebhookPayloadSchemaVersion,
+		Event:         EventStorageFileMissing,
+		Timestamp:     time.Now().Unix(),
+		VideoID:       videoID,
+		Filename:      filename,
+		Error:         restoreErr.Error(),
+	})
+	return false
+}
+
+// restoreFrom copies the file stored under key from source to the primary
+// store
+func (s *Server) restoreFrom(source FileStore, key string) error {
+	src, err := source.Open(key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return s.files.Put(key, src)
+}
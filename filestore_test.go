@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFileStore(t *testing.T) {
+	store := NewLocalFileStore(t.TempDir())
+
+	exists, err := store.Exists("a_video.mp4")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, store.Put("a_video.mp4", strings.NewReader("content")))
+
+	exists, err = store.Exists("a_video.mp4")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	file, err := store.Open("a_video.mp4")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, "content", string(data))
+
+	require.NoError(t, store.Remove("a_video.mp4"))
+	_, err = store.Open("a_video.mp4")
+	assert.True(t, os.IsNotExist(err))
+
+	// Keys may not escape the root directory
+	assert.Error(t, store.Put("../escape.mp4", strings.NewReader("x")))
+}
+
+func TestNewFileStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := newFileStore(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, store.(*LocalFileStore).root)
+
+	store, err = newFileStore("local:" + dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, store.(*LocalFileStore).root)
+
+	_, err = newFileStore("s3://bucket")
+	assert.Error(t, err)
+}
+
+func TestDownloadRestoresMissingFileFromBackup(t *testing.T) {
+	server := newTestServer(t)
+	backup := NewLocalFileStore(t.TempDir())
+	server.backupFiles = backup
+
+	video := uploadTestVideo(t, server, "healed.mp4", []byte("original content"))
+
+	// The primary copy disappears, the backup still has it
+	key := fileKey(video.ID, video.Name)
+	require.NoError(t, backup.Put(key, strings.NewReader("original content")))
+	require.NoError(t, server.files.Remove(key))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "original content", w.Body.String())
+
+	exists, err := server.files.Exists(key)
+	require.NoError(t, err)
+	assert.True(t, exists, "file should be restored to primary storage")
+}
+
+func TestDownloadMissingFileNotifiesWebhook(t *testing.T) {
+	server := newTestServer(t)
+	server.backupFiles = NewLocalFileStore(t.TempDir())
+
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("storage.file_missing", receiver.server.URL))
+
+	video := uploadTestVideo(t, server, "lost.mp4", []byte("content"))
+	require.NoError(t, server.files.Remove(fileKey(video.ID, video.Name)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	require.Equal(t, 1, receiver.count())
+	payload := receiver.payloads[0]
+	assert.Equal(t, "storage.file_missing", payload["event"])
+	assert.Equal(t, video.ID, payload["video_id"])
+	assert.Equal(t, WebhookPayloadSchemaVersion, payload["schema_version"])
+}
+
+// failingFileStore is a FileStore whose every operation fails
+type failingFileStore struct{}
+
+var errStoreDown = errors.New("store unavailable")
+
+func (failingFileStore) Open(key string) (io.ReadCloser, error) { return nil, errStoreDown }
+func (failingFileStore) Put(key string, r io.Reader) error      { return errStoreDown }
+func (failingFileStore) Exists(key string) (bool, error)        { return false, errStoreDown }
+func (failingFileStore) Stat(key string) (os.FileInfo, error)   { return nil, errStoreDown }
+func (failingFileStore) Remove(key string) error                { return errStoreDown }
+
+func TestFallbackFileStore(t *testing.T) {
+	secondary := NewLocalFileStore(t.TempDir())
+	third := NewLocalFileStore(t.TempDir())
+	store := NewFallbackFileStore(failingFileStore{}, secondary, third)
+
+	// The primary is down, the upload still lands on the others
+	require.NoError(t, store.Put("a_video.mp4", strings.NewReader("content")))
+	for _, replica := range []*LocalFileStore{secondary, third} {
+		exists, err := replica.Exists("a_video.mp4")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	exists, err := store.Exists("a_video.mp4")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	file, err := store.Open("a_video.mp4")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, "content", string(data))
+
+	// Reads fall through to a later store when an earlier one lacks the file
+	require.NoError(t, secondary.Remove("a_video.mp4"))
+	file, err = store.Open("a_video.mp4")
+	require.NoError(t, err)
+	file.Close()
+
+	// Missing files are ignored on delete, the down primary is not
+	assert.ErrorIs(t, store.Remove("a_video.mp4"), errStoreDown)
+	exists, _ = third.Exists("a_video.mp4")
+	assert.False(t, exists)
+	assert.NoError(t, NewFallbackFileStore(secondary, third).Remove("a_video.mp4"))
+}
+
+func TestFallbackFileStoreAllFail(t *testing.T) {
+	store := NewFallbackFileStore(failingFileStore{}, failingFileStore{})
+
+	assert.ErrorIs(t, store.Put("a_video.mp4", strings.NewReader("content")), errStoreDown)
+	_, err := store.Open("a_video.mp4")
+	assert.ErrorIs(t, err, errStoreDown)
+	_, err = store.Exists("a_video.mp4")
+	assert.ErrorIs(t, err, errStoreDown)
+}
+
+func TestUploadSurvivesPrimaryStorageFailure(t *testing.T) {
+	server := newTestServer(t)
+	fallback := NewLocalFileStore(t.TempDir())
+	server.fallbackFiles = NewFallbackFileStore(fallback)
+
+	// A file in place of the storage directory makes every primary write fail
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	require.NoError(t, os.WriteFile(blocked, nil, 0644))
+	server.config.StoragePath = blocked
+
+	video := uploadTestVideo(t, server, "survivor.mp4", []byte("fallback content"))
+	file, err := fallback.Open(fileKey(video.ID, video.Name))
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "fallback content", string(content))
+	assert.NotEmpty(t, video.Hash, "the staged file is hashed before it leaves")
+
+	// With every backend down the upload fails
+	server.fallbackFiles = NewFallbackFileStore(failingFileStore{})
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "lost.mp4")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("content"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Len(t, server.db.GetAllVideos(), 1)
+}
+
+func TestUploadCopiedToFallbackStorage(t *testing.T) {
+	server := newTestServer(t)
+	fallback := NewLocalFileStore(t.TempDir())
+	server.fallbackFiles = NewFallbackFileStore(failingFileStore{}, fallback)
+
+	video := uploadTestVideo(t, server, "replicated.mp4", []byte("original content"))
+	key := fileKey(video.ID, video.Name)
+	exists, err := fallback.Exists(key)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// Lost from primary storage, the download is served from the fallback
+	require.NoError(t, server.files.Remove(key))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "original content", w.Body.String())
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	exists, _ = fallback.Exists(key)
+	assert.False(t, exists, "deleting a video should remove its fallback copies")
+}
@@ -1,69 +1,201 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 // uploadVideoHandler handles video uploads
 func (s *Server) uploadVideoHandler(c *gin.Context) {
-	// Parse multipart form
-	form, err := c.MultipartForm()
-	if err != nil {
-		s.logger.Error().Err(err).Msg("failed to parse multipart form")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form data"})
-		return
+	// Chunked requests are parsed as a stream so progress can be reported
+	var source *uploadSource
+	if isChunkedRequest(c.Request) {
+		source = s.streamingUploadSource(c)
+	} else {
+		source = s.bufferedUploadSource(c)
 	}
-
-	// Get file from form
-	files := form.File["file"]
-	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+	if source == nil {
 		return
 	}
+	defer source.close()
 
-	file := files[0]
-	
-	// Validate file size
-	if file.Size > s.config.MaxFileSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file too large, max size is %d bytes", s.config.MaxFileSize)})
+	video, ok := s.storeUpload(c, source)
+	if !ok {
 		return
 	}
 
+	s.respondSuccess(c, http.StatusCreated, gin.H{
+		"success": true,
+		"video":   video,
+	})
+}
+
+// storeUpload saves the file of an upload and adds its video, notifying
+// webhooks and subscribers. It writes an error response and returns false
+// on failure. Finished resumable uploads are stored through it too.
+func (s *Server) storeUpload(c *gin.Context, source *uploadSource) (*Video, bool) {
 	// Generate unique ID and filename
-	videoID := uuid.New().String()
-	filename := sanitizeFilename(file.Filename)
+	videoID := newVideoID()
+	filename := sanitizeFilename(source.filename)
+
+	// Reject file types that are not on the allowlist
+	if ext := normalizeExtension(filepath.Ext(filename)); !s.isExtensionAllowed(ext) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":     "file extension not allowed",
+			"extension": ext,
+			"allowed":   s.config.AllowedExtensions,
+		})
+		return nil, false
+	}
+
+	// Resolve name conflicts according to the configured strategy
+	var replaced *Video
+	version := 0
+	switch s.config.DuplicateNameStrategy {
+	case DuplicateNameReject:
+		if existing, exists := s.db.GetVideoByName(filename); exists {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "a video with this name already exists",
+				"video_id": existing.ID,
+			})
+			return nil, false
+		}
+	case DuplicateNameOverwrite:
+		if existing, exists := s.db.GetVideoByName(filename); exists {
+			replaced = existing
+		}
+	case DuplicateNameVersion:
+		filename, version = s.reserveVersion(filename)
+	}
 	
 	// Determine content type
-	contentType := file.Header.Get("Content-Type")
+	contentType := source.contentType
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
+	if !s.isContentTypeAllowed(contentType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":        "content type not allowed",
+			"content_type": contentType,
+			"allowed":      s.config.AllowedContentTypes,
+		})
+		return nil, false
+	}
 
-	// Create file path
+	checksums, err := parseUploadChecksums(source.checksumHeader)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	backend, store, ok := s.requestStorageBackend(c)
+	if !ok {
+		return nil, false
+	}
+
+	// Create file path. With fallback storage the file is staged outside
+	// StoragePath and then written through the fallback chain, so the
+	// upload only fails when every backend does.
 	filePath := filepath.Join(s.config.StoragePath, videoID+"_"+filename)
+	var fallbackChain FileStore
+	if store == nil && s.fallbackFiles != nil {
+		stageDir, err := os.MkdirTemp("", "upload-*")
+		if err != nil {
+			getLogger(c).Error().Err(err).Msg("failed to create upload staging directory")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return nil, false
+		}
+		defer os.RemoveAll(stageDir)
+		filePath = filepath.Join(stageDir, videoID+"_"+filename)
+		fallbackChain = NewFallbackFileStore(NewLocalFileStore(s.config.StoragePath), s.fallbackFiles)
+	}
 	
 	// Save file to disk
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to save uploaded file")
+	if err := source.save(filePath); err != nil {
+		os.Remove(filePath)
+		if errors.Is(err, errUploadTooLarge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file too large, max size is %d bytes", s.config.MaxFileSize)})
+			return nil, false
+		}
+		if errors.Is(err, context.Canceled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "upload cancelled"})
+			return nil, false
+		}
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to save uploaded file")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
-		return
+		return nil, false
 	}
 
 	// Get file info
 	stat, err := os.Stat(filePath)
 	if err != nil {
-		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to get file stats")
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to get file stats")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file info"})
-		return
+		return nil, false
+	}
+
+	var tenantID string
+	if principal := principalFrom(c); principal != nil {
+		tenantID = principal.TenantID
+	}
+	if remaining, ok := s.tenantQuotaRemaining(tenantID); ok && stat.Size() > remaining {
+		os.Remove(filePath)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":                 "tenant quota exceeded",
+			"quota_remaining_bytes": remaining,
+		})
+		return nil, false
+	}
+
+	// Reject content that differs from what the client declared
+	if len(checksums) > 0 {
+		mismatch, actual, err := verifyUploadChecksums(filePath, checksums)
+		if err != nil {
+			os.Remove(filePath)
+			getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to verify upload checksum")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash file"})
+			return nil, false
+		}
+		if mismatch != nil {
+			os.Remove(filePath)
+			getLogger(c).Warn().
+				Str("filename", filename).
+				Str("algorithm", mismatch.algorithm).
+				Msg("upload checksum mismatch")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "checksum mismatch",
+				"expected": mismatch.expected,
+				"actual":   actual,
+			})
+			return nil, false
+		}
+	}
+
+	// Hash the stored file so later integrity checks have a reference. With
+	// hash workers the record is stored without one and hashed afterwards,
+	// unless the file is leaving the local disk or may not reach it.
+	var fileHash string
+	if s.hashQueue == nil || store != nil || fallbackChain != nil {
+		fileHash, err = computeFileHash(filePath, defaultHashAlgorithm)
+		if err != nil {
+			getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to hash uploaded file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash file"})
+			return nil, false
+		}
 	}
 
 	// Create video record
@@ -75,84 +207,290 @@ func (s *Server) uploadVideoHandler(c *gin.Context) {
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 		URL:         fmt.Sprintf("/api/videos/%s", videoID),
+		Hash:        fileHash,
+		Version:     version,
+		Tags:        normalizeTags(source.tags),
+
+		CollectionID: strings.TrimSpace(source.collection),
+		TenantID:     tenantID,
+
+		StorageBackend: backend,
+	}
+
+	// Files for another backend are only staged on the local disk
+	key := fileKey(videoID, filename)
+	if store != nil {
+		if err := moveToStorageBackend(store, filePath, key); err != nil {
+			os.Remove(filePath)
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Str("backend", backend).Msg("failed to move uploaded file to storage backend")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return nil, false
+		}
+	} else if fallbackChain != nil {
+		if err := moveToStorageBackend(fallbackChain, filePath, key); err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to save uploaded file to any storage backend")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return nil, false
+		}
+	}
+
+	// The old record goes first, deleting it afterwards would also drop the
+	// new video from the name index
+	if replaced != nil {
+		s.removeVideo(replaced)
 	}
 
 	// Add to database
-	s.db.AddVideo(video)
+	if err := s.db.AddVideo(video); err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", video.ID).Msg("failed to save video record")
+		os.Remove(filePath)
+		if store != nil {
+			store.Remove(key)
+		} else if fallbackChain != nil {
+			fallbackChain.Remove(key)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save video"})
+		return nil, false
+	}
 
-	s.logger.Info().
+	getLogger(c).Info().
 		Str("video_id", video.ID).
 		Str("filename", video.Name).
 		Int64("size", video.Size).
 		Msg("video uploaded successfully")
 
+	// Fallbacks, mirrors, hashing and sprites all read the local file
+	if store == nil {
+		if s.hashQueue != nil && fileHash == "" {
+			s.enqueueHash(hashJob{VideoID: video.ID, FilePath: filePath})
+		}
+		if fallbackChain == nil {
+			s.replicateToFallbacks(video.ID, video.Name)
+		}
+		s.mirrorVideoFile(video.ID, video.Name)
+	}
+
+	s.recordVideoEvent(c, video.ID, VideoEventUploaded, nil, video)
+
 	// Trigger webhook for video upload event
-	go s.webhookMgr.NotifyWebhooks("video.uploaded", gin.H{
-		"video":   video,
-		"event":   "video.uploaded",
-		"timestamp": time.Now().Unix(),
-	})
+	payload, _ := videoWebhookPayload(EventVideoUploaded, video)
+	s.webhookMgr.NotifyWebhooksContext(c.Request.Context(), EventVideoUploaded, payload)
+	s.publishEvent(EventVideoUploaded, payload)
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-		"video":   video,
-	})
+	if s.config.GenerateSprites && store == nil {
+		go s.generateSprites(video.ID)
+	}
+
+	return video, true
 }
 
 // downloadVideoHandler serves video files with range support
 func (s *Server) downloadVideoHandler(c *gin.Context) {
 	videoID := c.Param("id")
+
+	if s.proxyToOwner(c, videoID) {
+		return
+	}
 	
-	video, exists := s.db.GetVideoByID(videoID)
+	// Only a few fields are needed, so borrow the record instead of copying it
+	video, release, exists := getVideoRef(s.db, videoID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		release()
+		respondNegotiated(c, http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+	allowed := videoAccessAllowed(principalFrom(c), video, aclScopeRead)
+	name, contentType, size := video.Name, videoContentType(video.Name, video.ContentType), video.Size
+	backend := video.StorageBackend
+	release()
+	if !allowed {
+		respondNegotiated(c, http.StatusForbidden, gin.H{"error": "access denied", "scope": aclScopeRead})
+		return
+	}
+
+	// The header overrides the backend recorded at upload
+	requested, store, ok := s.requestStorageBackend(c)
+	if !ok {
+		return
+	}
+	if requested == "" {
+		if store, ok = s.storageBackend(backend); !ok {
+			getLogger(c).Error().Str("video_id", videoID).Str("backend", backend).Msg("video stored in an unconfigured storage backend")
+			respondNegotiated(c, http.StatusNotFound, gin.H{"error": "video file not found"})
+			return
+		}
+	}
+	if store != nil {
+		s.serveFromStorageBackend(c, store, videoID, name, contentType)
 		return
 	}
 
-	filePath := filepath.Join(s.config.StoragePath, videoID+"_"+video.Name)
+	filePath := filepath.Join(s.config.StoragePath, videoID+"_"+name)
 	
-	// Check if file exists
+	// Check if file exists, falling back to the backup store if it doesn't
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		s.logger.Error().Str("filepath", filePath).Msg("video file not found on disk")
-		c.JSON(http.StatusNotFound, gin.H{"error": "video file not found"})
-		return
+		getLogger(c).Error().Str("filepath", filePath).Msg("video file not found on disk")
+		if !s.recoverMissingFile(videoID, name) {
+			respondNegotiated(c, http.StatusNotFound, gin.H{"error": "video file not found"})
+			return
+		}
 	}
 
+	s.recordDownloadEvent(c, videoID)
+
 	// Handle range requests for streaming
 	rangeHeader := c.GetHeader("Range")
 	if rangeHeader != "" {
-		s.serveRangeRequest(c, filePath, video)
+		s.serveRangeRequest(c, videoID, filePath, contentType)
 		return
 	}
 
 	// Serve the entire file
-	c.Header("Content-Type", video.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", video.Size))
+	file, err := os.Open(filePath)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to open video file")
+		respondNegotiated(c, http.StatusInternalServerError, gin.H{"error": "failed to read video file"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to stat video file")
+		respondNegotiated(c, http.StatusInternalServerError, gin.H{"error": "failed to read video file"})
+		return
+	}
+
+	setVideoContentHeaders(c, contentType, inlineDisposition(name))
+	c.Header("Content-Length", fmt.Sprintf("%d", size))
 	c.Header("Accept-Ranges", "bytes")
 	
-	http.ServeFile(c.Writer, c.Request, filePath)
+	s.serveVideoFile(c, name, file, info)
 }
 
-// serveRangeRequest handles HTTP range requests for video streaming
-func (s *Server) serveRangeRequest(c *gin.Context, filePath string, video *Video) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to open video file")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+// contentTypeExtensions maps video MIME types to the file extension used for
+// attachment downloads
+var contentTypeExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"video/ogg":        ".ogv",
+	"video/x-msvideo":  ".avi",
+	"video/quicktime":  ".mov",
+	"video/x-matroska": ".mkv",
+	"video/x-flv":      ".flv",
+	"video/mpeg":       ".mpeg",
+	"video/mp2t":       ".ts",
+	"video/3gpp":       ".3gp",
+	"video/x-ms-wmv":   ".wmv",
+}
+
+// extensionForContentType returns the file extension for a content type,
+// ".bin" when the type is unknown
+func extensionForContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	if ext, ok := contentTypeExtensions[strings.ToLower(contentType)]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// videoContentType returns the MIME type to serve a video with. Uploads
+// whose client sent no type are stored as application/octet-stream, so for
+// those the type is looked up from the file extension instead.
+func videoContentType(name, contentType string) string {
+	if contentType != "" && contentType != "application/octet-stream" {
+		return contentType
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for mediaType, known := range contentTypeExtensions {
+		if known == ext {
+			return mediaType
+		}
+	}
+	if ext == ".mpg" {
+		return "video/mpeg"
+	}
+	return "application/octet-stream"
+}
+
+// inlineDisposition is the Content-Disposition of a video browsers should
+// play in place rather than save
+func inlineDisposition(name string) string {
+	return mime.FormatMediaType("inline", map[string]string{"filename": name})
+}
+
+// setVideoContentHeaders sets the headers describing a video response body.
+// Content-Transfer-Encoding is not part of HTTP, but some legacy proxies
+// mangle non-text bodies without it.
+func setVideoContentHeaders(c *gin.Context, contentType, disposition string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", disposition)
+	if !strings.HasPrefix(contentType, "text/") {
+		c.Header("Content-Transfer-Encoding", "binary")
+	}
+}
+
+// directDownloadHandler serves a video as a file download named
+// <videoID><ext>, with the extension derived from the stored content type
+func (s *Server) directDownloadHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	// Sessions are held by the node that created them, so they are checked
+	// before proxying to the owner
+	if !s.checkDownloadSession(c, videoID) {
 		return
 	}
-	defer file.Close()
 
+	if s.proxyToOwner(c, videoID) {
+		return
+	}
+
+	video, release, exists := getVideoRef(s.db, videoID)
+	if !exists {
+		release()
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+	allowed := videoAccessAllowed(principalFrom(c), video, aclScopeRead)
+	name, contentType := video.Name, videoContentType(video.Name, video.ContentType)
+	release()
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied", "scope": aclScopeRead})
+		return
+	}
+
+	filePath := s.getFilePath(videoID, name)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		getLogger(c).Error().Str("filepath", filePath).Msg("video file not found on disk")
+		if !s.recoverMissingFile(videoID, name) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "video file not found"})
+			return
+		}
+	}
+
+	s.recordDownloadEvent(c, videoID)
+
+	setVideoContentHeaders(c, contentType, fmt.Sprintf(`attachment; filename="%s%s"`, videoID, extensionForContentType(contentType)))
+
+	http.ServeFile(c.Writer, c.Request, filePath)
+}
+
+// serveRangeRequest handles HTTP range requests for video streaming.
+// Single ranges of the video videoID up to MaxCachableSegment bytes are
+// served from the segment cache, an empty videoID bypasses it.
+func (s *Server) serveRangeRequest(c *gin.Context, videoID, filePath, contentType string) {
 	// Get file info
-	stat, err := file.Stat()
+	stat, err := os.Stat(filePath)
 	if err != nil {
-		s.logger.Error().Err(err).Str("filepath", filePath).Msg("failed to get file stats")
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to get file stats")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file info"})
 		return
 	}
 
 	// Parse range header
-	start, end, err := parseRangeHeader(c.GetHeader("Range"), stat.Size())
+	ranges, err := parseRangeHeader(c.GetHeader("Range"), stat.Size())
 	if err != nil {
 		c.Header("Content-Range", fmt.Sprintf("bytes */%d", stat.Size()))
 		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "invalid range"})
@@ -160,43 +498,198 @@ func (s *Server) serveRangeRequest(c *gin.Context, filePath string, video *Video
 	}
 
 	// Calculate content length
+	start, end := ranges[0].Start, ranges[0].End
 	contentLength := end - start + 1
 
+	cacheKey := segmentCacheKey{videoID: videoID, start: start, end: end}
+	cachable := s.segmentCache != nil && videoID != "" && len(ranges) == 1 && contentLength <= s.config.MaxCachableSegment
+	if cachable {
+		if data, ok := s.segmentCache.Get(cacheKey); ok {
+			setRangeHeaders(c, contentType, start, end, stat.Size())
+			c.Status(http.StatusPartialContent)
+			if _, err := c.Writer.Write(data); err != nil {
+				getLogger(c).Debug().Err(err).Str("filepath", filePath).Msg("failed to write cached range")
+			}
+			return
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filepath", filePath).Msg("failed to open video file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+		return
+	}
+	defer file.Close()
+
+	if len(ranges) > 1 {
+		s.serveMultipartRanges(c, file, filePath, contentType, ranges, stat.Size())
+		return
+	}
+
 	// Seek to start position
 	if _, err := file.Seek(start, 0); err != nil {
-		s.logger.Error().Err(err).Int64("start", start).Msg("failed to seek file")
+		getLogger(c).Error().Err(err).Int64("start", start).Msg("failed to seek file")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
 		return
 	}
 
-	// Set headers
-	c.Header("Content-Type", video.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", contentLength))
-	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, stat.Size()))
-	c.Header("Accept-Ranges", "bytes")
-
-	// Set status code for partial content
+	// Set headers and the status code for partial content
+	setRangeHeaders(c, contentType, start, end, stat.Size())
 	c.Status(http.StatusPartialContent)
 
+	// Read again in the background, so this response isn't held up
+	if cachable {
+		s.segmentCache.fillFromFile(cacheKey, filePath)
+	}
+
 	// Stream the content
-	if _, err := io.CopyN(c.Writer, file, contentLength); err != nil {
-		s.logger.Error().Err(err).Msg("failed to stream file")
+	if _, err := copyRangeChunked(c.Request.Context(), c.Writer, file, contentLength, s.config.StreamChunkSize); err != nil {
+		if errors.Is(err, context.Canceled) {
+			getLogger(c).Debug().Str("filepath", filePath).Msg("client disconnected during range request")
+			return
+		}
+		getLogger(c).Error().Err(err).Msg("failed to stream file")
 		return
 	}
 }
 
-// parseRangeHeader parses the Range header and returns start and end positions
-func parseRangeHeader(rangeHeader string, fileSize int64) (int64, int64, error) {
+// setRangeHeaders sets the headers of a single range response
+func setRangeHeaders(c *gin.Context, contentType string, start, end, fileSize int64) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", fmt.Sprintf("%d", end-start+1))
+	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
+	c.Header("Accept-Ranges", "bytes")
+}
+
+// serveMultipartRanges answers a request for several ranges with a
+// multipart/byteranges body, one part per range
+func (s *Server) serveMultipartRanges(c *gin.Context, file *os.File, filePath, contentType string, ranges []RangePair, fileSize int64) {
+	mw := multipart.NewWriter(c.Writer)
+
+	c.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.Header("Accept-Ranges", "bytes")
+	c.Status(http.StatusPartialContent)
+
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, fileSize)},
+		})
+		if err != nil {
+			getLogger(c).Error().Err(err).Msg("failed to write range part")
+			return
+		}
+
+		if _, err := file.Seek(r.Start, io.SeekStart); err != nil {
+			getLogger(c).Error().Err(err).Int64("start", r.Start).Msg("failed to seek file")
+			return
+		}
+
+		if _, err := copyRangeChunked(c.Request.Context(), part, file, r.End-r.Start+1, s.config.StreamChunkSize); err != nil {
+			if errors.Is(err, context.Canceled) {
+				getLogger(c).Debug().Str("filepath", filePath).Msg("client disconnected during range request")
+				return
+			}
+			getLogger(c).Error().Err(err).Msg("failed to stream file")
+			return
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to finish multipart range response")
+	}
+}
+
+// copyRangeChunked copies n bytes from src to dst. Ranges larger than
+// chunkSize are copied chunk by chunk, stopping early once ctx is done so a
+// disconnected client releases the file straight away.
+func copyRangeChunked(ctx context.Context, dst io.Writer, src io.Reader, n, chunkSize int64) (int64, error) {
+	if chunkSize <= 0 || n <= chunkSize {
+		return io.CopyN(dst, src, n)
+	}
+
+	var written int64
+	for written < n {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		chunk := chunkSize
+		if remaining := n - written; remaining < chunk {
+			chunk = remaining
+		}
+
+		copied, err := io.CopyN(dst, src, chunk)
+		written += copied
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// RangePair is an inclusive byte range resolved against the file size
+type RangePair struct {
+	Start int64
+	End   int64
+}
+
+// maxRanges caps how many ranges one Range header may ask for
+const maxRanges = 16
+
+// parseRangeHeader parses the Range header into byte ranges. Several ranges
+// may be given separated by commas, e.g. "bytes=0-499,-200"; they are
+// returned sorted, with overlapping and adjacent ranges merged. Like
+// net/http, ranges adding up to more than the file select the whole file,
+// so one request can't have the file sent several times. An empty header
+// selects the whole file too.
+func parseRangeHeader(rangeHeader string, fileSize int64) ([]RangePair, error) {
+	whole := []RangePair{{Start: 0, End: fileSize - 1}}
 	if rangeHeader == "" {
-		return 0, fileSize - 1, nil
+		return whole, nil
 	}
 
-	// Format: "bytes=start-end"
+	// Format: "bytes=start-end[,start-end...]"
 	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return 0, 0, fmt.Errorf("invalid range header format")
+		return nil, fmt.Errorf("invalid range header format")
+	}
+	specs := strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), ",")
+	if len(specs) > maxRanges {
+		return nil, fmt.Errorf("more than %d ranges", maxRanges)
+	}
+
+	var ranges []RangePair
+	var total int64
+	for _, spec := range specs {
+		start, end, err := parseRangeSpec(strings.TrimSpace(spec), fileSize)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, RangePair{Start: start, End: end})
+		total += end - start + 1
+	}
+	if total > fileSize {
+		return whole, nil
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+		} else {
+			merged = append(merged, r)
+		}
 	}
+	return merged, nil
+}
 
-	rangeStr := strings.TrimPrefix(rangeHeader, "bytes=")
+// parseRangeSpec parses a single range spec such as "0-499", "500-" or
+// "-200" and returns its start and end positions
+func parseRangeSpec(rangeStr string, fileSize int64) (int64, int64, error) {
 	parts := strings.Split(rangeStr, "-")
 	if len(parts) != 2 {
 		return 0, 0, fmt.Errorf("invalid range format")
@@ -247,6 +740,141 @@ func parseNumber(s string) (int64, error) {
 	return n, err
 }
 
+// isExtensionAllowed reports whether uploads with the given extension are accepted.
+// An empty allowlist accepts every extension, including files without one.
+func (s *Server) isExtensionAllowed(ext string) bool {
+	if len(s.config.AllowedExtensions) == 0 {
+		return true
+	}
+
+	for _, allowed := range s.config.AllowedExtensions {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// replicaFetchTimeout bounds fetching a video announced by another
+// instance, so a stalled peer cannot hold the fetch open forever
+const replicaFetchTimeout = 10 * time.Minute
+
+// checkReplicaSource reports whether a replicated video may be fetched from
+// rawURL: from one of the ClusterNodes, or from a URL that passes the checks
+// webhook targets get
+func (s *Server) checkReplicaSource(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	for _, node := range s.config.ClusterNodes {
+		if peer, err := url.Parse(node); err == nil && peer.Scheme == u.Scheme && peer.Host == u.Host {
+			return nil
+		}
+	}
+	return s.validateWebhookURL(rawURL)
+}
+
+// fetchAndStore downloads a video announced by another instance and stores it
+// locally under the same ID
+func (s *Server) fetchAndStore(sourceURL string, remote *Video) {
+	logger := s.logger.With().Str("video_id", remote.ID).Logger()
+
+	// The ID becomes part of the file path
+	if !isValidVideoID(remote.ID) {
+		logger.Error().Msg("refusing to replicate video with an invalid ID")
+		return
+	}
+
+	fetchURL := remote.URL
+	if !strings.HasPrefix(fetchURL, "http://") && !strings.HasPrefix(fetchURL, "https://") {
+		if sourceURL == "" {
+			logger.Error().Msg("cannot fetch replicated video without a source URL")
+			return
+		}
+		fetchURL = strings.TrimSuffix(sourceURL, "/") + "/api/videos/" + remote.ID
+	}
+	if err := s.checkReplicaSource(fetchURL); err != nil {
+		logger.Error().Err(err).Str("url", fetchURL).Msg("refusing to fetch replicated video")
+		return
+	}
+
+	client := &http.Client{
+		Timeout: replicaFetchTimeout,
+		// Redirects must lead somewhere the video could be fetched from directly
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return s.checkReplicaSource(req.URL.String())
+		},
+	}
+	resp, err := client.Get(fetchURL)
+	if err != nil {
+		logger.Error().Err(err).Str("url", fetchURL).Msg("failed to fetch replicated video")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error().Int("status", resp.StatusCode).Str("url", fetchURL).Msg("replicated video fetch returned non-success status")
+		return
+	}
+
+	filename := sanitizeFilename(remote.Name)
+	filePath := s.getFilePath(remote.ID, filename)
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		logger.Error().Err(err).Str("filepath", filePath).Msg("failed to create replicated video file")
+		return
+	}
+
+	// Read one byte past the limit so oversized files can be detected
+	size, err := io.Copy(file, io.LimitReader(resp.Body, s.config.MaxFileSize+1))
+	file.Close()
+	if err == nil && size > s.config.MaxFileSize {
+		err = fmt.Errorf("file too large, max size is %d bytes", s.config.MaxFileSize)
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("filepath", filePath).Msg("failed to store replicated video")
+		os.Remove(filePath)
+		return
+	}
+
+	fileHash, err := computeFileHash(filePath, defaultHashAlgorithm)
+	if err != nil {
+		logger.Error().Err(err).Str("filepath", filePath).Msg("failed to hash replicated video")
+		os.Remove(filePath)
+		return
+	}
+
+	contentType := remote.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	video := &Video{
+		ID:          remote.ID,
+		Name:        filename,
+		Size:        size,
+		ContentType: contentType,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		URL:         fmt.Sprintf("/api/videos/%s", remote.ID),
+		Hash:        fileHash,
+	}
+	if err := s.db.AddVideo(video); err != nil {
+		logger.Error().Err(err).Msg("failed to save replicated video record")
+		os.Remove(filePath)
+		return
+	}
+
+	s.replicateToFallbacks(video.ID, video.Name)
+
+	logger.Info().Str("source", fetchURL).Int64("size", size).Msg("replicated video stored")
+}
+
 // sanitizeFilename sanitizes a filename to prevent path traversal
 func sanitizeFilename(filename string) string {
 	// Remove any path separators to prevent directory traversal
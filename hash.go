@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultHashAlgorithm is used for Video.Hash and when no algorithm is requested
+const defaultHashAlgorithm = "sha256"
+
+// hashCacheKey identifies a cached hash computation
+type hashCacheKey struct {
+	videoID   string
+	algorithm string
+}
+
+// hashCacheEntry is a hash computed from disk at a point in time
+type hashCacheEntry struct {
+	hash       string
+	computedAt time.Time
+}
+
+// newHasher returns a hash implementation for the given algorithm name
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		// MD5 and SHA1 are only offered for compatibility with legacy clients
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+	}
+}
+
+// computeFileHash hashes the file at path and returns the hex encoded digest
+func computeFileHash(path, algorithm string) (string, error) {
+	hasher, err := newHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// uploadChecksum is a digest a client declared for its upload, kept in the
+// encoding of the header it came in
+type uploadChecksum struct {
+	algorithm string
+	expected  string
+	encode    func([]byte) string
+}
+
+// parseUploadChecksums reads the Content-MD5 (base64, RFC 1864) and
+// X-Content-SHA256 (hex) upload headers
+func parseUploadChecksums(header http.Header) ([]uploadChecksum, error) {
+	var checksums []uploadChecksum
+
+	if value := strings.TrimSpace(header.Get("Content-MD5")); value != "" {
+		if digest, err := base64.StdEncoding.DecodeString(value); err != nil || len(digest) != md5.Size {
+			return nil, fmt.Errorf("Content-MD5 must be a base64 encoded MD5 digest")
+		}
+		checksums = append(checksums, uploadChecksum{
+			algorithm: "md5",
+			expected:  value,
+			encode:    base64.StdEncoding.EncodeToString,
+		})
+	}
+
+	if value := strings.ToLower(strings.TrimSpace(header.Get("X-Content-SHA256"))); value != "" {
+		if digest, err := hex.DecodeString(value); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("X-Content-SHA256 must be a hex encoded SHA-256 digest")
+		}
+		checksums = append(checksums, uploadChecksum{
+			algorithm: "sha256",
+			expected:  value,
+			encode:    hex.EncodeToString,
+		})
+	}
+
+	return checksums, nil
+}
+
+// verifyUploadChecksums hashes the file at path once for all checksums and
+// returns the first that doesn't match, with the actual digest
+func verifyUploadChecksums(path string, checksums []uploadChecksum) (*uploadChecksum, string, error) {
+	hashers := make([]hash.Hash, len(checksums))
+	writers := make([]io.Writer, len(checksums))
+	for i, checksum := range checksums {
+		hasher, err := newHasher(checksum.algorithm)
+		if err != nil {
+			return nil, "", err
+		}
+		hashers[i], writers[i] = hasher, hasher
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return nil, "", err
+	}
+
+	for i := range checksums {
+		if actual := checksums[i].encode(hashers[i].Sum(nil)); actual != checksums[i].expected {
+			return &checksums[i], actual, nil
+		}
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errHashTimeout is returned by WaitForHash when the hash is not ready in time
+var errHashTimeout = errors.New("timed out waiting for video hash")
+
+// hashJob asks a hash worker to hash an uploaded file
+type hashJob struct {
+	VideoID  string
+	FilePath string
+}
+
+// videoHashUpdater is implemented by stores that can set a video's hash
+// without replacing the whole record
+type videoHashUpdater interface {
+	UpdateVideoHash(id, hash string) error
+}
+
+// UpdateVideoHash sets a video's hash and wakes any WaitForHash callers
+func (db *InMemoryDB) UpdateVideoHash(id, hash string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	existing, exists := db.videos[id]
+	if !exists {
+		return ErrVideoNotFound
+	}
+
+	// Replaced rather than modified, AddVideo callers may still hold existing
+	updated := *existing
+	updated.Hash = hash
+	db.videos[id] = &updated
+	db.notifyHashWaiters(id)
+	db.markDirty(id)
+	return nil
+}
+
+// WaitForHash blocks until the video's hash is set, the video is deleted or
+// timeout passes
+func (db *InMemoryDB) WaitForHash(id string, timeout time.Duration) (string, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		db.mutex.Lock()
+		video, exists := db.videos[id]
+		if !exists {
+			db.mutex.Unlock()
+			return "", ErrVideoNotFound
+		}
+		if video.Hash != "" {
+			db.mutex.Unlock()
+			return video.Hash, nil
+		}
+
+		ready, waiting := db.hashWaiters[id]
+		if !waiting {
+			ready = make(chan struct{})
+			db.hashWaiters[id] = ready
+		}
+		db.mutex.Unlock()
+
+		select {
+		case <-ready:
+		case <-deadline.C:
+			return "", errHashTimeout
+		}
+	}
+}
+
+// notifyHashWaiters wakes the WaitForHash callers for a video. The caller
+// must hold the write lock.
+func (db *InMemoryDB) notifyHashWaiters(id string) {
+	if ready, waiting := db.hashWaiters[id]; waiting {
+		close(ready)
+		delete(db.hashWaiters, id)
+	}
+}
+
+// startHashWorkers starts Config.HashWorkers workers hashing uploads in the
+// background. Uploads are hashed synchronously when no workers are
+// configured.
+func (s *Server) startHashWorkers() {
+	if s.config.HashWorkers < 1 {
+		return
+	}
+
+	size := s.config.HashQueueSize
+	if size < 1 {
+		size = 1
+	}
+	s.hashQueue = make(chan hashJob, size)
+	s.hashStop = make(chan struct{})
+	for i := 0; i < s.config.HashWorkers; i++ {
+		go s.hashWorker()
+	}
+}
+
+// hashWorker hashes queued uploads until the server shuts down. Jobs still
+// queued then are picked up by the hash migration on the next start.
+func (s *Server) hashWorker() {
+	for {
+		select {
+		case <-s.hashStop:
+			return
+		case job := <-s.hashQueue:
+			s.hashUploadedFile(job)
+		}
+	}
+}
+
+// enqueueHash queues an uploaded file for hashing. When the queue is full
+// the file is hashed straight away, slowing uploads down instead of
+// dropping the job.
+func (s *Server) enqueueHash(job hashJob) {
+	select {
+	case s.hashQueue <- job:
+	default:
+		s.logger.Warn().Str("video_id", job.VideoID).Msg("hash queue full, hashing upload synchronously")
+		s.hashUploadedFile(job)
+	}
+}
+
+// hashUploadedFile computes and stores the hash of a queued upload
+func (s *Server) hashUploadedFile(job hashJob) {
+	hash, err := computeFileHash(job.FilePath, defaultHashAlgorithm)
+	if err != nil {
+		s.logger.Error().Err(err).Str("video_id", job.VideoID).Msg("failed to hash uploaded file")
+		return
+	}
+
+	if err := storeVideoHash(s.db, job.VideoID, hash); err != nil && !errors.Is(err, ErrVideoNotFound) {
+		s.logger.Error().Err(err).Str("video_id", job.VideoID).Msg("failed to store video hash")
+	}
+}
+
+// storeVideoHash sets a video's hash, through UpdateVideoHash when the
+// store supports it
+func storeVideoHash(db VideoStore, id, hash string) error {
+	if updater, ok := db.(videoHashUpdater); ok {
+		return updater.UpdateVideoHash(id, hash)
+	}
+
+	// Only the hash is set, so edits made while the file was hashed are kept
+	_, err := db.UpdateVideoFunc(id, func(video *Video) error {
+		video.Hash = hash
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hashResponse struct {
+	VideoID   string `json:"video_id"`
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+	FileSize  int64  `json:"file_size"`
+	Corrupted bool   `json:"corrupted"`
+}
+
+func getVideoHash(t *testing.T, server *Server, videoID, query string) (int, hashResponse) {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", "/api/videos/"+videoID+"/hash"+query, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var resp hashResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func TestVideoHashAlgorithms(t *testing.T) {
+	server := newTestServer(t)
+	data := []byte("fake video content for hashing")
+	video := uploadTestVideo(t, server, "hash.mp4", data)
+
+	sha256Sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+	sha1Sum := sha1.Sum(data)
+
+	tests := []struct {
+		query     string
+		algorithm string
+		expected  string
+	}{
+		{"", "sha256", hex.EncodeToString(sha256Sum[:])},
+		{"?algorithm=sha256", "sha256", hex.EncodeToString(sha256Sum[:])},
+		{"?algorithm=md5", "md5", hex.EncodeToString(md5Sum[:])},
+		{"?algorithm=sha1", "sha1", hex.EncodeToString(sha1Sum[:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm+tt.query, func(t *testing.T) {
+			code, resp := getVideoHash(t, server, video.ID, tt.query)
+
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, video.ID, resp.VideoID)
+			assert.Equal(t, tt.algorithm, resp.Algorithm)
+			assert.Equal(t, tt.expected, resp.Hash)
+			assert.Equal(t, int64(len(data)), resp.FileSize)
+			assert.False(t, resp.Corrupted)
+		})
+	}
+
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		code, _ := getVideoHash(t, server, video.ID, "?algorithm=crc32")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		code, _ := getVideoHash(t, server, "missing", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func TestVideoHashDetectsCorruption(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "corrupt.mp4", []byte("original content"))
+
+	// Overwrite the stored file in place
+	filePath := server.getFilePath(video.ID, video.Name)
+	require.NoError(t, os.WriteFile(filePath, []byte("tampered content"), 0644))
+
+	code, resp := getVideoHash(t, server, video.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Corrupted)
+	assert.NotEqual(t, video.Hash, resp.Hash)
+}
+
+func TestVideoHashCache(t *testing.T) {
+	server := newTestServer(t)
+	server.config.HashCacheTTL = time.Minute
+	video := uploadTestVideo(t, server, "cached.mp4", []byte("original content"))
+
+	_, first := getVideoHash(t, server, video.ID, "")
+	assert.Equal(t, video.Hash, first.Hash)
+
+	// A cached result is returned until the TTL expires
+	filePath := server.getFilePath(video.ID, video.Name)
+	require.NoError(t, os.WriteFile(filePath, []byte("tampered content"), 0644))
+
+	_, second := getVideoHash(t, server, video.ID, "")
+	assert.Equal(t, first.Hash, second.Hash)
+
+	server.config.HashCacheTTL = time.Nanosecond
+	_, third := getVideoHash(t, server, video.ID, "")
+	assert.NotEqual(t, first.Hash, third.Hash)
+	assert.True(t, third.Corrupted)
+}
+
+func TestAsyncUploadHashing(t *testing.T) {
+	db := NewInMemoryDB()
+	config := &Config{
+		StoragePath:   t.TempDir(),
+		MaxFileSize:   1024 * 1024,
+		HashWorkers:   2,
+		HashQueueSize: 4,
+	}
+	server := NewServer(config, db)
+	defer close(server.hashStop)
+	defer close(server.retentionStop)
+	defer server.migrator.Stop()
+
+	data := []byte("content hashed in the background")
+	expected := sha256.Sum256(data)
+	for i := 0; i < 10; i++ {
+		video := uploadTestVideo(t, server, "async.mp4", data)
+
+		// The hash shows up once a worker gets to it
+		hash, err := db.WaitForHash(video.ID, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(expected[:]), hash)
+
+		stored, _ := db.GetVideoByID(video.ID)
+		assert.Equal(t, hash, stored.Hash)
+	}
+}
+
+func TestWaitForHash(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newTestVideo("a", 1))
+	db.AddVideo(newTestVideo("b", 1))
+
+	_, err := db.WaitForHash("a", 20*time.Millisecond)
+	assert.ErrorIs(t, err, errHashTimeout)
+
+	_, err = db.WaitForHash("missing", time.Second)
+	assert.ErrorIs(t, err, ErrVideoNotFound)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.UpdateVideoHash("a", "abc")
+		db.DeleteVideo("b")
+	}()
+
+	hash, err := db.WaitForHash("a", 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", hash)
+
+	// Deleting the video gives up the wait
+	_, err = db.WaitForHash("b", 5*time.Second)
+	assert.ErrorIs(t, err, ErrVideoNotFound)
+}
+
+// uploadWithHeaders uploads data with extra request headers
+func uploadWithHeaders(t *testing.T, server *Server, filename string, data []byte, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadChecksums(t *testing.T) {
+	server := newTestServer(t)
+	data := []byte("checksummed video content")
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	contentSHA256 := hex.EncodeToString(sha256Sum[:])
+
+	w := uploadWithHeaders(t, server, "match.mp4", data, map[string]string{
+		"Content-MD5":      contentMD5,
+		"X-Content-SHA256": strings.ToUpper(contentSHA256),
+	})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	otherMD5 := md5.Sum([]byte("other"))
+	otherSHA256 := sha256.Sum256([]byte("other"))
+	for name, headers := range map[string]map[string]string{
+		"md5":    {"Content-MD5": base64.StdEncoding.EncodeToString(otherMD5[:])},
+		"sha256": {"Content-MD5": contentMD5, "X-Content-SHA256": hex.EncodeToString(otherSHA256[:])},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := uploadWithHeaders(t, server, "mismatch-"+name+".mp4", data, headers)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+
+			var resp map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "checksum mismatch", resp["error"])
+			if name == "md5" {
+				assert.Equal(t, contentMD5, resp["actual"])
+			} else {
+				assert.Equal(t, contentSHA256, resp["actual"])
+			}
+		})
+	}
+
+	w = uploadWithHeaders(t, server, "invalid.mp4", data, map[string]string{"X-Content-SHA256": "not-hex"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only the matching upload was kept
+	entries := storedVideoFiles(t, server.config.StoragePath)
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0], "match.mp4")
+	assert.Len(t, server.db.GetAllVideos(), 1)
+}
+
+// editingStore makes edit, as a concurrent request would, right after a
+// GetVideoByID, leaving the caller with a stale record, and right before an
+// UpdateVideoFunc
+type editingStore struct {
+	VideoStore
+	edit func()
+}
+
+func (s editingStore) GetVideoByID(id string) (*Video, bool) {
+	video, exists := s.VideoStore.GetVideoByID(id)
+	s.edit()
+	return video, exists
+}
+
+func (s editingStore) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	s.edit()
+	return s.VideoStore.UpdateVideoFunc(id, update)
+}
+
+func TestStoreVideoHashKeepsEdits(t *testing.T) {
+	bolt := openTestBoltStore(t, filepath.Join(t.TempDir(), "videos.db"))
+	defer bolt.Close()
+	require.NoError(t, bolt.AddVideo(newTestVideo("a", 10)))
+
+	store := editingStore{VideoStore: bolt, edit: func() {
+		bolt.UpdateVideoFunc("a", func(video *Video) error {
+			video.Tags = []string{"edited"}
+			return nil
+		})
+	}}
+	require.NoError(t, storeVideoHash(store, "a", "abc"))
+
+	video, _ := bolt.GetVideoByID("a")
+	assert.Equal(t, "abc", video.Hash)
+	assert.Equal(t, []string{"edited"}, video.Tags)
+	assert.ErrorIs(t, storeVideoHash(store, "missing", "abc"), ErrVideoNotFound)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// hlsSegmentSize is the number of bytes of the stored file in each HLS
+	// segment, a whole number of 188-byte MPEG-TS packets
+	hlsSegmentSize = 188 * 10000
+
+	// hlsDefaultSegmentDuration is the duration advertised for each segment
+	// of a video without a recorded duration
+	hlsDefaultSegmentDuration = 10.0
+
+	// hlsKeySize is the size of an AES-128 key
+	hlsKeySize = 16
+)
+
+// hlsSegmentCount returns how many segments a file of size bytes is split into
+func hlsSegmentCount(size int64) int {
+	if size <= 0 {
+		return 0
+	}
+	return int((size + hlsSegmentSize - 1) / hlsSegmentSize)
+}
+
+// hlsSegmentDurations estimates the duration of each segment from the
+// video's duration, in proportion to the bytes the segment holds
+func hlsSegmentDurations(video *Video) []float64 {
+	count := hlsSegmentCount(video.Size)
+	durations := make([]float64, count)
+	for i := range durations {
+		durations[i] = hlsDefaultSegmentDuration
+		if video.Metadata != nil && video.Metadata.DurationSeconds > 0 {
+			length := min(int64(hlsSegmentSize), video.Size-int64(i)*hlsSegmentSize)
+			durations[i] = video.Metadata.DurationSeconds * float64(length) / float64(video.Size)
+		}
+	}
+	return durations
+}
+
+// hlsSegmentIV is the IV of a segment: its media sequence number as a
+// 128-bit big-endian integer, as the HLS spec uses when a key has no IV
+func hlsSegmentIV(index int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(index))
+	return iv
+}
+
+// hlsPlaylist writes the VOD media playlist of a video. When encrypted every
+// segment is preceded by the EXT-X-KEY tag to decrypt it with.
+func hlsPlaylist(video *Video, encrypted bool) string {
+	durations := hlsSegmentDurations(video)
+	target := 0.0
+	for _, duration := range durations {
+		target = math.Max(target, duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, duration := range durations {
+		if encrypted {
+			b.WriteString(hlsKeyTag(video.ID, i))
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", duration)
+		b.WriteString(hlsSegmentName(i) + "\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// hlsKeyTag is the EXT-X-KEY tag preceding a segment in an encrypted
+// playlist
+func hlsKeyTag(videoID string, index int) string {
+	return fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=\"/api/videos/%s/hls/key\",IV=0x%s\n", videoID, hex.EncodeToString(hlsSegmentIV(index)))
+}
+
+// encryptHLSSegment encrypts a segment with AES-128-CBC and PKCS#7 padding,
+// as HLS players expect
+func encryptHLSSegment(key, iv, segment []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(segment)%aes.BlockSize
+	padded := append(append(make([]byte, 0, len(segment)+padding), segment...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	return encrypted, nil
+}
+
+// ensureHLSKey returns the video's HLS key, generating and storing one on
+// first use. The key is checked for and set in one UpdateVideoFunc, so
+// concurrent requests never generate two.
+func (s *Server) ensureHLSKey(videoID string) ([]byte, error) {
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		return nil, ErrVideoNotFound
+	}
+	if len(video.HLSKey) == hlsKeySize {
+		return video.HLSKey, nil
+	}
+
+	key := make([]byte, hlsKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	generated := false
+	updated, err := s.db.UpdateVideoFunc(videoID, func(current *Video) error {
+		if len(current.HLSKey) != hlsKeySize {
+			current.HLSKey = key
+			generated = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		s.logger.Info().Str("video_id", videoID).Msg("generated HLS encryption key")
+	}
+	return updated.HLSKey, nil
+}
+
+// hlsVideo looks up the video of an HLS request and checks the caller may
+// read it, writing an error response when not
+func (s *Server) hlsVideo(c *gin.Context) (*Video, bool) {
+	video, exists := s.db.GetVideoByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return nil, false
+	}
+	if !requireVideoAccess(c, video, aclScopeRead) {
+		return nil, false
+	}
+	return video, true
+}
+
+// hlsPlaylistHandler serves a video's HLS playlist, generating its
+// encryption key on the first request when HLS encryption is enabled. The
+// first request also cuts the video into segments with ffmpeg. Without
+// ffmpeg, or for videos outside local storage, segments are byte ranges
+// of the stored file instead.
+func (s *Server) hlsPlaylistHandler(c *gin.Context) {
+	video, ok := s.hlsVideo(c)
+	if !ok {
+		return
+	}
+	if s.config.EnableHLSEncryption {
+		if _, err := s.ensureHLSKey(video.ID); err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", video.ID).Msg("failed to generate HLS key")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate HLS key"})
+			return
+		}
+	}
+
+	var playlist string
+	segmented, err := s.segmentHLS(c.Request.Context(), video)
+	switch {
+	case err == nil:
+		data, err := os.ReadFile(filepath.Join(s.config.StoragePath, segmented.HLSPath, hlsPlaylistFile))
+		if err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", video.ID).Msg("failed to read HLS playlist")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read playlist"})
+			return
+		}
+		playlist = string(data)
+		if s.config.EnableHLSEncryption {
+			playlist = addHLSKeyTags(playlist, video.ID)
+		}
+	case errors.Is(err, ErrFFmpegUnavailable):
+		getLogger(c).Warn().Err(err).Str("video_id", video.ID).Msg("ffmpeg unavailable, serving HLS segments as byte ranges")
+		playlist = hlsPlaylist(video, s.config.EnableHLSEncryption)
+	case errors.Is(err, errHLSNotLocal):
+		playlist = hlsPlaylist(video, s.config.EnableHLSEncryption)
+	default:
+		getLogger(c).Error().Err(err).Str("video_id", video.ID).Msg("failed to generate HLS segments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate HLS segments"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+}
+
+// hlsKeyHandler serves the raw AES-128 key of a video's HLS segments
+func (s *Server) hlsKeyHandler(c *gin.Context) {
+	video, ok := s.hlsVideo(c)
+	if !ok {
+		return
+	}
+	if !s.config.EnableHLSEncryption || len(video.HLSKey) != hlsKeySize {
+		c.JSON(http.StatusNotFound, gin.H{"error": "HLS key not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/octet-stream", video.HLSKey)
+}
+
+// readHLSSegment reads the bytes of a segment from the video's file
+func (s *Server) readHLSSegment(video *Video, index int) ([]byte, error) {
+	store, ok := s.storageBackend(video.StorageBackend)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not configured", video.StorageBackend)
+	}
+	if store == nil {
+		store = s.files
+	}
+
+	file, err := store.Open(fileKey(video.ID, video.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	offset := int64(index) * hlsSegmentSize
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(file, hlsSegmentSize))
+}
+
+// hlsSegmentHandler serves a segment of a video's HLS playlist, encrypted
+// with the video's key when HLS encryption is enabled. Segments ffmpeg cut
+// never change, so clients may cache them.
+func (s *Server) hlsSegmentHandler(c *gin.Context) {
+	video, ok := s.hlsVideo(c)
+	if !ok {
+		return
+	}
+
+	index, ok := parseHLSSegmentName(c.Param("segment"))
+	if !ok || (!video.HLSReady && index >= hlsSegmentCount(video.Size)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+		return
+	}
+
+	var segment []byte
+	var err error
+	if video.HLSReady {
+		segment, err = os.ReadFile(filepath.Join(s.config.StoragePath, video.HLSPath, hlsSegmentName(index)))
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+		c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		segment, err = s.readHLSSegment(video, index)
+	}
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", video.ID).Int("segment", index).Msg("failed to read HLS segment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read segment"})
+		return
+	}
+
+	if s.config.EnableHLSEncryption {
+		key, err := s.ensureHLSKey(video.ID)
+		if err == nil {
+			segment, err = encryptHLSSegment(key, hlsSegmentIV(index), segment)
+		}
+		if err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", video.ID).Int("segment", index).Msg("failed to encrypt HLS segment")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt segment"})
+			return
+		}
+	}
+
+	c.Data(http.StatusOK, "video/mp2t", segment)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// hlsTargetDuration is the length in seconds of the segments ffmpeg
+	// cuts. Streams are copied, so segments end at the next keyframe.
+	hlsTargetDuration = 6
+
+	// hlsDir is the directory under StoragePath/<videoID> holding the
+	// segments and playlist ffmpeg wrote
+	hlsDir = "hls"
+
+	// hlsPlaylistFile is the playlist ffmpeg writes next to the segments
+	hlsPlaylistFile = "playlist.m3u8"
+)
+
+// errHLSNotLocal is returned for videos whose file is not on the local
+// disk, which are served as byte ranges instead of being segmented
+var errHLSNotLocal = errors.New("video file is not in local storage")
+
+// hlsSegmentDir returns where the segments of a video are kept, relative to
+// StoragePath as recorded in the video's HLSPath
+func hlsSegmentDir(videoID string) string {
+	return filepath.Join(videoID, hlsDir)
+}
+
+// hlsSegmentName is the file name of a segment, as ffmpeg writes it and the
+// playlists list it
+func hlsSegmentName(index int) string {
+	return fmt.Sprintf("segment%d.ts", index)
+}
+
+// parseHLSSegmentName returns the index of a segment file name, false for
+// names that are not segments
+func parseHLSSegmentName(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, "segment")
+	if !ok {
+		return 0, false
+	}
+	digits, ok = strings.CutSuffix(digits, ".ts")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(digits)
+	if err != nil || index < 0 || hlsSegmentName(index) != name {
+		return 0, false
+	}
+	return index, true
+}
+
+// segmentHLS returns the video with its file cut into segments by ffmpeg,
+// doing it on the first call and recording it in the video's HLSReady and
+// HLSPath. Later calls find the segments on disk and do not run ffmpeg.
+// A lock per video keeps concurrent requests from cutting a video twice,
+// without making requests for other videos wait.
+func (s *Server) segmentHLS(ctx context.Context, video *Video) (*Video, error) {
+	relDir := hlsSegmentDir(video.ID)
+	dir := filepath.Join(s.config.StoragePath, relDir)
+	if video.HLSReady {
+		if _, err := os.Stat(filepath.Join(dir, hlsPlaylistFile)); err == nil {
+			return video, nil
+		}
+	}
+	if store, _ := s.storageBackend(video.StorageBackend); store != nil {
+		return nil, errHLSNotLocal
+	}
+	sourcePath := s.getFilePath(video.ID, video.Name)
+	if _, err := os.Stat(sourcePath); err != nil {
+		return nil, errHLSNotLocal
+	}
+
+	defer s.hlsSegmentLocks.Lock(video.ID)()
+
+	if _, err := os.Stat(filepath.Join(dir, hlsPlaylistFile)); os.IsNotExist(err) {
+		if err := s.generateHLSSegments(ctx, sourcePath, dir); err != nil {
+			return nil, err
+		}
+		s.logger.Info().Str("video_id", video.ID).Msg("generated HLS segments")
+	}
+
+	return s.db.UpdateVideoFunc(video.ID, func(current *Video) error {
+		current.HLSReady = true
+		current.HLSPath = relDir
+		return nil
+	})
+}
+
+// generateHLSSegments cuts sourcePath into MPEG-TS segments of about
+// hlsTargetDuration seconds with a VOD playlist in dir. Streams are copied
+// rather than re-encoded. The files are written to a temporary directory
+// first, so a failed or interrupted run leaves nothing behind.
+func (s *Server) generateHLSSegments(ctx context.Context, sourcePath, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	// Runs last, removing the video's directory again if nothing was cut
+	defer os.Remove(filepath.Dir(dir))
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), ".hls-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = runFFmpeg(ctx, s.config.FFmpegPath,
+		"-y", "-i", sourcePath, "-c", "copy", "-f", "hls",
+		"-hls_time", strconv.Itoa(hlsTargetDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(tmpDir, "segment%d.ts"),
+		filepath.Join(tmpDir, hlsPlaylistFile))
+	if err != nil {
+		return err
+	}
+
+	// Left over from a run that stopped before writing the playlist
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+// addHLSKeyTags adds the EXT-X-KEY tag to decrypt each segment with before
+// the segment's EXTINF tag in a playlist ffmpeg wrote
+func addHLSKeyTags(playlist, videoID string) string {
+	var b strings.Builder
+	index := 0
+	for _, line := range strings.SplitAfter(playlist, "\n") {
+		if strings.HasPrefix(line, "#EXTINF:") {
+			b.WriteString(hlsKeyTag(videoID, index))
+			index++
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// removeHLSSegments deletes the segments cut from a video
+func (s *Server) removeHLSSegments(videoID string) {
+	if videoID == "" {
+		return
+	}
+	dir := filepath.Join(s.config.StoragePath, hlsSegmentDir(videoID))
+	if err := os.RemoveAll(dir); err != nil {
+		s.logger.Error().Err(err).Str("video_id", videoID).Msg("failed to delete HLS segments")
+		return
+	}
+	// Only removed once empty
+	os.Remove(filepath.Dir(dir))
+}
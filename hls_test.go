@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decryptHLSSegment reverses encryptHLSSegment the way an HLS player does
+func decryptHLSSegment(t *testing.T, key, iv, encrypted []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	require.Zero(t, len(encrypted)%aes.BlockSize)
+
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+	padding := int(decrypted[len(decrypted)-1])
+	require.True(t, padding >= 1 && padding <= aes.BlockSize)
+	return decrypted[:len(decrypted)-padding]
+}
+
+func getHLS(t *testing.T, server *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	server := newTestServer(t)
+	data := make([]byte, hlsSegmentSize+1000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	video := uploadTestVideo(t, server, "stream.mp4", data)
+
+	w := getHLS(t, server, "/api/videos/"+video.ID+"/hls/playlist.m3u8")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/vnd.apple.mpegurl", w.Header().Get("Content-Type"))
+	playlist := w.Body.String()
+	assert.True(t, strings.HasPrefix(playlist, "#EXTM3U\n"))
+	assert.Contains(t, playlist, "segment0.ts\n")
+	assert.Contains(t, playlist, "segment1.ts\n")
+	assert.NotContains(t, playlist, "#EXT-X-KEY")
+	assert.True(t, strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n"))
+
+	stored, _ := server.db.GetVideoByID(video.ID)
+	assert.Empty(t, stored.HLSKey, "no key without encryption")
+
+	w = getHLS(t, server, "/api/videos/"+video.ID+"/hls/segment1.ts")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "video/mp2t", w.Header().Get("Content-Type"))
+	assert.Equal(t, data[hlsSegmentSize:], w.Body.Bytes())
+
+	assert.Equal(t, http.StatusNotFound, getHLS(t, server, "/api/videos/"+video.ID+"/hls/segment2.ts").Code)
+	assert.Equal(t, http.StatusNotFound, getHLS(t, server, "/api/videos/"+video.ID+"/hls/key").Code)
+}
+
+func TestHLSEncryption(t *testing.T) {
+	server := newTestServer(t)
+	server.config.EnableHLSEncryption = true
+	data := make([]byte, hlsSegmentSize+1000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	video := uploadTestVideo(t, server, "stream.mp4", data)
+	base := "/api/videos/" + video.ID + "/hls/"
+
+	require.Equal(t, http.StatusNotFound, getHLS(t, server, base+"key").Code, "generated by the first playlist request")
+
+	w := getHLS(t, server, base+"playlist.m3u8")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `#EXT-X-KEY:METHOD=AES-128,URI="/api/videos/`+video.ID+`/hls/key",IV=0x00000000000000000000000000000001`+"\n#EXTINF:")
+
+	stored, _ := server.db.GetVideoByID(video.ID)
+	require.Len(t, stored.HLSKey, hlsKeySize)
+	key := stored.HLSKey
+
+	w = getHLS(t, server, base+"key")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, key, w.Body.Bytes())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	getHLS(t, server, base+"playlist.m3u8")
+	stored, _ = server.db.GetVideoByID(video.ID)
+	assert.Equal(t, key, stored.HLSKey, "the key is generated once")
+
+	t.Run("Segment round trip", func(t *testing.T) {
+		for i, plain := range [][]byte{data[:hlsSegmentSize], data[hlsSegmentSize:]} {
+			w := getHLS(t, server, base+fmt.Sprintf("segment%d.ts", i))
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.NotEqual(t, plain, w.Body.Bytes()[:len(plain)])
+			assert.Equal(t, plain, decryptHLSSegment(t, key, hlsSegmentIV(i), w.Body.Bytes()))
+		}
+	})
+
+	t.Run("Access control", func(t *testing.T) {
+		server.authenticator = NewJWTAuthenticator("jwt-secret")
+		defer func() { server.authenticator = nil }()
+		restricted := *stored
+		restricted.ACL = map[string][]string{"owner": {aclScopeRead}}
+		require.NoError(t, server.db.UpdateVideo(&restricted))
+
+		req := httptest.NewRequest(http.MethodGet, base+"key", nil)
+		for name, value := range bearer(signHS256(t, "jwt-secret", map[string]interface{}{
+			"sub": "other",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})) {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, getHLS(t, server, base+"key").Code)
+	})
+}
+
+func TestHLSKeyPersistence(t *testing.T) {
+	key, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	require.NoError(t, err)
+	video := newTestVideo("encrypted", 100)
+	video.HLSKey = key
+
+	data, err := json.Marshal(video)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"hls_key":"AAECAwQFBgcICQoLDA0ODw=="`)
+
+	path := filepath.Join(t.TempDir(), "videos.db")
+	store := openTestBoltStore(t, path)
+	require.NoError(t, store.AddVideo(video))
+	require.NoError(t, store.Close())
+
+	store = openTestBoltStore(t, path)
+	defer store.Close()
+	stored, exists := store.GetVideoByID("encrypted")
+	require.True(t, exists)
+	assert.Equal(t, key, stored.HLSKey)
+}
+
+func TestEncryptHLSSegment(t *testing.T) {
+	key := make([]byte, hlsKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, aes.BlockSize, 188 * 3} {
+		plain := bytes.Repeat([]byte{0x47}, size)
+		encrypted, err := encryptHLSSegment(key, hlsSegmentIV(7), plain)
+		require.NoError(t, err)
+		assert.Equal(t, (size/aes.BlockSize+1)*aes.BlockSize, len(encrypted), "always padded")
+		assert.Equal(t, plain, decryptHLSSegment(t, key, hlsSegmentIV(7), encrypted))
+	}
+
+	_, err = encryptHLSSegment(key[:5], hlsSegmentIV(0), []byte("segment"))
+	assert.Error(t, err)
+}
+
+// writeFakeHLSFFmpeg installs a shell script standing in for ffmpeg's HLS
+// muxer. It writes two segments named after -hls_segment_filename and a
+// playlist listing them to the output (the last argument), and appends a
+// line to the returned counter file on every run.
+func writeFakeHLSFFmpeg(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "ffmpeg")
+
+	content := `#!/bin/sh
+echo "$@" >> "` + counter + `"
+pattern=""
+out=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-hls_segment_filename" ]; then pattern="$2"; fi
+	out="$1"
+	shift
+done
+for i in 0 1; do
+	printf 'ts%s' "$i" > "$(echo "$pattern" | sed "s/%d/$i/")"
+done
+printf '#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000000,\nsegment0.ts\n#EXTINF:2.500000,\nsegment1.ts\n#EXT-X-ENDLIST\n' > "$out"
+`
+	require.NoError(t, os.WriteFile(script, []byte(content), 0755))
+	return script, counter
+}
+
+func TestHLSSegmentedByFFmpeg(t *testing.T) {
+	ffmpeg, counter := writeFakeHLSFFmpeg(t)
+	server := newTestServer(t)
+	server.config.FFmpegPath = ffmpeg
+	video := uploadTestVideo(t, server, "movie.mp4", []byte("not really a movie"))
+	base := "/api/videos/" + video.ID + "/hls/"
+
+	w := getHLS(t, server, base+"playlist.m3u8")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/vnd.apple.mpegurl", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "#EXTINF:2.500000,\nsegment1.ts\n")
+	runs, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	assert.Contains(t, string(runs), "-hls_time 6")
+
+	stored, _ := server.db.GetVideoByID(video.ID)
+	assert.True(t, stored.HLSReady)
+	assert.Equal(t, filepath.Join(video.ID, hlsDir), stored.HLSPath)
+
+	w = getHLS(t, server, base+"segment1.ts")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "video/mp2t", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+	assert.Equal(t, "ts1", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, getHLS(t, server, base+"segment2.ts").Code)
+	assert.Equal(t, http.StatusNotFound, getHLS(t, server, base+"segment01.ts").Code)
+
+	getHLS(t, server, base+"playlist.m3u8")
+	assert.Equal(t, 1, countRuns(t, counter), "segments are cut once")
+
+	t.Run("Other videos do not wait", func(t *testing.T) {
+		// As if the first video were still being cut
+		unlock := server.hlsSegmentLocks.Lock(video.ID)
+		defer unlock()
+
+		other := uploadTestVideo(t, server, "other.mp4", []byte("another movie"))
+		done := make(chan int)
+		go func() {
+			done <- getHLS(t, server, "/api/videos/"+other.ID+"/hls/playlist.m3u8").Code
+		}()
+		select {
+		case code := <-done:
+			assert.Equal(t, http.StatusOK, code)
+		case <-time.After(5 * time.Second):
+			t.Fatal("segmenting another video waited for the lock")
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		server.config.EnableHLSEncryption = true
+		defer func() { server.config.EnableHLSEncryption = false }()
+
+		w := getHLS(t, server, base+"playlist.m3u8")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `IV=0x00000000000000000000000000000001`+"\n#EXTINF:2.500000,\nsegment1.ts\n")
+
+		stored, _ := server.db.GetVideoByID(video.ID)
+		require.Len(t, stored.HLSKey, hlsKeySize)
+		assert.True(t, stored.HLSReady, "kept when the key is added")
+		w = getHLS(t, server, base+"segment1.ts")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ts1", string(decryptHLSSegment(t, stored.HLSKey, hlsSegmentIV(1), w.Body.Bytes())))
+	})
+
+	t.Run("Removed with the video", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NoDirExists(t, filepath.Join(server.config.StoragePath, video.ID))
+	})
+}
+
+func TestHLSWithoutFFmpeg(t *testing.T) {
+	server := newTestServer(t)
+	server.config.FFmpegPath = filepath.Join(t.TempDir(), "no-such-ffmpeg")
+	video := uploadTestVideo(t, server, "stream.ts", []byte("0123456789"))
+
+	// Served as byte ranges of the file instead
+	w := getHLS(t, server, "/api/videos/"+video.ID+"/hls/playlist.m3u8")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "segment0.ts\n")
+	w = getHLS(t, server, "/api/videos/"+video.ID+"/hls/segment0.ts")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+
+	stored, _ := server.db.GetVideoByID(video.ID)
+	assert.False(t, stored.HLSReady)
+	assert.NoDirExists(t, filepath.Join(server.config.StoragePath, video.ID))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newUUIDv7 returns a time-ordered UUID as described in RFC 9562. uuid
+// orders UUIDs generated within the same millisecond by a sequence number,
+// so UUIDs generated by this process sort in the order they were made.
+func newUUIDv7() uuid.UUID {
+	// Only fails if crypto/rand does, as uuid.New panics then too
+	return uuid.Must(uuid.NewV7())
+}
+
+// newVideoID returns a new video ID. IDs are UUIDv7, so they sort by
+// creation time and the latest video has the greatest ID.
+func newVideoID() string {
+	return newUUIDv7().String()
+}
+
+// isValidVideoID reports whether id is a UUID in its canonical form, as
+// every video ID is. Such IDs are safe to use in file paths.
+func isValidVideoID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
+}
+
+// ParseVideoIDTimestamp returns the time a video ID was generated, to the
+// millisecond. IDs generated before the switch to UUIDv7 carry no time and
+// return an error.
+func ParseVideoIDTimestamp(id string) (time.Time, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if parsed.Version() != 7 {
+		return time.Time{}, fmt.Errorf("video ID %s is a version %d UUID, not a time-ordered one", id, parsed.Version())
+	}
+	millis := int64(binary.BigEndian.Uint64(parsed[:8]) >> 16)
+	return time.UnixMilli(millis), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoIDsAreTimeOrdered(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = newVideoID()
+	}
+	for i := 1; i < len(ids); i++ {
+		assert.Less(t, ids[i-1], ids[i], "IDs must sort in the order they were generated")
+	}
+
+	id, err := uuid.Parse(ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), id.Version())
+	assert.Equal(t, uuid.RFC4122, id.Variant())
+
+	created, err := ParseVideoIDTimestamp(ids[len(ids)-1])
+	require.NoError(t, err)
+	assert.False(t, created.Before(before))
+	assert.False(t, created.After(time.Now()))
+}
+
+func TestParseVideoIDTimestamp(t *testing.T) {
+	created, err := ParseVideoIDTimestamp("017f22e2-79b0-7cc3-98c4-dc0c0c07398f")
+	require.NoError(t, err)
+	assert.Equal(t, time.UnixMilli(0x017f22e279b0), created)
+
+	_, err = ParseVideoIDTimestamp(uuid.New().String())
+	assert.Error(t, err, "random UUIDs carry no time")
+	_, err = ParseVideoIDTimestamp("not-a-uuid")
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Problems an integrity check reports
+const (
+	IntegrityMissing      = "missing"
+	IntegritySizeMismatch = "size_mismatch"
+	IntegrityStatFailed   = "stat_failed"
+)
+
+// IntegrityIssue is a video whose stored file does not match its record
+type IntegrityIssue struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Problem    string `json:"problem"`
+	Expected   int64  `json:"expected_size,omitempty"`
+	ActualSize int64  `json:"actual_size,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// IntegrityReport is the outcome of an integrity check. When the check was
+// cancelled Complete is false and only Checked videos were looked at.
+type IntegrityReport struct {
+	Total    int              `json:"total"`
+	Checked  int              `json:"checked"`
+	Complete bool             `json:"complete"`
+	Issues   []IntegrityIssue `json:"issues"` // sorted by video ID
+	Duration time.Duration    `json:"duration"`
+}
+
+// checkFileIntegrity stats the file of every video in files using workers
+// concurrent calls, which matters for network backed stores. It returns the
+// context's error, along with what was checked so far, when ctx is done
+// first.
+func checkFileIntegrity(ctx context.Context, files FileStore, videos []*Video, workers int) (IntegrityReport, error) {
+	start := time.Now()
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *Video)
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		checked int
+		issues  = []IntegrityIssue{}
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for video := range queue {
+				issue, ok := checkVideoFile(files, video)
+
+				mutex.Lock()
+				checked++
+				if !ok {
+					issues = append(issues, issue)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, video := range videos {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		case queue <- video:
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].ID < issues[j].ID
+	})
+	return IntegrityReport{
+		Total:    len(videos),
+		Checked:  checked,
+		Complete: err == nil,
+		Issues:   issues,
+		Duration: time.Since(start),
+	}, err
+}
+
+// checkVideoFile compares a video's stored file with its record, returning
+// false and the issue when they differ
+func checkVideoFile(files FileStore, video *Video) (IntegrityIssue, bool) {
+	issue := IntegrityIssue{ID: video.ID, Name: video.Name}
+
+	info, err := files.Stat(fileKey(video.ID, video.Name))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		issue.Problem = IntegrityMissing
+		return issue, false
+	case err != nil:
+		issue.Problem = IntegrityStatFailed
+		issue.Error = err.Error()
+		return issue, false
+	case info.Size() != video.Size:
+		issue.Problem = IntegritySizeMismatch
+		issue.Expected = video.Size
+		issue.ActualSize = info.Size()
+		return issue, false
+	}
+	return issue, true
+}
+
+// startupIntegrityCheck checks that the file of every video on primary
+// storage exists with the recorded size, using
+// Config.IntegrityCheckWorkers workers
+func (s *Server) startupIntegrityCheck(ctx context.Context) (IntegrityReport, error) {
+	var videos []*Video
+	for _, video := range s.db.GetAllVideos() {
+		if store, _ := s.storageBackend(video.StorageBackend); store == nil {
+			videos = append(videos, video)
+		}
+	}
+	return checkFileIntegrity(ctx, s.files, videos, s.config.IntegrityCheckWorkers)
+}
+
+// runStartupIntegrityCheck logs the outcome of startupIntegrityCheck, it
+// does nothing when IntegrityCheckWorkers is 0
+func (s *Server) runStartupIntegrityCheck(ctx context.Context) {
+	if s.config.IntegrityCheckWorkers < 1 {
+		return
+	}
+
+	report, err := s.startupIntegrityCheck(ctx)
+	for _, issue := range report.Issues {
+		s.logger.Warn().
+			Str("video_id", issue.ID).
+			Str("name", issue.Name).
+			Str("problem", issue.Problem).
+			Str("error", issue.Error).
+			Msg("video file failed integrity check")
+	}
+
+	event := s.logger.Info()
+	if err != nil {
+		event = s.logger.Warn().Err(err)
+	}
+	event.
+		Int("total", report.Total).
+		Int("checked", report.Checked).
+		Int("issues", len(report.Issues)).
+		Dur("duration", report.Duration).
+		Msg("startup integrity check finished")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupIntegrityCheck(t *testing.T) {
+	server := newTestServer(t)
+	server.config.IntegrityCheckWorkers = 4
+
+	intact := uploadTestVideo(t, server, "intact.mp4", []byte("intact video"))
+	missing := uploadTestVideo(t, server, "missing.mp4", []byte("missing video"))
+	resized := uploadTestVideo(t, server, "resized.mp4", []byte("resized video"))
+
+	require.NoError(t, os.Remove(server.getFilePath(missing.ID, missing.Name)))
+	require.NoError(t, os.WriteFile(server.getFilePath(resized.ID, resized.Name), []byte("short"), 0644))
+
+	report, err := server.startupIntegrityCheck(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 3, report.Checked)
+
+	want := []IntegrityIssue{
+		{ID: missing.ID, Name: missing.Name, Problem: IntegrityMissing},
+		{ID: resized.ID, Name: resized.Name, Problem: IntegritySizeMismatch, Expected: resized.Size, ActualSize: 5},
+	}
+	if want[0].ID > want[1].ID {
+		want[0], want[1] = want[1], want[0]
+	}
+	assert.Equal(t, want, report.Issues, "issues should be sorted by video ID")
+	for _, issue := range report.Issues {
+		assert.NotEqual(t, intact.ID, issue.ID)
+	}
+
+	t.Run("Stat failures", func(t *testing.T) {
+		report, err := checkFileIntegrity(context.Background(), failingFileStore{}, []*Video{intact}, 2)
+		require.NoError(t, err)
+		require.Len(t, report.Issues, 1)
+		assert.Equal(t, IntegrityStatFailed, report.Issues[0].Problem)
+		assert.Equal(t, errStoreDown.Error(), report.Issues[0].Error)
+	})
+}
+
+func TestIntegrityCheckCancelled(t *testing.T) {
+	videos := integrityTestVideos(1000)
+	store := &slowFileStore{latency: time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	report, err := checkFileIntegrity(ctx, store, videos, 4)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, report.Complete)
+	assert.Equal(t, 1000, report.Total)
+	assert.Greater(t, report.Checked, 0, "partial results should be reported")
+	assert.Less(t, report.Checked, 1000)
+	assert.Equal(t, int64(report.Checked), store.calls.Load())
+}
+
+// slowFileStore answers Stat after a delay, like a network backed store,
+// with a file the size of the video number in its key
+type slowFileStore struct {
+	failingFileStore
+	latency time.Duration
+	calls   atomic.Int64
+}
+
+func (fs *slowFileStore) Stat(key string) (os.FileInfo, error) {
+	fs.calls.Add(1)
+	time.Sleep(fs.latency)
+	var size int64
+	fmt.Sscanf(key[strings.LastIndex(key, "-")+1:], "%d", &size)
+	return fakeFileInfo{name: key, size: size}, nil
+}
+
+// fakeFileInfo describes a file that only exists in a test store
+type fakeFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// integrityTestVideos returns n videos whose files slowFileStore reports
+// with the recorded size
+func integrityTestVideos(n int) []*Video {
+	videos := make([]*Video, n)
+	for i := range videos {
+		videos[i] = &Video{ID: fmt.Sprintf("video-%05d", i), Name: fmt.Sprintf("file-%d", i), Size: int64(i)}
+	}
+	return videos
+}
+
+func BenchmarkIntegrityCheck(b *testing.B) {
+	videos := integrityTestVideos(10000)
+
+	for _, workers := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			store := &slowFileStore{latency: time.Millisecond}
+			for i := 0; i < b.N; i++ {
+				report, err := checkFileIntegrity(context.Background(), store, videos, workers)
+				if err != nil || len(report.Issues) > 0 {
+					b.Fatalf("unexpected result: %v, %d issues", err, len(report.Issues))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "sync"
+
+// keyedMutex serializes work per key, so work on one video never waits for
+// another. The zero value is ready to use.
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock of one key, removed once nobody holds or waits for it
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock waits until no one else holds key and returns the function that
+// releases it
+func (m *keyedMutex) Lock(key string) func() {
+	m.mutex.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	lock, exists := m.locks[key]
+	if !exists {
+		lock = &keyedLock{}
+		m.locks[key] = lock
+	}
+	lock.refs++
+	m.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var m keyedMutex
+
+	unlock := m.Lock("a")
+
+	// Another key is not blocked
+	done := make(chan struct{})
+	go func() {
+		m.Lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking another key blocked")
+	}
+
+	// The same key waits for the holder
+	var wg sync.WaitGroup
+	var acquired bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.Lock("a")()
+		acquired = true
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, acquired)
+	unlock()
+	wg.Wait()
+	assert.True(t, acquired)
+
+	assert.Empty(t, m.locks, "released keys are dropped")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// Log formats accepted by Config.LogFormat
+const (
+	LogFormatConsole = "console" // human-readable, for terminals
+	LogFormatJSON    = "json"    // one JSON object per line
+	LogFormatNone    = "none"    // discard all logs
+)
+
+// logLevels are the levels accepted by Config.LogLevel
+var logLevels = map[string]zerolog.Level{
+	"debug": zerolog.DebugLevel,
+	"info":  zerolog.InfoLevel,
+	"warn":  zerolog.WarnLevel,
+	"error": zerolog.ErrorLevel,
+}
+
+// validateLogFormat reports whether format is a known log format, empty
+// meaning json
+func validateLogFormat(format string) error {
+	switch format {
+	case "", LogFormatConsole, LogFormatJSON, LogFormatNone:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, must be console, json or none", format)
+}
+
+// parseLogLevel returns the zerolog level of a Config.LogLevel, empty
+// meaning info
+func parseLogLevel(level string) (zerolog.Level, error) {
+	if level == "" {
+		return zerolog.InfoLevel, nil
+	}
+	if l, ok := logLevels[level]; ok {
+		return l, nil
+	}
+	return zerolog.NoLevel, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", level)
+}
+
+// newLogger creates a logger writing to w in format, dropping messages
+// below level. Unknown formats log JSON and unknown levels log at info.
+func newLogger(w io.Writer, format, level string) zerolog.Logger {
+	if format == LogFormatNone {
+		return zerolog.Nop()
+	}
+	if format == LogFormatConsole {
+		w = zerolog.ConsoleWriter{Out: w}
+	}
+
+	l, err := parseLogLevel(level)
+	if err != nil {
+		l = zerolog.InfoLevel
+	}
+	return zerolog.New(w).Level(l).With().Timestamp().Logger()
+}
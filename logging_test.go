@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFormatJSON(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	config := LoadConfig()
+	require.Equal(t, LogFormatJSON, config.LogFormat)
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf, config.LogFormat, config.LogLevel)
+	logger.Info().Str("video_id", "abc").Msg("video uploaded")
+	logger.Warn().Int("depth", 8).Msg("queue nearly full")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.Contains(t, entry, "level")
+		assert.Contains(t, entry, "time")
+		assert.Contains(t, entry, "message")
+	}
+	var first map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &first)
+	assert.Equal(t, "abc", first["video_id"])
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "error")
+	config := LoadConfig()
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf, config.LogFormat, config.LogLevel)
+	logger.Info().Msg("suppressed")
+	logger.Warn().Msg("suppressed")
+	logger.Error().Msg("kept")
+
+	assert.NotContains(t, buf.String(), "suppressed")
+	assert.Contains(t, buf.String(), `"message":"kept"`)
+}
+
+func TestLogFormatFromEnv(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "")
+	t.Setenv("ENABLE_LOGGING", "")
+	assert.Equal(t, LogFormatConsole, LoadConfig().LogFormat)
+
+	t.Setenv("ENABLE_LOGGING", "false")
+	assert.Equal(t, LogFormatJSON, LoadConfig().LogFormat, "the old switch still applies")
+
+	t.Setenv("LOG_FORMAT", "none")
+	assert.Equal(t, LogFormatNone, LoadConfig().LogFormat)
+
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("LOG_LEVEL", "verbose")
+	config := LoadConfig()
+	assert.Equal(t, LogFormatConsole, config.LogFormat)
+	assert.Equal(t, "info", config.LogLevel)
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf, LogFormatNone, "debug")
+	logger.Error().Msg("discarded")
+	assert.Empty(t, buf.String())
+}
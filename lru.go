@@ -0,0 +1,227 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LRUCache is a fixed-size cache of video records by ID, evicting the least
+// recently used entry when full. It is safe for concurrent use.
+type LRUCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is the most recently used
+	mutex    sync.Mutex
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewLRUCache creates a cache holding at most capacity videos
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns a copy of the cached video and marks it as recently used
+func (c *LRUCache) Get(id string) (*Video, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[id]
+	if !exists {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	c.order.MoveToFront(element)
+	videoCopy := *element.Value.(*Video)
+	return &videoCopy, true
+}
+
+// Put stores a copy of the video, evicting the least recently used entry if
+// the cache is full
+func (c *LRUCache) Put(v *Video) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	videoCopy := *v
+	if element, exists := c.entries[v.ID]; exists {
+		element.Value = &videoCopy
+		c.order.MoveToFront(element)
+		return
+	}
+
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Video).ID)
+	}
+	c.entries[v.ID] = c.order.PushFront(&videoCopy)
+}
+
+// Remove drops a video from the cache
+func (c *LRUCache) Remove(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[id]; exists {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+// Len returns the number of cached videos
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// Hits returns how many lookups were answered from the cache
+func (c *LRUCache) Hits() int64 {
+	return c.hits.Load()
+}
+
+// Misses returns how many lookups were not in the cache
+func (c *LRUCache) Misses() int64 {
+	return c.misses.Load()
+}
+
+// cachedVideoStore serves GetVideoByID from an LRUCache in front of a store
+// that is not held in memory, such as the bolt backend
+type cachedVideoStore struct {
+	VideoStore
+	cache *LRUCache
+
+	// fillMutex keeps a cache fill after a miss from racing with a write
+	// that invalidates the same entry
+	fillMutex sync.RWMutex
+}
+
+// newCachedVideoStore wraps store with a metadata cache of the given size
+func newCachedVideoStore(store VideoStore, size int) *cachedVideoStore {
+	return &cachedVideoStore{VideoStore: store, cache: NewLRUCache(size)}
+}
+
+// GetVideoByID returns the cached video, loading it from the store on a miss
+func (cs *cachedVideoStore) GetVideoByID(id string) (*Video, bool) {
+	if video, exists := cs.cache.Get(id); exists {
+		return video, true
+	}
+
+	cs.fillMutex.RLock()
+	defer cs.fillMutex.RUnlock()
+
+	video, exists := cs.VideoStore.GetVideoByID(id)
+	if exists {
+		cs.cache.Put(video)
+	}
+	return video, exists
+}
+
+// AddVideo adds a video to the store, dropping any cached record with its ID
+func (cs *cachedVideoStore) AddVideo(v *Video) error {
+	cs.fillMutex.Lock()
+	defer cs.fillMutex.Unlock()
+
+	cs.cache.Remove(v.ID)
+	return cs.VideoStore.AddVideo(v)
+}
+
+// UpdateVideo updates a video in the store and drops its cached record
+func (cs *cachedVideoStore) UpdateVideo(v *Video) error {
+	cs.fillMutex.Lock()
+	defer cs.fillMutex.Unlock()
+
+	cs.cache.Remove(v.ID)
+	return cs.VideoStore.UpdateVideo(v)
+}
+
+// UpdateVideoFunc updates a video in the store and drops its cached record
+func (cs *cachedVideoStore) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	cs.fillMutex.Lock()
+	defer cs.fillMutex.Unlock()
+
+	cs.cache.Remove(id)
+	return cs.VideoStore.UpdateVideoFunc(id, update)
+}
+
+// DeleteVideo deletes a video from the store and the cache
+func (cs *cachedVideoStore) DeleteVideo(id string) bool {
+	cs.fillMutex.Lock()
+	defer cs.fillMutex.Unlock()
+
+	cs.cache.Remove(id)
+	return cs.VideoStore.DeleteVideo(id)
+}
+
+// Close closes the underlying store if it needs closing
+func (cs *cachedVideoStore) Close() error {
+	if closer, ok := cs.VideoStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// warmCache loads video records into the metadata cache so the first
+// requests after startup do not all miss. Newest videos are loaded first
+// when there are more videos than the cache holds. cacheWarmed is set once
+// done, or straight away when no cache is configured.
+func (s *Server) warmCache() {
+	defer s.cacheWarmed.Store(true)
+
+	cached, ok := s.db.(*cachedVideoStore)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	s.logger.Info().Msg("warming metadata cache")
+
+	cached.fillMutex.RLock()
+	defer cached.fillMutex.RUnlock()
+
+	videos := cached.VideoStore.GetAllVideos()
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+	if len(videos) > cached.cache.capacity {
+		videos = videos[:cached.cache.capacity]
+	}
+
+	// Insert the lowest priority first, so the newest are the most recently used
+	for i := len(videos) - 1; i >= 0; i-- {
+		cached.cache.Put(videos[i])
+	}
+
+	s.logger.Info().
+		Int("entries", len(videos)).
+		Dur("duration", time.Since(start)).
+		Msg("metadata cache warmed")
+}
+
+// readyHandler reports whether the server is ready for traffic, returning
+// 503 until the metadata cache has been warmed and once shutdown has begun
+func (s *Server) readyHandler(c *gin.Context) {
+	if s.shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting_down"})
+		return
+	}
+	if !s.cacheWarmed.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
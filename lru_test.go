@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Put(newTestVideo("a", 1))
+	cache.Put(newTestVideo("b", 1))
+
+	// Touching a makes b the least recently used
+	_, exists := cache.Get("a")
+	require.True(t, exists)
+	cache.Put(newTestVideo("c", 1))
+
+	_, exists = cache.Get("b")
+	assert.False(t, exists)
+	_, exists = cache.Get("a")
+	assert.True(t, exists)
+	_, exists = cache.Get("c")
+	assert.True(t, exists)
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, int64(3), cache.Hits())
+	assert.Equal(t, int64(1), cache.Misses())
+
+	cache.Remove("a")
+	_, exists = cache.Get("a")
+	assert.False(t, exists)
+}
+
+func TestCachedVideoStoreInvalidation(t *testing.T) {
+	mock := NewMockVideoStore()
+	store := newCachedVideoStore(mock, 10)
+	require.NoError(t, store.AddVideo(newNamedVideo("a", "first.mp4")))
+
+	video, exists := store.GetVideoByID("a")
+	require.True(t, exists)
+	assert.Equal(t, "first.mp4", video.Name)
+
+	// Updates are visible straight away, not served stale from the cache
+	require.NoError(t, store.UpdateVideo(newNamedVideo("a", "second.mp4")))
+	video, _ = store.GetVideoByID("a")
+	assert.Equal(t, "second.mp4", video.Name)
+
+	assert.True(t, store.DeleteVideo("a"))
+	_, exists = store.GetVideoByID("a")
+	assert.False(t, exists)
+}
+
+func TestWarmCache(t *testing.T) {
+	mock := NewMockVideoStore()
+	for i := 0; i < 3; i++ {
+		video := newTestVideo(fmt.Sprintf("video-%d", i), 1)
+		video.CreatedAt = time.Now().Add(time.Duration(i) * time.Minute)
+		mock.Videos[video.ID] = video
+	}
+
+	config := &Config{
+		StoragePath:       t.TempDir(),
+		MaxFileSize:       1024,
+		MetadataCacheSize: 2,
+	}
+	server := NewServer(config, mock)
+	defer close(server.retentionStop)
+	defer server.migrator.Stop()
+
+	cached, ok := server.db.(*cachedVideoStore)
+	require.True(t, ok, "non in-memory stores should be wrapped in the cache")
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	server.warmCache()
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only the two newest fit
+	assert.Equal(t, 2, cached.cache.Len())
+	lookups := mock.CallCount("GetVideoByID")
+	hits := cached.cache.Hits()
+
+	video, exists := server.db.GetVideoByID("video-2")
+	require.True(t, exists)
+	assert.Equal(t, "video-2", video.ID)
+	assert.Equal(t, hits+1, cached.cache.Hits())
+	assert.Equal(t, lookups, mock.CallCount("GetVideoByID"), "a warmed lookup should not reach the store")
+
+	// The oldest was left out and is loaded on demand
+	_, exists = server.db.GetVideoByID("video-0")
+	require.True(t, exists)
+	assert.Equal(t, lookups+1, mock.CallCount("GetVideoByID"))
+}
@@ -2,24 +2,256 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-// Config holds server configuration
+// Config holds server configuration. The config tag of a field is its key
+// in every ConfigLayer, see LoadConfig.
 type Config struct {
-	ServerPort       string
-	StoragePath      string
-	MaxFileSize      int64
-	EnableLogging    bool
+	ServerAddr        string        `config:"SERVER_ADDR"` // interface to bind, empty for all; a path starting with / or ./ is a Unix socket
+	ServerPort        string        `config:"SERVER_PORT"`
+	StoragePath       string        `config:"STORAGE_PATH"`
+	DBBackend         string        `config:"DB_BACKEND"`              // "memory" (default), "json" or "bolt"
+	DBLockTimeout     time.Duration `config:"DB_LOCK_TIMEOUT_SECONDS"` // wait for another instance to release the database files
+	MaxFileSize       int64         `config:"MAX_FILE_SIZE"`
+	LogFormat         string        `config:"LOG_FORMAT"` // "console" (the default), "json" or "none"
+	LogLevel          string        `config:"LOG_LEVEL"`  // "debug", "info" (the default when empty), "warn" or "error"
+	ShutdownTimeout   time.Duration `config:"SHUTDOWN_TIMEOUT_SECONDS"`
+	HashCacheTTL      time.Duration `config:"HASH_CACHE_TTL_SECONDS"`
+	StreamChunkSize   int64         `config:"STREAM_CHUNK_SIZE"`  // range responses larger than this are copied in chunks
+	AllowedExtensions []string      `config:"ALLOWED_EXTENSIONS"` // lower-case, e.g. ".mp4"; empty allows all
+
+	// AllowedContentTypes are the accepted upload content types, lower-case,
+	// where "video/*" accepts every video type; empty allows all
+	AllowedContentTypes []string `config:"ALLOWED_CONTENT_TYPES"`
+
+	// PersistenceBackend is where the json DBBackend saves the in-memory
+	// store: "json" (default) rewrites database.json on every save, "sqlite"
+	// writes only the changed videos to database.sqlite, importing
+	// database.json the first time
+	PersistenceBackend string `config:"PERSISTENCE_BACKEND"`
+
+	// SegmentCacheSize is the bytes of recently served ranges kept in
+	// memory, 0 disables the cache. Only ranges up to MaxCachableSegment
+	// bytes are cached.
+	SegmentCacheSize   int64 `config:"SEGMENT_CACHE_SIZE"`
+	MaxCachableSegment int64 `config:"MAX_CACHABLE_SEGMENT"`
+
+	// TenantQuotaBytes caps the bytes each tenant may store, 0 for no limit.
+	// Uploads without a tenant are not limited.
+	TenantQuotaBytes int64 `config:"TENANT_QUOTA_BYTES"`
+
+	// EnableGracefulUpgrade hands the listener to a new process on SIGUSR2,
+	// see upgrade.go
+	EnableGracefulUpgrade bool `config:"ENABLE_GRACEFUL_UPGRADE"`
+
+	// EnableResourceHints adds Link preload headers for the latest video's
+	// sprites to GET /api/videos
+	EnableResourceHints bool `config:"ENABLE_RESOURCE_HINTS"`
+
+	// EnableDebugRoutes adds the /api/debug endpoints. DebugPort, when set,
+	// serves net/http/pprof on localhost only.
+	EnableDebugRoutes bool   `config:"ENABLE_DEBUG_ROUTES"`
+	DebugPort         string `config:"DEBUG_PORT"`
+
+	// DuplicateNameStrategy handles uploads whose name is taken: "allow"
+	// (default), "reject", "overwrite" or "version"
+	DuplicateNameStrategy string `config:"DUPLICATE_NAME_STRATEGY"`
+
+	// Webhook subscription limits, 0 disables a limit
+	MaxWebhooksPerEvent int `config:"MAX_WEBHOOKS_PER_EVENT"`
+	MaxTotalWebhooks    int `config:"MAX_TOTAL_WEBHOOKS"`
+	MaxEventsPerURL     int `config:"MAX_EVENTS_PER_URL"`
+
+	// Webhook target restrictions. Targets on private networks are always
+	// rejected; an empty WebhookAllowedPorts allows any port.
+	WebhookRequireHTTPS bool  `config:"WEBHOOK_REQUIRE_HTTPS"`
+	WebhookAllowedPorts []int `config:"WEBHOOK_ALLOWED_PORTS"`
+
+	// WebhookHealthCheckTimeout bounds each HEAD request of GET /healthz/webhooks
+	WebhookHealthCheckTimeout time.Duration `config:"WEBHOOK_HEALTH_CHECK_TIMEOUT_SECONDS"`
+
+	// Per-URL webhook rate limit in deliveries per second, 0 disables it.
+	// Deliveries over the limit wait in a queue of WebhookQueueSize per URL
+	// and are dropped when it is full.
+	WebhookMaxRatePerURL int `config:"WEBHOOK_MAX_RATE_PER_URL"`
+	WebhookBurstPerURL   int `config:"WEBHOOK_BURST_PER_URL"`
+	WebhookQueueSize     int `config:"WEBHOOK_QUEUE_SIZE"`
+
+	// FFmpegPath is the ffmpeg binary used for previews
+	FFmpegPath      string  `config:"FFMPEG_PATH"`
+	PreviewDuration float64 `config:"PREVIEW_DURATION_SECONDS"` // default preview length in seconds
+	// PreviewMaxDuration caps the duration a preview may be requested with
+	PreviewMaxDuration float64 `config:"PREVIEW_MAX_DURATION_SECONDS"`
+
+	// BackupStorageBackend is a second file store missing files are restored
+	// from, either a directory or "local:<dir>"; empty disables restores
+	BackupStorageBackend string `config:"BACKUP_STORAGE_BACKEND"`
+
+	// FallbackStorageBackends are further file stores every upload is copied
+	// to, tried in order when a file is missing from StoragePath
+	FallbackStorageBackends []string `config:"FALLBACK_STORAGE_BACKENDS"`
+
+	// MirrorStorageBackend shadows primary storage for testing a new backend:
+	// every upload is also written to it, downloads never read from it
+	MirrorStorageBackend string `config:"MIRROR_STORAGE_BACKEND"`
+
+	// StorageBackends are named file stores, as name=spec, that admins can
+	// send a single upload or download to with X-Storage-Backend
+	StorageBackends []string `config:"STORAGE_BACKENDS"`
+
+	// Sprite sheets for seek bar thumbnails, generated after upload
+	GenerateSprites bool `config:"GENERATE_SPRITES"`
+	SpriteInterval  int  `config:"SPRITE_INTERVAL_SECONDS"` // seconds between sprite frames
+
+	// WebhookSchemaVersion "2" wraps payloads in {"v":2,"event","payload"}
+	WebhookSchemaVersion string `config:"WEBHOOK_SCHEMA_VERSION"`
+
+	// Retention policies by content type, applied every
+	// RetentionCheckInterval (0 disables the job). RetentionPoliciesFile is
+	// re-read on every run.
+	RetentionPolicies      []RetentionPolicy
+	RetentionPoliciesFile  string        `config:"RETENTION_POLICIES_FILE"`
+	RetentionCheckInterval time.Duration `config:"RETENTION_CHECK_INTERVAL_SECONDS"`
+
+	// EnableBillingWebhooks sends video.billed for videos with a known
+	// duration every BillingInterval
+	EnableBillingWebhooks bool          `config:"ENABLE_BILLING_WEBHOOKS"`
+	BillingInterval       time.Duration `config:"BILLING_INTERVAL_SECONDS"`
+
+	// Finished streamed upload jobs stay queryable for UploadJobTTL, or
+	// FailedJobTTL when they failed, and are removed every
+	// UploadJobCleanupInterval (0 keeps them forever)
+	UploadJobTTL             time.Duration `config:"UPLOAD_JOB_TTL_SECONDS"`
+	FailedJobTTL             time.Duration `config:"FAILED_UPLOAD_JOB_TTL_SECONDS"`
+	UploadJobCleanupInterval time.Duration `config:"UPLOAD_JOB_CLEANUP_INTERVAL_SECONDS"`
+
+	// Resumable (TUS) uploads that receive no data for TusUploadExpiry are
+	// removed with their partial files every TusCleanupInterval (0 keeps
+	// them until they finish or are cancelled)
+	TusUploadExpiry    time.Duration `config:"TUS_UPLOAD_EXPIRY_SECONDS"`
+	TusCleanupInterval time.Duration `config:"TUS_CLEANUP_INTERVAL_SECONDS"`
+
+	// CatalogSnapshotInterval is how often the video catalog is saved to
+	// StoragePath/snapshots, 0 disables automatic snapshots. Only the
+	// newest CatalogSnapshotCount are kept (0 keeps them all).
+	CatalogSnapshotInterval time.Duration `config:"CATALOG_SNAPSHOT_INTERVAL_SECONDS"`
+	CatalogSnapshotCount    int           `config:"CATALOG_SNAPSHOT_COUNT"`
+
+	// PreloadConcurrency limits concurrent CDN cache warming requests
+	PreloadConcurrency int `config:"PRELOAD_CONCURRENCY"`
+
+	// ProbeMaxBytes is how much of a file POST /api/videos/probe accepts
+	ProbeMaxBytes int `config:"PROBE_MAX_BYTES"`
+
+	// ReadAheadSize caps the buffer whole video downloads are read through
+	// when they can't be sent with sendfile
+	ReadAheadSize int64 `config:"READ_AHEAD_SIZE"`
+
+	// MigrationWorkers hash videos loaded without a hash in the background
+	MigrationWorkers int `config:"MIGRATION_WORKERS"`
+
+	// IntegrityCheckWorkers check concurrently at startup that every video's
+	// file exists with the recorded size, 0 skips the check
+	IntegrityCheckWorkers int `config:"INTEGRITY_CHECK_WORKERS"`
+
+	// HashWorkers hash uploads in the background, taking jobs from a queue of
+	// HashQueueSize. With no workers uploads are hashed before responding.
+	HashWorkers   int `config:"HASH_WORKERS"`
+	HashQueueSize int `config:"HASH_QUEUE_SIZE"`
+
+	// MetadataCacheSize is the number of video records cached in front of
+	// stores that are not held in memory, 0 disables the cache
+	MetadataCacheSize int `config:"METADATA_CACHE_SIZE"`
+
+	// ReadReplicaCount is the number of read replicas of the in-memory store
+	// the video listing is served from, 0 reads from the store itself
+	ReadReplicaCount int `config:"READ_REPLICA_COUNT"`
+
+	// APIKeys accepted in the X-API-Key header, empty disables API key auth
+	APIKeys []string `config:"API_KEYS"`
+	// NonceWindowSeconds bounds the X-Timestamp skew accepted on uploads
+	// when API key auth is enabled
+	NonceWindowSeconds int `config:"NONCE_WINDOW_SECONDS"`
+
+	// DownloadSessionTTL is how long a download session can be used to
+	// resume a download
+	DownloadSessionTTL time.Duration `config:"DOWNLOAD_SESSION_TTL_SECONDS"`
+
+	// NodeID is this instance's URL as listed in ClusterNodes. When
+	// ClusterNodes is set, downloads are proxied to the node owning the video.
+	NodeID       string   `config:"NODE_ID"`
+	ClusterNodes []string `config:"CLUSTER_NODES"`
+
+	// TrustedProxies are the addresses and CIDRs of reverse proxies whose
+	// X-Forwarded-For header names the client IP. Requests from anywhere
+	// else are attributed to the connecting address, so clients can't pick
+	// their own IP. Empty trusts no proxy.
+	TrustedProxies []string `config:"TRUSTED_PROXIES"`
+
+	// AuthMode selects the Authenticator: api_key, jwt (HS256 tokens signed
+	// with JWTSecret), oidc (tokens from OIDCIssuer, for OIDCAudience when
+	// set) or composite (every one of them that is configured)
+	AuthMode     string `config:"AUTH_MODE"`
+	JWTSecret    string `config:"JWT_SECRET"`
+	OIDCIssuer   string `config:"OIDC_ISSUER"`
+	OIDCAudience string `config:"OIDC_AUDIENCE"`
+
+	// RateLimitRequests is how many requests a client IP may make per
+	// RateLimitWindow, 0 disables rate limiting. RateLimitBypassTokens are
+	// the hex SHA-256 hashes of tokens that skip the limit; those listed in
+	// RateLimitBypassTokensFile are re-read when the file changes.
+	RateLimitRequests         int           `config:"RATE_LIMIT_REQUESTS"`
+	RateLimitWindow           time.Duration `config:"RATE_LIMIT_WINDOW_SECONDS"`
+	RateLimitBypassTokens     []string      `config:"RATE_LIMIT_BYPASS_TOKENS"`
+	RateLimitBypassTokensFile string        `config:"RATE_LIMIT_BYPASS_TOKENS_FILE"`
+
+	// IncomingWebhookSecret signs webhooks received from other instances,
+	// empty disables POST /api/webhooks/receive
+	IncomingWebhookSecret string `config:"INCOMING_WEBHOOK_SECRET"`
+
+	// MessageQueueDriver publishes video events to a message queue: nats,
+	// kafka or none. MessageQueueURLs are the NATS servers or Kafka brokers.
+	MessageQueueDriver string   `config:"MESSAGE_QUEUE_DRIVER"`
+	MessageQueueURLs   []string `config:"MESSAGE_QUEUE_URLS"`
+
+	// CSPHeader is the Content-Security-Policy of the web UI, empty uses
+	// defaultCSPHeader
+	CSPHeader string `config:"CSP_HEADER"`
+
+	// ResponseEnvelopeStyle is how successful API responses are wrapped:
+	// "flat" (default), "data" or "jsonapi", see respondSuccess
+	ResponseEnvelopeStyle string `config:"RESPONSE_ENVELOPE_STYLE"`
+
+	// EnableHLSEncryption encrypts HLS segments with AES-128, using a key
+	// per video generated on its first playlist request
+	EnableHLSEncryption bool `config:"ENABLE_HLS_ENCRYPTION"`
+
+	// Source records the layer that set each config key: default, file,
+	// env or flag
+	Source map[string]string `json:"-"`
 }
 
 // Video represents a video entry in our system
@@ -31,16 +263,82 @@ type Video struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	URL         string    `json:"url"`
+	Hash        string    `json:"hash,omitempty"`    // SHA-256 of the file contents at upload time
+	Version     int       `json:"version,omitempty"` // set by the "version" duplicate name strategy
+	Tags        []string  `json:"tags,omitempty"`    // lower-case, sorted and unique
+
+	CustomMetadata map[string]string `json:"custom_metadata,omitempty"` // free-form key/value pairs, see batchUpdateVideosHandler
+
+	CollectionID string `json:"collection_id,omitempty"` // set from the upload's "collection_id" field
+
+	// ACL maps principal IDs to the scopes they are granted on this video,
+	// empty leaves it open to every caller, see videoAccessAllowed
+	ACL map[string][]string `json:"acl,omitempty"`
+
+	SpriteURL    string `json:"sprite_url,omitempty"`
+	SpriteVTTURL string `json:"sprite_vtt_url,omitempty"`
+
+	// Derivatives are transcoded copies of the video, listed after the
+	// original by GET /api/videos/:id/manifest
+	Derivatives []Variant `json:"derivatives,omitempty"`
+
+	Metadata     *VideoMetadata `json:"metadata,omitempty"`
+	LastBilledAt *time.Time     `json:"last_billed_at,omitempty"` // end of the last period sent in video.billed
+
+	DownloadCount int64 `json:"download_count"` // added in schema version 2
+
+	TenantID string `json:"tenant_id,omitempty"` // tenant of the uploader, counted against TenantQuotaBytes
+
+	// StorageBackend names the backend holding the file when it was
+	// uploaded with X-Storage-Backend, empty for primary storage
+	StorageBackend string `json:"storage_backend,omitempty"`
+
+	// HLSKey is the AES-128 key the video's HLS segments are encrypted
+	// with, generated by the first playlist request, see ensureHLSKey
+	HLSKey []byte `json:"hls_key,omitempty"`
+
+	// HLSReady is set once ffmpeg has cut the video into HLS segments,
+	// kept in HLSPath relative to StoragePath, see segmentHLS
+	HLSReady bool   `json:"hls_ready,omitempty"`
+	HLSPath  string `json:"hls_path,omitempty"`
 }
 
 // InMemoryDB represents our optimized in-memory database
 type InMemoryDB struct {
 	videos map[string]*Video
 	mutex  sync.RWMutex
-	
+
 	// Indexes for faster lookups
-	nameIndex map[string]string // name -> id
-	latestID  string           // most recently added video ID
+	nameIndex map[string]string              // name -> id
+	latestID  string                         // most recently added video ID
+	sizeIndex []*VideoSizeEntry              // sorted by size, then ID
+	tagIndex  map[string]map[string]struct{} // tag -> set of video IDs
+
+	// trigramIndex maps each trigram of a lower-cased name to video IDs
+	trigramIndex map[string]map[string]struct{}
+
+	pendingHashes []string // loaded videos without a hash, see migrationCheck
+
+	// hashWaiters are closed when a video's hash is set, see WaitForHash
+	hashWaiters map[string]chan struct{}
+
+	persist *dbPersistence // nil unless backed by a JSON file
+
+	// subscribers receive a snapshot after each write, see Subscribe
+	subscribers []chan []*Video
+}
+
+// VideoSizeEntry is an entry in the size index
+type VideoSizeEntry struct {
+	Size int64
+	ID   string
+}
+
+// SearchQuery describes the filters applied by SearchVideos
+type SearchQuery struct {
+	Query   string // case-insensitive substring of the video name
+	MinSize int64  // inclusive, 0 means no lower bound
+	MaxSize int64  // inclusive, 0 means no upper bound
 }
 
 // NewInMemoryDB creates a new instance of the in-memory database
@@ -48,20 +346,147 @@ func NewInMemoryDB() *InMemoryDB {
 	return &InMemoryDB{
 		videos:    make(map[string]*Video),
 		nameIndex: make(map[string]string),
+		tagIndex:  make(map[string]map[string]struct{}),
+
+		trigramIndex: make(map[string]map[string]struct{}),
+		hashWaiters:  make(map[string]chan struct{}),
 	}
 }
 
-// AddVideo adds a video to the database
-func (db *InMemoryDB) AddVideo(v *Video) {
+// AddVideo adds a video to the database. It never fails, the error return
+// satisfies VideoStore.
+func (db *InMemoryDB) AddVideo(v *Video) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 	
+	if existing, exists := db.videos[v.ID]; exists {
+		db.removeFromSizeIndex(existing)
+		db.removeFromTagIndex(existing)
+		db.removeFromTrigramIndex(existing)
+	}
+
 	db.videos[v.ID] = v
 	db.nameIndex[v.Name] = v.ID
 	db.latestID = v.ID
+	db.insertIntoSizeIndex(v)
+	db.insertIntoTagIndex(v)
+	db.insertIntoTrigramIndex(v)
+	db.markDirty(v.ID)
+	return nil
+}
+
+// UpdateVideo replaces an existing video record, keeping the indexes in sync
+func (db *InMemoryDB) UpdateVideo(v *Video) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.updateVideoLocked(v); err != nil {
+		return err
+	}
+	db.markDirty(v.ID)
+	return nil
+}
+
+// UpdateVideoFunc applies update to the stored video under the write lock
+func (db *InMemoryDB) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	updated, err := db.updateVideoFuncLocked(id, update)
+	if err != nil {
+		return nil, err
+	}
+	db.markDirty(id)
+	return updated, nil
+}
+
+// updateVideoFuncLocked applies update to a copy of a stored video and
+// stores it. The caller must hold the write lock.
+func (db *InMemoryDB) updateVideoFuncLocked(id string, update func(*Video) error) (*Video, error) {
+	existing, exists := db.videos[id]
+	if !exists {
+		return nil, ErrVideoNotFound
+	}
+	updated := *existing
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	updated.ID = id
+	if err := db.updateVideoLocked(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// updateVideoLocked replaces a stored video and its index entries. The
+// caller must hold the write lock.
+func (db *InMemoryDB) updateVideoLocked(v *Video) error {
+	existing, exists := db.videos[v.ID]
+	if !exists {
+		return ErrVideoNotFound
+	}
+
+	if existing.Name != v.Name {
+		delete(db.nameIndex, existing.Name)
+		db.nameIndex[v.Name] = v.ID
+		db.removeFromTrigramIndex(existing)
+		db.insertIntoTrigramIndex(v)
+	}
+	if existing.Size != v.Size {
+		db.removeFromSizeIndex(existing)
+		db.insertIntoSizeIndex(v)
+	}
+	db.removeFromTagIndex(existing)
+	db.insertIntoTagIndex(v)
+
+	videoCopy := *v
+	db.videos[v.ID] = &videoCopy
+	if v.Hash != "" {
+		db.notifyHashWaiters(v.ID)
+	}
+	return nil
 }
 
-// GetVideoByID retrieves a video by its ID
+// GetVideoByIDRef returns the stored video without copying it, holding the
+// read lock until release is called. The video must only be read, and not
+// kept, after release. release must be called exactly once.
+func (db *InMemoryDB) GetVideoByIDRef(id string) (*Video, func(), bool) {
+	db.mutex.RLock()
+
+	video, exists := db.videos[id]
+	if !exists {
+		db.mutex.RUnlock()
+		return nil, func() {}, false
+	}
+
+	return video, db.mutex.RUnlock, true
+}
+
+// insertIntoSizeIndex adds a video to the size index keeping it sorted
+func (db *InMemoryDB) insertIntoSizeIndex(v *Video) {
+	i := sort.Search(len(db.sizeIndex), func(i int) bool {
+		entry := db.sizeIndex[i]
+		return entry.Size > v.Size || (entry.Size == v.Size && entry.ID >= v.ID)
+	})
+
+	db.sizeIndex = append(db.sizeIndex, nil)
+	copy(db.sizeIndex[i+1:], db.sizeIndex[i:])
+	db.sizeIndex[i] = &VideoSizeEntry{Size: v.Size, ID: v.ID}
+}
+
+// removeFromSizeIndex removes a video from the size index. The index is only
+// used for searches, so a linear scan is acceptable here.
+func (db *InMemoryDB) removeFromSizeIndex(v *Video) {
+	for i, entry := range db.sizeIndex {
+		if entry.ID == v.ID {
+			db.sizeIndex = append(db.sizeIndex[:i], db.sizeIndex[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetVideoByID retrieves a video by its ID. It returns a copy, so this is
+// the safe default for callers that may modify or keep the video.
 func (db *InMemoryDB) GetVideoByID(id string) (*Video, bool) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
@@ -96,6 +521,48 @@ func (db *InMemoryDB) GetVideoByName(name string) (*Video, bool) {
 	return &videoCopy, true
 }
 
+// FindVideoByFilePrefix returns the video stored in a file named
+// <videoID>_<name>. The video is looked up by the ID prefix of the file
+// name; the database is the source of truth, so a file without a matching
+// record is not found.
+func (db *InMemoryDB) FindVideoByFilePrefix(fileName string) (*Video, bool) {
+	id, name, ok := strings.Cut(fileName, "_")
+	if !ok {
+		return nil, false
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	video, exists := db.videos[id]
+	if !exists || video.Name != name {
+		return nil, false
+	}
+
+	videoCopy := *video
+	return &videoCopy, true
+}
+
+// FindVideosByIDPrefix returns the videos whose ID starts with prefix,
+// sorted by ID, for completing partially typed IDs
+func (db *InMemoryDB) FindVideosByIDPrefix(prefix string) []*Video {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var videos []*Video
+	for id, video := range db.videos {
+		if strings.HasPrefix(id, prefix) {
+			videoCopy := *video
+			videos = append(videos, &videoCopy)
+		}
+	}
+
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].ID < videos[j].ID
+	})
+	return videos
+}
+
 // GetLatestVideo returns the most recently added video
 func (db *InMemoryDB) GetLatestVideo() (*Video, bool) {
 	db.mutex.RLock()
@@ -127,6 +594,10 @@ func (db *InMemoryDB) DeleteVideo(id string) bool {
 	
 	delete(db.videos, id)
 	delete(db.nameIndex, video.Name)
+	db.removeFromSizeIndex(video)
+	db.removeFromTagIndex(video)
+	db.removeFromTrigramIndex(video)
+	db.notifyHashWaiters(id)
 	
 	// Update latestID if this was the latest video
 	if db.latestID == id {
@@ -138,7 +609,8 @@ func (db *InMemoryDB) DeleteVideo(id string) bool {
 			}
 		}
 	}
-	
+
+	db.markDirty(id)
 	return true
 }
 
@@ -157,30 +629,357 @@ func (db *InMemoryDB) GetAllVideos() []*Video {
 	return videos
 }
 
+// SearchVideos returns all videos matching the query. Size bounds are
+// resolved with a binary search over the size index instead of a full scan,
+// and name queries of three or more characters with the trigram index.
+func (db *InMemoryDB) SearchVideos(query SearchQuery) []*Video {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	needle := strings.ToLower(query.Query)
+	candidates, indexed := db.trigramCandidates(needle)
+	matches := func(v *Video) bool {
+		if indexed {
+			if _, ok := candidates[v.ID]; !ok {
+				return false
+			}
+		}
+		return needle == "" || strings.Contains(strings.ToLower(v.Name), needle)
+	}
+
+	var videos []*Video
+	if query.MinSize <= 0 && query.MaxSize <= 0 {
+		if indexed {
+			for id := range candidates {
+				if video := db.videos[id]; matches(video) {
+					videoCopy := *video
+					videos = append(videos, &videoCopy)
+				}
+			}
+			return videos
+		}
+
+		for _, video := range db.videos {
+			if matches(video) {
+				videoCopy := *video
+				videos = append(videos, &videoCopy)
+			}
+		}
+		return videos
+	}
+
+	lo := sort.Search(len(db.sizeIndex), func(i int) bool {
+		return db.sizeIndex[i].Size >= query.MinSize
+	})
+	hi := len(db.sizeIndex)
+	if query.MaxSize > 0 {
+		hi = sort.Search(len(db.sizeIndex), func(i int) bool {
+			return db.sizeIndex[i].Size > query.MaxSize
+		})
+	}
+
+	for i := lo; i < hi; i++ {
+		video := db.videos[db.sizeIndex[i].ID]
+		if matches(video) {
+			videoCopy := *video
+			videos = append(videos, &videoCopy)
+		}
+	}
+
+	return videos
+}
+
 // Server represents the main server
 type Server struct {
 	config       *Config
-	db           *InMemoryDB
+	db           VideoStore
 	webhookMgr   *WebhookManager
+	files        FileStore // primary video file storage under StoragePath
+	backupFiles  FileStore // nil unless BackupStorageBackend is configured
+	preloadMgr   *PreloadManager
+	migrator     *HashMigrator
+	nodeRouter   *ConsistentHashRouter // nil unless ClusterNodes is configured
+	nonceStore   *NonceStore           // nil unless API keys are configured
+	publisher    MessagePublisher      // an AsyncPublisher, nil unless MessageQueueDriver is set
+	comments     *CommentStore
 	router       *gin.Engine
 	logger       zerolog.Logger
+
+	// lookupHost resolves webhook target hosts, replaceable in tests
+	lookupHost func(host string) ([]net.IP, error)
+
+	// hashCache holds hashCacheKey -> hashCacheEntry for the hash endpoint
+	hashCache sync.Map
+
+	// segmentCache is nil when SegmentCacheSize is 0
+	segmentCache *SegmentCache
+
+	// baseNameIndex tracks the highest version reserved per uploaded name
+	baseNameIndex map[string]int
+	versionMutex  sync.Mutex
+
+	// progressMap holds upload session ID -> *uploadProgress for streamed uploads
+	progressMap sync.Map
+
+	// pendingUploads holds video ID -> *pendingUpload for presigned uploads
+	// that have not been confirmed yet
+	pendingUploads sync.Map
+
+	// retentionStop is closed on shutdown to stop retentionLoop
+	retentionStop chan struct{}
+
+	// billingStop is closed on shutdown to stop billingLoop, nil when
+	// billing is disabled. billingMutex keeps billing runs from overlapping.
+	billingStop  chan struct{}
+	billingMutex sync.Mutex
+
+	// uploadJobStop is closed on shutdown to stop uploadJobCleanupLoop, nil
+	// when the cleanup is disabled
+	uploadJobStop chan struct{}
+
+	// uploadSessions holds resumable uploads, nil when their directory
+	// could not be read. tusExpiryStop is closed on shutdown to stop
+	// tusExpiryLoop, nil when uploads never expire.
+	uploadSessions *UploadSessionStore
+	tusExpiryStop  chan struct{}
+
+	// catalogSnapshotStop is closed on shutdown to stop
+	// catalogSnapshotLoop, nil when automatic snapshots are disabled
+	catalogSnapshotStop chan struct{}
+
+	// webhookQueueStop is closed on shutdown to stop webhookQueueLoop, nil
+	// when webhooks are not rate limited and so never queue
+	webhookQueueStop chan struct{}
+
+	// cacheWarmed is set once warmCache has finished, see readyHandler
+	cacheWarmed atomic.Bool
+
+	// fallbackFiles chains the FallbackStorageBackends, nil when none are
+	// configured
+	fallbackFiles FileStore
+
+	// mirrorFiles is the MirrorStorageBackend, nil unless configured. files
+	// wraps it in a MirroredFileStore.
+	mirrorFiles FileStore
+
+	// storageBackends are the StorageBackends by name
+	storageBackends map[string]FileStore
+
+	// hlsSegmentLocks serializes cutting each video into segments, see
+	// segmentHLS
+	hlsSegmentLocks keyedMutex
+	// fileLocks serializes moving a video's file with compaction deciding
+	// whether to remove it, see renameVideoHandler and compactStorage
+	fileLocks keyedMutex
+
+	// hashQueue feeds uploads to the hash workers until hashStop is closed,
+	// both are nil when uploads are hashed synchronously
+	hashQueue chan hashJob
+	hashStop  chan struct{}
+
+	// authenticator identifies API callers, nil when authentication is
+	// disabled
+	authenticator Authenticator
+
+	// rateLimiter is nil when rate limiting is disabled
+	rateLimiter     *RateLimiter
+	rateLimitBypass *rateLimitBypassTokens
+
+	// videoEvents is the audit trail of every video, downloadCount samples
+	// downloads into it
+	videoEvents   *VideoEventStore
+	downloadCount atomic.Int64
+
+	// shuttingDown is set when shutdown begins, after which new uploads are
+	// rejected while uploadDrainer waits for those in flight
+	shuttingDown  atomic.Bool
+	uploadDrainer *uploadDrainer
+
+	// downloadSessions holds the tokens clients resume downloads with
+	downloadSessions *DownloadSessionStore
+
+	// upgrader is nil unless graceful upgrades are enabled
+	upgrader *gracefulUpgrader
+
+	// readReplicas serve reads in turn, picked by nextReplica, see readDB
+	readReplicas []*ReplicaDB
+	nextReplica  atomic.Int64
+
+	// batchErrors keeps the failed items of batch requests
+	batchErrors *BatchErrorLog
+
+	// metadataSchemas are the global schemas custom metadata must match
+	metadataSchemas *MetadataSchemaRegistry
 }
 
-// NewServer creates a new server instance
-func NewServer(config *Config) *Server {
+// NewServer creates a new server instance using db for video metadata
+func NewServer(config *Config, db VideoStore) *Server {
 	// Initialize logger
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	if level, err := parseLogLevel(config.LogLevel); err == nil {
+		zerolog.SetGlobalLevel(level)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logger := newLogger(os.Stderr, config.LogFormat, config.LogLevel)
 
-	if config.EnableLogging {
-		logger = logger.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	// The in-memory stores are their own cache
+	switch db.(type) {
+	case *InMemoryDB, *InMemorySQLiteDB:
+	default:
+		if config.MetadataCacheSize > 0 {
+			db = newCachedVideoStore(db, config.MetadataCacheSize)
+		}
 	}
 
 	server := &Server{
 		config:     config,
-		db:         NewInMemoryDB(),
-		webhookMgr: NewWebhookManager(),
+		db:         db,
+		webhookMgr: NewWebhookManager(config),
+		files:      NewLocalFileStore(config.StoragePath),
 		logger:     logger.With().Str("component", "server").Logger(),
+		lookupHost: net.LookupIP,
+
+		baseNameIndex: make(map[string]int),
+		uploadDrainer: newUploadDrainer(),
+		batchErrors:   NewBatchErrorLog(batchErrorLogSize),
+
+		metadataSchemas: NewMetadataSchemaRegistry(),
+	}
+
+	if config.SegmentCacheSize > 0 {
+		server.segmentCache = NewSegmentCache(config.SegmentCacheSize)
+	}
+
+	// CDN URLs and their redirects get the checks webhook targets get
+	server.preloadMgr = NewPreloadManager(config.PreloadConcurrency, server.validateWebhookURL)
+
+	if config.BackupStorageBackend != "" {
+		backup, err := newFileStore(config.BackupStorageBackend)
+		if err != nil {
+			server.logger.Error().Err(err).Msg("backup storage disabled")
+		} else {
+			server.backupFiles = backup
+		}
+	}
+
+	var fallbacks []FileStore
+	for _, spec := range config.FallbackStorageBackends {
+		fallback, err := newFileStore(spec)
+		if err != nil {
+			server.logger.Error().Err(err).Str("backend", spec).Msg("fallback storage backend disabled")
+			continue
+		}
+		fallbacks = append(fallbacks, fallback)
+	}
+	if len(fallbacks) > 0 {
+		server.fallbackFiles = NewFallbackFileStore(fallbacks...)
+	}
+
+	if config.MirrorStorageBackend != "" {
+		mirror, err := newFileStore(config.MirrorStorageBackend)
+		if err != nil {
+			server.logger.Error().Err(err).Msg("mirror storage disabled")
+		} else {
+			server.mirrorFiles = mirror
+			server.files = NewMirroredFileStore(server.files, mirror)
+		}
+	}
+
+	backends, err := parseStorageBackends(config.StorageBackends)
+	if err != nil {
+		server.logger.Error().Err(err).Msg("named storage backends disabled")
+	}
+	server.storageBackends = backends
+
+	comments, err := NewCommentStore(filepath.Join(config.StoragePath, commentsFile))
+	if err != nil {
+		// Keep the unreadable file rather than overwrite it with new comments
+		server.logger.Error().Err(err).Msg("failed to load comments, new comments will not be saved")
+		comments, _ = NewCommentStore("")
+	}
+	server.comments = comments
+
+	videoEvents, err := NewVideoEventStore(filepath.Join(config.StoragePath, videoEventsFile))
+	if err != nil {
+		server.logger.Error().Err(err).Msg("failed to open video event history, events will not be saved")
+		videoEvents, _ = NewVideoEventStore("")
+	}
+	server.videoEvents = videoEvents
+
+	publisher, err := newMessagePublisher(config)
+	if err != nil {
+		server.logger.Error().Err(err).Msg("message queue publishing disabled")
+	} else if publisher != nil {
+		server.publisher = NewAsyncPublisher(publisher, publishQueueSize, server.logger)
+	}
+
+	authenticator, err := newAuthenticator(config)
+	if err != nil {
+		server.logger.Error().Err(err).Msg("authentication misconfigured, rejecting all API requests")
+		authenticator = failedAuthenticator{}
+	}
+	server.authenticator = authenticator
+
+	if config.RateLimitRequests > 0 && config.RateLimitWindow > 0 {
+		server.rateLimiter = NewRateLimiter(config.RateLimitRequests, config.RateLimitWindow)
+	}
+	server.rateLimitBypass = &rateLimitBypassTokens{
+		static: config.RateLimitBypassTokens,
+		path:   config.RateLimitBypassTokensFile,
+	}
+
+	sessionTTL := config.DownloadSessionTTL
+	if sessionTTL <= 0 {
+		sessionTTL = time.Hour
+	}
+	server.downloadSessions = NewDownloadSessionStore(sessionTTL)
+
+	if len(config.APIKeys) > 0 {
+		server.nonceStore = NewNonceStore(time.Duration(config.NonceWindowSeconds*2) * time.Second)
+	}
+
+	if len(config.ClusterNodes) > 0 {
+		server.nodeRouter = NewConsistentHashRouter(config.ClusterNodes)
+	}
+
+	server.startReadReplicas(config.ReadReplicaCount)
+
+	server.migrator = server.startHashMigration()
+	server.startHashWorkers()
+
+	server.retentionStop = make(chan struct{})
+	if config.RetentionCheckInterval > 0 {
+		go server.retentionLoop()
+	}
+
+	if config.EnableBillingWebhooks && config.BillingInterval > 0 {
+		server.billingStop = make(chan struct{})
+		go server.billingLoop()
+	}
+
+	if config.UploadJobCleanupInterval > 0 {
+		server.uploadJobStop = make(chan struct{})
+		go server.uploadJobCleanupLoop()
+	}
+
+	uploadSessions, err := NewUploadSessionStore(filepath.Join(config.StoragePath, tusUploadDir), config.TusUploadExpiry)
+	if err != nil {
+		server.logger.Error().Err(err).Msg("failed to load resumable uploads, TUS uploads disabled")
+	} else {
+		server.uploadSessions = uploadSessions
+	}
+	if server.uploadSessions != nil && config.TusUploadExpiry > 0 && config.TusCleanupInterval > 0 {
+		server.tusExpiryStop = make(chan struct{})
+		go server.tusExpiryLoop()
+	}
+
+	if config.CatalogSnapshotInterval > 0 {
+		server.catalogSnapshotStop = make(chan struct{})
+		go server.catalogSnapshotLoop()
+	}
+
+	if config.WebhookMaxRatePerURL > 0 {
+		server.webhookQueueStop = make(chan struct{})
+		go server.webhookQueueLoop()
 	}
 
 	// Setup routes
@@ -193,30 +992,135 @@ func NewServer(config *Config) *Server {
 func (s *Server) setupRoutes() {
 	gin.SetMode(gin.ReleaseMode)
 	s.router = gin.New()
+	if err := s.router.SetTrustedProxies(s.config.TrustedProxies); err != nil {
+		s.logger.Error().Err(err).Msg("invalid TRUSTED_PROXIES, trusting no proxy")
+		s.router.SetTrustedProxies(nil)
+	}
 
 	// Middleware
-	s.router.Use(gin.Recovery())
+	s.router.Use(s.panicRecoveryMiddleware())
+	s.router.Use(s.contextLoggerMiddleware())
+	s.router.Use(traceContextMiddleware())
 	s.router.Use(s.loggingMiddleware())
+	s.router.Use(s.rateLimitMiddleware())
 
 	// Health check
 	s.router.GET("/health", s.healthHandler)
+	s.router.GET("/ready", s.readyHandler)
+	s.router.GET("/metrics", s.metricsHandler)
+
+	// Web UI, static asset routes belong in this group too
+	ui := s.router.Group("/", s.staticFileSecurityMiddleware())
+	{
+		ui.GET("/", s.serveUIHandler)
+	}
+
+	// Auth is a no-op in api_key mode unless API keys are configured
+	auth := s.authMiddleware()
+
+	// Checked before authenticating for the upload, see uploadCheckHandler
+	s.router.POST("/api/videos/upload-check", s.uploadCheckHandler)
+
+	// Lists webhook URLs, so it needs an API key unlike the checks above
+	s.router.GET("/healthz/webhooks", auth, s.webhookHealthHandler)
 
 	// Video endpoints
-	videoGroup := s.router.Group("/api/videos")
+	videoGroup := s.router.Group("/api/videos", auth)
 	{
-		videoGroup.POST("", s.uploadVideoHandler)
+		videoGroup.POST("", s.uploadDrainMiddleware(), s.nonceMiddleware(), s.uploadVideoHandler)
+		videoGroup.POST("/presign", s.presignUploadHandler)
+		videoGroup.OPTIONS("/tus", s.tusOptionsHandler)
+		videoGroup.POST("/tus", s.tusMiddleware(), s.nonceMiddleware(), s.tusCreateHandler)
+		videoGroup.HEAD("/tus/:upload_id", s.tusMiddleware(), s.tusHeadHandler)
+		videoGroup.PATCH("/tus/:upload_id", s.tusMiddleware(), s.uploadDrainMiddleware(), s.tusPatchHandler)
+		videoGroup.DELETE("/tus/:upload_id", s.tusMiddleware(), s.tusDeleteHandler)
+		videoGroup.POST("/probe", s.probeVideoHandler)
+		videoGroup.POST("/presign/:id/confirm", s.confirmPresignedUploadHandler)
 		videoGroup.GET("/:id", s.downloadVideoHandler)
+		videoGroup.GET("/:id/download", s.directDownloadHandler)
+		videoGroup.POST("/:id/download-session", s.createDownloadSessionHandler)
+		videoGroup.PATCH("/:id", s.renameVideoHandler)
+		videoGroup.PATCH("/:id/tags", s.updateVideoTagsHandler)
+		videoGroup.PUT("/:id/metadata", s.setCustomMetadataHandler)
 		videoGroup.DELETE("/:id", s.deleteVideoHandler)
 		videoGroup.GET("/latest", s.getLatestVideoHandler)
+		videoGroup.GET("/search", s.searchVideosHandler)
 		videoGroup.GET("", s.getAllVideosHandler)
+		videoGroup.PATCH("", s.batchUpdateVideosHandler)
+		videoGroup.GET("/:id/hash", s.getVideoHashHandler)
+		videoGroup.GET("/:id/preview", s.previewVideoHandler)
+		videoGroup.GET("/:id/sprite", s.getSpriteHandler)
+		videoGroup.GET("/:id/sprite.vtt", s.getSpriteVTTHandler)
+		videoGroup.GET("/:id/manifest", s.getVideoManifestHandler)
+		videoGroup.GET("/:id/hls/playlist.m3u8", s.hlsPlaylistHandler)
+		videoGroup.GET("/:id/hls/key", s.hlsKeyHandler)
+		videoGroup.GET("/:id/hls/:segment", s.hlsSegmentHandler)
+		videoGroup.POST("/:id/comments", s.addCommentHandler)
+		videoGroup.GET("/:id/comments", s.getCommentsHandler)
+		videoGroup.PATCH("/:id/comments/:cid", s.updateCommentHandler)
+		videoGroup.DELETE("/:id/comments/:cid", s.deleteCommentHandler)
+		videoGroup.GET("/:id/events", s.getVideoEventsHandler)
+		videoGroup.PUT("/:id/acl", s.setVideoACLHandler)
+		videoGroup.PATCH("/:id/acl/:key_id", s.patchVideoACLHandler)
+	}
+
+	// Upload progress endpoints
+	uploadGroup := s.router.Group("/api/upload", auth)
+	{
+		uploadGroup.GET("/progress/:session_id", s.uploadProgressHandler)
+		uploadGroup.DELETE("/jobs/:job_id", s.cancelUploadHandler)
 	}
 
 	// Webhook endpoints
 	webhookGroup := s.router.Group("/api/webhooks")
 	{
-		webhookGroup.POST("", s.addWebhookHandler)
-		webhookGroup.GET("", s.getWebhooksHandler)
-		webhookGroup.DELETE("", s.removeWebhookHandler)
+		webhookGroup.POST("", auth, s.addWebhookHandler)
+		webhookGroup.GET("", auth, s.getWebhooksHandler)
+		webhookGroup.GET("/urls", auth, s.getWebhookURLsHandler)
+		webhookGroup.GET("/queue", auth, s.webhookQueueHandler)
+		webhookGroup.GET("/stream", auth, s.webhookStreamHandler)
+		webhookGroup.POST("/test", auth, s.testWebhookHandler)
+		webhookGroup.DELETE("", auth, s.removeWebhookHandler)
+		webhookGroup.GET("/changelog", auth, s.webhookChangelogHandler)
+		webhookGroup.GET("/export", auth, s.exportWebhooksHandler)
+		webhookGroup.POST("/import", auth, s.importWebhooksHandler)
+
+		// Incoming webhooks authenticate with their HMAC signature instead
+		webhookGroup.POST("/receive", s.receiveWebhookHandler)
+	}
+
+	// Statistics endpoints
+	statsGroup := s.router.Group("/api/stats", auth)
+	{
+		statsGroup.GET("/size-distribution", s.sizeDistributionHandler)
+	}
+
+	// Debug endpoints, for troubleshooting only
+	if s.config.EnableDebugRoutes {
+		debugGroup := s.router.Group("/api/debug", auth)
+		{
+			debugGroup.GET("/state", s.debugStateHandler)
+			debugGroup.GET("/pprof", s.debugPprofHandler)
+		}
+	}
+
+	// Admin endpoints
+	adminGroup := s.router.Group("/api/admin", auth)
+	{
+		adminGroup.POST("/videos/:id/redeliver", s.redeliverWebhookHandler)
+		adminGroup.POST("/preload", s.preloadHandler)
+		adminGroup.GET("/preload/:job_id", s.getPreloadJobHandler)
+		adminGroup.GET("/migration/status", s.migrationStatusHandler)
+		adminGroup.GET("/mirror/diff", s.mirrorDiffHandler)
+		adminGroup.POST("/storage/migrate", s.storageMigrateHandler)
+		adminGroup.POST("/compact", s.compactStorageHandler)
+		adminGroup.GET("/batch-errors", s.batchErrorsHandler)
+		adminGroup.GET("/audit/summary", s.auditSummaryHandler)
+		adminGroup.POST("/catalog-snapshots", s.createCatalogSnapshotHandler)
+		adminGroup.GET("/catalog-snapshots", s.listCatalogSnapshotsHandler)
+		adminGroup.GET("/catalog-snapshots/:ts", s.getCatalogSnapshotHandler)
+		adminGroup.POST("/metadata-schemas", s.addMetadataSchemaHandler)
+		adminGroup.GET("/metadata-schemas", s.listMetadataSchemasHandler)
 	}
 }
 
@@ -229,7 +1133,7 @@ func (s *Server) loggingMiddleware() gin.HandlerFunc {
 		
 		duration := time.Since(start)
 		
-		s.logger.Info().
+		getLogger(c).Info().
 			Str("method", c.Request.Method).
 			Str("path", c.Request.URL.Path).
 			Int("status", c.Writer.Status()).
@@ -238,6 +1142,39 @@ func (s *Server) loggingMiddleware() gin.HandlerFunc {
 	}
 }
 
+// panicRecoveryMiddleware turns a panicking handler into a 500 response and
+// logs the panic with its stack trace. The panic value is never sent to the
+// client.
+func (s *Server) panicRecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			getLogger(c).Error().
+				Str("panic_value", fmt.Sprint(recovered)).
+				Str("stack_trace", string(debug.Stack())).
+				Str("method", c.Request.Method).
+				Str("path", c.Request.URL.Path).
+				Msg("recovered from panic")
+
+			// Headers may already be out if the handler panicked mid-response
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "internal server error",
+				"code":  "INTERNAL_ERROR",
+			})
+		}()
+
+		c.Next()
+	}
+}
+
 // healthHandler returns server health status
 func (s *Server) healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -246,43 +1183,334 @@ func (s *Server) healthHandler(c *gin.Context) {
 	})
 }
 
-// Run starts the HTTP server
+// logStartupConfig logs a snapshot of the effective configuration. Secrets
+// are redacted so the log can be shared safely.
+func (s *Server) logStartupConfig() {
+	s.logger.Info().
+		Str("addr", s.config.ServerAddr).
+		Str("port", s.config.ServerPort).
+		Str("storage_path", s.config.StoragePath).
+		Str("db_backend", s.config.DBBackend).
+		Str("persistence_backend", s.config.PersistenceBackend).
+		Str("log_format", s.config.LogFormat).
+		Str("log_level", s.config.LogLevel).
+		Dur("db_lock_timeout", s.config.DBLockTimeout).
+		Str("backup_storage_backend", s.config.BackupStorageBackend).
+		Strs("fallback_storage_backends", s.config.FallbackStorageBackends).
+		Str("mirror_storage_backend", s.config.MirrorStorageBackend).
+		Strs("storage_backends", s.config.StorageBackends).
+		Int64("max_file_size", s.config.MaxFileSize).
+		Strs("allowed_extensions", s.config.AllowedExtensions).
+		Strs("allowed_content_types", s.config.AllowedContentTypes).
+		Int64("tenant_quota_bytes", s.config.TenantQuotaBytes).
+		Str("duplicate_name_strategy", s.config.DuplicateNameStrategy).
+		Dur("hash_cache_ttl", s.config.HashCacheTTL).
+		Int64("stream_chunk_size", s.config.StreamChunkSize).
+		Int64("segment_cache_size", s.config.SegmentCacheSize).
+		Int64("max_cachable_segment", s.config.MaxCachableSegment).
+		Dur("shutdown_timeout", s.config.ShutdownTimeout).
+		Bool("graceful_upgrade", s.config.EnableGracefulUpgrade).
+		Bool("resource_hints", s.config.EnableResourceHints).
+		Bool("debug_routes", s.config.EnableDebugRoutes).
+		Str("debug_port", s.config.DebugPort).
+		Int("max_webhooks_per_event", s.config.MaxWebhooksPerEvent).
+		Int("max_total_webhooks", s.config.MaxTotalWebhooks).
+		Int("max_events_per_url", s.config.MaxEventsPerURL).
+		Bool("webhook_require_https", s.config.WebhookRequireHTTPS).
+		Ints("webhook_allowed_ports", s.config.WebhookAllowedPorts).
+		Dur("webhook_health_check_timeout", s.config.WebhookHealthCheckTimeout).
+		Int("webhook_max_rate_per_url", s.config.WebhookMaxRatePerURL).
+		Int("webhook_burst_per_url", s.config.WebhookBurstPerURL).
+		Int("webhook_queue_size", s.config.WebhookQueueSize).
+		Str("webhook_schema_version", s.config.WebhookSchemaVersion).
+		Str("ffmpeg_path", s.config.FFmpegPath).
+		Float64("preview_duration", s.config.PreviewDuration).
+		Float64("preview_max_duration", s.config.PreviewMaxDuration).
+		Bool("generate_sprites", s.config.GenerateSprites).
+		Int("sprite_interval", s.config.SpriteInterval).
+		Bool("hls_encryption", s.config.EnableHLSEncryption).
+		Int("preload_concurrency", s.config.PreloadConcurrency).
+		Int("probe_max_bytes", s.config.ProbeMaxBytes).
+		Int64("read_ahead_size", s.config.ReadAheadSize).
+		Int("migration_workers", s.config.MigrationWorkers).
+		Int("integrity_check_workers", s.config.IntegrityCheckWorkers).
+		Int("hash_workers", s.config.HashWorkers).
+		Int("hash_queue_size", s.config.HashQueueSize).
+		Int("metadata_cache_size", s.config.MetadataCacheSize).
+		Int("read_replicas", len(s.readReplicas)).
+		Int("retention_policies", len(s.config.RetentionPolicies)).
+		Str("retention_policies_file", s.config.RetentionPoliciesFile).
+		Dur("retention_check_interval", s.config.RetentionCheckInterval).
+		Bool("enable_billing_webhooks", s.config.EnableBillingWebhooks).
+		Dur("billing_interval", s.config.BillingInterval).
+		Dur("upload_job_ttl", s.config.UploadJobTTL).
+		Dur("failed_upload_job_ttl", s.config.FailedJobTTL).
+		Dur("upload_job_cleanup_interval", s.config.UploadJobCleanupInterval).
+		Dur("tus_upload_expiry", s.config.TusUploadExpiry).
+		Dur("tus_cleanup_interval", s.config.TusCleanupInterval).
+		Dur("catalog_snapshot_interval", s.config.CatalogSnapshotInterval).
+		Int("catalog_snapshot_count", s.config.CatalogSnapshotCount).
+		Str("auth_mode", s.config.AuthMode).
+		Int("api_keys", len(s.config.APIKeys)).
+		Str("jwt_secret", redactSecret(s.config.JWTSecret)).
+		Str("oidc_issuer", s.config.OIDCIssuer).
+		Str("oidc_audience", s.config.OIDCAudience).
+		Int("nonce_window_seconds", s.config.NonceWindowSeconds).
+		Dur("download_session_ttl", s.config.DownloadSessionTTL).
+		Int("rate_limit_requests", s.config.RateLimitRequests).
+		Dur("rate_limit_window", s.config.RateLimitWindow).
+		Int("rate_limit_bypass_tokens", len(s.config.RateLimitBypassTokens)).
+		Str("rate_limit_bypass_tokens_file", s.config.RateLimitBypassTokensFile).
+		Str("node_id", s.config.NodeID).
+		Strs("cluster_nodes", s.config.ClusterNodes).
+		Strs("trusted_proxies", s.config.TrustedProxies).
+		Bool("incoming_webhooks_enabled", s.config.IncomingWebhookSecret != "").
+		Str("incoming_webhook_secret", redactSecret(s.config.IncomingWebhookSecret)).
+		Str("message_queue_driver", s.config.MessageQueueDriver).
+		Strs("message_queue_urls", s.config.MessageQueueURLs).
+		Str("csp_header", s.config.CSPHeader).
+		Str("response_envelope_style", s.config.ResponseEnvelopeStyle).
+		Int("videos_loaded", len(s.db.GetAllVideos())).
+		Interface("config_sources", s.config.overriddenSources()).
+		Msg("server configuration")
+}
+
+// redactSecret hides a secret's value while still showing whether it is set
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// Run starts the HTTP server and blocks until it has been shut down
 func (s *Server) Run() error {
-	s.logger.Info().Str("port", s.config.ServerPort).Msg("starting server")
-	
+	s.logStartupConfig()
+	go s.warmCache()
+
+	// The check stops early, reporting what it got through, when we return
+	integrityCtx, cancelIntegrityCheck := context.WithCancel(context.Background())
+	defer cancelIntegrityCheck()
+	go s.runStartupIntegrityCheck(integrityCtx)
+
+	if debugSrv := s.startDebugServer(); debugSrv != nil {
+		defer debugSrv.Close()
+	}
+
+	var listener net.Listener
+	var err error
+	if s.upgrader != nil {
+		listener, err = s.upgrader.Listen(s.listen)
+	} else {
+		listener, err = s.listen()
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info().Str("network", listener.Addr().Network()).Str("addr", listener.Addr().String()).Msg("starting server")
+
 	srv := &http.Server{
-		Addr:    ":" + s.config.ServerPort,
+		Addr:    listener.Addr().String(),
 		Handler: s.router,
 	}
 	
-	// Graceful shutdown
+	// Graceful shutdown on Ctrl-C locally and SIGTERM from Docker/Kubernetes
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	// SIGUSR2 starts a new process on our listener, then we shut down
+	var upgradeChan chan os.Signal
+	if s.upgrader != nil {
+		upgradeChan = make(chan os.Signal, 1)
+		signal.Notify(upgradeChan, upgradeSignals...)
+		defer signal.Stop(upgradeChan)
+	}
+
+	serveDone := make(chan struct{})
+	shutdownDone := make(chan struct{})
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt)
-		<-sigChan
-		
-		s.logger.Info().Msg("shutting down server...")
-		
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		
-		if err := srv.Shutdown(ctx); err != nil {
-			s.logger.Error().Err(err).Msg("server shutdown error")
+		defer close(shutdownDone)
+
+		for {
+			select {
+			case <-sigChan:
+				s.shutdown(srv)
+				return
+			case <-upgradeChan:
+				s.logger.Info().Msg("starting graceful upgrade")
+				released := false
+				err := s.upgrader.Upgrade(func() {
+					s.logger.Info().Msg("new process started, handing over")
+					s.shutdown(srv)
+					released = true
+				})
+				if err != nil && !released {
+					s.logger.Error().Err(err).Msg("graceful upgrade failed, still serving")
+					continue
+				}
+				if err != nil {
+					s.logger.Error().Err(err).Msg("new process failed after the handover")
+				} else {
+					s.logger.Info().Msg("new process is ready")
+				}
+				return
+			case <-serveDone:
+				return
+			}
 		}
 	}()
 	
-	return srv.ListenAndServe()
+	// A process started by an upgrade lets its parent exit once it serves
+	if s.upgrader != nil {
+		if err := s.upgrader.Ready(); err != nil {
+			return fmt.Errorf("failed to signal upgrade readiness: %w", err)
+		}
+	}
+
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		close(serveDone)
+		return err
+	}
+
+	// Wait for the shutdown sequence to finish before returning to main
+	<-shutdownDone
+	return http.ErrServerClosed
+}
+
+// isUnixSocketAddr reports whether a ServerAddr is a Unix socket path
+func isUnixSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "./")
+}
+
+// listen opens the server's listener: a Unix socket when ServerAddr is a
+// path, otherwise TCP on ServerAddr and ServerPort
+func (s *Server) listen() (net.Listener, error) {
+	if !isUnixSocketAddr(s.config.ServerAddr) {
+		return net.Listen("tcp", net.JoinHostPort(s.config.ServerAddr, s.config.ServerPort))
+	}
+
+	// A socket left behind by a crashed instance would make the bind fail
+	if info, err := os.Lstat(s.config.ServerAddr); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(s.config.ServerAddr); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", s.config.ServerAddr)
+}
+
+// shutdown rejects new uploads and lets those in flight finish, then stops
+// accepting requests, waits for in-flight requests to finish and drains
+// background work, all within the configured timeout
+func (s *Server) shutdown(srv *http.Server) {
+	s.logger.Info().Dur("timeout", s.config.ShutdownTimeout).Msg("shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	s.shuttingDown.Store(true)
+	if inFlight := s.uploadDrainer.InFlight(); inFlight > 0 {
+		s.logger.Info().Int("uploads", inFlight).Msg("waiting for in-flight uploads")
+	}
+	if err := s.uploadDrainer.Wait(ctx); err != nil {
+		s.logger.Error().Err(err).Int("uploads", s.uploadDrainer.InFlight()).Msg("timed out waiting for in-flight uploads")
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("server shutdown error")
+	}
+
+	// Handlers have returned, so no new webhook deliveries can be started
+	if err := s.webhookMgr.Wait(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("timed out waiting for webhook deliveries")
+	}
+
+	if s.nonceStore != nil {
+		s.nonceStore.Close()
+	}
+	s.downloadSessions.Close()
+	for _, replica := range s.readReplicas {
+		replica.Close()
+	}
+	s.migrator.Stop()
+	close(s.retentionStop)
+	if s.billingStop != nil {
+		close(s.billingStop)
+	}
+	if s.uploadJobStop != nil {
+		close(s.uploadJobStop)
+	}
+	if s.tusExpiryStop != nil {
+		close(s.tusExpiryStop)
+	}
+	if s.catalogSnapshotStop != nil {
+		close(s.catalogSnapshotStop)
+	}
+	if s.webhookQueueStop != nil {
+		close(s.webhookQueueStop)
+	}
+	if s.hashStop != nil {
+		close(s.hashStop)
+	}
+
+	// Handlers have returned, so buffered events can be flushed
+	s.videoEvents.Close()
+	if async, ok := s.publisher.(*AsyncPublisher); ok {
+		if err := async.Drain(ctx); err != nil {
+			s.logger.Error().Err(err).Int("events", async.Depth()).Msg("timed out publishing queued events")
+		}
+	}
+	if closer, ok := s.publisher.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Error().Err(err).Msg("failed to close message publisher")
+		}
+	}
+
+	// Persistent stores must be closed so their files are flushed and unlocked
+	if closer, ok := s.db.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Error().Err(err).Msg("failed to close video store")
+		}
+	}
+
+	s.logger.Info().Msg("server stopped")
 }
 
 func main() {
-	config := LoadConfig()
+	config, err := LoadConfigArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(fmt.Sprintf("failed to load configuration: %v", err))
+	}
+	// Logs written before the server exists use the same format
+	zlog.Logger = newLogger(os.Stderr, config.LogFormat, config.LogLevel)
 
 	// Create storage directory if it doesn't exist
 	if err := os.MkdirAll(config.StoragePath, 0755); err != nil {
 		log.Fatal(fmt.Sprintf("failed to create storage directory: %v", err))
 	}
 
-	server := NewServer(config)
+	var upgrader *gracefulUpgrader
+	if config.EnableGracefulUpgrade {
+		if upgrader, err = newGracefulUpgrader(); err != nil {
+			log.Fatal(fmt.Sprintf("failed to set up graceful upgrades: %v", err))
+		}
+		// The parent holds the database files until its in-flight
+		// requests have finished and its store is closed
+		if err := upgrader.AcquireDB(); err != nil {
+			log.Fatal(fmt.Sprintf("failed to take over the database: %v", err))
+		}
+	}
+
+	db, err := newVideoStore(config)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("failed to open video store: %v", err))
+	}
+
+	server := NewServer(config, db)
+	server.upgrader = upgrader
 
 	if err := server.Run(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(fmt.Sprintf("server error: %v", err))
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// originalQualityLabel is the quality label of a video's uploaded file
+const originalQualityLabel = "original"
+
+// Variant is one quality of a video a client can choose to play. Fields
+// that are not known are left out.
+type Variant struct {
+	QualityLabel string `json:"quality_label"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	BitrateBps   int64  `json:"bitrate_bps,omitempty"`
+	Codec        string `json:"codec,omitempty"`
+	URL          string `json:"url"`
+}
+
+// VideoManifest lists every variant of a video, the original first
+type VideoManifest struct {
+	VideoID  string    `json:"video_id"`
+	Variants []Variant `json:"variants"`
+}
+
+// originalVariant describes the uploaded file from the video's metadata.
+// Without a recorded bitrate it is worked out from the size and duration.
+func originalVariant(video *Video) Variant {
+	variant := Variant{QualityLabel: originalQualityLabel, URL: video.URL}
+	if metadata := video.Metadata; metadata != nil {
+		variant.Width = metadata.Width
+		variant.Height = metadata.Height
+		variant.Codec = metadata.Codec
+		variant.BitrateBps = metadata.BitrateBps
+		if variant.BitrateBps == 0 && metadata.DurationSeconds > 0 {
+			variant.BitrateBps = int64(float64(video.Size*8) / metadata.DurationSeconds)
+		}
+	}
+	return variant
+}
+
+// videoManifest lists the original file followed by the video's derivatives
+func videoManifest(video *Video) VideoManifest {
+	variants := make([]Variant, 0, len(video.Derivatives)+1)
+	variants = append(variants, originalVariant(video))
+	variants = append(variants, video.Derivatives...)
+	return VideoManifest{VideoID: video.ID, Variants: variants}
+}
+
+// getVideoManifestHandler returns the quality variants of a video, so
+// clients can pick one for the available bandwidth
+func (s *Server) getVideoManifestHandler(c *gin.Context) {
+	video, exists := s.db.GetVideoByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	s.respondSuccess(c, http.StatusOK, videoManifest(video))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoManifest(t *testing.T) {
+	server := newTestServer(t)
+
+	getManifest := func(id string) (*httptest.ResponseRecorder, VideoManifest) {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+id+"/manifest", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		var manifest VideoManifest
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &manifest))
+		}
+		return w, manifest
+	}
+
+	t.Run("Original only", func(t *testing.T) {
+		video := uploadTestVideo(t, server, "plain.mp4", []byte("original video"))
+
+		w, manifest := getManifest(video.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, video.ID, manifest.VideoID)
+		assert.Equal(t, []Variant{{QualityLabel: "original", URL: video.URL}}, manifest.Variants)
+	})
+
+	t.Run("Transcoded derivatives", func(t *testing.T) {
+		video := uploadTestVideo(t, server, "talk.mp4", make([]byte, 1000))
+
+		updated := *video
+		updated.Metadata = &VideoMetadata{DurationSeconds: 4, Width: 1920, Height: 1080, Codec: "h264"}
+		updated.Derivatives = []Variant{
+			{QualityLabel: "720p", Width: 1280, Height: 720, BitrateBps: 2500000, Codec: "h264", URL: "/api/videos/" + video.ID + "/derivatives/720p"},
+			{QualityLabel: "480p", Width: 854, Height: 480, BitrateBps: 1000000, Codec: "h264", URL: "/api/videos/" + video.ID + "/derivatives/480p"},
+		}
+		require.NoError(t, server.db.UpdateVideo(&updated))
+
+		w, manifest := getManifest(video.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, manifest.Variants, 3)
+		assert.Equal(t, Variant{
+			QualityLabel: "original",
+			Width:        1920,
+			Height:       1080,
+			BitrateBps:   2000, // 1000 bytes over 4 seconds
+			Codec:        "h264",
+			URL:          video.URL,
+		}, manifest.Variants[0])
+		assert.Equal(t, updated.Derivatives, manifest.Variants[1:])
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		w, _ := getManifest("missing")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
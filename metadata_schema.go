@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metadataSchemaAnnotations are the JSON Schema keywords that don't affect
+// validation and are accepted anywhere in a schema
+var metadataSchemaAnnotations = []string{"$schema", "$id", "$comment", "title", "description", "examples", "default"}
+
+// MetadataSchema is a compiled JSON Schema for a video's custom metadata.
+// Since custom metadata is a flat object of strings, the subset of JSON
+// Schema that applies to it is supported: type, properties, required,
+// additionalProperties, minProperties and maxProperties on the object, and
+// type, pattern, minLength, maxLength and enum on its values. Other
+// keywords are rejected rather than ignored. Patterns are Go regular
+// expressions.
+type MetadataSchema struct {
+	properties    map[string]*metadataValueSchema
+	required      []string
+	additional    *metadataValueSchema // nil with additionalProperties: false
+	minProperties int
+	maxProperties int // -1 for no limit
+}
+
+// metadataValueSchema constrains a single custom metadata value
+type metadataValueSchema struct {
+	pattern   *regexp.Regexp
+	minLength int
+	maxLength int // -1 for no limit
+	enum      []string
+}
+
+// MetadataValidationError is a custom metadata value that does not match a
+// schema
+type MetadataValidationError struct {
+	Field   string `json:"field"` // custom_metadata.<key>, or custom_metadata for the whole object
+	Message string `json:"message"`
+}
+
+// schemaKeywords decodes a schema object, rejecting keywords that are
+// neither allowed nor annotations
+func schemaKeywords(doc json.RawMessage, allowed ...string) (map[string]json.RawMessage, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &keywords); err != nil || keywords == nil {
+		return nil, errors.New("schema must be a JSON object")
+	}
+	for keyword := range keywords {
+		if !slices.Contains(allowed, keyword) && !slices.Contains(metadataSchemaAnnotations, keyword) {
+			return nil, fmt.Errorf("unsupported schema keyword %q", keyword)
+		}
+	}
+	return keywords, nil
+}
+
+// schemaCount decodes a non-negative integer keyword, def when absent
+func schemaCount(keywords map[string]json.RawMessage, keyword string, def int) (int, error) {
+	raw, ok := keywords[keyword]
+	if !ok {
+		return def, nil
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", keyword)
+	}
+	return n, nil
+}
+
+// compileMetadataValueSchema compiles the schema of a custom metadata value
+func compileMetadataValueSchema(doc json.RawMessage) (*metadataValueSchema, error) {
+	keywords, err := schemaKeywords(doc, "type", "pattern", "minLength", "maxLength", "enum")
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := keywords["type"]; ok {
+		var schemaType string
+		if err := json.Unmarshal(raw, &schemaType); err != nil || schemaType != "string" {
+			return nil, errors.New(`custom metadata values are strings, type must be "string"`)
+		}
+	}
+
+	schema := &metadataValueSchema{}
+	if raw, ok := keywords["pattern"]; ok {
+		var pattern string
+		if err := json.Unmarshal(raw, &pattern); err != nil {
+			return nil, errors.New("pattern must be a string")
+		}
+		if schema.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	if schema.minLength, err = schemaCount(keywords, "minLength", 0); err != nil {
+		return nil, err
+	}
+	if schema.maxLength, err = schemaCount(keywords, "maxLength", -1); err != nil {
+		return nil, err
+	}
+	if raw, ok := keywords["enum"]; ok {
+		if err := json.Unmarshal(raw, &schema.enum); err != nil || len(schema.enum) == 0 {
+			return nil, errors.New("enum must be a non-empty array of strings")
+		}
+	}
+	return schema, nil
+}
+
+// compileMetadataSchema compiles a JSON Schema document for custom metadata
+func compileMetadataSchema(doc json.RawMessage) (*MetadataSchema, error) {
+	keywords, err := schemaKeywords(doc, "type", "properties", "required", "additionalProperties", "minProperties", "maxProperties")
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := keywords["type"]; ok {
+		var schemaType string
+		if err := json.Unmarshal(raw, &schemaType); err != nil || schemaType != "object" {
+			return nil, errors.New(`custom metadata is an object, type must be "object"`)
+		}
+	}
+
+	schema := &MetadataSchema{properties: make(map[string]*metadataValueSchema), additional: &metadataValueSchema{maxLength: -1}}
+	if raw, ok := keywords["properties"]; ok {
+		var properties map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &properties); err != nil {
+			return nil, errors.New("properties must be an object")
+		}
+		for key, doc := range properties {
+			if schema.properties[key], err = compileMetadataValueSchema(doc); err != nil {
+				return nil, fmt.Errorf("properties.%s: %w", key, err)
+			}
+		}
+	}
+	if raw, ok := keywords["required"]; ok {
+		if err := json.Unmarshal(raw, &schema.required); err != nil {
+			return nil, errors.New("required must be an array of strings")
+		}
+	}
+	if raw, ok := keywords["additionalProperties"]; ok {
+		var allowed bool
+		if err := json.Unmarshal(raw, &allowed); err == nil {
+			if !allowed {
+				schema.additional = nil
+			}
+		} else if schema.additional, err = compileMetadataValueSchema(raw); err != nil {
+			return nil, fmt.Errorf("additionalProperties: %w", err)
+		}
+	}
+	if schema.minProperties, err = schemaCount(keywords, "minProperties", 0); err != nil {
+		return nil, err
+	}
+	if schema.maxProperties, err = schemaCount(keywords, "maxProperties", -1); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// validate checks a single value, returning what is wrong with it
+func (vs *metadataValueSchema) validate(value string) []string {
+	var problems []string
+	length := utf8.RuneCountInString(value)
+	if length < vs.minLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", vs.minLength))
+	}
+	if vs.maxLength >= 0 && length > vs.maxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters long", vs.maxLength))
+	}
+	if vs.pattern != nil && !vs.pattern.MatchString(value) {
+		problems = append(problems, fmt.Sprintf("does not match pattern %q", vs.pattern.String()))
+	}
+	if vs.enum != nil && !slices.Contains(vs.enum, value) {
+		problems = append(problems, fmt.Sprintf("must be one of %q", vs.enum))
+	}
+	return problems
+}
+
+// Validate checks custom metadata against the schema. It returns the
+// problems found sorted by field, none when the metadata is valid.
+func (ms *MetadataSchema) Validate(metadata map[string]string) []MetadataValidationError {
+	var errs []MetadataValidationError
+	fail := func(key, message string) {
+		field := "custom_metadata"
+		if key != "" {
+			field += "." + key
+		}
+		errs = append(errs, MetadataValidationError{Field: field, Message: message})
+	}
+
+	if len(metadata) < ms.minProperties {
+		fail("", fmt.Sprintf("must have at least %d keys", ms.minProperties))
+	}
+	if ms.maxProperties >= 0 && len(metadata) > ms.maxProperties {
+		fail("", fmt.Sprintf("must have at most %d keys", ms.maxProperties))
+	}
+	for _, key := range ms.required {
+		if _, ok := metadata[key]; !ok {
+			fail(key, "is required")
+		}
+	}
+	for key, value := range metadata {
+		schema, ok := ms.properties[key]
+		if !ok {
+			schema = ms.additional
+		}
+		if schema == nil {
+			fail(key, "is not allowed")
+			continue
+		}
+		for _, problem := range schema.validate(value) {
+			fail(key, problem)
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+// RegisteredMetadataSchema is a global schema every video's custom metadata
+// must match
+type RegisteredMetadataSchema struct {
+	ID        string          `json:"id"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	compiled *MetadataSchema
+}
+
+// MetadataSchemaRegistry holds the global metadata schemas. Like webhooks
+// they are kept in memory only.
+type MetadataSchemaRegistry struct {
+	schemas []RegisteredMetadataSchema
+	mutex   sync.RWMutex
+}
+
+// NewMetadataSchemaRegistry creates an empty registry
+func NewMetadataSchemaRegistry() *MetadataSchemaRegistry {
+	return &MetadataSchemaRegistry{}
+}
+
+// Add registers a compiled schema and returns its record
+func (r *MetadataSchemaRegistry) Add(doc json.RawMessage, compiled *MetadataSchema, now time.Time) RegisteredMetadataSchema {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	registered := RegisteredMetadataSchema{ID: newUUIDv7().String(), Schema: doc, CreatedAt: now, compiled: compiled}
+	r.schemas = append(r.schemas, registered)
+	return registered
+}
+
+// List returns the registered schemas, oldest first
+func (r *MetadataSchemaRegistry) List() []RegisteredMetadataSchema {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]RegisteredMetadataSchema{}, r.schemas...)
+}
+
+// MetadataSchemas returns the schemas of the webhooks that receive events
+// for videos in collectionID: those registered for every video and those
+// of the collection
+func (wm *WebhookManager) MetadataSchemas(collectionID string) []*MetadataSchema {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	var schemas []*MetadataSchema
+	collect := func(records []WebhookRecord) {
+		for _, record := range records {
+			if record.metadataSchema != nil {
+				schemas = append(schemas, record.metadataSchema)
+			}
+		}
+	}
+	for _, records := range wm.webhooks {
+		collect(records)
+	}
+	if collectionID != "" {
+		for _, collections := range wm.collectionWebhooks {
+			collect(collections[collectionID])
+		}
+	}
+	return schemas
+}
+
+// validateCustomMetadata checks a video's custom metadata against the
+// global schemas and the schemas of the webhooks for its collection. A
+// problem reported by several schemas is listed once.
+func (s *Server) validateCustomMetadata(video *Video) []MetadataValidationError {
+	schemas := s.webhookMgr.MetadataSchemas(video.CollectionID)
+	for _, registered := range s.metadataSchemas.List() {
+		schemas = append(schemas, registered.compiled)
+	}
+
+	var errs []MetadataValidationError
+	seen := make(map[MetadataValidationError]bool)
+	for _, schema := range schemas {
+		for _, err := range schema.Validate(video.CustomMetadata) {
+			if !seen[err] {
+				seen[err] = true
+				errs = append(errs, err)
+			}
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+// parseMetadataSchema compiles the metadata_schema of a request, nil when
+// none was sent
+func parseMetadataSchema(doc json.RawMessage) (*MetadataSchema, error) {
+	if len(doc) == 0 || bytes.Equal(doc, []byte("null")) {
+		return nil, nil
+	}
+	schema, err := compileMetadataSchema(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata_schema: %w", err)
+	}
+	return schema, nil
+}
+
+// addMetadataSchemaHandler registers a global JSON Schema that custom
+// metadata writes to every video are validated against
+func (s *Server) addMetadataSchemaHandler(c *gin.Context) {
+	var req struct {
+		Schema json.RawMessage `json:"schema" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	compiled, err := compileMetadataSchema(req.Schema)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schema: " + err.Error()})
+		return
+	}
+
+	registered := s.metadataSchemas.Add(req.Schema, compiled, time.Now())
+	getLogger(c).Info().Str("schema_id", registered.ID).Msg("metadata schema registered")
+	s.respondSuccess(c, http.StatusCreated, registered)
+}
+
+// listMetadataSchemasHandler lists the global metadata schemas
+func (s *Server) listMetadataSchemasHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, gin.H{"schemas": s.metadataSchemas.List()})
+}
+
+// errMetadataInvalid fails an update whose custom metadata does not match a
+// schema
+var errMetadataInvalid = errors.New("custom metadata does not match the schema")
+
+// setCustomMetadataHandler replaces a video's custom metadata with the
+// key/value pairs in the body. It responds 422 with the problems found when
+// the metadata does not match a schema.
+func (s *Server) setCustomMetadataHandler(c *gin.Context) {
+	var metadata map[string]string
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be an object of string values"})
+		return
+	}
+
+	videoID := c.Param("id")
+	var video *Video
+	var errs []MetadataValidationError
+	updated, err := s.db.UpdateVideoFunc(videoID, func(current *Video) error {
+		original := *current
+		video = &original
+		current.CustomMetadata = metadata
+		if len(metadata) == 0 {
+			current.CustomMetadata = nil
+		}
+		current.UpdatedAt = time.Now()
+
+		if errs = s.validateCustomMetadata(current); len(errs) > 0 {
+			return errMetadataInvalid
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrVideoNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		case errors.Is(err, errMetadataInvalid):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "custom metadata does not match the schema", "validation_errors": errs})
+		default:
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to update custom metadata")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update video"})
+		}
+		return
+	}
+	s.recordMetadataEvents(c, video, updated)
+
+	getLogger(c).Info().
+		Str("video_id", video.ID).
+		Int("keys", len(metadata)).
+		Msg("custom metadata updated")
+
+	s.respondSuccess(c, http.StatusOK, updated)
+}
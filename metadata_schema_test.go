@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMetadataSchema = `{
+	"type": "object",
+	"properties": {
+		"isrc": {"type": "string", "pattern": "^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$"},
+		"rating": {"enum": ["G", "PG", "R"]}
+	},
+	"required": ["isrc"]
+}`
+
+// sendJSON sends body to the server and returns the recorded response
+func sendJSON(server *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMetadataSchemaValidate(t *testing.T) {
+	schema, err := compileMetadataSchema(json.RawMessage(testMetadataSchema))
+	require.NoError(t, err)
+
+	assert.Empty(t, schema.Validate(map[string]string{"isrc": "USRC17607839", "rating": "PG", "notes": "anything"}))
+	assert.Equal(t, []MetadataValidationError{
+		{Field: "custom_metadata.isrc", Message: `does not match pattern "^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$"`},
+		{Field: "custom_metadata.rating", Message: `must be one of ["G" "PG" "R"]`},
+	}, schema.Validate(map[string]string{"isrc": "not-an-isrc", "rating": "NC-17"}))
+	assert.Equal(t, []MetadataValidationError{
+		{Field: "custom_metadata.isrc", Message: "is required"},
+	}, schema.Validate(nil))
+
+	t.Run("Additional properties", func(t *testing.T) {
+		closed, err := compileMetadataSchema(json.RawMessage(`{"properties": {"a": {}}, "additionalProperties": false}`))
+		require.NoError(t, err)
+		assert.Equal(t, []MetadataValidationError{
+			{Field: "custom_metadata.b", Message: "is not allowed"},
+		}, closed.Validate(map[string]string{"a": "1", "b": "2"}))
+
+		short, err := compileMetadataSchema(json.RawMessage(`{"additionalProperties": {"maxLength": 3}, "maxProperties": 1}`))
+		require.NoError(t, err)
+		assert.Equal(t, []MetadataValidationError{
+			{Field: "custom_metadata", Message: "must have at most 1 keys"},
+			{Field: "custom_metadata.a", Message: "must be at most 3 characters long"},
+		}, short.Validate(map[string]string{"a": "long", "b": "ok"}))
+	})
+
+	t.Run("Invalid schemas", func(t *testing.T) {
+		for _, doc := range []string{
+			`[]`,
+			`{"type": "array"}`,
+			`{"properties": {"a": {"type": "integer"}}}`,
+			`{"properties": {"a": {"pattern": "("}}}`,
+			`{"required": "a"}`,
+			`{"properties": {"a": {"minLength": -1}}}`,
+			`{"oneOf": []}`,
+		} {
+			_, err := compileMetadataSchema(json.RawMessage(doc))
+			assert.Error(t, err, doc)
+		}
+	})
+}
+
+func TestSetCustomMetadata(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTestVideo("a", 10)))
+
+	w := sendJSON(server, http.MethodPost, "/api/admin/metadata-schemas", `{"schema": `+testMetadataSchema+`}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	t.Run("Valid", func(t *testing.T) {
+		w := sendJSON(server, http.MethodPut, "/api/videos/a/metadata", `{"isrc": "USRC17607839"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		video, _ := server.db.GetVideoByID("a")
+		assert.Equal(t, map[string]string{"isrc": "USRC17607839"}, video.CustomMetadata)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		w := sendJSON(server, http.MethodPut, "/api/videos/a/metadata", `{"isrc": "usrc1760783"}`)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		var resp struct {
+			ValidationErrors []MetadataValidationError `json:"validation_errors"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.ValidationErrors, 1)
+		assert.Equal(t, "custom_metadata.isrc", resp.ValidationErrors[0].Field)
+
+		w = sendJSON(server, http.MethodPut, "/api/videos/a/metadata", `{}`)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"message":"is required"`)
+
+		video, _ := server.db.GetVideoByID("a")
+		assert.Equal(t, "USRC17607839", video.CustomMetadata["isrc"], "left unchanged")
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		require.NoError(t, server.db.AddVideo(newTestVideo("b", 10)))
+		code, resp := batchUpdate(t, server, `{"ids":["a","b"],"updates":{"custom_metadata":{"rating":"PG"}}}`)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"a"}, resp.Updated)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, BatchErrorValidation, resp.Errors[0].Error.Code)
+		assert.Equal(t, "custom_metadata.isrc is required", resp.Errors[0].Error.Message)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/metadata-schemas", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		var resp struct {
+			Schemas []RegisteredMetadataSchema `json:"schemas"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Schemas, 1)
+		assert.NotEmpty(t, resp.Schemas[0].ID)
+	})
+
+	t.Run("Invalid schema", func(t *testing.T) {
+		w := sendJSON(server, http.MethodPost, "/api/admin/metadata-schemas", `{"schema": {"type": "array"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWebhookMetadataSchema(t *testing.T) {
+	server := newTestServer(t)
+	collected := newTestVideo("in", 10)
+	collected.CollectionID = "music"
+	require.NoError(t, server.db.AddVideo(collected))
+	require.NoError(t, server.db.AddVideo(newTestVideo("out", 10)))
+
+	w := sendJSON(server, http.MethodPost, "/api/webhooks", `{
+		"event": "upload",
+		"url": "https://hooks.example.com/music",
+		"collection_id": "music",
+		"metadata_schema": {"required": ["artist"]}
+	}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Only videos in the webhook's collection are checked
+	w = sendJSON(server, http.MethodPut, "/api/videos/in/metadata", `{"title": "x"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = sendJSON(server, http.MethodPut, "/api/videos/out/metadata", `{"title": "x"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = sendJSON(server, http.MethodPut, "/api/videos/in/metadata", `{"artist": "x"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("Exported", func(t *testing.T) {
+		entries := server.webhookMgr.ExportWebhooks()
+		require.Len(t, entries, 1)
+		assert.JSONEq(t, `{"required": ["artist"]}`, string(entries[0].Options.MetadataSchema))
+	})
+
+	t.Run("Invalid schema", func(t *testing.T) {
+		w := sendJSON(server, http.MethodPost, "/api/webhooks", `{"event": "upload", "url": "https://hooks.example.com/b", "metadata_schema": {"pattern": 1}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// currentSchemaVersion is the version of the records saved in
+// database.json. Bump it along with a new step in NewMigrator when a Video
+// field needs filling in for older records.
+const currentSchemaVersion = 2
+
+// schemaMigration upgrades a loaded database by one schema version
+type schemaMigration func(db *InMemoryDB) error
+
+// Migrator upgrades databases loaded from files saved with an older schema
+type Migrator struct {
+	steps map[int]schemaMigration // version -> step to the next version
+}
+
+// NewMigrator returns a Migrator with every schema migration
+func NewMigrator() *Migrator {
+	return &Migrator{steps: map[int]schemaMigration{
+		0: migrateHashes,
+		1: migrateDownloadCounts,
+	}}
+}
+
+// Migrate runs the steps from fromVersion up to toVersion in order. It is
+// run by loadFromDisk, before the database is shared.
+func (m *Migrator) Migrate(db *InMemoryDB, fromVersion, toVersion int) error {
+	if fromVersion > toVersion {
+		return fmt.Errorf("cannot migrate schema version %d down to %d", fromVersion, toVersion)
+	}
+	for version := fromVersion; version < toVersion; version++ {
+		step, ok := m.steps[version]
+		if !ok {
+			return fmt.Errorf("no migration from schema version %d", version)
+		}
+		if err := step(db); err != nil {
+			return fmt.Errorf("failed to migrate schema version %d to %d: %w", version, version+1, err)
+		}
+		log.Info().Int("from", version).Int("to", version+1).Int("videos", len(db.videos)).Msg("migrated database schema")
+	}
+	return nil
+}
+
+// migrateHashes upgrades to version 1, which added Video.Hash. Hashing
+// needs the files, so the videos without one are handed to the
+// HashMigrator, which computes them in the background.
+func migrateHashes(db *InMemoryDB) error {
+	db.migrationCheck()
+	return nil
+}
+
+// migrateDownloadCounts upgrades to version 2, which added
+// Video.DownloadCount. Downloads before the upgrade were not counted, so
+// every video starts from zero.
+func migrateDownloadCounts(db *InMemoryDB) error {
+	for _, video := range db.videos {
+		video.DownloadCount = 0
+	}
+	return nil
+}
+
+// downloadCounter is implemented by stores that count downloads
+type downloadCounter interface {
+	IncrementDownloadCount(id string)
+}
+
+// IncrementDownloadCount adds a download to a video's count
+func (db *InMemoryDB) IncrementDownloadCount(id string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if video, exists := db.videos[id]; exists {
+		video.DownloadCount++
+		db.markDirty(id)
+	}
+}
+
+// hashMigrationSource is implemented by stores that can report videos saved
+// before upload hashing was introduced
+type hashMigrationSource interface {
+	// TakePendingHashes returns the IDs of videos without a hash and clears
+	// the list, so each ID is handed out once
+	TakePendingHashes() []string
+}
+
+// migrationCheck records the loaded videos that have no hash yet. It is run
+// by loadFromDisk, before the database is shared.
+func (db *InMemoryDB) migrationCheck() {
+	db.pendingHashes = nil
+	for id, video := range db.videos {
+		if video.Hash == "" {
+			db.pendingHashes = append(db.pendingHashes, id)
+		}
+	}
+}
+
+// TakePendingHashes returns the videos found by migrationCheck
+func (db *InMemoryDB) TakePendingHashes() []string {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ids := db.pendingHashes
+	db.pendingHashes = nil
+	return ids
+}
+
+// HashMigrator hashes videos that were stored without a hash, in the
+// background, so older databases are upgraded without downtime
+type HashMigrator struct {
+	queue chan string
+	stop  chan struct{}
+
+	total     atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// MigrationStatus is a snapshot of a HashMigrator's progress
+type MigrationStatus struct {
+	Total     int64 `json:"total"`
+	Pending   int64 `json:"pending"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+// startHashMigration queues the store's pending hash migrations and starts
+// Config.MigrationWorkers workers to process them
+func (s *Server) startHashMigration() *HashMigrator {
+	migrator := &HashMigrator{stop: make(chan struct{})}
+
+	source, ok := s.db.(hashMigrationSource)
+	if !ok {
+		return migrator
+	}
+	ids := source.TakePendingHashes()
+	if len(ids) == 0 {
+		return migrator
+	}
+
+	migrator.total.Store(int64(len(ids)))
+	migrator.queue = make(chan string, len(ids))
+	for _, id := range ids {
+		migrator.queue <- id
+	}
+	close(migrator.queue)
+
+	workers := s.config.MigrationWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go s.hashMigrationWorker(migrator)
+	}
+
+	s.logger.Info().Int("videos", len(ids)).Int("workers", workers).Msg("hashing videos stored without a hash")
+	return migrator
+}
+
+// hashMigrationWorker hashes queued videos until the queue is drained or the
+// migrator is stopped
+func (s *Server) hashMigrationWorker(migrator *HashMigrator) {
+	for {
+		select {
+		case <-migrator.stop:
+			return
+		case id, ok := <-migrator.queue:
+			if !ok {
+				return
+			}
+			if err := s.migrateVideoHash(id); err != nil {
+				log.Error().Err(err).Str("video_id", id).Msg("failed to hash video during migration")
+				migrator.failed.Add(1)
+				continue
+			}
+			migrator.completed.Add(1)
+		}
+	}
+}
+
+// migrateVideoHash computes and stores the hash of one video. Videos deleted
+// in the meantime are skipped.
+func (s *Server) migrateVideoHash(id string) error {
+	video, exists := s.db.GetVideoByID(id)
+	if !exists || video.Hash != "" {
+		return nil
+	}
+
+	hash, err := computeFileHash(s.getFilePath(video.ID, video.Name), defaultHashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.UpdateVideoFunc(id, func(current *Video) error {
+		current.Hash = hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrVideoNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Status returns the migration's progress
+func (m *HashMigrator) Status() MigrationStatus {
+	status := MigrationStatus{
+		Total:     m.total.Load(),
+		Completed: m.completed.Load(),
+		Failed:    m.failed.Load(),
+	}
+	status.Pending = status.Total - status.Completed - status.Failed
+	return status
+}
+
+// Stop makes the workers exit after their current video
+func (m *HashMigrator) Stop() {
+	close(m.stop)
+}
+
+// migrationStatusHandler reports the progress of the background hash migration
+func (s *Server) migrationStatusHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, s.migrator.Status())
+}
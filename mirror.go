@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// mirrorDiffSampleSize is how many videos the mirror diff compares
+const mirrorDiffSampleSize = 100
+
+// MirroredFileStore shadows a primary FileStore with a mirror, so a new
+// storage backend sees real writes before it is relied on. Reads only use
+// the primary and mirror failures are logged, never returned.
+type MirroredFileStore struct {
+	primary FileStore
+	mirror  FileStore
+}
+
+// NewMirroredFileStore creates a file store writing to primary and mirror
+func NewMirroredFileStore(primary, mirror FileStore) *MirroredFileStore {
+	return &MirroredFileStore{primary: primary, mirror: mirror}
+}
+
+// Open opens the file from the primary store
+func (ms *MirroredFileStore) Open(key string) (io.ReadCloser, error) {
+	return ms.primary.Open(key)
+}
+
+// Put writes r to the primary and the mirror in parallel, streaming it to
+// the mirror as the primary reads it
+func (ms *MirroredFileStore) Put(key string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mirrorErr := make(chan error, 1)
+	go func() {
+		err := ms.mirror.Put(key, pr)
+		// Keep draining so a failed mirror never blocks the primary
+		io.Copy(io.Discard, pr)
+		mirrorErr <- err
+	}()
+
+	err := ms.primary.Put(key, io.TeeReader(r, pw))
+	pw.CloseWithError(err)
+
+	if err := <-mirrorErr; err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to write file to mirror storage")
+	}
+	return err
+}
+
+// Exists reports whether the primary store has the file
+func (ms *MirroredFileStore) Exists(key string) (bool, error) {
+	return ms.primary.Exists(key)
+}
+
+// Stat describes the file in the primary store
+func (ms *MirroredFileStore) Stat(key string) (os.FileInfo, error) {
+	return ms.primary.Stat(key)
+}
+
+// Remove deletes the file from the primary and the mirror
+func (ms *MirroredFileStore) Remove(key string) error {
+	if err := ms.mirror.Remove(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Str("key", key).Msg("failed to remove file from mirror storage")
+	}
+	return ms.primary.Remove(key)
+}
+
+// mirrorVideoFile copies a video file written straight to StoragePath, such
+// as an upload, to the mirror. Failures are only logged.
+func (s *Server) mirrorVideoFile(videoID, filename string) {
+	if s.mirrorFiles == nil {
+		return
+	}
+	if err := s.copyToStore(s.mirrorFiles, videoID, filename); err != nil {
+		s.logger.Error().Err(err).Str("video_id", videoID).Msg("failed to write video file to mirror storage")
+	}
+}
+
+// hashStoredFile hashes the file stored under key in store
+func hashStoredFile(store FileStore, key string) (string, error) {
+	hasher, err := newHasher(defaultHashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := store.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// MirrorDiscrepancy is a sampled video whose mirror copy differs from the
+// primary one
+type MirrorDiscrepancy struct {
+	VideoID     string `json:"video_id"`
+	PrimaryHash string `json:"primary_hash,omitempty"`
+	MirrorHash  string `json:"mirror_hash,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// mirrorDiffHandler compares the files of a random sample of videos on the
+// primary and mirror storage backends
+func (s *Server) mirrorDiffHandler(c *gin.Context) {
+	if s.mirrorFiles == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mirror storage is not configured"})
+		return
+	}
+
+	videos := s.db.GetAllVideos()
+	rand.Shuffle(len(videos), func(i, j int) {
+		videos[i], videos[j] = videos[j], videos[i]
+	})
+	if len(videos) > mirrorDiffSampleSize {
+		videos = videos[:mirrorDiffSampleSize]
+	}
+
+	discrepancies := []MirrorDiscrepancy{}
+	for _, video := range videos {
+		key := fileKey(video.ID, video.Name)
+		diff := MirrorDiscrepancy{VideoID: video.ID}
+
+		primaryHash, primaryErr := computeFileHash(s.getFilePath(video.ID, video.Name), defaultHashAlgorithm)
+		mirrorHash, mirrorErr := hashStoredFile(s.mirrorFiles, key)
+		diff.PrimaryHash, diff.MirrorHash = primaryHash, mirrorHash
+
+		switch {
+		case primaryErr != nil:
+			diff.Error = "primary: " + primaryErr.Error()
+		case mirrorErr != nil:
+			diff.Error = "mirror: " + mirrorErr.Error()
+		case primaryHash == mirrorHash:
+			continue
+		}
+		discrepancies = append(discrepancies, diff)
+	}
+
+	getLogger(c).Info().
+		Int("sampled", len(videos)).
+		Int("discrepancies", len(discrepancies)).
+		Msg("mirror storage compared")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success":       true,
+		"sampled":       len(videos),
+		"discrepancies": discrepancies,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirroredFileStore(t *testing.T) {
+	primary := NewLocalFileStore(t.TempDir())
+	mirror := NewLocalFileStore(t.TempDir())
+	store := NewMirroredFileStore(primary, mirror)
+
+	require.NoError(t, store.Put("a_video.mp4", strings.NewReader("content")))
+	for _, s := range []*LocalFileStore{primary, mirror} {
+		data, err := os.ReadFile(filepath.Join(s.root, "a_video.mp4"))
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(data))
+	}
+
+	require.NoError(t, store.Remove("a_video.mp4"))
+	exists, _ := mirror.Exists("a_video.mp4")
+	assert.False(t, exists)
+
+	// A failing mirror does not fail the write, a failing primary does
+	require.NoError(t, NewMirroredFileStore(primary, failingFileStore{}).Put("b_video.mp4", strings.NewReader("content")))
+	assert.ErrorIs(t, NewMirroredFileStore(failingFileStore{}, mirror).Put("c_video.mp4", strings.NewReader("content")), errStoreDown)
+}
+
+func newMirroredTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	mirrorDir := t.TempDir()
+	config := &Config{
+		StoragePath:          t.TempDir(),
+		MaxFileSize:          1024 * 1024,
+		MirrorStorageBackend: "local:" + mirrorDir,
+	}
+	server := NewServer(config, NewInMemoryDB())
+	t.Cleanup(func() {
+		server.migrator.Stop()
+		close(server.retentionStop)
+	})
+	return server, mirrorDir
+}
+
+func TestUploadsMirrored(t *testing.T) {
+	server, mirrorDir := newMirroredTestServer(t)
+
+	for i := 0; i < 10; i++ {
+		uploadTestVideo(t, server, fmt.Sprintf("video-%d.mp4", i), []byte(fmt.Sprintf("content of video %d", i)))
+	}
+
+	primaryFiles := storedVideoFiles(t, server.config.StoragePath)
+	mirrorFiles, err := os.ReadDir(mirrorDir)
+	require.NoError(t, err)
+	require.Len(t, mirrorFiles, 10)
+	require.Len(t, primaryFiles, len(mirrorFiles))
+
+	for _, name := range primaryFiles {
+		primaryData, err := os.ReadFile(filepath.Join(server.config.StoragePath, name))
+		require.NoError(t, err)
+		mirrorData, err := os.ReadFile(filepath.Join(mirrorDir, name))
+		require.NoError(t, err)
+		assert.Equal(t, primaryData, mirrorData, name)
+	}
+}
+
+func TestMirrorDiffHandler(t *testing.T) {
+	server, mirrorDir := newMirroredTestServer(t)
+
+	same := uploadTestVideo(t, server, "same.mp4", []byte("content"))
+	changed := uploadTestVideo(t, server, "changed.mp4", []byte("content"))
+	missing := uploadTestVideo(t, server, "missing.mp4", []byte("content"))
+	require.NoError(t, os.WriteFile(filepath.Join(mirrorDir, fileKey(changed.ID, changed.Name)), []byte("corrupted"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(mirrorDir, fileKey(missing.ID, missing.Name))))
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/mirror/diff", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Sampled       int                 `json:"sampled"`
+		Discrepancies []MirrorDiscrepancy `json:"discrepancies"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Sampled)
+
+	byID := make(map[string]MirrorDiscrepancy)
+	for _, diff := range resp.Discrepancies {
+		byID[diff.VideoID] = diff
+	}
+	assert.Len(t, byID, 2)
+	assert.NotContains(t, byID, same.ID)
+	assert.NotEqual(t, byID[changed.ID].PrimaryHash, byID[changed.ID].MirrorHash)
+	assert.Contains(t, byID[missing.ID].Error, "mirror:")
+
+	// Without a mirror there is nothing to compare
+	w = httptest.NewRecorder()
+	newTestServer(t).router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/mirror/diff", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
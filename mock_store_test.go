@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// MockVideoStore is an in-memory VideoStore for handler tests. Its exported
+// fields control what the store returns and record how it was called.
+type MockVideoStore struct {
+	mutex sync.Mutex
+
+	Videos   map[string]*Video
+	LatestID string
+
+	// AddVideoErr, if set, is returned by AddVideo without storing the video
+	AddVideoErr error
+	// DeleteVideoFails makes DeleteVideo report failure
+	DeleteVideoFails bool
+
+	// Calls records the name of each method called, in order
+	Calls []string
+}
+
+// NewMockVideoStore creates an empty mock store
+func NewMockVideoStore() *MockVideoStore {
+	return &MockVideoStore{Videos: make(map[string]*Video)}
+}
+
+func (m *MockVideoStore) record(call string) {
+	m.Calls = append(m.Calls, call)
+}
+
+// CallCount returns how many times the named method was called
+func (m *MockVideoStore) CallCount(call string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count := 0
+	for _, c := range m.Calls {
+		if c == call {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *MockVideoStore) AddVideo(v *Video) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("AddVideo")
+
+	if m.AddVideoErr != nil {
+		return m.AddVideoErr
+	}
+	videoCopy := *v
+	m.Videos[v.ID] = &videoCopy
+	m.LatestID = v.ID
+	return nil
+}
+
+func (m *MockVideoStore) UpdateVideo(v *Video) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("UpdateVideo")
+
+	if _, exists := m.Videos[v.ID]; !exists {
+		return ErrVideoNotFound
+	}
+	videoCopy := *v
+	m.Videos[v.ID] = &videoCopy
+	return nil
+}
+
+func (m *MockVideoStore) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("UpdateVideoFunc")
+
+	existing, exists := m.Videos[id]
+	if !exists {
+		return nil, ErrVideoNotFound
+	}
+	videoCopy := *existing
+	if err := update(&videoCopy); err != nil {
+		return nil, err
+	}
+	videoCopy.ID = id
+	m.Videos[id] = &videoCopy
+	stored := videoCopy
+	return &stored, nil
+}
+
+func (m *MockVideoStore) GetVideoByID(id string) (*Video, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("GetVideoByID")
+
+	video, exists := m.Videos[id]
+	if !exists {
+		return nil, false
+	}
+	videoCopy := *video
+	return &videoCopy, true
+}
+
+func (m *MockVideoStore) GetVideoByName(name string) (*Video, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("GetVideoByName")
+
+	for _, video := range m.Videos {
+		if video.Name == name {
+			videoCopy := *video
+			return &videoCopy, true
+		}
+	}
+	return nil, false
+}
+
+func (m *MockVideoStore) GetLatestVideo() (*Video, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("GetLatestVideo")
+
+	video, exists := m.Videos[m.LatestID]
+	if !exists {
+		return nil, false
+	}
+	videoCopy := *video
+	return &videoCopy, true
+}
+
+func (m *MockVideoStore) GetAllVideos() []*Video {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("GetAllVideos")
+
+	videos := make([]*Video, 0, len(m.Videos))
+	for _, video := range m.Videos {
+		videoCopy := *video
+		videos = append(videos, &videoCopy)
+	}
+	return videos
+}
+
+func (m *MockVideoStore) DeleteVideo(id string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("DeleteVideo")
+
+	if _, exists := m.Videos[id]; !exists || m.DeleteVideoFails {
+		return false
+	}
+	delete(m.Videos, id)
+	if m.LatestID == id {
+		m.LatestID = ""
+	}
+	return true
+}
+
+func (m *MockVideoStore) SearchVideos(query SearchQuery) []*Video {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record("SearchVideos")
+
+	var videos []*Video
+	for _, video := range m.Videos {
+		if strings.Contains(strings.ToLower(video.Name), strings.ToLower(query.Query)) {
+			videoCopy := *video
+			videos = append(videos, &videoCopy)
+		}
+	}
+	return videos
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MIMEMsgpack is the media type clients send in Accept to get MessagePack
+const MIMEMsgpack = "application/msgpack"
+
+// negotiateEncoder picks a response encoder from the request's Accept header.
+// MessagePack is used when requested, JSON otherwise. The returned function
+// encodes a value and returns the body along with its content type.
+func negotiateEncoder(c *gin.Context) func(v interface{}) ([]byte, string) {
+	if c.NegotiateFormat(gin.MIMEJSON, MIMEMsgpack) == MIMEMsgpack {
+		return encodeMsgpack
+	}
+	return encodeJSON
+}
+
+// encodeMsgpack encodes v as MessagePack, using the json struct tags so field
+// names match the JSON representation
+func encodeMsgpack(v interface{}) ([]byte, string) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+
+	if err := enc.Encode(v); err != nil {
+		return encodeJSON(gin.H{"error": "failed to encode response"})
+	}
+	return buf.Bytes(), MIMEMsgpack
+}
+
+// encodeJSON encodes v as JSON
+func encodeJSON(v interface{}) ([]byte, string) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte(`{"error":"failed to encode response"}`)
+	}
+	return data, gin.MIMEJSON + "; charset=utf-8"
+}
+
+// respondNegotiated writes obj using the encoder negotiated for the request
+func respondNegotiated(c *gin.Context, status int, obj interface{}) {
+	data, contentType := negotiateEncoder(c)(obj)
+	c.Data(status, contentType, data)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	server := newTestServer(t)
+	uploaded := uploadTestVideo(t, server, "negotiate.mp4", []byte("content"))
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	type latestResponse struct {
+		Success bool   `json:"success"`
+		Video   *Video `json:"video"`
+	}
+
+	jsonResp := get("/api/videos/latest", "application/json")
+	require.Equal(t, http.StatusOK, jsonResp.Code)
+	assert.Contains(t, jsonResp.Header().Get("Content-Type"), "application/json")
+
+	msgpackResp := get("/api/videos/latest", MIMEMsgpack)
+	require.Equal(t, http.StatusOK, msgpackResp.Code)
+	assert.Equal(t, MIMEMsgpack, msgpackResp.Header().Get("Content-Type"))
+
+	assert.NotEqual(t, jsonResp.Body.Bytes(), msgpackResp.Body.Bytes())
+
+	var fromJSON, fromMsgpack latestResponse
+	require.NoError(t, json.Unmarshal(jsonResp.Body.Bytes(), &fromJSON))
+
+	dec := msgpack.NewDecoder(bytes.NewReader(msgpackResp.Body.Bytes()))
+	dec.SetCustomStructTag("json")
+	require.NoError(t, dec.Decode(&fromMsgpack))
+
+	assert.True(t, fromMsgpack.Success)
+	require.NotNil(t, fromMsgpack.Video)
+	assert.Equal(t, uploaded.ID, fromMsgpack.Video.ID)
+
+	// Normalise time zones, which the two encodings represent differently
+	for _, v := range []*Video{fromJSON.Video, fromMsgpack.Video} {
+		v.CreatedAt = v.CreatedAt.UTC()
+		v.UpdatedAt = v.UpdatedAt.UTC()
+	}
+	assert.Equal(t, *fromJSON.Video, *fromMsgpack.Video)
+
+	t.Run("Default is JSON", func(t *testing.T) {
+		w := get("/api/videos", "")
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+		assert.True(t, json.Valid(w.Body.Bytes()))
+	})
+
+	t.Run("Errors are negotiated too", func(t *testing.T) {
+		w := get("/api/videos/missing", MIMEMsgpack)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		var resp map[string]string
+		require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "video not found", resp["error"])
+	})
+}
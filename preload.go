@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Preload job statuses
+const (
+	PreloadQueued   = "queued"
+	PreloadFetching = "fetching"
+	PreloadDone     = "done"
+	PreloadFailed   = "failed"
+)
+
+// preloadJobTTL is how long finished preload jobs can still be looked up
+const preloadJobTTL = time.Hour
+
+// PreloadJob tracks warming a CDN cache for a single video
+type PreloadJob struct {
+	ID        string    `json:"job_id"`
+	VideoID   string    `json:"video_id"`
+	URL       string    `json:"url"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PreloadManager runs CDN preload requests with bounded concurrency
+type PreloadManager struct {
+	jobs   map[string]*PreloadJob
+	mutex  sync.RWMutex
+	slots  chan struct{} // semaphore limiting concurrent fetches
+	client *http.Client
+}
+
+// NewPreloadManager creates a preload manager running at most concurrency
+// fetches at once. Redirects must pass validate, and connections to private
+// addresses are refused however the host resolves.
+func NewPreloadManager(concurrency int, validate func(rawURL string) error) *PreloadManager {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &PreloadManager{
+		jobs:  make(map[string]*PreloadJob),
+		slots: make(chan struct{}, concurrency),
+		client: &http.Client{
+			Timeout:   5 * time.Minute,
+			Transport: &http.Transport{DialContext: publicDialer().DialContext},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				return validate(req.URL.String())
+			},
+		},
+	}
+}
+
+// Enqueue creates a job that fetches <cdnURL>/api/videos/<videoID> in the background
+func (pm *PreloadManager) Enqueue(cdnURL, videoID string) PreloadJob {
+	job := &PreloadJob{
+		ID:        newUUIDv7().String(),
+		VideoID:   videoID,
+		URL:       fmt.Sprintf("%s/api/videos/%s", strings.TrimSuffix(cdnURL, "/"), videoID),
+		Status:    PreloadQueued,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	pm.mutex.Lock()
+	pm.expireJobsLocked(job.CreatedAt)
+	pm.jobs[job.ID] = job
+	snapshot := *job
+	pm.mutex.Unlock()
+
+	go pm.run(job)
+
+	return snapshot
+}
+
+// GetJob returns a copy of the job with the given ID
+func (pm *PreloadManager) GetJob(id string) (PreloadJob, bool) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	job, exists := pm.jobs[id]
+	if !exists {
+		return PreloadJob{}, false
+	}
+	return *job, true
+}
+
+// expireJobsLocked forgets jobs that finished more than preloadJobTTL
+// before now. The caller must hold the lock.
+func (pm *PreloadManager) expireJobsLocked(now time.Time) {
+	for id, job := range pm.jobs {
+		finished := job.Status == PreloadDone || job.Status == PreloadFailed
+		if finished && now.Sub(job.UpdatedAt) > preloadJobTTL {
+			delete(pm.jobs, id)
+		}
+	}
+}
+
+// run waits for a free slot and then issues the warming request
+func (pm *PreloadManager) run(job *PreloadJob) {
+	pm.slots <- struct{}{}
+	defer func() { <-pm.slots }()
+
+	pm.setStatus(job, PreloadFetching, "")
+
+	resp, err := pm.client.Get(job.URL)
+	if err != nil {
+		log.Error().Err(err).Str("url", job.URL).Msg("CDN preload request failed")
+		pm.setStatus(job, PreloadFailed, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	// The CDN only caches what it has fully served, so read the whole body
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		pm.setStatus(job, PreloadFailed, err.Error())
+		return
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		pm.setStatus(job, PreloadFailed, fmt.Sprintf("unexpected status %d", resp.StatusCode))
+		return
+	}
+
+	pm.setStatus(job, PreloadDone, "")
+}
+
+// setStatus updates a job's status under the lock
+func (pm *PreloadManager) setStatus(job *PreloadJob, status, errMsg string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	job.Status = status
+	job.Error = errMsg
+	job.UpdatedAt = time.Now()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cdnTransport sends every request to cdn, whatever host the URL names, so
+// CDN URLs can use a public host name
+func cdnTransport(cdn *httptest.Server) *http.Transport {
+	return &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, cdn.Listener.Addr().String())
+	}}
+}
+
+// allowAnyURL is a NewPreloadManager validate function accepting every URL
+func allowAnyURL(string) error { return nil }
+
+func TestPreloadCDN(t *testing.T) {
+	var requests, active, maxActive int32
+	var mutex sync.Mutex
+	paths := make(map[string]bool)
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		current := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+
+		mutex.Lock()
+		paths[r.URL.Path] = true
+		if current > maxActive {
+			maxActive = current
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("video bytes"))
+	}))
+	defer cdn.Close()
+
+	server := newTestServer(t)
+	server.preloadMgr = NewPreloadManager(2, server.validateWebhookURL)
+	server.preloadMgr.client.Transport = cdnTransport(cdn)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, uploadTestVideo(t, server, fmt.Sprintf("preload-%d.mp4", i), []byte("content")).ID)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"video_ids": append(ids, "missing"), "cdn_url": "http://cdn.example.com"})
+	req, _ := http.NewRequest("POST", "/api/admin/preload", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var resp struct {
+		Succeeded int `json:"succeeded"`
+		Items     []struct {
+			ID    string     `json:"id"`
+			Error *APIError  `json:"error"`
+			Data  PreloadJob `json:"data"`
+		} `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 6)
+	assert.Equal(t, 5, resp.Succeeded)
+	assert.Equal(t, "missing", resp.Items[5].ID)
+	assert.Equal(t, BatchErrorNotFound, resp.Items[5].Error.Code)
+
+	jobStatus := func(jobID string) string {
+		req, _ := http.NewRequest("GET", "/api/admin/preload/"+jobID, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Job PreloadJob `json:"job"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Job.Status
+	}
+
+	assert.Eventually(t, func() bool {
+		for _, item := range resp.Items[:5] {
+			if jobStatus(item.Data.ID) != PreloadDone {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&requests))
+
+	mutex.Lock()
+	assert.LessOrEqual(t, maxActive, int32(2))
+	for _, id := range ids {
+		assert.True(t, paths["/api/videos/"+id], "expected a GET for video %s", id)
+	}
+	mutex.Unlock()
+
+	t.Run("Unknown job", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/admin/preload/unknown", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestPreloadJobFailure(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "origin unavailable", http.StatusBadGateway)
+	}))
+	defer cdn.Close()
+
+	pm := NewPreloadManager(1, allowAnyURL)
+	pm.client.Transport = cdnTransport(cdn)
+	job := pm.Enqueue("http://cdn.example.com", "video-id")
+	assert.Equal(t, PreloadQueued, job.Status)
+
+	assert.Eventually(t, func() bool {
+		job, _ := pm.GetJob(job.ID)
+		return job.Status == PreloadFailed
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// waitPreloadFailure waits for a job to fail and returns its error
+func waitPreloadFailure(t *testing.T, pm *PreloadManager, jobID string) string {
+	t.Helper()
+
+	var job PreloadJob
+	require.Eventually(t, func() bool {
+		job, _ = pm.GetJob(jobID)
+		return job.Status == PreloadFailed
+	}, 2*time.Second, 10*time.Millisecond)
+	return job.Error
+}
+
+func TestPreloadRejectsPrivateAddresses(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "private.mp4", []byte("content"))
+
+	for _, cdnURL := range []string{"http://127.0.0.1:8080", "http://10.0.0.1", "ftp://cdn.example.com"} {
+		body, _ := json.Marshal(map[string]interface{}{"video_ids": []string{video.ID}, "cdn_url": cdnURL})
+		w := postJSON(server, "/api/admin/preload", string(body))
+		assert.Equal(t, http.StatusBadRequest, w.Code, cdnURL)
+	}
+
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the internal service must not be reached")
+	}))
+	defer internal.Close()
+
+	t.Run("Dial", func(t *testing.T) {
+		// A host that passed validation but resolves to a private address
+		pm := NewPreloadManager(1, allowAnyURL)
+		job := pm.Enqueue(internal.URL, video.ID)
+		assert.Contains(t, waitPreloadFailure(t, pm, job.ID), "private address")
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://10.0.0.1/admin", http.StatusFound)
+		}))
+		defer cdn.Close()
+
+		pm := NewPreloadManager(1, server.validateWebhookURL)
+		pm.client.Transport = cdnTransport(cdn)
+		job := pm.Enqueue("http://cdn.example.com", video.ID)
+		assert.Contains(t, waitPreloadFailure(t, pm, job.ID), "private address")
+	})
+}
+
+func TestPreloadJobsExpire(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer cdn.Close()
+
+	pm := NewPreloadManager(1, allowAnyURL)
+	pm.client.Transport = cdnTransport(cdn)
+	finished := pm.Enqueue("http://cdn.example.com", "a")
+	require.Eventually(t, func() bool {
+		job, _ := pm.GetJob(finished.ID)
+		return job.Status == PreloadDone
+	}, 2*time.Second, 10*time.Millisecond)
+
+	pm.mutex.Lock()
+	pm.jobs[finished.ID].UpdatedAt = time.Now().Add(-2 * preloadJobTTL)
+	pm.mutex.Unlock()
+
+	// Finished jobs are forgotten once a new job comes in after the TTL
+	next := pm.Enqueue("http://cdn.example.com", "b")
+	_, exists := pm.GetJob(finished.ID)
+	assert.False(t, exists)
+	_, exists = pm.GetJob(next.ID)
+	assert.True(t, exists)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultPresignTTL applies when a presign request has no ttl_seconds
+	defaultPresignTTL = 15 * time.Minute
+
+	// maxPresignTTL is the longest validity S3 allows for a presigned URL
+	maxPresignTTL = 7 * 24 * time.Hour
+
+	// pendingUploadGrace is how long an unconfirmed upload is remembered
+	// after its URL expires, for uploads that finished just in time
+	pendingUploadGrace = time.Hour
+)
+
+// presignedUploader is implemented by file stores clients can upload to
+// directly, such as S3
+type presignedUploader interface {
+	GeneratePresignedPutURL(key, contentType string, expiry time.Duration) (string, error)
+}
+
+// pendingUpload is a presigned upload waiting for the client to confirm it
+type pendingUpload struct {
+	Filename    string
+	ContentType string
+	ExpiresAt   time.Time
+}
+
+// presignUploadHandler returns a URL the client can upload a video file to
+// directly, bypassing the server. The video is added once the client
+// confirms the upload.
+func (s *Server) presignUploadHandler(c *gin.Context) {
+	var req struct {
+		Filename    string `json:"filename" binding:"required"`
+		ContentType string `json:"content_type"`
+		TTLSeconds  int64  `json:"ttl_seconds" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presigner, ok := s.files.(presignedUploader)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "storage backend does not support direct uploads"})
+		return
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = defaultPresignTTL
+	}
+	if ttl > maxPresignTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ttl_seconds must be at most %d", int64(maxPresignTTL/time.Second))})
+		return
+	}
+
+	filename := sanitizeFilename(req.Filename)
+	if ext := normalizeExtension(filepath.Ext(filename)); !s.isExtensionAllowed(ext) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":     "file extension not allowed",
+			"extension": ext,
+			"allowed":   s.config.AllowedExtensions,
+		})
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	videoID := newVideoID()
+	uploadURL, err := presigner.GeneratePresignedPutURL(fileKey(videoID, filename), contentType, ttl)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filename", filename).Msg("failed to presign upload")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate upload URL"})
+		return
+	}
+
+	s.forgetExpiredUploads(time.Now())
+	expiresAt := time.Now().Add(ttl)
+	s.pendingUploads.Store(videoID, &pendingUpload{
+		Filename:    filename,
+		ContentType: contentType,
+		ExpiresAt:   expiresAt,
+	})
+
+	s.respondSuccess(c, http.StatusCreated, gin.H{
+		"upload_url": uploadURL,
+		"video_id":   videoID,
+		"expires_at": expiresAt,
+	})
+}
+
+// forgetExpiredUploads drops presigned uploads that were never confirmed
+func (s *Server) forgetExpiredUploads(now time.Time) {
+	s.pendingUploads.Range(func(key, value interface{}) bool {
+		if now.After(value.(*pendingUpload).ExpiresAt.Add(pendingUploadGrace)) {
+			s.pendingUploads.Delete(key)
+		}
+		return true
+	})
+}
+
+// confirmPresignedUploadHandler adds the video uploaded to a presigned URL.
+// The file is read back from storage to size and hash it.
+func (s *Server) confirmPresignedUploadHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	value, exists := s.pendingUploads.Load(videoID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "presigned upload not found"})
+		return
+	}
+	pending := value.(*pendingUpload)
+	key := fileKey(videoID, pending.Filename)
+
+	if uploaded, err := s.files.Exists(key); err != nil || !uploaded {
+		if err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to check presigned upload")
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "file has not been uploaded"})
+		return
+	}
+
+	// Confirming twice must not add the video twice
+	if _, loaded := s.pendingUploads.LoadAndDelete(videoID); !loaded {
+		c.JSON(http.StatusNotFound, gin.H{"error": "presigned upload not found"})
+		return
+	}
+
+	size, hash, err := s.measureStoredFile(key)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to read presigned upload")
+		s.pendingUploads.Store(videoID, pending)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read uploaded file"})
+		return
+	}
+
+	if size > s.config.MaxFileSize {
+		s.files.Remove(key)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file too large, max size is %d bytes", s.config.MaxFileSize)})
+		return
+	}
+
+	video := &Video{
+		ID:          videoID,
+		Name:        pending.Filename,
+		Size:        size,
+		ContentType: pending.ContentType,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		URL:         fmt.Sprintf("/api/videos/%s", videoID),
+		Hash:        hash,
+	}
+
+	if err := s.db.AddVideo(video); err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to save video record")
+		s.pendingUploads.Store(videoID, pending)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save video"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("video_id", video.ID).
+		Str("filename", video.Name).
+		Int64("size", video.Size).
+		Msg("presigned upload confirmed")
+
+	s.recordVideoEvent(c, video.ID, VideoEventUploaded, nil, video)
+
+	payload, _ := videoWebhookPayload(EventVideoUploaded, video)
+	s.webhookMgr.NotifyWebhooksContext(c.Request.Context(), EventVideoUploaded, payload)
+	s.publishEvent(EventVideoUploaded, payload)
+
+	s.respondSuccess(c, http.StatusCreated, gin.H{
+		"success": true,
+		"video":   video,
+	})
+}
+
+// measureStoredFile returns the size and hash of a file in primary storage
+func (s *Server) measureStoredFile(key string) (int64, string, error) {
+	hasher, err := newHasher(defaultHashAlgorithm)
+	if err != nil {
+		return 0, "", err
+	}
+
+	file, err := s.files.Open(key)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// presigningFileStore stands in for an S3 store, handing out fake presigned
+// URLs for a local directory
+type presigningFileStore struct {
+	*LocalFileStore
+	presigned []string // keys URLs were generated for
+}
+
+func (fs *presigningFileStore) GeneratePresignedPutURL(key, contentType string, expiry time.Duration) (string, error) {
+	fs.presigned = append(fs.presigned, key)
+	return fmt.Sprintf("https://bucket.s3.example.com/%s?X-Amz-Expires=%d", key, int64(expiry/time.Second)), nil
+}
+
+func postJSON(server *Server, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPresignedUpload(t *testing.T) {
+	server := newTestServer(t)
+	store := &presigningFileStore{LocalFileStore: NewLocalFileStore(server.config.StoragePath)}
+	server.files = store
+
+	w := postJSON(server, "/api/videos/presign", `{"filename": "direct.mp4", "content_type": "video/mp4", "ttl_seconds": 900}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var presigned struct {
+		UploadURL string    `json:"upload_url"`
+		VideoID   string    `json:"video_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &presigned))
+	key := fileKey(presigned.VideoID, "direct.mp4")
+	assert.Equal(t, []string{key}, store.presigned)
+	assert.Contains(t, presigned.UploadURL, "X-Amz-Expires=900")
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), presigned.ExpiresAt, time.Minute)
+
+	confirmPath := "/api/videos/presign/" + presigned.VideoID + "/confirm"
+	assert.Equal(t, http.StatusConflict, postJSON(server, confirmPath, "").Code, "nothing uploaded yet")
+
+	// The client uploads straight to storage
+	require.NoError(t, store.Put(key, strings.NewReader("direct upload content")))
+
+	w = postJSON(server, confirmPath, "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	video, exists := server.db.GetVideoByID(presigned.VideoID)
+	require.True(t, exists)
+	assert.Equal(t, "direct.mp4", video.Name)
+	assert.Equal(t, "video/mp4", video.ContentType)
+	assert.Equal(t, int64(len("direct upload content")), video.Size)
+	expectedHash, err := computeFileHash(server.getFilePath(video.ID, video.Name), defaultHashAlgorithm)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash, video.Hash)
+
+	assert.Equal(t, http.StatusNotFound, postJSON(server, confirmPath, "").Code, "already confirmed")
+	assert.Equal(t, http.StatusNotFound, postJSON(server, "/api/videos/presign/unknown/confirm", "").Code)
+}
+
+func TestPresignedUploadValidation(t *testing.T) {
+	server := newTestServer(t)
+
+	// Local storage can't hand out upload URLs
+	assert.Equal(t, http.StatusNotImplemented, postJSON(server, "/api/videos/presign", `{"filename": "a.mp4"}`).Code)
+
+	server.files = &presigningFileStore{LocalFileStore: NewLocalFileStore(server.config.StoragePath)}
+	server.config.AllowedExtensions = []string{".mp4"}
+	server.config.MaxFileSize = 4
+
+	assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/videos/presign", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/videos/presign", `{"filename": "a.mp4", "ttl_seconds": 999999999}`).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, postJSON(server, "/api/videos/presign", `{"filename": "a.exe"}`).Code)
+
+	// Oversized uploads are removed on confirmation
+	w := postJSON(server, "/api/videos/presign", `{"filename": "big.mp4"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var presigned struct {
+		VideoID string `json:"video_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &presigned))
+	key := fileKey(presigned.VideoID, "big.mp4")
+	require.NoError(t, server.files.Put(key, strings.NewReader("too large")))
+
+	assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/videos/presign/"+presigned.VideoID+"/confirm", "").Code)
+	exists, err := server.files.Exists(key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, added := server.db.GetVideoByID(presigned.VideoID)
+	assert.False(t, added)
+}
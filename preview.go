@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewDir is the directory under StoragePath holding cached previews
+const previewDir = "previews"
+
+// previewVideoHandler serves the first N seconds of a video, generating the
+// clip with ffmpeg on first request and serving it from disk afterwards
+func (s *Server) previewVideoHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	duration := s.config.PreviewDuration
+	if durationStr := c.Query("duration"); durationStr != "" {
+		parsed, err := strconv.ParseFloat(durationStr, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive number of seconds"})
+			return
+		}
+		duration = parsed
+	}
+
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+	if !requireVideoAccess(c, video, aclScopeRead) {
+		return
+	}
+
+	sourcePath := s.getFilePath(videoID, video.Name)
+	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
+		getLogger(c).Error().Str("filepath", sourcePath).Msg("video file not found on disk")
+		c.JSON(http.StatusNotFound, gin.H{"error": "video file not found"})
+		return
+	}
+
+	durationStr := strconv.Itoa(s.previewSeconds(video, duration))
+	previewPath := filepath.Join(s.config.StoragePath, previewDir, videoID+"_"+durationStr+".mp4")
+
+	if _, err := os.Stat(previewPath); os.IsNotExist(err) {
+		if err := s.generatePreview(c, sourcePath, previewPath, durationStr); err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to generate preview")
+			if errors.Is(err, ErrFFmpegUnavailable) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "preview generation is not available"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate preview"})
+			return
+		}
+	}
+
+	c.Header("X-Preview-Duration", durationStr)
+
+	if c.GetHeader("Range") != "" {
+		s.serveRangeRequest(c, "", previewPath, "video/mp4")
+		return
+	}
+
+	c.Header("Content-Type", "video/mp4")
+	c.Header("Accept-Ranges", "bytes")
+	http.ServeFile(c.Writer, c.Request, previewPath)
+}
+
+// previewSeconds bounds a requested preview length by the video's duration
+// when known and by PreviewMaxDuration, rounded to whole seconds so that
+// close values share one cached preview
+func (s *Server) previewSeconds(video *Video, duration float64) int {
+	if video.Metadata != nil && video.Metadata.DurationSeconds > 0 {
+		duration = min(duration, math.Ceil(video.Metadata.DurationSeconds))
+	}
+	if s.config.PreviewMaxDuration > 0 {
+		duration = min(duration, s.config.PreviewMaxDuration)
+	}
+	return max(int(math.Round(duration)), 1)
+}
+
+// generatePreview extracts the first duration seconds of sourcePath into
+// previewPath. Streams are copied rather than re-encoded, and a video shorter
+// than the duration simply yields the whole file.
+func (s *Server) generatePreview(c *gin.Context, sourcePath, previewPath, duration string) error {
+	if err := os.MkdirAll(filepath.Dir(previewPath), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so concurrent requests never serve a partial preview
+	tmpFile, err := os.CreateTemp(filepath.Dir(previewPath), ".preview-*.mp4")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	err = runFFmpeg(c.Request.Context(), s.config.FFmpegPath,
+		"-y", "-t", duration, "-i", sourcePath, "-c", "copy", "-f", "mp4", tmpPath)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, previewPath)
+}
+
+// removePreviews deletes all cached previews for a video
+func (s *Server) removePreviews(videoID string) {
+	matches, _ := filepath.Glob(filepath.Join(s.config.StoragePath, previewDir, videoID+"_*.mp4"))
+	for _, path := range matches {
+		if err := os.Remove(path); err != nil {
+			s.logger.Error().Err(err).Str("filepath", path).Msg("failed to delete cached preview")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFakeFFmpeg installs a shell script standing in for ffmpeg. It copies
+// the first 10 bytes of the -i input to the output (the last argument) and
+// appends a line to the returned counter file on every run.
+func writeFakeFFmpeg(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "ffmpeg")
+
+	content := `#!/bin/sh
+echo "$@" >> "` + counter + `"
+in=""
+out=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-i" ]; then in="$2"; fi
+	out="$1"
+	shift
+done
+head -c 10 "$in" > "$out"
+`
+	require.NoError(t, os.WriteFile(script, []byte(content), 0755))
+	return script, counter
+}
+
+func countRuns(t *testing.T, counter string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(counter)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return strings.Count(string(data), "\n")
+}
+
+func TestVideoPreview(t *testing.T) {
+	ffmpeg, counter := writeFakeFFmpeg(t)
+
+	server := newTestServer(t)
+	server.config.FFmpegPath = ffmpeg
+	server.config.PreviewDuration = 30
+	server.config.PreviewMaxDuration = 60
+
+	video := uploadTestVideo(t, server, "preview.mp4", []byte("0123456789abcdefghij"))
+
+	get := func(path, rangeHeader string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Generates preview with default duration", func(t *testing.T) {
+		w := get("/api/videos/"+video.ID+"/preview", "")
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "0123456789", w.Body.String())
+		assert.Equal(t, "30", w.Header().Get("X-Preview-Duration"))
+		assert.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+		assert.Equal(t, 1, countRuns(t, counter))
+		assert.FileExists(t, filepath.Join(server.config.StoragePath, previewDir, video.ID+"_30.mp4"))
+	})
+
+	t.Run("Serves cached preview", func(t *testing.T) {
+		w := get("/api/videos/"+video.ID+"/preview?duration=30", "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0123456789", w.Body.String())
+		assert.Equal(t, 1, countRuns(t, counter), "ffmpeg must not run again for a cached preview")
+	})
+
+	t.Run("Range request", func(t *testing.T) {
+		w := get("/api/videos/"+video.ID+"/preview?duration=12.4", "bytes=2-4")
+
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "234", w.Body.String())
+		assert.Equal(t, "12", w.Header().Get("X-Preview-Duration"))
+		assert.Equal(t, 2, countRuns(t, counter))
+	})
+
+	t.Run("Duration is bounded", func(t *testing.T) {
+		// Rounds to the preview cached above
+		w := get("/api/videos/"+video.ID+"/preview?duration=11.6", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "12", w.Header().Get("X-Preview-Duration"))
+		assert.Equal(t, 2, countRuns(t, counter))
+
+		w = get("/api/videos/"+video.ID+"/preview?duration=1e308", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "60", w.Header().Get("X-Preview-Duration"))
+
+		w = get("/api/videos/"+video.ID+"/preview?duration=0.1", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-Preview-Duration"))
+
+		assert.Equal(t, http.StatusBadRequest, get("/api/videos/"+video.ID+"/preview?duration=Inf", "").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/videos/"+video.ID+"/preview?duration=NaN", "").Code)
+	})
+
+	t.Run("Invalid duration", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/videos/"+video.ID+"/preview?duration=-1", "").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/videos/"+video.ID+"/preview?duration=abc", "").Code)
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/videos/missing/preview", "").Code)
+	})
+
+	t.Run("Missing ffmpeg", func(t *testing.T) {
+		server.config.FFmpegPath = filepath.Join(t.TempDir(), "no-such-ffmpeg")
+		assert.Equal(t, http.StatusServiceUnavailable, get("/api/videos/"+video.ID+"/preview?duration=5", "").Code)
+	})
+
+	t.Run("Previews are removed with the video", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/api/videos/"+video.ID, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		matches, _ := filepath.Glob(filepath.Join(server.config.StoragePath, previewDir, video.ID+"_*"))
+		assert.Empty(t, matches)
+	})
+}
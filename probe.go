@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProbeResult describes the format detected from the start of a file
+type ProbeResult struct {
+	DetectedType string `json:"detected_type"`
+	Container    string `json:"container,omitempty"`
+	IsVideo      bool   `json:"is_video"`
+	Supported    bool   `json:"supported"`
+}
+
+// defaultProbeMaxBytes is used when ProbeMaxBytes is not set
+const defaultProbeMaxBytes = 4096
+
+// ebmlHeaderID starts WebM and Matroska files, ebmlDocTypeID holds the
+// header's document type
+const (
+	ebmlHeaderID  = 0x1A45DFA3
+	ebmlDocTypeID = 0x4282
+)
+
+// asfHeaderGUID starts WMV and other ASF files
+var asfHeaderGUID = []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9, 0x00, 0xAA, 0x00, 0x62, 0xCE, 0x6C}
+
+// probeFormat detects a file's format from its first bytes, parsing the
+// container header where the format has one. Formats without a known
+// signature fall back to http.DetectContentType.
+func probeFormat(data []byte) ProbeResult {
+	switch {
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		brand := strings.TrimSpace(string(data[8:12]))
+		contentType := "video/mp4"
+		switch {
+		case brand == "qt":
+			contentType = "video/quicktime"
+		case strings.HasPrefix(brand, "3gp"):
+			contentType = "video/3gpp"
+		}
+		return ProbeResult{DetectedType: contentType, Container: brand, IsVideo: true}
+
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == ebmlHeaderID:
+		docType, err := ebmlDocType(data)
+		if err != nil {
+			return ProbeResult{DetectedType: "application/octet-stream", Container: "ebml"}
+		}
+		switch docType {
+		case "webm":
+			return ProbeResult{DetectedType: "video/webm", Container: docType, IsVideo: true}
+		case "matroska":
+			return ProbeResult{DetectedType: "video/x-matroska", Container: docType, IsVideo: true}
+		}
+		return ProbeResult{DetectedType: "application/octet-stream", Container: docType}
+
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "AVI ":
+		return ProbeResult{DetectedType: "video/x-msvideo", Container: "avi", IsVideo: true}
+
+	case bytes.HasPrefix(data, []byte("FLV\x01")):
+		return ProbeResult{DetectedType: "video/x-flv", Container: "flv", IsVideo: true}
+
+	case bytes.HasPrefix(data, []byte("OggS")):
+		// Ogg also carries audio only streams, Theora marks video
+		if bytes.Contains(data, []byte("\x80theora")) {
+			return ProbeResult{DetectedType: "video/ogg", Container: "ogg", IsVideo: true}
+		}
+		return ProbeResult{DetectedType: "audio/ogg", Container: "ogg"}
+
+	case bytes.HasPrefix(data, asfHeaderGUID):
+		return ProbeResult{DetectedType: "video/x-ms-wmv", Container: "asf", IsVideo: true}
+
+	case bytes.HasPrefix(data, []byte{0x00, 0x00, 0x01, 0xBA}):
+		return ProbeResult{DetectedType: "video/mpeg", Container: "mpeg-ps", IsVideo: true}
+
+	case len(data) > 188 && data[0] == 0x47 && data[188] == 0x47:
+		// Transport stream packets are 188 bytes, each starting with a sync byte
+		return ProbeResult{DetectedType: "video/mp2t", Container: "mpeg-ts", IsVideo: true}
+	}
+
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return ProbeResult{DetectedType: contentType, IsVideo: strings.HasPrefix(contentType, "video/")}
+}
+
+// ebmlDocType returns the document type from an EBML header, which must be
+// complete in data
+func ebmlDocType(data []byte) (string, error) {
+	id, n, err := readEBMLVint(data, false)
+	if err != nil || id != ebmlHeaderID {
+		return "", fmt.Errorf("missing EBML header")
+	}
+	data = data[n:]
+	size, n, err := readEBMLVint(data, true)
+	if err != nil {
+		return "", err
+	}
+	data = data[n:]
+	if size > uint64(len(data)) {
+		return "", fmt.Errorf("truncated EBML header")
+	}
+
+	header := data[:size]
+	for len(header) > 0 {
+		id, n, err := readEBMLVint(header, false)
+		if err != nil {
+			return "", err
+		}
+		header = header[n:]
+		size, n, err := readEBMLVint(header, true)
+		if err != nil {
+			return "", err
+		}
+		header = header[n:]
+		if size > uint64(len(header)) {
+			return "", fmt.Errorf("truncated EBML element")
+		}
+		if id == ebmlDocTypeID {
+			return strings.TrimRight(string(header[:size]), "\x00"), nil
+		}
+		header = header[size:]
+	}
+	return "", fmt.Errorf("EBML header has no document type")
+}
+
+// readEBMLVint reads a variable length integer, returning it and its length.
+// Element IDs keep their length marker bit, sizes drop it.
+func readEBMLVint(data []byte, isSize bool) (uint64, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, fmt.Errorf("invalid EBML integer")
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > len(data) {
+		return 0, 0, fmt.Errorf("truncated EBML integer")
+	}
+
+	value := uint64(data[0])
+	if isSize {
+		value &= uint64(0xFF >> length)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+// probeVideoHandler detects the format of a file from its first bytes, sent
+// as the raw request body or base64 encoded in {"data": "..."}, so clients
+// can check a file is accepted before uploading it. Nothing is stored.
+func (s *Server) probeVideoHandler(c *gin.Context) {
+	maxBytes := s.config.ProbeMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultProbeMaxBytes
+	}
+
+	var data []byte
+	if c.ContentType() == "application/json" {
+		// Room for the base64 encoded bytes, the rest is rejected unread
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(base64.StdEncoding.EncodedLen(maxBytes))+1024)
+
+		var req struct {
+			Data string `json:"data" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("send at most the first %d bytes of the file", maxBytes)})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		decoded, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "data must be base64 encoded"})
+			return
+		}
+		data = decoded
+	} else {
+		read, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(maxBytes)+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		data = read
+	}
+
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no data to probe"})
+		return
+	}
+	if len(data) > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("send at most the first %d bytes of the file", maxBytes)})
+		return
+	}
+
+	result := probeFormat(data)
+	if ext, known := contentTypeExtensions[result.DetectedType]; known {
+		result.Supported = s.isExtensionAllowed(ext)
+	}
+
+	getLogger(c).Debug().
+		Str("detected_type", result.DetectedType).
+		Bool("supported", result.Supported).
+		Msg("video probed")
+
+	s.respondSuccess(c, http.StatusOK, result)
+}
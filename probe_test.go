@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ebmlHeader builds an EBML header with the given document type
+func ebmlHeader(docType string) []byte {
+	body := append([]byte{0x42, 0x86, 0x81, 0x01}, 0x42, 0x82, 0x80|byte(len(docType)))
+	body = append(body, docType...)
+	header := append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x80 | byte(len(body))}, body...)
+	// Followed by the start of a Segment
+	return append(header, 0x18, 0x53, 0x80, 0x67)
+}
+
+// ftypBox builds an MP4 ftyp box with the given major brand
+func ftypBox(brand string) []byte {
+	return append([]byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p'}, []byte(brand+"\x00\x00\x02\x00isomiso2")...)
+}
+
+func TestProbeFormat(t *testing.T) {
+	transportStream := make([]byte, 376)
+	transportStream[0], transportStream[188] = 0x47, 0x47
+
+	tests := []struct {
+		name      string
+		data      []byte
+		want      string
+		container string
+		isVideo   bool
+	}{
+		{"MP4", ftypBox("isom"), "video/mp4", "isom", true},
+		{"MP4 version 2", ftypBox("mp42"), "video/mp4", "mp42", true},
+		{"QuickTime", ftypBox("qt  "), "video/quicktime", "qt", true},
+		{"3GP", ftypBox("3gp5"), "video/3gpp", "3gp5", true},
+		{"WebM", ebmlHeader("webm"), "video/webm", "webm", true},
+		{"Matroska", ebmlHeader("matroska"), "video/x-matroska", "matroska", true},
+		{"Other EBML document", ebmlHeader("mka"), "application/octet-stream", "mka", false},
+		{"AVI", []byte("RIFF\x00\x10\x00\x00AVI LIST"), "video/x-msvideo", "avi", true},
+		{"WAV", []byte("RIFF\x00\x10\x00\x00WAVEfmt "), "audio/wave", "", false},
+		{"FLV", []byte("FLV\x01\x05\x00\x00\x00\x09"), "video/x-flv", "flv", true},
+		{"Ogg Theora", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x80theora"), "video/ogg", "ogg", true},
+		{"Ogg Vorbis", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01vorbis"), "audio/ogg", "ogg", false},
+		{"WMV", append(append([]byte{}, asfHeaderGUID...), 0x00, 0x10), "video/x-ms-wmv", "asf", true},
+		{"MPEG program stream", []byte{0x00, 0x00, 0x01, 0xBA, 0x44, 0x00}, "video/mpeg", "mpeg-ps", true},
+		{"MPEG transport stream", transportStream, "video/mp2t", "mpeg-ts", true},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png", "", false},
+		{"Text", []byte("just some text"), "text/plain", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := probeFormat(tt.data)
+			assert.Equal(t, tt.want, result.DetectedType)
+			assert.Equal(t, tt.container, result.Container)
+			assert.Equal(t, tt.isVideo, result.IsVideo)
+		})
+	}
+
+	t.Run("Truncated EBML header", func(t *testing.T) {
+		result := probeFormat(ebmlHeader("webm")[:8])
+		assert.Equal(t, "application/octet-stream", result.DetectedType)
+		assert.False(t, result.IsVideo)
+	})
+}
+
+func TestProbeVideoHandler(t *testing.T) {
+	server := newTestServer(t)
+	server.config.AllowedExtensions = []string{".mp4", ".webm"}
+
+	probe := func(contentType string, body []byte) (*httptest.ResponseRecorder, ProbeResult) {
+		req := httptest.NewRequest(http.MethodPost, "/api/videos/probe", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		var result ProbeResult
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w, result
+	}
+
+	t.Run("Raw body", func(t *testing.T) {
+		w, result := probe("application/octet-stream", ftypBox("isom"))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, ProbeResult{DetectedType: "video/mp4", Container: "isom", IsVideo: true, Supported: true}, result)
+	})
+
+	t.Run("Base64 in JSON", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"data": base64.StdEncoding.EncodeToString(ebmlHeader("webm"))})
+		w, result := probe("application/json", body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "video/webm", result.DetectedType)
+		assert.True(t, result.Supported)
+	})
+
+	t.Run("Video outside the allowlist", func(t *testing.T) {
+		_, result := probe("application/octet-stream", ebmlHeader("matroska"))
+		assert.True(t, result.IsVideo)
+		assert.False(t, result.Supported)
+	})
+
+	t.Run("Not a video", func(t *testing.T) {
+		_, result := probe("application/octet-stream", []byte("just some text"))
+		assert.False(t, result.IsVideo)
+		assert.False(t, result.Supported)
+	})
+
+	t.Run("Nothing is stored", func(t *testing.T) {
+		assert.Empty(t, server.db.GetAllVideos())
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		w, _ := probe("application/octet-stream", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, _ = probe("application/json", []byte(`{"data": "not base64!"}`))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, _ = probe("application/octet-stream", make([]byte, defaultProbeMaxBytes+1))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		body, _ := json.Marshal(map[string]string{"data": base64.StdEncoding.EncodeToString(make([]byte, 2*defaultProbeMaxBytes))})
+		w, _ = probe("application/json", body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
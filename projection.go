@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// videoFieldIndex maps each Video JSON field name to its struct field index
+var videoFieldIndex = jsonFieldIndex(reflect.TypeOf(Video{}))
+
+// jsonFieldIndex maps the JSON names of t's exported fields to their index
+func jsonFieldIndex(t reflect.Type) map[string]int {
+	index := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		index[name] = i
+	}
+	return index
+}
+
+// parseVideoFields parses a comma-separated ?fields list. An empty list
+// returns nil, meaning every field.
+func parseVideoFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := videoFieldIndex[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// projectVideos returns videos reduced to fields. Requested fields are
+// included even when empty. With no fields the videos are returned as is.
+func projectVideos(videos []*Video, fields []string) interface{} {
+	if fields == nil {
+		return videos
+	}
+
+	projected := make([]map[string]interface{}, len(videos))
+	for i, video := range videos {
+		value := reflect.ValueOf(video).Elem()
+		projected[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			projected[i][field] = value.Field(videoFieldIndex[field]).Interface()
+		}
+	}
+	return projected
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoListFields(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "projected.mp4", []byte("projected video"))
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos"+query, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Selected fields only", func(t *testing.T) {
+		w := list("?fields=id,name")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Videos []map[string]interface{} `json:"videos"`
+			Total  int                      `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Videos, 1)
+		assert.Equal(t, map[string]interface{}{"id": video.ID, "name": "projected.mp4"}, resp.Videos[0])
+		assert.Equal(t, 1, resp.Total)
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		w := list("?fields=id,codec")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "codec")
+	})
+
+	t.Run("All fields by default", func(t *testing.T) {
+		w := list("")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Videos []*Video `json:"videos"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Videos, 1)
+		assert.Equal(t, video.ID, resp.Videos[0].ID)
+		assert.Equal(t, video.Hash, resp.Videos[0].Hash)
+		assert.Equal(t, video.ContentType, resp.Videos[0].ContentType)
+		assert.Equal(t, video.URL, resp.Videos[0].URL)
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	messageQueueNone  = "none"
+	messageQueueNATS  = "nats"
+	messageQueueKafka = "kafka"
+
+	// eventsTopic is the topic (NATS subject) video events are published to
+	eventsTopic = "vidserver.events"
+
+	// eventHeader carries the event name of a published message
+	eventHeader = "event"
+
+	// kafkaPublishTimeout bounds how long publishing an event waits for
+	// Kafka to acknowledge it
+	kafkaPublishTimeout = 5 * time.Second
+
+	// publishQueueSize bounds the events waiting to be published
+	publishQueueSize = 1024
+)
+
+var (
+	errPublishQueueFull = errors.New("publish queue is full")
+	errPublisherClosed  = errors.New("publisher is closed")
+)
+
+// MessagePublisher publishes video events to a message queue, for
+// consumers that need persistence or consumer groups webhooks can't offer
+type MessagePublisher interface {
+	Publish(topic, event string, payload []byte) error
+}
+
+// newMessagePublisher connects to the message queue selected by
+// Config.MessageQueueDriver. It returns nil when no queue is configured.
+func newMessagePublisher(config *Config) (MessagePublisher, error) {
+	switch config.MessageQueueDriver {
+	case "", messageQueueNone:
+		return nil, nil
+	case messageQueueNATS:
+		return NewNATSPublisher(config.MessageQueueURLs)
+	case messageQueueKafka:
+		return NewKafkaPublisher(config.MessageQueueURLs)
+	}
+	return nil, fmt.Errorf("unknown message queue driver %q", config.MessageQueueDriver)
+}
+
+// NATSPublisher publishes events as NATS messages, with the event name in
+// a message header
+type NATSPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATSPublisher connects to the NATS servers at urls. An unreachable
+// server doesn't fail startup, the connection keeps retrying in the
+// background.
+func NewNATSPublisher(urls []string) (*NATSPublisher, error) {
+	if len(urls) == 0 {
+		urls = []string{nats.DefaultURL}
+	}
+
+	conn, err := nats.Connect(strings.Join(urls, ","),
+		nats.Name("video-server"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{conn: conn}, nil
+}
+
+func (p *NATSPublisher) Publish(topic, event string, payload []byte) error {
+	msg := nats.NewMsg(topic)
+	msg.Header.Set(eventHeader, event)
+	msg.Data = payload
+	return p.conn.PublishMsg(msg)
+}
+
+// Close sends buffered messages and closes the connection
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// kafkaWriter is the part of *kafka.Writer KafkaPublisher uses
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes events as Kafka messages keyed by event name, so
+// each event type stays ordered within its partition
+type KafkaPublisher struct {
+	writer kafkaWriter
+}
+
+// NewKafkaPublisher creates a publisher writing to the given brokers
+func NewKafkaPublisher(brokers []string) (*KafkaPublisher, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("kafka message queue requires MESSAGE_QUEUE_URLS")
+	}
+
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			BatchTimeout:           10 * time.Millisecond,
+			RequiredAcks:           kafka.RequireOne,
+			AllowAutoTopicCreation: true,
+		},
+	}, nil
+}
+
+func (p *KafkaPublisher) Publish(topic, event string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaPublishTimeout)
+	defer cancel()
+
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     []byte(event),
+		Value:   payload,
+		Headers: []kafka.Header{{Key: eventHeader, Value: []byte(event)}},
+	})
+}
+
+// Close flushes pending messages and closes the writer
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// queuedEvent is an event waiting in an AsyncPublisher's queue
+type queuedEvent struct {
+	topic, event string
+	payload      []byte
+}
+
+// AsyncPublisher publishes events in the background through a bounded
+// queue, so handlers never wait on the message queue. Events arriving while
+// the queue is full are dropped and counted.
+type AsyncPublisher struct {
+	next   MessagePublisher
+	logger zerolog.Logger
+
+	mutex  sync.RWMutex // guards closed and sends on queue
+	closed bool
+	queue  chan queuedEvent
+	done   chan struct{} // closed once the queue has been worked off
+
+	dropped atomic.Int64
+}
+
+// NewAsyncPublisher starts publishing to next through a queue of size events
+func NewAsyncPublisher(next MessagePublisher, size int, logger zerolog.Logger) *AsyncPublisher {
+	p := &AsyncPublisher{
+		next:   next,
+		logger: logger,
+		queue:  make(chan queuedEvent, size),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues an event, failing when the queue is full or closed
+func (p *AsyncPublisher) Publish(topic, event string, payload []byte) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return errPublisherClosed
+	}
+	select {
+	case p.queue <- queuedEvent{topic: topic, event: event, payload: payload}:
+		return nil
+	default:
+		p.dropped.Add(1)
+		return errPublishQueueFull
+	}
+}
+
+// run publishes queued events until the queue is closed and empty
+func (p *AsyncPublisher) run() {
+	defer close(p.done)
+
+	for msg := range p.queue {
+		if err := p.next.Publish(msg.topic, msg.event, msg.payload); err != nil {
+			p.logger.Error().Err(err).Str("event", msg.event).Msg("failed to publish event")
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full
+func (p *AsyncPublisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Depth returns the number of events waiting to be published
+func (p *AsyncPublisher) Depth() int {
+	return len(p.queue)
+}
+
+// stop closes the queue to new events
+func (p *AsyncPublisher) stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+}
+
+// Drain stops accepting events and waits until the queued ones have been
+// published or ctx is done
+func (p *AsyncPublisher) Drain(ctx context.Context) error {
+	p.stop()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events and closes the underlying publisher. Events
+// still queued are lost, Drain publishes them first.
+func (p *AsyncPublisher) Close() error {
+	p.stop()
+	if closer, ok := p.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// publishEvent sends an event to the message queue, when one is configured.
+// The payload is the same JSON webhook subscribers receive.
+func (s *Server) publishEvent(event EventType, payload interface{}) {
+	if s.publisher == nil {
+		return
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Error().Err(err).Str("event", string(event)).Msg("failed to encode event")
+		return
+	}
+
+	if err := s.publisher.Publish(eventsTopic, string(event), data); err != nil {
+		s.logger.Error().Err(err).Str("event", string(event)).Msg("failed to publish event")
+	}
+}
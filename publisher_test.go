@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNATSServer runs an embedded NATS server on a random port
+func startNATSServer(t *testing.T) string {
+	t.Helper()
+
+	ns, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	require.True(t, ns.ReadyForConnections(5*time.Second), "NATS server did not start")
+	return ns.ClientURL()
+}
+
+func TestNATSPublisher(t *testing.T) {
+	url := startNATSServer(t)
+
+	subscriber, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer subscriber.Close()
+	sub, err := subscriber.SubscribeSync(eventsTopic)
+	require.NoError(t, err)
+	require.NoError(t, subscriber.Flush())
+
+	publisher, err := NewNATSPublisher([]string{url})
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	require.NoError(t, publisher.Publish(eventsTopic, "video.uploaded", []byte(`{"video_id":"abc"}`)))
+
+	msg, err := sub.NextMsg(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "video.uploaded", msg.Header.Get(eventHeader))
+	assert.JSONEq(t, `{"video_id":"abc"}`, string(msg.Data))
+}
+
+func TestUploadAndDeletePublishToNATS(t *testing.T) {
+	url := startNATSServer(t)
+
+	subscriber, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer subscriber.Close()
+	sub, err := subscriber.SubscribeSync(eventsTopic)
+	require.NoError(t, err)
+	require.NoError(t, subscriber.Flush())
+
+	server := newTestServer(t)
+	publisher, err := newMessagePublisher(&Config{MessageQueueDriver: messageQueueNATS, MessageQueueURLs: []string{url}})
+	require.NoError(t, err)
+	defer publisher.(*NATSPublisher).Close()
+	server.publisher = publisher
+
+	video := uploadTestVideo(t, server, "queued.mp4", []byte("content"))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, event := range []string{"video.uploaded", "video.deleted"} {
+		msg, err := sub.NextMsg(2 * time.Second)
+		require.NoError(t, err, event)
+		assert.Equal(t, event, msg.Header.Get(eventHeader))
+
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(msg.Data, &payload))
+		assert.Equal(t, event, payload["event"])
+	}
+}
+
+// fakeKafkaWriter records messages instead of sending them to a broker
+type fakeKafkaWriter struct {
+	mutex    sync.Mutex
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	return nil
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	publisher := &KafkaPublisher{writer: writer}
+
+	require.NoError(t, publisher.Publish(eventsTopic, "video.deleted", []byte(`{"video_id":"abc"}`)))
+	require.Len(t, writer.messages, 1)
+
+	msg := writer.messages[0]
+	assert.Equal(t, eventsTopic, msg.Topic)
+	assert.Equal(t, "video.deleted", string(msg.Key))
+	assert.Equal(t, []kafka.Header{{Key: eventHeader, Value: []byte("video.deleted")}}, msg.Headers)
+	assert.JSONEq(t, `{"video_id":"abc"}`, string(msg.Value))
+
+	_, err := NewKafkaPublisher(nil)
+	assert.Error(t, err, "brokers are required")
+}
+
+func TestRetentionPurgePublishesEvent(t *testing.T) {
+	server := newTestServer(t)
+	writer := &fakeKafkaWriter{}
+	server.publisher = &KafkaPublisher{writer: writer}
+
+	video := newTestVideo("expired", 10)
+	require.NoError(t, server.db.AddVideo(video))
+	require.True(t, server.purgeVideo(video, RetentionPolicy{ContentType: "video/*"}, "max_age"))
+
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, "video.purged", string(writer.messages[0].Key))
+
+	// Publishing failures don't affect the purge
+	writer.err = kafka.LeaderNotAvailable
+	other := newTestVideo("also-expired", 10)
+	require.NoError(t, server.db.AddVideo(other))
+	assert.True(t, server.purgeVideo(other, RetentionPolicy{ContentType: "video/*"}, "max_age"))
+}
+
+// blockingPublisher records events once release is closed
+type blockingPublisher struct {
+	release chan struct{}
+	mutex   sync.Mutex
+	events  []string
+}
+
+func (p *blockingPublisher) Publish(topic, event string, payload []byte) error {
+	<-p.release
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestAsyncPublisher(t *testing.T) {
+	next := &blockingPublisher{release: make(chan struct{})}
+	publisher := NewAsyncPublisher(next, 1, zerolog.Nop())
+
+	// One event is being published and one waits, further ones are dropped
+	require.NoError(t, publisher.Publish(eventsTopic, "first", nil))
+	require.Eventually(t, func() bool { return publisher.Depth() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, publisher.Publish(eventsTopic, "second", nil))
+	assert.ErrorIs(t, publisher.Publish(eventsTopic, "third", nil), errPublishQueueFull)
+	assert.Equal(t, int64(1), publisher.Dropped())
+
+	server := newTestServer(t)
+	server.publisher = publisher
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "message_queue_publish_queue_depth 1\n")
+	assert.Contains(t, w.Body.String(), "message_queue_events_dropped_total 1\n")
+
+	// Draining gives up at the deadline, then waits for the queued events
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, publisher.Drain(ctx), context.DeadlineExceeded)
+	close(next.release)
+	require.NoError(t, publisher.Drain(context.Background()))
+	assert.Equal(t, []string{"first", "second"}, next.events)
+
+	assert.ErrorIs(t, publisher.Publish(eventsTopic, "late", nil), errPublisherClosed)
+	assert.NoError(t, publisher.Close())
+}
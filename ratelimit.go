@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rateLimitBypassHeader carries a token letting internal services skip
+	// the rate limit
+	rateLimitBypassHeader = "X-Rate-Limit-Bypass"
+
+	// bypassedRateLimitKey is set in the gin context for requests that
+	// skipped the rate limit
+	bypassedRateLimitKey = "bypassed_rate_limit"
+)
+
+// rateWindow counts a client's requests in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimiter allows each client a fixed number of requests per window
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	clients   map[string]*rateWindow
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// NewRateLimiter creates a limiter allowing limit requests per window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*rateWindow),
+	}
+}
+
+// RateLimitStatus is a client's standing after a request was counted
+type RateLimitStatus struct {
+	Allowed   bool
+	Limit     int
+	Remaining int       // requests left in the window
+	Reset     time.Time // when the window ends
+}
+
+// Allow counts a request from client and reports whether it is within the
+// limit
+func (rl *RateLimiter) Allow(client string, now time.Time) bool {
+	return rl.Take(client, now).Allowed
+}
+
+// Take counts a request from client and returns whether it is within the
+// limit along with what is left of the client's window
+func (rl *RateLimiter) Take(client string, now time.Time) RateLimitStatus {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	// Forget clients whose window has ended, at most once per window
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for key, w := range rl.clients {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.clients, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	w, exists := rl.clients[client]
+	if !exists || now.Sub(w.start) >= rl.window {
+		w = &rateWindow{start: now}
+		rl.clients[client] = w
+	}
+	status := RateLimitStatus{Limit: rl.limit, Reset: w.start.Add(rl.window)}
+	if w.count < rl.limit {
+		w.count++
+		status.Allowed = true
+	}
+	status.Remaining = rl.limit - w.count
+	return status
+}
+
+// hashBypassToken returns the hex SHA-256 of a bypass token, the form
+// tokens are configured in
+func hashBypassToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// rateLimitBypassTokens holds the SHA-256 hashes of the accepted bypass
+// tokens. Hashes in the file are re-read whenever it changes, so tokens can
+// be rotated without a restart.
+type rateLimitBypassTokens struct {
+	static []string // from RateLimitBypassTokens
+	path   string   // RateLimitBypassTokensFile, one hash per line
+
+	fileHashes  []string
+	fileModTime time.Time
+	mutex       sync.Mutex
+}
+
+// hashes returns the accepted hashes, reloading the file if it changed.
+// A file that can't be read keeps the hashes last read from it.
+func (t *rateLimitBypassTokens) hashes() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.path != "" {
+		info, err := os.Stat(t.path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			t.fileHashes, t.fileModTime = nil, time.Time{}
+		case err == nil && !info.ModTime().Equal(t.fileModTime):
+			if data, err := os.ReadFile(t.path); err == nil {
+				t.fileHashes = parseBypassTokenHashes(data)
+				t.fileModTime = info.ModTime()
+			}
+		}
+	}
+
+	// Configured hashes may be upper case, hashBypassToken's are not
+	hashes := make([]string, 0, len(t.static)+len(t.fileHashes))
+	for _, hash := range t.static {
+		hashes = append(hashes, strings.ToLower(strings.TrimSpace(hash)))
+	}
+	return append(hashes, t.fileHashes...)
+}
+
+// parseBypassTokenHashes reads one hash per line, skipping blank lines and
+// # comments
+func parseBypassTokenHashes(data []byte) []string {
+	var hashes []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			hashes = append(hashes, strings.ToLower(line))
+		}
+	}
+	return hashes
+}
+
+// Valid reports whether token hashes to one of the accepted hashes
+func (t *rateLimitBypassTokens) Valid(token string) bool {
+	hash := []byte(hashBypassToken(token))
+	valid := false
+	for _, accepted := range t.hashes() {
+		if subtle.ConstantTimeCompare(hash, []byte(accepted)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// rateLimitMiddleware rejects clients that exceed RateLimitRequests per
+// RateLimitWindow with 429. Every limited response carries the client's
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (unix
+// time the window ends), and 429s a Retry-After. Requests with a valid
+// X-Rate-Limit-Bypass token are never limited. Clients are told apart by
+// ClientIP, which only believes X-Forwarded-For from TrustedProxies, so a
+// client can't get a fresh window by sending a new one.
+func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.rateLimiter == nil {
+			c.Next()
+			return
+		}
+
+		if token := c.GetHeader(rateLimitBypassHeader); token != "" && s.rateLimitBypass.Valid(token) {
+			c.Set(bypassedRateLimitKey, true)
+			getLogger(c).Debug().Str("path", c.Request.URL.Path).Msg("rate limit bypassed")
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		status := s.rateLimiter.Take(c.ClientIP(), now)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(status.Reset.Unix(), 10))
+		if !status.Allowed {
+			retryAfter := int(math.Ceil(status.Reset.Sub(now).Seconds()))
+			c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	assert.True(t, limiter.Allow("a", now))
+	assert.True(t, limiter.Allow("a", now))
+	assert.False(t, limiter.Allow("a", now))
+	assert.True(t, limiter.Allow("b", now), "clients are limited separately")
+	assert.True(t, limiter.Allow("a", now.Add(time.Minute)), "a new window starts afresh")
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	server := newTestServer(t)
+	server.rateLimiter = NewRateLimiter(5, time.Minute)
+	server.router.GET("/limited", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	start := time.Now()
+	var reset string
+	for i := 1; i <= 5; i++ {
+		w := get()
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "5", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(5-i), w.Header().Get("X-RateLimit-Remaining"), "request %d", i)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+		if i > 1 {
+			assert.Equal(t, reset, w.Header().Get("X-RateLimit-Reset"), "the window doesn't move")
+		}
+		reset = w.Header().Get("X-RateLimit-Reset")
+	}
+
+	resetAt, err := strconv.ParseInt(reset, 10, 64)
+	require.NoError(t, err)
+	assert.Greater(t, resetAt, start.Unix())
+	assert.LessOrEqual(t, resetAt, start.Add(time.Minute).Unix()+1)
+
+	w := get()
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, reset, w.Header().Get("X-RateLimit-Reset"))
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.True(t, retryAfter >= 59 && retryAfter <= 60, "got %d", retryAfter)
+}
+
+func TestRateLimitBypass(t *testing.T) {
+	server := newTestServer(t)
+	server.rateLimiter = NewRateLimiter(5, time.Minute)
+	server.rateLimitBypass = &rateLimitBypassTokens{static: []string{hashBypassToken("internal-token")}}
+
+	var bypassed bool
+	server.router.GET("/limited", func(c *gin.Context) {
+		bypassed = c.GetBool(bypassedRateLimitKey)
+		c.Status(http.StatusOK)
+	})
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		if token != "" {
+			req.Header.Set(rateLimitBypassHeader, token)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// A burst of 1000 requests, far beyond 5 per minute
+	for i := 0; i < 1000; i++ {
+		require.Equal(t, http.StatusOK, get("internal-token"), "request %d", i)
+	}
+	assert.True(t, bypassed)
+
+	// Without a valid token the client is throttled as usual
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, get(""))
+	}
+	assert.False(t, bypassed)
+	assert.Equal(t, http.StatusTooManyRequests, get(""))
+	assert.Equal(t, http.StatusTooManyRequests, get("wrong-token"))
+
+	t.Run("Upper case hash", func(t *testing.T) {
+		server.rateLimitBypass = &rateLimitBypassTokens{static: []string{strings.ToUpper(hashBypassToken("internal-token"))}}
+		assert.Equal(t, http.StatusOK, get("internal-token"))
+	})
+}
+
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	server := newTestServer(t)
+	server.rateLimiter = NewRateLimiter(2, time.Minute)
+
+	get := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = "203.0.113.9:40000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// No proxy is trusted, so every request counts against the same address
+	assert.Equal(t, http.StatusOK, get("198.51.100.1"))
+	assert.Equal(t, http.StatusOK, get("198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, get("198.51.100.3"))
+}
+
+func TestRateLimitBypassTokensFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bypass_tokens")
+	tokens := &rateLimitBypassTokens{path: path}
+	assert.False(t, tokens.Valid("first"))
+
+	writeTokens := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	writeTokens("# internal services\n"+hashBypassToken("first")+"\n\n", time.Now().Add(-time.Minute))
+	assert.True(t, tokens.Valid("first"))
+
+	// Rotating the token takes effect without a restart
+	writeTokens(hashBypassToken("second")+"\n", time.Now())
+	assert.False(t, tokens.Valid("first"))
+	assert.True(t, tokens.Valid("second"))
+
+	require.NoError(t, os.Remove(path))
+	assert.False(t, tokens.Valid("second"))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultReadAheadSize is used when ReadAheadSize is not set
+	defaultReadAheadSize = 4 * 1024 * 1024
+
+	// readAheadMinSize is the smallest file read through a read-ahead
+	// buffer, smaller files take only a few reads either way
+	readAheadMinSize = 1024 * 1024
+)
+
+// readAheadPools holds a *sync.Pool of *bufio.Reader per buffer size, so
+// downloads reuse buffers instead of allocating one each
+var readAheadPools sync.Map
+
+// readAheadFile reads a file through a large buffer, so sequential reads
+// reach the disk in ReadAheadSize chunks rather than the few kilobytes a
+// response writer asks for at a time
+type readAheadFile struct {
+	file   *os.File
+	reader *bufio.Reader
+	pool   *sync.Pool
+}
+
+// newReadAheadFile wraps file in a pooled buffer of size bytes, first
+// hinting the OS that the file will be read sequentially. Release returns
+// the buffer once the file has been read.
+func newReadAheadFile(file *os.File, size int) *readAheadFile {
+	if size <= 0 {
+		size = defaultReadAheadSize
+	}
+	adviseSequential(file)
+
+	pool, _ := readAheadPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} { return bufio.NewReaderSize(nil, size) },
+	})
+	reader := pool.(*sync.Pool).Get().(*bufio.Reader)
+	reader.Reset(file)
+	return &readAheadFile{file: file, reader: reader, pool: pool.(*sync.Pool)}
+}
+
+// readAheadBufferSize returns the buffer used for a file of fileSize bytes:
+// the smallest power of two from readAheadMinSize that holds the file, at
+// most size. Rounding keeps the number of pooled sizes small.
+func readAheadBufferSize(size int, fileSize int64) int {
+	if size <= 0 {
+		size = defaultReadAheadSize
+	}
+	n := readAheadMinSize
+	for n < size && int64(n) < fileSize {
+		n *= 2
+	}
+	return min(n, size)
+}
+
+func (f *readAheadFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Seek moves the file offset, discarding anything buffered
+func (f *readAheadFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		// The file is ahead of the reader by what is buffered
+		offset -= int64(f.reader.Buffered())
+	}
+	pos, err := f.file.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	f.reader.Reset(f.file)
+	return pos, nil
+}
+
+// Release returns the buffer to its pool. The file must not be read after.
+func (f *readAheadFile) Release() {
+	f.reader.Reset(nil)
+	f.pool.Put(f.reader)
+	f.reader = nil
+}
+
+// sendfileWriter passes files http.ServeContent copies to the connection,
+// which sends them with sendfile where the OS supports it. gin's writer
+// hides the io.ReaderFrom of the connection's writer.
+type sendfileWriter struct {
+	gin.ResponseWriter
+	to io.ReaderFrom
+}
+
+func (w sendfileWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.WriteHeaderNow()
+	return w.to.ReadFrom(r)
+}
+
+// newSendfileWriter returns a writer sending files with sendfile, false
+// when the response can't, e.g. over TLS or when recorded in tests
+func newSendfileWriter(c *gin.Context) (sendfileWriter, bool) {
+	if c.Request.TLS != nil {
+		return sendfileWriter{}, false
+	}
+	unwrapper, ok := c.Writer.(interface{ Unwrap() http.ResponseWriter })
+	if !ok {
+		return sendfileWriter{}, false
+	}
+	to, ok := unwrapper.Unwrap().(io.ReaderFrom)
+	if !ok {
+		return sendfileWriter{}, false
+	}
+	return sendfileWriter{ResponseWriter: c.Writer, to: to}, true
+}
+
+// serveVideoFile writes a whole video file with http.ServeContent. The file
+// goes to the connection with sendfile when possible; otherwise files of at
+// least readAheadMinSize are read through a read-ahead buffer.
+func (s *Server) serveVideoFile(c *gin.Context, name string, file *os.File, info os.FileInfo) {
+	if w, ok := newSendfileWriter(c); ok {
+		adviseSequential(file)
+		http.ServeContent(w, c.Request, name, info.ModTime(), file)
+		return
+	}
+
+	var content io.ReadSeeker = file
+	if info.Size() >= readAheadMinSize {
+		f := newReadAheadFile(file, readAheadBufferSize(int(s.config.ReadAheadSize), info.Size()))
+		defer f.Release()
+		content = f
+	}
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), content)
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential tells the kernel the whole file will be read in order, so
+// it reads further ahead than it would by default
+func adviseSequential(file *os.File) {
+	if err := unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_SEQUENTIAL); err != nil {
+		log.Debug().Err(err).Str("file", file.Name()).Msg("fadvise failed")
+	}
+}
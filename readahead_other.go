@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// adviseSequential does nothing, fadvise is only used on Linux
+func adviseSequential(file *os.File) {}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789abcdef"), 0644))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	f := newReadAheadFile(file, 16)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(buf))
+
+	// The whole file is buffered, yet the position is after what was read
+	pos, err := f.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pos)
+
+	pos, err = f.Seek(2, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), pos)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "6789", string(buf))
+
+	size, err := f.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), size)
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	all, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(all))
+}
+
+func TestReadAheadBufferSize(t *testing.T) {
+	assert.Equal(t, readAheadMinSize, readAheadBufferSize(0, 10))
+	assert.Equal(t, 2*readAheadMinSize, readAheadBufferSize(0, readAheadMinSize+1))
+	assert.Equal(t, defaultReadAheadSize, readAheadBufferSize(0, 100*readAheadMinSize))
+	assert.Equal(t, 3*readAheadMinSize, readAheadBufferSize(3*readAheadMinSize, 100*readAheadMinSize), "size caps the buffer")
+	assert.Equal(t, 64*1024, readAheadBufferSize(64*1024, 100*readAheadMinSize))
+}
+
+func TestServeVideoFile(t *testing.T) {
+	server := newTestServer(t)
+	content := make([]byte, 2*readAheadMinSize+123)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	video := uploadTestVideo(t, server, "large.mp4", content)
+
+	// Recorded responses are read through the read-ahead buffer, twice to
+	// reuse a pooled one
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, bytes.Equal(content, w.Body.Bytes()))
+	}
+
+	// Responses on a connection are sent from the file
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/api/videos/" + video.ID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(len(content)), resp.ContentLength)
+	assert.True(t, bytes.Equal(content, body))
+}
+
+// sequentialRead reads r to the end in the chunks http.ServeContent copies
+// a response in
+func sequentialRead(b *testing.B, r io.Reader) {
+	b.Helper()
+	if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{r}, make([]byte, 32*1024)); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkSequentialRead(b *testing.B) {
+	const size = 100 * 1024 * 1024
+
+	path := filepath.Join(b.TempDir(), "video.mp4")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := io.CopyN(file, rand.Reader, size); err != nil {
+		b.Fatal(err)
+	}
+	file.Close()
+
+	read := func(b *testing.B, wrap func(*os.File) io.Reader) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sequentialRead(b, wrap(file))
+			file.Close()
+		}
+	}
+
+	b.Run("Unbuffered", func(b *testing.B) {
+		read(b, func(file *os.File) io.Reader { return file })
+	})
+	b.Run("Buffered", func(b *testing.B) {
+		read(b, func(file *os.File) io.Reader { return bufio.NewReaderSize(file, defaultReadAheadSize) })
+	})
+	b.Run("ReadAhead", func(b *testing.B) {
+		read(b, func(file *os.File) io.Reader { return newReadAheadFile(file, defaultReadAheadSize) })
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// renameVideoFile moves a video file with moveFile. The file's access and
+// modification times are set to createdAt, so backup tools and cache
+// validators see the upload time rather than the time of the rename.
+func renameVideoFile(oldPath, newPath string, createdAt time.Time) error {
+	if err := moveFile(oldPath, newPath); err != nil {
+		return err
+	}
+	return os.Chtimes(newPath, createdAt, createdAt)
+}
+
+// moveFile renames a file, copying it when the paths are on different
+// filesystems
+func moveFile(oldPath, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFileContents(oldPath, newPath); err != nil {
+		return err
+	}
+	return os.Remove(oldPath)
+}
+
+// copyFileContents copies src to a new file at dst, removing dst on failure
+func copyFileContents(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// renameVideoHandler changes a video's name, moving its file to match
+func (s *Server) renameVideoHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := sanitizeFilename(req.Name)
+
+	// Held until the record matches the moved file, so compaction doesn't
+	// take the file for a leftover meanwhile
+	defer s.fileLocks.Lock(videoID)()
+
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+	if name == video.Name {
+		s.respondSuccess(c, http.StatusOK, video)
+		return
+	}
+
+	if ext := normalizeExtension(filepath.Ext(name)); !s.isExtensionAllowed(ext) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":     "file extension not allowed",
+			"extension": ext,
+			"allowed":   s.config.AllowedExtensions,
+		})
+		return
+	}
+	if existing, exists := s.db.GetVideoByName(name); exists && existing.ID != videoID {
+		c.JSON(http.StatusConflict, gin.H{"error": "a video with this name already exists", "existing_id": existing.ID})
+		return
+	}
+
+	oldPath, newPath := s.getFilePath(videoID, video.Name), s.getFilePath(videoID, name)
+	if err := renameVideoFile(oldPath, newPath, video.CreatedAt); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "video file not found"})
+			return
+		}
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to rename video file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rename video file"})
+		return
+	}
+
+	updated, err := s.db.UpdateVideoFunc(videoID, func(current *Video) error {
+		current.Name = name
+		current.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		// Put the file back so it still matches the record
+		if err := renameVideoFile(newPath, oldPath, video.CreatedAt); err != nil {
+			getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to restore renamed video file")
+		}
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to update renamed video")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update video"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("video_id", videoID).
+		Str("old_name", video.Name).
+		Str("new_name", name).
+		Msg("video renamed")
+
+	s.recordVideoEvent(c, videoID, VideoEventUpdated, gin.H{"name": video.Name}, gin.H{"name": name})
+
+	s.respondSuccess(c, http.StatusOK, updated)
+}
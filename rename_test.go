@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameVideo(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "draft.mp4", []byte("renamed video"))
+	other := uploadTestVideo(t, server, "taken.mp4", []byte("another video"))
+
+	rename := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/videos/"+id, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	before := time.Now()
+	w := rename(video.ID, `{"name": "final.mp4"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var renamed Video
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &renamed))
+	assert.Equal(t, "final.mp4", renamed.Name)
+
+	t.Run("File keeps its upload time", func(t *testing.T) {
+		_, err := os.Stat(server.getFilePath(video.ID, "draft.mp4"))
+		assert.True(t, os.IsNotExist(err))
+
+		info, err := os.Stat(server.getFilePath(video.ID, "final.mp4"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(video.CreatedAt), "mtime %v, created %v", info.ModTime(), video.CreatedAt)
+	})
+
+	t.Run("Record tracks the rename", func(t *testing.T) {
+		stored, exists := server.db.GetVideoByID(video.ID)
+		require.True(t, exists)
+		assert.Equal(t, "final.mp4", stored.Name)
+		assert.False(t, stored.UpdatedAt.Before(before))
+		assert.True(t, stored.CreatedAt.Equal(video.CreatedAt))
+
+		_, exists = server.db.GetVideoByName("draft.mp4")
+		assert.False(t, exists)
+	})
+
+	t.Run("Name taken", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, rename(video.ID, `{"name": "`+other.Name+`"}`).Code)
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, rename("missing", `{"name": "x.mp4"}`).Code)
+	})
+}
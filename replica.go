@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Subscribe returns a channel receiving a snapshot of every video after each
+// write, starting with the current contents. A replica that falls behind
+// only sees the newest snapshot: one not yet received is replaced rather
+// than queued, so writers never block.
+func (db *InMemoryDB) Subscribe() chan []*Video {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	updates := make(chan []*Video, 1)
+	updates <- db.videoSnapshotLocked()
+	db.subscribers = append(db.subscribers, updates)
+	return updates
+}
+
+// videoSnapshotLocked copies every video. The caller must hold the lock.
+func (db *InMemoryDB) videoSnapshotLocked() []*Video {
+	videos := make([]*Video, 0, len(db.videos))
+	for _, video := range db.videos {
+		videoCopy := *video
+		videos = append(videos, &videoCopy)
+	}
+	return videos
+}
+
+// publishSnapshotLocked sends the current contents to every subscriber. The
+// caller must hold the write lock, which also keeps senders from racing.
+func (db *InMemoryDB) publishSnapshotLocked() {
+	if len(db.subscribers) == 0 {
+		return
+	}
+
+	videos := db.videoSnapshotLocked()
+	for _, updates := range db.subscribers {
+		select {
+		case <-updates: // drop the snapshot the replica has not applied yet
+		default:
+		}
+		updates <- videos
+	}
+}
+
+// ReplicaDB is a read-only copy of an InMemoryDB kept up to date from its
+// snapshots. Reads load the current copy atomically and never take a lock,
+// so they do not contend with the master's writers or with each other. A
+// replica may briefly lag behind the master.
+type ReplicaDB struct {
+	videos atomic.Pointer[map[string]*Video]
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewReplicaDB creates a replica applying the snapshots sent on updates,
+// usually from InMemoryDB.Subscribe
+func NewReplicaDB(updates chan []*Video) *ReplicaDB {
+	r := &ReplicaDB{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	empty := make(map[string]*Video)
+	r.videos.Store(&empty)
+
+	go r.applyLoop(updates)
+	return r
+}
+
+// applyLoop swaps in each snapshot. Snapshots are never modified once
+// stored, so readers can use them without copying the map.
+func (r *ReplicaDB) applyLoop(updates chan []*Video) {
+	defer close(r.done)
+
+	for {
+		select {
+		case videos := <-updates:
+			byID := make(map[string]*Video, len(videos))
+			for _, video := range videos {
+				byID[video.ID] = video
+			}
+			r.videos.Store(&byID)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// GetVideoByID retrieves a copy of a video from the replica
+func (r *ReplicaDB) GetVideoByID(id string) (*Video, bool) {
+	video, exists := (*r.videos.Load())[id]
+	if !exists {
+		return nil, false
+	}
+	videoCopy := *video
+	return &videoCopy, true
+}
+
+// GetAllVideos returns copies of every video in the replica
+func (r *ReplicaDB) GetAllVideos() []*Video {
+	byID := *r.videos.Load()
+	videos := make([]*Video, 0, len(byID))
+	for _, video := range byID {
+		videoCopy := *video
+		videos = append(videos, &videoCopy)
+	}
+	return videos
+}
+
+// Close stops applying snapshots
+func (r *ReplicaDB) Close() {
+	close(r.stop)
+	<-r.done
+}
+
+// videoReader is the part of VideoStore read replicas serve
+type videoReader interface {
+	GetVideoByID(id string) (*Video, bool)
+	GetAllVideos() []*Video
+}
+
+// startReadReplicas creates count replicas of db. Only the in-memory store
+// publishes snapshots, other stores get none.
+func (s *Server) startReadReplicas(count int) {
+	if count <= 0 {
+		return
+	}
+	db, ok := s.db.(*InMemoryDB)
+	if !ok {
+		s.logger.Warn().Str("backend", s.config.DBBackend).Msg("read replicas need the in-memory store, serving reads from the database")
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		s.readReplicas = append(s.readReplicas, NewReplicaDB(db.Subscribe()))
+	}
+}
+
+// readDB returns the store to serve a read from, taking the replicas in
+// turn when there are any. Replicas may lag behind writes, so it is only
+// used where a slightly stale answer is acceptable.
+func (s *Server) readDB() videoReader {
+	if len(s.readReplicas) == 0 {
+		return s.db
+	}
+	next := s.nextReplica.Add(1)
+	return s.readReplicas[next%int64(len(s.readReplicas))]
+}
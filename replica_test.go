@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaDB(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newTestVideo("before", 100))
+
+	replica := NewReplicaDB(db.Subscribe())
+	defer replica.Close()
+
+	// Replicas are eventually consistent
+	waitFor := func(check func() bool) {
+		t.Helper()
+		require.Eventually(t, check, time.Second, time.Millisecond)
+	}
+
+	t.Run("Starts with the current contents", func(t *testing.T) {
+		waitFor(func() bool {
+			_, exists := replica.GetVideoByID("before")
+			return exists
+		})
+	})
+
+	t.Run("Follows writes", func(t *testing.T) {
+		db.AddVideo(newTestVideo("after", 200))
+		updated := newTestVideo("before", 150)
+		require.NoError(t, db.UpdateVideo(updated))
+		db.DeleteVideo("after")
+		db.AddVideo(newTestVideo("last", 300))
+
+		waitFor(func() bool {
+			video, exists := replica.GetVideoByID("before")
+			_, deleted := replica.GetVideoByID("after")
+			return exists && video.Size == 150 && !deleted && len(replica.GetAllVideos()) == 2
+		})
+	})
+
+	t.Run("Returns copies", func(t *testing.T) {
+		video, exists := replica.GetVideoByID("last")
+		require.True(t, exists)
+		video.Name = "changed"
+
+		video, _ = replica.GetVideoByID("last")
+		assert.NotEqual(t, "changed", video.Name)
+	})
+}
+
+func TestServerReadReplicas(t *testing.T) {
+	server := newTestServer(t)
+	assert.Equal(t, server.db, server.readDB(), "no replicas by default")
+
+	db := server.db.(*InMemoryDB)
+	server.startReadReplicas(3)
+	defer func() {
+		for _, replica := range server.readReplicas {
+			replica.Close()
+		}
+	}()
+	require.Len(t, server.readReplicas, 3)
+
+	seen := make(map[videoReader]bool)
+	for i := 0; i < 6; i++ {
+		seen[server.readDB()] = true
+	}
+	assert.Len(t, seen, 3, "reads are spread over every replica")
+
+	db.AddVideo(newTestVideo("listed", 100))
+	for _, replica := range server.readReplicas {
+		require.Eventually(t, func() bool {
+			return len(replica.GetAllVideos()) == 1
+		}, time.Second, time.Millisecond)
+	}
+}
+
+// benchmarkConcurrentReads reads from reader with about 1000 goroutines
+// while a writer keeps updating db
+func benchmarkConcurrentReads(b *testing.B, db *InMemoryDB, reader videoReader) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				db.UpdateVideo(newTestVideo(fmt.Sprintf("video-%d", i%1000), int64(i)))
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	b.SetParallelism((1000 + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			reader.GetVideoByID(fmt.Sprintf("video-%d", i%1000))
+			i++
+		}
+	})
+}
+
+func BenchmarkReadsSingleDB(b *testing.B) {
+	db := newBenchmarkDB(1000)
+	benchmarkConcurrentReads(b, db, db)
+}
+
+func BenchmarkReadsReplicated(b *testing.B) {
+	db := newBenchmarkDB(1000)
+	replica := NewReplicaDB(db.Subscribe())
+	defer replica.Close()
+	benchmarkConcurrentReads(b, db, replica)
+}
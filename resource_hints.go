@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setResourceHints adds Link preload headers for the latest video's sprite
+// sheet and WebVTT track, so browsers start fetching them before the page's
+// scripts ask for them. Nothing is added until the sprites are generated.
+func (s *Server) setResourceHints(c *gin.Context) {
+	if !s.config.EnableResourceHints {
+		return
+	}
+	video, exists := s.db.GetLatestVideo()
+	if !exists {
+		return
+	}
+
+	if video.SpriteURL != "" {
+		c.Writer.Header().Add("Link", fmt.Sprintf("<%s>; rel=preload; as=image", video.SpriteURL))
+	}
+	if video.SpriteVTTURL != "" {
+		c.Writer.Header().Add("Link", fmt.Sprintf("<%s>; rel=preload; as=fetch; crossorigin", video.SpriteVTTURL))
+	}
+}
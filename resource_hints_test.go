@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceHints(t *testing.T) {
+	server := newTestServer(t)
+	server.config.EnableResourceHints = true
+
+	list := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	t.Run("No videos", func(t *testing.T) {
+		assert.Empty(t, list().Header().Values("Link"))
+	})
+
+	uploadTestVideo(t, server, "older.mp4", []byte("older video"))
+	video := uploadTestVideo(t, server, "latest.mp4", []byte("latest video"))
+
+	t.Run("Sprites not generated", func(t *testing.T) {
+		assert.Empty(t, list().Header().Values("Link"))
+	})
+
+	// What generateSprites records once ffmpeg has run
+	updated := *video
+	updated.SpriteURL = fmt.Sprintf("/api/videos/%s/sprite", video.ID)
+	updated.SpriteVTTURL = fmt.Sprintf("/api/videos/%s/sprite.vtt", video.ID)
+	require.NoError(t, server.db.UpdateVideo(&updated))
+
+	t.Run("Latest video's sprites are preloaded", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"</api/videos/" + video.ID + "/sprite>; rel=preload; as=image",
+			"</api/videos/" + video.ID + "/sprite.vtt>; rel=preload; as=fetch; crossorigin",
+		}, list().Header().Values("Link"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		server.config.EnableResourceHints = false
+		assert.Empty(t, list().Header().Values("Link"))
+	})
+}
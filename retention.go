@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons reported in video.purged payloads
+const (
+	PurgeReasonMaxAge  = "max_age"
+	PurgeReasonMaxSize = "max_size"
+)
+
+// RetentionPolicy limits how long, and how much of, a content type is kept
+type RetentionPolicy struct {
+	// ContentType is matched against the video's content type. "video/*"
+	// matches a whole type and "*" matches everything.
+	ContentType string `json:"content_type"`
+	// MaxAgeDays deletes videos older than this, 0 disables the limit
+	MaxAgeDays int `json:"max_age_days"`
+	// MaxSizeBytes caps the total size of matching videos, deleting the
+	// oldest first, 0 disables the limit
+	MaxSizeBytes int64 `json:"max_size_bytes"`
+}
+
+// loadRetentionPolicies reads policies from a JSON array at path. A missing
+// file means no policies.
+func loadRetentionPolicies(path string) ([]RetentionPolicy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []RetentionPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// matchContentType reports whether contentType matches a policy pattern
+func matchContentType(pattern, contentType string) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	contentType = strings.ToLower(contentType)
+
+	if pattern == "*" || pattern == "*/*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+	return pattern == contentType
+}
+
+// retentionLoop applies the retention policies every RetentionCheckInterval
+// until shutdown. The policy file is re-read on every run, so policies can
+// be changed without a restart.
+func (s *Server) retentionLoop() {
+	ticker := time.NewTicker(s.config.RetentionCheckInterval)
+	defer ticker.Stop()
+
+	policies := s.config.RetentionPolicies
+	for {
+		select {
+		case <-s.retentionStop:
+			return
+		case now := <-ticker.C:
+			if s.config.RetentionPoliciesFile != "" {
+				reloaded, err := loadRetentionPolicies(s.config.RetentionPoliciesFile)
+				if err != nil {
+					s.logger.Error().Err(err).Str("path", s.config.RetentionPoliciesFile).Msg("failed to reload retention policies, keeping the previous ones")
+				} else {
+					policies = reloaded
+				}
+			}
+			s.applyRetentionPolicies(policies, now)
+		}
+	}
+}
+
+// applyRetentionPolicies deletes the videos that violate a policy and returns
+// them. Age limits are applied before size limits, so videos removed for age
+// also count towards the size limit.
+func (s *Server) applyRetentionPolicies(policies []RetentionPolicy, now time.Time) []*Video {
+	var purged []*Video
+
+	for _, policy := range policies {
+		var matching []*Video
+		for _, video := range s.db.GetAllVideos() {
+			if matchContentType(policy.ContentType, video.ContentType) {
+				matching = append(matching, video)
+			}
+		}
+
+		// Oldest first, for both the age cut-off and size eviction
+		sort.Slice(matching, func(i, j int) bool {
+			return matching[i].CreatedAt.Before(matching[j].CreatedAt)
+		})
+
+		if policy.MaxAgeDays > 0 {
+			cutoff := now.Add(-time.Duration(policy.MaxAgeDays) * 24 * time.Hour)
+			kept := matching[:0]
+			for _, video := range matching {
+				if video.CreatedAt.Before(cutoff) {
+					if s.purgeVideo(video, policy, PurgeReasonMaxAge) {
+						purged = append(purged, video)
+					}
+					continue
+				}
+				kept = append(kept, video)
+			}
+			matching = kept
+		}
+
+		if policy.MaxSizeBytes > 0 {
+			total := sumVideoSizes(matching)
+			for _, video := range matching {
+				if total <= policy.MaxSizeBytes {
+					break
+				}
+				if s.purgeVideo(video, policy, PurgeReasonMaxSize) {
+					purged = append(purged, video)
+				}
+				total -= video.Size
+			}
+		}
+	}
+
+	return purged
+}
+
+// purgeVideo deletes a video removed by a retention policy and notifies
+// video.purged subscribers
+func (s *Server) purgeVideo(video *Video, policy RetentionPolicy, reason string) bool {
+	if !s.db.DeleteVideo(video.ID) {
+		return false
+	}
+	s.removeVideoFiles(video)
+
+	s.logger.Info().
+		Str("video_id", video.ID).
+		Str("filename", video.Name).
+		Str("content_type", video.ContentType).
+		Str("reason", reason).
+		Msg("video purged by retention policy")
+
+	s.recordVideoEvent(nil, video.ID, VideoEventPurged, video, map[string]string{"reason": reason, "content_type": policy.ContentType})
+
+	payload := VideoPurgedPayload{
+		SchemaVersion: WebhookPayloadSchemaVersion,
+		Event:         EventVideoPurged,
+		Timestamp:     time.Now().Unix(),
+		VideoID:       video.ID,
+		Filename:      video.Name,
+		ContentType:   video.ContentType,
+		Reason:        reason,
+		Policy:        policy.ContentType,
+	}
+	s.webhookMgr.NotifyWebhooks(EventVideoPurged, payload)
+	s.publishEvent(EventVideoPurged, payload)
+	return true
+}
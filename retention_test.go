@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addRetentionVideo stores a video of the given type, size and age along
+// with its file
+func addRetentionVideo(t *testing.T, server *Server, id, contentType string, size int64, age time.Duration) {
+	t.Helper()
+
+	video := newTestVideo(id, size)
+	video.ContentType = contentType
+	video.CreatedAt = time.Now().Add(-age)
+	require.NoError(t, os.WriteFile(server.getFilePath(video.ID, video.Name), make([]byte, size), 0644))
+	require.NoError(t, server.db.AddVideo(video))
+}
+
+func remainingIDs(server *Server) []string {
+	return videoIDs(server.db.GetAllVideos())
+}
+
+func TestMatchContentType(t *testing.T) {
+	assert.True(t, matchContentType("video/mp4", "video/mp4"))
+	assert.True(t, matchContentType("video/mp4", "Video/MP4; codecs=avc1"))
+	assert.True(t, matchContentType("video/*", "video/webm"))
+	assert.True(t, matchContentType("*", "application/octet-stream"))
+	assert.False(t, matchContentType("video/*", "audio/mpeg"))
+	assert.False(t, matchContentType("video/mp4", "video/webm"))
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	server := newTestServer(t)
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.purged", receiver.server.URL))
+
+	day := 24 * time.Hour
+	addRetentionVideo(t, server, "old-mp4", "video/mp4", 10, 40*day)
+	addRetentionVideo(t, server, "new-mp4", "video/mp4", 10, 5*day)
+	addRetentionVideo(t, server, "old-audio", "audio/mpeg", 10, 40*day)
+
+	purged := server.applyRetentionPolicies([]RetentionPolicy{{ContentType: "video/*", MaxAgeDays: 30}}, time.Now())
+	assert.Equal(t, []string{"old-mp4"}, videoIDs(purged))
+	assert.Equal(t, []string{"new-mp4", "old-audio"}, remainingIDs(server))
+
+	_, err := os.Stat(server.getFilePath("old-mp4", "old-mp4.mp4"))
+	assert.True(t, os.IsNotExist(err), "purged video file should be removed")
+
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	require.Equal(t, 1, receiver.count())
+	payload := receiver.payloads[0]
+	assert.Equal(t, "video.purged", payload["event"])
+	assert.Equal(t, "old-mp4", payload["video_id"])
+	assert.Equal(t, PurgeReasonMaxAge, payload["reason"])
+	assert.Equal(t, "video/*", payload["policy"])
+}
+
+func TestRetentionMaxSize(t *testing.T) {
+	server := newTestServer(t)
+
+	addRetentionVideo(t, server, "oldest", "video/webm", 40, 3*time.Hour)
+	addRetentionVideo(t, server, "middle", "video/webm", 40, 2*time.Hour)
+	addRetentionVideo(t, server, "newest", "video/webm", 40, time.Hour)
+	addRetentionVideo(t, server, "other", "video/mp4", 500, 4*time.Hour)
+
+	// 120 bytes of webm against a 90 byte limit: only the oldest has to go
+	purged := server.applyRetentionPolicies([]RetentionPolicy{{ContentType: "video/webm", MaxSizeBytes: 90}}, time.Now())
+	assert.Equal(t, []string{"oldest"}, videoIDs(purged))
+	assert.Equal(t, []string{"middle", "newest", "other"}, remainingIDs(server))
+
+	// Already within the limit, nothing more is deleted
+	assert.Empty(t, server.applyRetentionPolicies([]RetentionPolicy{{ContentType: "video/webm", MaxSizeBytes: 90}}, time.Now()))
+}
+
+func TestRetentionAgeThenSize(t *testing.T) {
+	server := newTestServer(t)
+
+	day := 24 * time.Hour
+	addRetentionVideo(t, server, "expired", "video/mp4", 100, 10*day)
+	addRetentionVideo(t, server, "a", "video/mp4", 30, 3*day)
+	addRetentionVideo(t, server, "b", "video/mp4", 30, 2*day)
+
+	// The expired video no longer counts towards the size limit
+	policy := RetentionPolicy{ContentType: "*", MaxAgeDays: 7, MaxSizeBytes: 60}
+	purged := server.applyRetentionPolicies([]RetentionPolicy{policy}, time.Now())
+	assert.Equal(t, []string{"expired"}, videoIDs(purged))
+	assert.Equal(t, []string{"a", "b"}, remainingIDs(server))
+}
+
+func TestLoadRetentionPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention_policies.json")
+
+	policies, err := loadRetentionPolicies(path)
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"content_type":"video/*","max_age_days":30,"max_size_bytes":1024}]`), 0644))
+	policies, err = loadRetentionPolicies(path)
+	require.NoError(t, err)
+	assert.Equal(t, []RetentionPolicy{{ContentType: "video/*", MaxAgeDays: 30, MaxSizeBytes: 1024}}, policies)
+
+	require.NoError(t, os.WriteFile(path, []byte(`not json`), 0644))
+	_, err = loadRetentionPolicies(path)
+	assert.Error(t, err)
+}
+
+func TestRetentionLoopReloadsPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention_policies.json")
+	config := &Config{
+		StoragePath:            t.TempDir(),
+		MaxFileSize:            1024,
+		RetentionPoliciesFile:  path,
+		RetentionCheckInterval: 20 * time.Millisecond,
+	}
+	server := NewServer(config, NewInMemoryDB())
+	defer close(server.retentionStop)
+
+	addRetentionVideo(t, server, "old", "video/mp4", 10, 48*time.Hour)
+
+	// No policies yet, so the video survives a few runs
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"old"}, remainingIDs(server))
+
+	// Policies written at runtime are picked up by the next run
+	require.NoError(t, os.WriteFile(path, []byte(`[{"content_type":"video/mp4","max_age_days":1}]`), 0644))
+	require.Eventually(t, func() bool {
+		return len(server.db.GetAllVideos()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
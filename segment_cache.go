@@ -0,0 +1,164 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// segmentCacheKey identifies a byte range of a video file, inclusive of end
+type segmentCacheKey struct {
+	videoID    string
+	start, end int64
+}
+
+// segmentCacheEntry is a cached byte range
+type segmentCacheEntry struct {
+	key  segmentCacheKey
+	data []byte
+}
+
+// SegmentCache holds recently served byte ranges of video files, so ranges
+// many clients request, such as fixed-size HLS segments, are not read from
+// disk each time. It is bounded by the total bytes cached, evicting the
+// least recently used ranges first, and is safe for concurrent use.
+type SegmentCache struct {
+	capacity int64
+	size     int64
+	entries  map[segmentCacheKey]*list.Element
+	order    *list.List // front is the most recently used
+	filling  map[segmentCacheKey]bool
+	mutex    sync.Mutex
+
+	// fills tracks the fills in progress, so tests can wait for them
+	fills sync.WaitGroup
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewSegmentCache creates a cache holding at most capacity bytes
+func NewSegmentCache(capacity int64) *SegmentCache {
+	return &SegmentCache{
+		capacity: capacity,
+		entries:  make(map[segmentCacheKey]*list.Element),
+		order:    list.New(),
+		filling:  make(map[segmentCacheKey]bool),
+	}
+}
+
+// Get returns a cached range and marks it as recently used. The returned
+// bytes are shared and must not be modified.
+func (sc *SegmentCache) Get(key segmentCacheKey) ([]byte, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	element, exists := sc.entries[key]
+	if !exists {
+		sc.misses.Add(1)
+		return nil, false
+	}
+
+	sc.hits.Add(1)
+	sc.order.MoveToFront(element)
+	return element.Value.(*segmentCacheEntry).data, true
+}
+
+// Put stores a range, evicting the least recently used ranges until it
+// fits. Ranges larger than the whole cache are not stored.
+func (sc *SegmentCache) Put(key segmentCacheKey, data []byte) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if int64(len(data)) > sc.capacity {
+		return
+	}
+	if element, exists := sc.entries[key]; exists {
+		sc.removeLocked(element)
+	}
+	for sc.size+int64(len(data)) > sc.capacity {
+		sc.removeLocked(sc.order.Back())
+	}
+	sc.entries[key] = sc.order.PushFront(&segmentCacheEntry{key: key, data: data})
+	sc.size += int64(len(data))
+}
+
+// removeLocked drops an entry. The caller must hold the lock.
+func (sc *SegmentCache) removeLocked(element *list.Element) {
+	entry := sc.order.Remove(element).(*segmentCacheEntry)
+	delete(sc.entries, entry.key)
+	sc.size -= int64(len(entry.data))
+}
+
+// RemoveVideo drops every cached range of a video
+func (sc *SegmentCache) RemoveVideo(videoID string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	for key, element := range sc.entries {
+		if key.videoID == videoID {
+			sc.removeLocked(element)
+		}
+	}
+}
+
+// fillFromFile reads a range of path into the cache in the background,
+// unless it is cached or being read already
+func (sc *SegmentCache) fillFromFile(key segmentCacheKey, path string) {
+	sc.mutex.Lock()
+	if _, exists := sc.entries[key]; exists || sc.filling[key] {
+		sc.mutex.Unlock()
+		return
+	}
+	sc.filling[key] = true
+	sc.fills.Add(1)
+	sc.mutex.Unlock()
+
+	go func() {
+		defer sc.fills.Done()
+		defer func() {
+			sc.mutex.Lock()
+			delete(sc.filling, key)
+			sc.mutex.Unlock()
+		}()
+
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		data := make([]byte, key.end-key.start+1)
+		// A short read means the file changed since the request, so
+		// nothing is cached
+		if _, err := file.ReadAt(data, key.start); err != nil {
+			return
+		}
+		sc.Put(key, data)
+	}()
+}
+
+// Size returns the number of bytes cached
+func (sc *SegmentCache) Size() int64 {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.size
+}
+
+// Len returns the number of cached ranges
+func (sc *SegmentCache) Len() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.order.Len()
+}
+
+// Hits returns how many range requests were served from the cache
+func (sc *SegmentCache) Hits() int64 {
+	return sc.hits.Load()
+}
+
+// Misses returns how many cachable range requests were read from disk
+func (sc *SegmentCache) Misses() int64 {
+	return sc.misses.Load()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentCacheEviction(t *testing.T) {
+	cache := NewSegmentCache(10)
+	a := segmentCacheKey{videoID: "a", start: 0, end: 3}
+	b := segmentCacheKey{videoID: "b", start: 0, end: 3}
+	c := segmentCacheKey{videoID: "c", start: 0, end: 3}
+
+	cache.Put(a, []byte("aaaa"))
+	cache.Put(b, []byte("bbbb"))
+	_, ok := cache.Get(a)
+	require.True(t, ok)
+
+	// b is the least recently used, so it makes room for c
+	cache.Put(c, []byte("cccc"))
+	_, ok = cache.Get(b)
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), cache.Size())
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Put(segmentCacheKey{videoID: "huge"}, make([]byte, 11))
+	assert.Equal(t, 2, cache.Len(), "larger than the whole cache")
+
+	cache.Put(a, []byte("AA"))
+	data, _ := cache.Get(a)
+	assert.Equal(t, "AA", string(data))
+	assert.Equal(t, int64(6), cache.Size())
+
+	cache.RemoveVideo("a")
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, int64(4), cache.Size())
+
+	assert.Equal(t, int64(2), cache.Hits())
+	assert.Equal(t, int64(1), cache.Misses())
+}
+
+// rangeRequest requests a byte range of a video
+func rangeRequest(server *Server, videoID, rangeHeader string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID, nil)
+	req.Header.Set("Range", rangeHeader)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSegmentCacheRangeRequests(t *testing.T) {
+	server := newTestServer(t)
+	server.config.MaxCachableSegment = 1024
+	server.segmentCache = NewSegmentCache(1024 * 1024)
+
+	data := make([]byte, 4096)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	video := uploadTestVideo(t, server, "cached.mp4", data)
+
+	w := rangeRequest(server, video.ID, "bytes=100-1099")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, data[100:1100], w.Body.Bytes())
+	server.segmentCache.fills.Wait()
+	assert.Equal(t, int64(0), server.segmentCache.Hits())
+	assert.Equal(t, int64(1), server.segmentCache.Misses())
+	assert.Equal(t, 1, server.segmentCache.Len())
+
+	// Served from memory, even once the file changes on disk
+	require.NoError(t, os.WriteFile(server.getFilePath(video.ID, video.Name), bytes.Repeat([]byte{0}, len(data)), 0644))
+	w = rangeRequest(server, video.ID, "bytes=100-1099")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, data[100:1100], w.Body.Bytes())
+	assert.Equal(t, "bytes 100-1099/4096", w.Header().Get("Content-Range"))
+	assert.Equal(t, "1000", w.Header().Get("Content-Length"))
+	assert.Equal(t, int64(1), server.segmentCache.Hits())
+
+	t.Run("Not cachable", func(t *testing.T) {
+		rangeRequest(server, video.ID, "bytes=0-2047")
+		rangeRequest(server, video.ID, "bytes=0-9,20-29")
+		server.segmentCache.fills.Wait()
+		assert.Equal(t, 1, server.segmentCache.Len(), "too large, and several ranges")
+		assert.Equal(t, int64(1), server.segmentCache.Misses())
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), "# TYPE segment_cache_hits_total counter\nsegment_cache_hits_total 1\n")
+		assert.Contains(t, w.Body.String(), "segment_cache_misses_total 1\n")
+	})
+
+	t.Run("Dropped on delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 0, server.segmentCache.Len())
+	})
+}
+
+func BenchmarkRangeRequest(b *testing.B) {
+	const segmentSize = 1024 * 1024
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Cached=%t", cached), func(b *testing.B) {
+			config := &Config{
+				StoragePath:        b.TempDir(),
+				LogFormat:          LogFormatNone,
+				MaxCachableSegment: segmentSize,
+			}
+			if cached {
+				config.SegmentCacheSize = 16 * segmentSize
+			}
+			server := NewServer(config, NewInMemoryDB())
+
+			video := newTestVideo("bench", 8*segmentSize)
+			if err := server.db.AddVideo(video); err != nil {
+				b.Fatal(err)
+			}
+			data := make([]byte, video.Size)
+			rand.Read(data)
+			if err := os.WriteFile(server.getFilePath(video.ID, video.Name), data, 0644); err != nil {
+				b.Fatal(err)
+			}
+
+			// Every client asks for the same segment, as HLS players do
+			rangeHeader := fmt.Sprintf("bytes=%d-%d", 2*segmentSize, 3*segmentSize-1)
+			rangeRequest(server, video.ID, rangeHeader)
+			if server.segmentCache != nil {
+				server.segmentCache.fills.Wait()
+			}
+
+			b.SetBytes(segmentSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if w := rangeRequest(server, video.ID, rangeHeader); w.Code != http.StatusPartialContent {
+					b.Fatalf("status %d", w.Code)
+				}
+			}
+		})
+	}
+}
@@ -2,18 +2,95 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestServer creates a server backed by a temporary storage directory
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	config := &Config{
+		ServerPort:    "0",
+		StoragePath:   t.TempDir(),
+		MaxFileSize:   1024 * 1024 * 10, // 10MB
+		LogFormat:     LogFormatJSON,
+	}
+
+	server := NewServer(config, NewInMemoryDB())
+
+	// Resolve webhook hosts to a public address without touching DNS
+	server.lookupHost = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("93.184.216.34")}, nil
+	}
+	return server
+}
+
+// storedVideoFiles lists the files in dir, leaving out the server's own
+// metadata files
+func storedVideoFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		if !isStorageMetadataFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// uploadTestVideo uploads data as a multipart file and returns the created video
+func uploadTestVideo(t *testing.T, server *Server, filename string, data []byte) *Video {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var resp struct {
+		Video *Video `json:"video"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Video)
+
+	return resp.Video
+}
+
 func TestServer(t *testing.T) {
 	// Create a temporary storage directory for tests
 	tempDir := t.TempDir()
@@ -22,10 +99,11 @@ func TestServer(t *testing.T) {
 		ServerPort:    "0", // Use port 0 to let the OS assign a free port
 		StoragePath:   tempDir,
 		MaxFileSize:   1024 * 1024 * 10, // 10MB
-		EnableLogging: false,
+		LogFormat:     LogFormatJSON,
 	}
 	
-	server := NewServer(config)
+	store := NewMockVideoStore()
+	server := NewServer(config, store)
 	
 	// Test health endpoint
 	t.Run("Health Check", func(t *testing.T) {
@@ -43,38 +121,21 @@ func TestServer(t *testing.T) {
 	
 	// Test video upload and retrieval
 	t.Run("Video Upload and Download", func(t *testing.T) {
-		// Create a mock video file (just some bytes for testing)
-		var buf bytes.Buffer
-		writer := multipart.NewWriter(&buf)
-		
-		part, err := writer.CreateFormFile("file", "test_video.mp4")
-		require.NoError(t, err)
+		video := uploadTestVideo(t, server, "test_video.mp4", []byte("fake video content for testing"))
 		
-		// Write some test data
-		testData := []byte("fake video content for testing")
-		_, err = part.Write(testData)
-		require.NoError(t, err)
-		
-		err = writer.Close()
-		require.NoError(t, err)
+		// The handler must have stored exactly the record it returned
+		assert.Equal(t, 1, store.CallCount("AddVideo"))
+		stored, exists := store.Videos[video.ID]
+		require.True(t, exists)
+		assert.Equal(t, "test_video.mp4", stored.Name)
+		assert.Equal(t, video.Hash, stored.Hash)
 		
-		// Upload the video
-		req, _ := http.NewRequest("POST", "/api/videos", &buf)
-		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req, _ := http.NewRequest("GET", "/api/videos/"+video.ID, nil)
 		w := httptest.NewRecorder()
 		server.router.ServeHTTP(w, req)
 		
-		assert.Equal(t, http.StatusCreated, w.Code)
-		
-		// Parse the response to get the video ID
-		// In a real test, we would parse the JSON response to extract the video ID
-		// For simplicity, we'll just verify that the video was added to the DB
-		assert.Contains(t, w.Body.String(), "success")
-		
-		// Since we can't easily extract the video ID from the response in this test,
-		// we'll just verify that there's at least one video in the DB now
-		videos := server.db.GetAllVideos()
-		assert.Greater(t, len(videos), 0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "fake video content for testing", w.Body.String())
 	})
 	
 	// Test getting latest video
@@ -97,6 +158,49 @@ func TestServer(t *testing.T) {
 	})
 }
 
+func TestUploadStoreFailure(t *testing.T) {
+	config := &Config{
+		ServerPort:  "0",
+		StoragePath: t.TempDir(),
+		MaxFileSize: 1024 * 1024,
+	}
+
+	store := NewMockVideoStore()
+	store.AddVideoErr = errors.New("disk full")
+	server := NewServer(config, store)
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "video.mp4")
+	require.NoError(t, err)
+	part.Write([]byte("content"))
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", "/api/videos", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The stored file must not be left behind without a record
+	assert.Empty(t, storedVideoFiles(t, config.StoragePath))
+}
+
+func TestDeleteVideoStoreFailure(t *testing.T) {
+	store := NewMockVideoStore()
+	store.Videos["id"] = &Video{ID: "id", Name: "video.mp4"}
+	store.DeleteVideoFails = true
+	server := NewServer(&Config{StoragePath: t.TempDir()}, store)
+
+	req, _ := http.NewRequest("DELETE", "/api/videos/id", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 1, store.CallCount("DeleteVideo"))
+}
+
 func TestParseRangeHeader(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -148,19 +252,75 @@ func TestParseRangeHeader(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			start, end, err := parseRangeHeader(tt.header, tt.fileSize)
+			ranges, err := parseRangeHeader(tt.header, tt.fileSize)
 			
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedStart, start)
-				assert.Equal(t, tt.expectedEnd, end)
+				assert.Equal(t, []RangePair{{Start: tt.expectedStart, End: tt.expectedEnd}}, ranges)
 			}
 		})
 	}
 }
 
+func TestParseMultiRangeHeader(t *testing.T) {
+	ranges, err := parseRangeHeader("bytes=0-9,90-99,-5", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []RangePair{{0, 9}, {90, 99}}, ranges, "overlapping ranges are merged")
+
+	ranges, err = parseRangeHeader("bytes=-200, 0-499", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []RangePair{{0, 499}, {800, 999}}, ranges, "ranges are sorted")
+
+	ranges, err = parseRangeHeader("bytes=0-9,10-19,30-39", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []RangePair{{0, 19}, {30, 39}}, ranges, "adjacent ranges are merged")
+
+	ranges, err = parseRangeHeader("bytes=0-,0-,0-", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []RangePair{{0, 99}}, ranges, "more than the file selects the file once")
+
+	_, err = parseRangeHeader("bytes="+strings.Repeat("0-0,", maxRanges)+"0-0", 100)
+	assert.Error(t, err, "too many ranges")
+	_, err = parseRangeHeader("bytes=0-9,abc", 100)
+	assert.Error(t, err)
+	_, err = parseRangeHeader("bytes=0-9,200-300", 100)
+	assert.Error(t, err)
+}
+
+func TestMultiRangeRequest(t *testing.T) {
+	server := newTestServer(t)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	video := uploadTestVideo(t, server, "multi.mp4", data)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil)
+	req.Header.Set("Range", "bytes=90-99,0-9,-5")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	expected := []RangePair{{0, 9}, {90, 99}}
+	for _, r := range expected {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("bytes %d-%d/100", r.Start, r.End), part.Header.Get("Content-Range"))
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, data[r.Start:r.End+1], body)
+	}
+	_, err = reader.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
 func TestSanitizeFilename(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -221,4 +381,479 @@ func TestInMemoryDB(t *testing.T) {
 	
 	_, exists = db.GetVideoByName("test-video.mp4")
 	assert.False(t, exists)
-}
\ No newline at end of file
+}
+
+func TestGracefulShutdownOnSIGTERM(t *testing.T) {
+	config := &Config{
+		ServerPort:      "0",
+		StoragePath:     t.TempDir(),
+		MaxFileSize:     1024 * 1024,
+		ShutdownTimeout: 2 * time.Second,
+	}
+	server := NewServer(config, NewMockVideoStore())
+
+	// Keep the default SIGTERM action from killing the test binary
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGTERM)
+	defer signal.Stop(guard)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run()
+	}()
+
+	// Run may not have registered its handler yet, so keep signalling until it exits
+	deadline := time.After(config.ShutdownTimeout + time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-runErr:
+			assert.Equal(t, http.ErrServerClosed, err)
+			return
+		case <-ticker.C:
+			require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+		case <-deadline:
+			t.Fatal("server did not shut down within the timeout")
+		}
+	}
+}
+
+func TestAllowedExtensions(t *testing.T) {
+	upload := func(server *Server, filename string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("fake video content"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req, _ := http.NewRequest("POST", "/api/videos", &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Allowed extension", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.AllowedExtensions = []string{".mp4", ".webm"}
+
+		w := upload(server, "clip.MP4")
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Disallowed extension", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.AllowedExtensions = []string{".mp4", ".webm"}
+
+		w := upload(server, "script.xyz")
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+		var resp struct {
+			Error     string   `json:"error"`
+			Extension string   `json:"extension"`
+			Allowed   []string `json:"allowed"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "file extension not allowed", resp.Error)
+		assert.Equal(t, ".xyz", resp.Extension)
+		assert.Equal(t, []string{".mp4", ".webm"}, resp.Allowed)
+		assert.Empty(t, server.db.GetAllVideos())
+	})
+
+	t.Run("No extension", func(t *testing.T) {
+		server := newTestServer(t)
+		server.config.AllowedExtensions = []string{".mp4"}
+
+		w := upload(server, "README")
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.Contains(t, w.Body.String(), `"extension":""`)
+	})
+
+	t.Run("Empty allowlist allows all", func(t *testing.T) {
+		server := newTestServer(t)
+
+		assert.Equal(t, http.StatusCreated, upload(server, "anything.xyz").Code)
+		assert.Equal(t, http.StatusCreated, upload(server, "README").Code)
+	})
+}
+
+func TestLogStartupConfig(t *testing.T) {
+	server := newTestServer(t)
+	server.config.DBBackend = "memory"
+	server.config.IncomingWebhookSecret = "super-secret-value"
+	uploadTestVideo(t, server, "startup.mp4", []byte("content"))
+
+	var buf bytes.Buffer
+	server.logger = zerolog.New(&buf)
+	server.logStartupConfig()
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+
+	for _, field := range []string{
+		"port", "storage_path", "db_backend", "max_file_size", "allowed_extensions",
+		"hash_cache_ttl", "shutdown_timeout", "max_webhooks_per_event", "max_total_webhooks",
+		"preload_concurrency", "incoming_webhooks_enabled", "incoming_webhook_secret", "videos_loaded",
+	} {
+		assert.Contains(t, entry, field)
+	}
+
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "memory", entry["db_backend"])
+	assert.Equal(t, float64(1), entry["videos_loaded"])
+	assert.Equal(t, "[REDACTED]", entry["incoming_webhook_secret"])
+	assert.NotContains(t, buf.String(), "super-secret-value")
+}
+
+func TestListVideosTotalSize(t *testing.T) {
+	server := newTestServer(t)
+
+	uploadTestVideo(t, server, "small.mp4", bytes.Repeat([]byte("a"), 10))
+	uploadTestVideo(t, server, "medium.mp4", bytes.Repeat([]byte("b"), 200))
+	uploadTestVideo(t, server, "large.mp4", bytes.Repeat([]byte("c"), 3000))
+
+	list := func(query string) map[string]interface{} {
+		req, _ := http.NewRequest("GET", "/api/videos"+query, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := list("")
+	assert.Equal(t, float64(3), resp["total"])
+	assert.Equal(t, float64(3210), resp["total_size_bytes"])
+	assert.Equal(t, float64(3210), resp["page_size_bytes"])
+
+	// A partial page still reports the total across all videos
+	resp = list("?limit=2&page=2")
+	assert.Equal(t, float64(3210), resp["total_size_bytes"])
+	videos := resp["videos"].([]interface{})
+	require.Len(t, videos, 1)
+	assert.Equal(t, videos[0].(map[string]interface{})["size"], resp["page_size_bytes"])
+}
+
+// cancelAfterWriter cancels a context once limit bytes have been written
+type cancelAfterWriter struct {
+	written int64
+	limit   int64
+	cancel  context.CancelFunc
+}
+
+func (w *cancelAfterWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.written >= w.limit {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestCopyRangeChunked(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 10000)
+
+	t.Run("Copies whole range", func(t *testing.T) {
+		var dst bytes.Buffer
+		n, err := copyRangeChunked(context.Background(), &dst, bytes.NewReader(data), 9000, 1024)
+		require.NoError(t, err)
+		assert.Equal(t, int64(9000), n)
+		assert.Equal(t, 9000, dst.Len())
+	})
+
+	t.Run("Stops after cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dst := &cancelAfterWriter{limit: 1024, cancel: cancel}
+		n, err := copyRangeChunked(ctx, dst, bytes.NewReader(data), 9000, 1024)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int64(1024), n, "no chunk should be copied after the client is gone")
+	})
+}
+
+func TestRangeRequestClientDisconnect(t *testing.T) {
+	server := newTestServer(t)
+	server.config.StreamChunkSize = 16 * 1024
+
+	video := uploadTestVideo(t, server, "large.mp4", bytes.Repeat([]byte("v"), 8*1024*1024))
+
+	handlerDone := make(chan struct{}, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.router.ServeHTTP(w, r)
+		handlerDone <- struct{}{}
+	}))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/api/videos/"+video.ID, nil)
+	req.Header.Set("Range", "bytes=0-")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	// Read a little, then drop the connection mid-range
+	_, err = io.ReadFull(resp.Body, make([]byte, 32*1024))
+	require.NoError(t, err)
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-handlerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("range handler kept streaming after the client disconnected")
+	}
+}
+
+func TestPanicRecoveryMiddleware(t *testing.T) {
+	server := newTestServer(t)
+
+	var buf bytes.Buffer
+	server.logger = zerolog.New(&buf)
+	server.router.GET("/panic", func(c *gin.Context) {
+		panic("secret internal detail")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error":"internal server error","code":"INTERNAL_ERROR"}`, w.Body.String())
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "secret internal detail", entry["panic_value"])
+	assert.Equal(t, "req-123", entry["request_id"])
+	assert.Contains(t, entry["stack_trace"], "runtime/debug.Stack")
+	assert.Contains(t, entry["stack_trace"], "TestPanicRecoveryMiddleware")
+}
+
+func TestContextLogger(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "logged.mp4", []byte("content"))
+
+	var buf bytes.Buffer
+	server.logger = zerolog.New(&buf)
+	server.authenticator = NewJWTAuthenticator("jwt-secret")
+	token := signHS256(t, "jwt-secret", map[string]interface{}{
+		"sub":       "user-1",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"tenant_id": "tenant-a",
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "req-456")
+	req.RemoteAddr = "203.0.113.7:4321"
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "req-456", w.Header().Get("X-Request-ID"))
+
+	// Both the handler's log and the request log carry the request's fields
+	var messages []string
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &entry))
+		messages = append(messages, entry["message"].(string))
+
+		assert.Equal(t, "req-456", entry["request_id"])
+		assert.Equal(t, "203.0.113.7", entry["client_ip"])
+		assert.Equal(t, "user-1", entry["principal"])
+		assert.Equal(t, "tenant-a", entry["tenant_id"])
+	}
+	assert.Equal(t, []string{"video deleted successfully", "request completed"}, messages)
+
+	// Requests without an ID are given one
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	server := newTestServer(t)
+	socketPath := filepath.Join(t.TempDir(), "server.sock")
+	server.config.ServerAddr = socketPath
+
+	// A stale socket from a previous run is replaced
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := server.listen()
+	require.NoError(t, err)
+	assert.Equal(t, "unix", listener.Addr().Network())
+
+	srv := &http.Server{Handler: server.router}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://unix/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestListenInterface(t *testing.T) {
+	server := newTestServer(t)
+	server.config.ServerAddr = "127.0.0.1"
+	server.config.ServerPort = "0"
+
+	listener, err := server.listen()
+	require.NoError(t, err)
+	defer listener.Close()
+
+	host, _, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+	assert.False(t, isUnixSocketAddr("127.0.0.1"))
+	assert.True(t, isUnixSocketAddr("./server.sock"))
+}
+
+func TestDirectDownloadContentType(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		contentType string
+		extension   string
+	}{
+		{"video/mp4", ".mp4"},
+		{"video/webm", ".webm"},
+		{"video/ogg", ".ogv"},
+		{"video/x-msvideo", ".avi"},
+		{"video/quicktime", ".mov"},
+		{"video/x-matroska", ".mkv"},
+		{"video/x-flv", ".flv"},
+		{"video/mpeg", ".mpeg"},
+		{"video/mp2t", ".ts"},
+		{"video/3gpp", ".3gp"},
+		{"video/x-ms-wmv", ".wmv"},
+		{"video/mp4; codecs=avc1", ".mp4"},
+		{"application/x-unknown", ".bin"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			assert.Equal(t, tt.extension, extensionForContentType(tt.contentType))
+
+			video := newTestVideo(fmt.Sprintf("direct-%d", i), 7)
+			video.ContentType = tt.contentType
+			require.NoError(t, os.WriteFile(server.getFilePath(video.ID, video.Name), []byte("content"), 0644))
+			require.NoError(t, server.db.AddVideo(video))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/download", nil)
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, `attachment; filename="`+video.ID+tt.extension+`"`, w.Header().Get("Content-Disposition"))
+			assert.Equal(t, "content", w.Body.String())
+		})
+	}
+
+	t.Run("Unknown video", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/missing/download", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestDirectDownloadContentTypeFromName(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name        string
+		stored      string
+		contentType string
+	}{
+		{"clip.webm", "video/webm", "video/webm"},
+		{"clip.mkv", "video/x-matroska", "video/x-matroska"},
+		// Stored without a type by clients that don't know the format
+		{"untyped.webm", "application/octet-stream", "video/webm"},
+		{"untyped.mkv", "", "video/x-matroska"},
+		{"untyped.dat", "", "application/octet-stream"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newTestVideo(fmt.Sprintf("typed-%d", i), 7)
+			video.Name = tt.name
+			video.ContentType = tt.stored
+			require.NoError(t, os.WriteFile(server.getFilePath(video.ID, video.Name), []byte("content"), 0644))
+			require.NoError(t, server.db.AddVideo(video))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/download", nil)
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, `attachment; filename="`+video.ID+extensionForContentType(tt.contentType)+`"`, w.Header().Get("Content-Disposition"))
+			assert.Equal(t, "binary", w.Header().Get("Content-Transfer-Encoding"))
+		})
+	}
+
+	t.Run("Streamed inline", func(t *testing.T) {
+		video := newTestVideo("inline", 7)
+		video.Name = "inline.webm"
+		video.ContentType = ""
+		require.NoError(t, os.WriteFile(server.getFilePath(video.ID, video.Name), []byte("content"), 0644))
+		require.NoError(t, server.db.AddVideo(video))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/inline", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "video/webm", w.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename=inline.webm`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "binary", w.Header().Get("Content-Transfer-Encoding"))
+	})
+}
+
+func TestUISecurityHeaders(t *testing.T) {
+	// The UI is served from the working directory
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	server := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<html></html>", w.Body.String())
+
+	assert.Equal(t, defaultCSPHeader, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "camera=(), microphone=()", w.Header().Get("Permissions-Policy"))
+
+	// The policy can be relaxed for development
+	server.config.CSPHeader = "default-src 'self' 'unsafe-inline'"
+	server.setupRoutes()
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "default-src 'self' 'unsafe-inline'", w.Header().Get("Content-Security-Policy"))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSizeBuckets = 10
+	maxSizeBuckets     = 100
+
+	// logBucketBase is the upper bound of the first log-scale bucket, 1 KB;
+	// each further bucket is ten times wider
+	logBucketBase = 1000
+)
+
+// SizeBucket is one bin of the video size histogram. MinBytes and MaxBytes
+// are inclusive.
+type SizeBucket struct {
+	MinBytes   int64 `json:"min_bytes"`
+	MaxBytes   int64 `json:"max_bytes"`
+	Count      int   `json:"count"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// quantileSizeBuckets splits sorted sizes into n groups of (nearly) equal
+// count, so the many small files spread over several buckets instead of
+// landing in the first one. Each bucket spans the sizes it holds; with
+// fewer sizes than n there are fewer buckets.
+func quantileSizeBuckets(sizes []int64, n int) []SizeBucket {
+	buckets := []SizeBucket{}
+	for i := 0; i < n; i++ {
+		group := sizes[i*len(sizes)/n : (i+1)*len(sizes)/n]
+		if len(group) == 0 {
+			continue
+		}
+
+		bucket := SizeBucket{MinBytes: group[0], MaxBytes: group[len(group)-1], Count: len(group)}
+		for _, size := range group {
+			bucket.TotalBytes += size
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// logSizeBuckets counts sorted sizes in buckets growing tenfold: under 1 KB,
+// 1-10 KB, 10-100 KB and so on up to the largest size. Empty buckets in
+// between are kept so the histogram has no gaps.
+func logSizeBuckets(sizes []int64) []SizeBucket {
+	buckets := []SizeBucket{}
+	if len(sizes) == 0 {
+		return buckets
+	}
+
+	largest := sizes[len(sizes)-1]
+	var min, next int64 = 0, logBucketBase
+	i := 0
+	for {
+		bucket := SizeBucket{MinBytes: min, MaxBytes: next - 1}
+		for ; i < len(sizes) && sizes[i] < next; i++ {
+			bucket.Count++
+			bucket.TotalBytes += sizes[i]
+		}
+		buckets = append(buckets, bucket)
+
+		if largest < next || next > math.MaxInt64/10 {
+			return buckets
+		}
+		min, next = next, next*10
+	}
+}
+
+// sizeDistributionHandler returns a histogram of video sizes for capacity
+// planning. Buckets hold equal numbers of videos, or with ?log_scale=true
+// cover tenfold size ranges.
+func (s *Server) sizeDistributionHandler(c *gin.Context) {
+	n := defaultSizeBuckets
+	if value := c.Query("buckets"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSizeBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "buckets must be between 1 and 100"})
+			return
+		}
+		n = parsed
+	}
+
+	videos := s.db.GetAllVideos()
+	sizes := make([]int64, 0, len(videos))
+	for _, video := range videos {
+		sizes = append(sizes, video.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	var buckets []SizeBucket
+	if c.Query("log_scale") == "true" {
+		buckets = logSizeBuckets(sizes)
+	} else {
+		buckets = quantileSizeBuckets(sizes, n)
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"buckets":     buckets,
+		"total":       len(sizes),
+		"total_bytes": sumVideoSizes(videos),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantileSizeBuckets(t *testing.T) {
+	sizes := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 1000}
+
+	assert.Equal(t, []SizeBucket{
+		{MinBytes: 1, MaxBytes: 2, Count: 2, TotalBytes: 3},
+		{MinBytes: 3, MaxBytes: 4, Count: 2, TotalBytes: 7},
+		{MinBytes: 5, MaxBytes: 6, Count: 2, TotalBytes: 11},
+		{MinBytes: 7, MaxBytes: 8, Count: 2, TotalBytes: 15},
+		{MinBytes: 9, MaxBytes: 1000, Count: 2, TotalBytes: 1009},
+	}, quantileSizeBuckets(sizes, 5))
+
+	t.Run("Uneven split", func(t *testing.T) {
+		buckets := quantileSizeBuckets(sizes, 3)
+		require.Len(t, buckets, 3)
+		assert.Equal(t, []int{3, 3, 4}, []int{buckets[0].Count, buckets[1].Count, buckets[2].Count})
+	})
+
+	t.Run("More buckets than videos", func(t *testing.T) {
+		assert.Len(t, quantileSizeBuckets([]int64{10, 20}, 5), 2)
+		assert.Empty(t, quantileSizeBuckets(nil, 5))
+	})
+}
+
+func TestLogSizeBuckets(t *testing.T) {
+	sizes := []int64{0, 500, 1000, 5000, 50000, 3000000}
+
+	assert.Equal(t, []SizeBucket{
+		{MinBytes: 0, MaxBytes: 999, Count: 2, TotalBytes: 500},
+		{MinBytes: 1000, MaxBytes: 9999, Count: 2, TotalBytes: 6000},
+		{MinBytes: 10000, MaxBytes: 99999, Count: 1, TotalBytes: 50000},
+		{MinBytes: 100000, MaxBytes: 999999, Count: 0, TotalBytes: 0},
+		{MinBytes: 1000000, MaxBytes: 9999999, Count: 1, TotalBytes: 3000000},
+	}, logSizeBuckets(sizes))
+
+	assert.Len(t, logSizeBuckets([]int64{999}), 1)
+	assert.Empty(t, logSizeBuckets(nil))
+}
+
+func TestSizeDistributionEndpoint(t *testing.T) {
+	server := newTestServer(t)
+	for i := 1; i <= 4; i++ {
+		server.db.AddVideo(newTestVideo(fmt.Sprintf("video-%d", i), int64(i*1000)))
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats/size-distribution"+query, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	var resp struct {
+		Buckets    []SizeBucket `json:"buckets"`
+		Total      int          `json:"total"`
+		TotalBytes int64        `json:"total_bytes"`
+	}
+
+	w := get("?buckets=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []SizeBucket{
+		{MinBytes: 1000, MaxBytes: 2000, Count: 2, TotalBytes: 3000},
+		{MinBytes: 3000, MaxBytes: 4000, Count: 2, TotalBytes: 7000},
+	}, resp.Buckets)
+	assert.Equal(t, 4, resp.Total)
+	assert.Equal(t, int64(10000), resp.TotalBytes)
+
+	w = get("?log_scale=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []SizeBucket{
+		{MinBytes: 0, MaxBytes: 999, Count: 0, TotalBytes: 0},
+		{MinBytes: 1000, MaxBytes: 9999, Count: 4, TotalBytes: 10000},
+	}, resp.Buckets)
+
+	for _, query := range []string{"?buckets=0", "?buckets=101", "?buckets=ten"} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// spriteDir is the directory under StoragePath holding sprite sheets
+	spriteDir = "sprites"
+
+	// spriteTileWidth is the width frames are scaled to before tiling
+	spriteTileWidth = 160
+
+	// spriteColumns is the maximum number of tiles per sprite row
+	spriteColumns = 10
+)
+
+// spriteGrid returns the number of columns and rows needed for count tiles
+func spriteGrid(count int) (int, int) {
+	if count <= 0 {
+		return 0, 0
+	}
+
+	columns := spriteColumns
+	if count < columns {
+		columns = count
+	}
+	rows := (count + columns - 1) / columns
+	return columns, rows
+}
+
+// spriteTileRect returns the position of tile index within a sprite sheet
+// laid out left to right, top to bottom
+func spriteTileRect(index, columns, tileWidth, tileHeight int) image.Rectangle {
+	x := (index % columns) * tileWidth
+	y := (index / columns) * tileHeight
+	return image.Rect(x, y, x+tileWidth, y+tileHeight)
+}
+
+// buildSpriteVTT returns a WebVTT track with one cue per tile, each pointing
+// at the tile's region of the sprite image via a #xywh= fragment
+func buildSpriteVTT(spriteURL string, count, interval, columns, tileWidth, tileHeight int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	step := time.Duration(interval) * time.Second
+	for i := 0; i < count; i++ {
+		rect := spriteTileRect(i, columns, tileWidth, tileHeight)
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(time.Duration(i)*step),
+			formatVTTTimestamp(time.Duration(i+1)*step),
+			spriteURL, rect.Min.X, rect.Min.Y, tileWidth, tileHeight)
+	}
+
+	return b.String()
+}
+
+// formatVTTTimestamp formats d as HH:MM:SS.mmm
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// generateSprites builds the sprite sheet and WebVTT track for a video and
+// records their URLs on the video. It runs in the background after upload.
+func (s *Server) generateSprites(videoID string) {
+	video, exists := s.db.GetVideoByID(videoID)
+	if !exists {
+		return
+	}
+
+	spritePath, vttPath := s.spritePaths(videoID)
+	sourcePath := s.getFilePath(videoID, video.Name)
+
+	if err := s.buildSpriteFiles(context.Background(), sourcePath, spritePath, vttPath, videoID); err != nil {
+		s.logger.Error().Err(err).Str("video_id", videoID).Msg("failed to generate sprite sheet")
+		return
+	}
+
+	// Only the sprite fields are set, so changes made while ffmpeg ran are kept
+	_, err := s.db.UpdateVideoFunc(videoID, func(current *Video) error {
+		current.SpriteURL = fmt.Sprintf("/api/videos/%s/sprite", videoID)
+		current.SpriteVTTURL = fmt.Sprintf("/api/videos/%s/sprite.vtt", videoID)
+		return nil
+	})
+	if errors.Is(err, ErrVideoNotFound) {
+		s.removeSprites(videoID)
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("video_id", videoID).Msg("failed to record sprite sheet")
+		s.removeSprites(videoID)
+		return
+	}
+
+	s.logger.Info().Str("video_id", videoID).Msg("sprite sheet generated")
+}
+
+// buildSpriteFiles extracts frames with ffmpeg, tiles them into spritePath
+// and writes the matching WebVTT track to vttPath
+func (s *Server) buildSpriteFiles(ctx context.Context, sourcePath, spritePath, vttPath, videoID string) error {
+	frameDir, err := os.MkdirTemp("", "sprite-frames-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(frameDir)
+
+	interval := s.config.SpriteInterval
+	if interval < 1 {
+		interval = 1
+	}
+
+	err = runFFmpeg(ctx, s.config.FFmpegPath,
+		"-y", "-i", sourcePath,
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:-2", interval, spriteTileWidth),
+		filepath.Join(frameDir, "frame_%05d.jpg"))
+	if err != nil {
+		return err
+	}
+
+	framePaths, err := filepath.Glob(filepath.Join(frameDir, "frame_*.jpg"))
+	if err != nil {
+		return err
+	}
+	if len(framePaths) == 0 {
+		return fmt.Errorf("ffmpeg produced no frames")
+	}
+	sort.Strings(framePaths)
+
+	frames := make([]image.Image, 0, len(framePaths))
+	for _, path := range framePaths {
+		frame, err := decodeJPEGFile(path)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+
+	// All tiles share the size of the first frame
+	tileWidth := frames[0].Bounds().Dx()
+	tileHeight := frames[0].Bounds().Dy()
+	columns, rows := spriteGrid(len(frames))
+
+	sprite := image.NewRGBA(image.Rect(0, 0, columns*tileWidth, rows*tileHeight))
+	for i, frame := range frames {
+		draw.Draw(sprite, spriteTileRect(i, columns, tileWidth, tileHeight), frame, frame.Bounds().Min, draw.Src)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(spritePath), 0755); err != nil {
+		return err
+	}
+
+	// Write both files via temporary files so readers never see partial output
+	err = writeFileAtomic(spritePath, func(f *os.File) error {
+		return jpeg.Encode(f, sprite, &jpeg.Options{Quality: 75})
+	})
+	if err != nil {
+		return err
+	}
+
+	spriteURL := fmt.Sprintf("/api/videos/%s/sprite", videoID)
+	vtt := buildSpriteVTT(spriteURL, len(frames), interval, columns, tileWidth, tileHeight)
+	return writeFileAtomic(vttPath, func(f *os.File) error {
+		_, err := f.WriteString(vtt)
+		return err
+	})
+}
+
+// decodeJPEGFile decodes the JPEG image at path
+func decodeJPEGFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return jpeg.Decode(file)
+}
+
+// writeFileAtomic writes path through a temporary file in the same directory
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// spritePaths returns the sprite image and WebVTT paths for a video
+func (s *Server) spritePaths(videoID string) (string, string) {
+	dir := filepath.Join(s.config.StoragePath, spriteDir)
+	return filepath.Join(dir, videoID+".jpg"), filepath.Join(dir, videoID+".vtt")
+}
+
+// removeSprites deletes the sprite sheet and WebVTT track for a video
+func (s *Server) removeSprites(videoID string) {
+	spritePath, vttPath := s.spritePaths(videoID)
+	for _, path := range []string{spritePath, vttPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Str("filepath", path).Msg("failed to delete sprite file")
+		}
+	}
+}
+
+// getSpriteHandler serves a video's sprite sheet image
+func (s *Server) getSpriteHandler(c *gin.Context) {
+	spritePath, _ := s.spritePaths(c.Param("id"))
+	s.serveSpriteFile(c, spritePath, "image/jpeg")
+}
+
+// getSpriteVTTHandler serves a video's sprite WebVTT track
+func (s *Server) getSpriteVTTHandler(c *gin.Context) {
+	_, vttPath := s.spritePaths(c.Param("id"))
+	s.serveSpriteFile(c, vttPath, "text/vtt")
+}
+
+// serveSpriteFile serves a generated sprite file if the video has one
+func (s *Server) serveSpriteFile(c *gin.Context, path, contentType string) {
+	video, exists := s.db.GetVideoByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+	if !requireVideoAccess(c, video, aclScopeRead) {
+		return
+	}
+
+	if video.SpriteURL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sprite sheet not available"})
+		return
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		getLogger(c).Error().Str("filepath", path).Msg("sprite file not found on disk")
+		c.JSON(http.StatusNotFound, gin.H{"error": "sprite sheet not available"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	http.ServeFile(c.Writer, c.Request, path)
+}
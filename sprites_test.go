@@ -0,0 +1,145 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpriteGrid(t *testing.T) {
+	tests := []struct {
+		count, columns, rows int
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{7, 7, 1},
+		{10, 10, 1},
+		{11, 10, 2},
+		{25, 10, 3},
+	}
+
+	for _, tt := range tests {
+		columns, rows := spriteGrid(tt.count)
+		assert.Equal(t, tt.columns, columns, "columns for %d tiles", tt.count)
+		assert.Equal(t, tt.rows, rows, "rows for %d tiles", tt.count)
+	}
+}
+
+func TestSpriteTileRect(t *testing.T) {
+	tests := []struct {
+		index int
+		want  image.Rectangle
+	}{
+		{0, image.Rect(0, 0, 160, 90)},
+		{1, image.Rect(160, 0, 320, 90)},
+		{9, image.Rect(1440, 0, 1600, 90)},
+		{10, image.Rect(0, 90, 160, 180)},
+		{23, image.Rect(480, 180, 640, 270)},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, spriteTileRect(tt.index, 10, 160, 90), "tile %d", tt.index)
+	}
+}
+
+func TestBuildSpriteVTT(t *testing.T) {
+	vtt := buildSpriteVTT("/api/videos/abc/sprite", 3, 10, 2, 160, 90)
+
+	expected := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\n/api/videos/abc/sprite#xywh=0,0,160,90\n" +
+		"\n00:00:10.000 --> 00:00:20.000\n/api/videos/abc/sprite#xywh=160,0,160,90\n" +
+		"\n00:00:20.000 --> 00:00:30.000\n/api/videos/abc/sprite#xywh=0,90,160,90\n"
+	assert.Equal(t, expected, vtt)
+
+	assert.Equal(t, "01:02:03.000", formatVTTTimestamp(time.Hour+2*time.Minute+3*time.Second))
+}
+
+// writeFakeSpriteFFmpeg installs a script standing in for ffmpeg that writes
+// count solid 16x9 JPEG frames into the directory of its output pattern
+func writeFakeSpriteFFmpeg(t *testing.T, count int) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	framesDir := filepath.Join(dir, "frames")
+	require.NoError(t, os.Mkdir(framesDir, 0755))
+
+	for i := 0; i < count; i++ {
+		frame := image.NewRGBA(image.Rect(0, 0, 16, 9))
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 16; x++ {
+				frame.Set(x, y, color.Gray{Y: uint8(i * 60)})
+			}
+		}
+
+		file, err := os.Create(filepath.Join(framesDir, "frame_0000"+string(rune('1'+i))+".jpg"))
+		require.NoError(t, err)
+		require.NoError(t, jpeg.Encode(file, frame, nil))
+		require.NoError(t, file.Close())
+	}
+
+	script := filepath.Join(dir, "ffmpeg")
+	content := `#!/bin/sh
+for out; do :; done
+cp "` + framesDir + `"/*.jpg "$(dirname "$out")"/
+`
+	require.NoError(t, os.WriteFile(script, []byte(content), 0755))
+	return script
+}
+
+func TestGenerateSprites(t *testing.T) {
+	server := newTestServer(t)
+	server.config.FFmpegPath = writeFakeSpriteFFmpeg(t, 3)
+	server.config.SpriteInterval = 5
+
+	video := uploadTestVideo(t, server, "sprite.mp4", []byte("not really a video"))
+
+	// No sprite until the job has run
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/sprite", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	server.generateSprites(video.ID)
+
+	updated, exists := server.db.GetVideoByID(video.ID)
+	require.True(t, exists)
+	assert.Equal(t, "/api/videos/"+video.ID+"/sprite", updated.SpriteURL)
+	assert.Equal(t, "/api/videos/"+video.ID+"/sprite.vtt", updated.SpriteVTTURL)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/sprite", nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+
+	sprite, err := jpeg.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 48, 9), sprite.Bounds())
+
+	req = httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID+"/sprite.vtt", nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "WEBVTT\n"))
+	assert.Contains(t, w.Body.String(), "00:00:10.000 --> 00:00:15.000\n/api/videos/"+video.ID+"/sprite#xywh=32,0,16,9")
+
+	// Deleting the video removes its sprite files
+	req = httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spritePath, vttPath := server.spritePaths(video.ID)
+	assert.NoFileExists(t, spritePath)
+	assert.NoFileExists(t, vttPath)
+}
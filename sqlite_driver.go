@@ -0,0 +1,5 @@
+package main
+
+// Registers the "sqlite" database/sql driver used by InMemorySQLiteDB and
+// SQLiteBackend
+import _ "modernc.org/sqlite"
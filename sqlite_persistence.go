@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sqliteBusyTimeout is how long a transaction waits for another writer to
+// release the database file
+const sqliteBusyTimeout = 5 * time.Second
+
+// sqlitePersistenceSchema holds each video as JSON, with the latest video ID
+// and the schema version the records were saved with in meta
+const sqlitePersistenceSchema = `
+CREATE TABLE IF NOT EXISTS videos (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// SQLiteBackend keeps the records of a persistent InMemoryDB in a SQLite
+// file. Saves write only the videos that changed, one row each, instead of
+// rewriting the whole database. Every save is a single BEGIN IMMEDIATE
+// transaction, which takes the write lock up front, so a concurrent writer
+// waits for it rather than failing halfway through.
+type SQLiteBackend struct {
+	db *sql.DB
+
+	// importPath is a JSON database loaded into an empty SQLite file, so
+	// deployments can switch backends without losing their records
+	importPath string
+}
+
+// NewSQLiteBackend opens the SQLite database at path, creating it if needed.
+// When it holds no records yet, the JSON database at importPath is imported
+// on Load; empty skips the import.
+func NewSQLiteBackend(path, importPath string) (*SQLiteBackend, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", path, sqliteBusyTimeout.Milliseconds())
+	db, err := sql.Open(sqliteDriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Saves come from the single background writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqlitePersistenceSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteBackend{db: db, importPath: importPath}, nil
+}
+
+// NewSQLitePersistentInMemoryDB creates an in-memory database that is loaded
+// from and saved to the SQLite file at path, importing the JSON database at
+// importPath on first use. Locking and saving work as for
+// NewPersistentInMemoryDB.
+func NewSQLitePersistentInMemoryDB(path, importPath string, lockTimeout time.Duration) (*InMemoryDB, error) {
+	return newPersistentInMemoryDB(path, lockTimeout, func() (persistenceBackend, error) {
+		return NewSQLiteBackend(path, importPath)
+	})
+}
+
+// immediate runs fn in a BEGIN IMMEDIATE transaction, committed when fn
+// returns nil and rolled back otherwise
+func (b *SQLiteBackend) immediate(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	err = fn(ctx, conn)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "COMMIT")
+	}
+	if err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+	}
+	return err
+}
+
+// putVideos inserts or replaces the rows of videos
+func putVideos(ctx context.Context, conn *sql.Conn, videos []Video) error {
+	for i := range videos {
+		data, err := json.Marshal(&videos[i])
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, `INSERT OR REPLACE INTO videos (id, data) VALUES (?, ?)`, videos[i].ID, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putMeta sets a meta value
+func putMeta(ctx context.Context, conn *sql.Conn, key, value string) error {
+	_, err := conn.ExecContext(ctx, `INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)`, key, value)
+	return err
+}
+
+// Load reads every record. A database that was never saved to returns the
+// imported JSON database, or nil when there is none.
+func (b *SQLiteBackend) Load() (*inMemorySnapshot, error) {
+	var version string
+	err := b.db.QueryRow(`SELECT value FROM meta WHERE key = 'schema_version'`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b.importJSON()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &inMemorySnapshot{}
+	if snapshot.SchemaVersion, err = strconv.Atoi(version); err != nil {
+		return nil, fmt.Errorf("invalid schema version %q: %w", version, err)
+	}
+	err = b.db.QueryRow(`SELECT value FROM meta WHERE key = 'latest_id'`).Scan(&snapshot.LatestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	rows, err := b.db.Query(`SELECT data FROM videos`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var video Video
+		if err := json.Unmarshal([]byte(data), &video); err != nil {
+			return nil, err
+		}
+		snapshot.Videos = append(snapshot.Videos, video)
+	}
+	return snapshot, rows.Err()
+}
+
+// importJSON copies the JSON database at importPath into SQLite and returns
+// it, nil when there is nothing to import. The JSON file is left in place.
+func (b *SQLiteBackend) importJSON() (*inMemorySnapshot, error) {
+	if b.importPath == "" {
+		return nil, nil
+	}
+	snapshot, err := newJSONBackend(b.importPath).Load()
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+
+	if err := b.Save(*snapshot); err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", b.importPath, err)
+	}
+	log.Info().Str("path", b.importPath).Int("videos", len(snapshot.Videos)).Msg("imported JSON database into SQLite")
+	return snapshot, nil
+}
+
+// Save replaces every record with snapshot
+func (b *SQLiteBackend) Save(snapshot inMemorySnapshot) error {
+	return b.immediate(func(ctx context.Context, conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM videos`); err != nil {
+			return err
+		}
+		if err := putVideos(ctx, conn, snapshot.Videos); err != nil {
+			return err
+		}
+		if err := putMeta(ctx, conn, "schema_version", strconv.Itoa(snapshot.SchemaVersion)); err != nil {
+			return err
+		}
+		return putMeta(ctx, conn, "latest_id", snapshot.LatestID)
+	})
+}
+
+// SaveChanges writes the changed videos and removes the deleted ones. The
+// records are in the current schema, which is recorded too, so a database
+// only ever saved to this way is not taken for an empty one on Load.
+func (b *SQLiteBackend) SaveChanges(changed []Video, deleted []string, latestID string) error {
+	return b.immediate(func(ctx context.Context, conn *sql.Conn) error {
+		if err := putVideos(ctx, conn, changed); err != nil {
+			return err
+		}
+		for _, id := range deleted {
+			if _, err := conn.ExecContext(ctx, `DELETE FROM videos WHERE id = ?`, id); err != nil {
+				return err
+			}
+		}
+		if err := putMeta(ctx, conn, "schema_version", strconv.Itoa(currentSchemaVersion)); err != nil {
+			return err
+		}
+		return putMeta(ctx, conn, "latest_id", latestID)
+	})
+}
+
+// Close closes the database file
+func (b *SQLiteBackend) Close() error {
+	return b.db.Close()
+}
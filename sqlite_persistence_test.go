@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestSQLitePersistentDB opens the SQLite-backed store in dir
+func openTestSQLitePersistentDB(t *testing.T, dir string) *InMemoryDB {
+	t.Helper()
+
+	db, err := NewSQLitePersistentInMemoryDB(filepath.Join(dir, sqliteDatabaseFile), filepath.Join(dir, jsonDatabaseFile), time.Second)
+	require.NoError(t, err)
+	return db
+}
+
+func TestSQLitePersistenceRestart(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestSQLitePersistentDB(t, dir)
+
+	older := newNamedVideo("older", "clip.mp4")
+	older.CreatedAt = time.Now().Add(-time.Hour)
+	require.NoError(t, db.AddVideo(older))
+	// Reuses the name, so the name index points at the newer video
+	require.NoError(t, db.AddVideo(newNamedVideo("newer", "clip.mp4")))
+	require.NoError(t, db.AddVideo(newTestVideo("renamed", 10)))
+	renamed := newNamedVideo("renamed", "final.mp4")
+	require.NoError(t, db.UpdateVideo(renamed))
+	require.NoError(t, db.AddVideo(newTestVideo("latest", 20)))
+	require.NoError(t, db.AddVideo(newTestVideo("deleted", 30)))
+	assert.True(t, db.DeleteVideo("deleted"))
+	require.NoError(t, db.Close())
+
+	db = openTestSQLitePersistentDB(t, dir)
+	defer db.Close()
+
+	assert.Len(t, db.GetAllVideos(), 4)
+	assert.Equal(t, map[string]string{
+		"clip.mp4":   "newer",
+		"final.mp4":  "renamed",
+		"latest.mp4": "latest",
+	}, db.nameIndex)
+	latest, exists := db.GetLatestVideo()
+	require.True(t, exists)
+	assert.Equal(t, "latest", latest.ID)
+	_, exists = db.GetVideoByID("deleted")
+	assert.False(t, exists)
+}
+
+func TestSQLitePersistenceImportsJSON(t *testing.T) {
+	dir := t.TempDir()
+	jsonDB, err := NewPersistentInMemoryDB(filepath.Join(dir, jsonDatabaseFile), time.Second)
+	require.NoError(t, err)
+	require.NoError(t, jsonDB.AddVideo(newTestVideo("a", 1)))
+	require.NoError(t, jsonDB.AddVideo(newTestVideo("b", 2)))
+	require.NoError(t, jsonDB.Close())
+
+	db := openTestSQLitePersistentDB(t, dir)
+	assert.Len(t, db.GetAllVideos(), 2)
+	require.NoError(t, db.AddVideo(newTestVideo("c", 3)))
+	require.NoError(t, db.Close())
+
+	// The JSON file is only read into an empty database
+	require.NoError(t, os.Remove(filepath.Join(dir, jsonDatabaseFile)))
+	db = openTestSQLitePersistentDB(t, dir)
+	defer db.Close()
+	assert.Len(t, db.GetAllVideos(), 3)
+	latest, _ := db.GetLatestVideo()
+	assert.Equal(t, "c", latest.ID)
+}
+
+// recordingBackend is an incremental backend that records the saves made
+type recordingBackend struct {
+	mutex   sync.Mutex
+	full    int
+	changed []string
+	deleted []string
+}
+
+func (b *recordingBackend) Load() (*inMemorySnapshot, error) {
+	return nil, nil
+}
+
+func (b *recordingBackend) Save(snapshot inMemorySnapshot) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.full++
+	return nil
+}
+
+func (b *recordingBackend) SaveChanges(changed []Video, deleted []string, latestID string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, video := range changed {
+		b.changed = append(b.changed, video.ID)
+	}
+	b.deleted = append(b.deleted, deleted...)
+	return nil
+}
+
+func (b *recordingBackend) Close() error {
+	return nil
+}
+
+func TestIncrementalSaves(t *testing.T) {
+	backend := &recordingBackend{}
+	db, err := newPersistentInMemoryDB(filepath.Join(t.TempDir(), "recorded"), time.Second, func() (persistenceBackend, error) {
+		return backend, nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddVideo(newTestVideo("a", 1)))
+	require.NoError(t, db.AddVideo(newTestVideo("b", 2)))
+	require.NoError(t, db.AddVideo(newTestVideo("c", 3)))
+	require.NoError(t, db.UpdateVideo(newTestVideo("a", 10)))
+	db.UpdateVideosFunc([]string{"b", "missing"}, func(video *Video) error {
+		video.Size *= 10
+		return nil
+	})
+	db.DeleteVideo("c")
+	require.NoError(t, db.Close())
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	assert.Zero(t, backend.full, "every write named the videos it changed")
+	assert.Subset(t, backend.changed, []string{"a", "b"})
+	assert.NotContains(t, backend.changed, "missing")
+	assert.Contains(t, backend.deleted, "c")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sqliteDriverName is the database/sql driver registered by modernc.org/sqlite,
+// see sqlite_driver.go
+const sqliteDriverName = "sqlite"
+
+// sqliteDatabaseCount names each in-memory database, so stores created in
+// the same process never share one
+var sqliteDatabaseCount atomic.Int64
+
+// sqliteSchema holds each video as JSON next to the columns searches filter
+// and sort on. added orders videos by insertion, for GetLatestVideo.
+const sqliteSchema = `
+CREATE TABLE videos (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	size       INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	added      INTEGER NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX videos_name ON videos (name);
+CREATE INDEX videos_size ON videos (size);
+CREATE INDEX videos_added ON videos (added);
+`
+
+// InMemorySQLiteDB is a VideoStore held in an in-memory SQLite database.
+// Searches are single SQL queries, so filters compose without maintaining
+// an index structure per filter. Nothing is persisted.
+type InMemorySQLiteDB struct {
+	db *sql.DB
+}
+
+// NewInMemorySQLiteDB creates an empty in-memory SQLite store
+func NewInMemorySQLiteDB() (*InMemorySQLiteDB, error) {
+	dsn := fmt.Sprintf("file:videos-%d?mode=memory", sqliteDatabaseCount.Add(1))
+	db, err := sql.Open(sqliteDriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to an in-memory database gets its own, empty one.
+	// Idle connections are never closed, so this one lives as long as db.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &InMemorySQLiteDB{db: db}, nil
+}
+
+// Close releases the database, discarding its contents
+func (s *InMemorySQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// AddVideo stores a video, replacing one with the same ID
+func (s *InMemorySQLiteDB) AddVideo(v *Video) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO videos (id, name, size, created_at, added, data)
+		VALUES (?, ?, ?, ?, (SELECT COALESCE(MAX(added), 0) + 1 FROM videos), ?)`,
+		v.ID, v.Name, v.Size, v.CreatedAt.UnixNano(), string(data))
+	return err
+}
+
+// UpdateVideo replaces an existing video record
+func (s *InMemorySQLiteDB) UpdateVideo(v *Video) error {
+	return updateSQLiteVideo(s.db.Exec, v)
+}
+
+// UpdateVideoFunc reads a video, applies update and stores the result in
+// one transaction
+func (s *InMemorySQLiteDB) UpdateVideoFunc(id string, update func(*Video) error) (*Video, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRow(`SELECT data FROM videos WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVideoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var video Video
+	if err := json.Unmarshal([]byte(data), &video); err != nil {
+		return nil, err
+	}
+
+	if err := update(&video); err != nil {
+		return nil, err
+	}
+	video.ID = id
+	if err := updateSQLiteVideo(tx.Exec, &video); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+// updateSQLiteVideo replaces an existing video record through exec, the
+// Exec of the database or of a transaction
+func updateSQLiteVideo(exec func(query string, args ...interface{}) (sql.Result, error), v *Video) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	result, err := exec(`UPDATE videos SET name = ?, size = ?, created_at = ?, data = ? WHERE id = ?`,
+		v.Name, v.Size, v.CreatedAt.UnixNano(), string(data), v.ID)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrVideoNotFound
+	}
+	return nil
+}
+
+// GetVideoByID retrieves a video by its ID
+func (s *InMemorySQLiteDB) GetVideoByID(id string) (*Video, bool) {
+	return s.queryVideo(`SELECT data FROM videos WHERE id = ?`, id)
+}
+
+// GetVideoByName retrieves the most recently added video with a name
+func (s *InMemorySQLiteDB) GetVideoByName(name string) (*Video, bool) {
+	return s.queryVideo(`SELECT data FROM videos WHERE name = ? ORDER BY added DESC LIMIT 1`, name)
+}
+
+// GetLatestVideo returns the most recently added video
+func (s *InMemorySQLiteDB) GetLatestVideo() (*Video, bool) {
+	return s.queryVideo(`SELECT data FROM videos ORDER BY added DESC LIMIT 1`)
+}
+
+// DeleteVideo removes a video
+func (s *InMemorySQLiteDB) DeleteVideo(id string) bool {
+	result, err := s.db.Exec(`DELETE FROM videos WHERE id = ?`, id)
+	if err != nil {
+		log.Error().Err(err).Str("video_id", id).Msg("failed to delete video from sqlite store")
+		return false
+	}
+	deleted, err := result.RowsAffected()
+	return err == nil && deleted > 0
+}
+
+// GetAllVideos returns all videos, newest first
+func (s *InMemorySQLiteDB) GetAllVideos() []*Video {
+	return s.queryVideos(`SELECT data FROM videos ORDER BY created_at DESC`)
+}
+
+// SearchVideos returns all videos matching the query, with a WHERE clause
+// built from the query's filters
+func (s *InMemorySQLiteDB) SearchVideos(query SearchQuery) []*Video {
+	var where []string
+	var args []interface{}
+
+	if query.Query != "" {
+		where = append(where, `lower(name) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLikePattern(strings.ToLower(query.Query))+"%")
+	}
+	if query.MinSize > 0 {
+		where = append(where, `size >= ?`)
+		args = append(args, query.MinSize)
+	}
+	if query.MaxSize > 0 {
+		where = append(where, `size <= ?`)
+		args = append(args, query.MaxSize)
+	}
+
+	stmt := `SELECT data FROM videos`
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	return s.queryVideos(stmt, args...)
+}
+
+// escapeLikePattern escapes the LIKE wildcards in s, using \ as the escape
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// queryVideo runs a query selecting at most one video's data
+func (s *InMemorySQLiteDB) queryVideo(query string, args ...interface{}) (*Video, bool) {
+	var data string
+	err := s.db.QueryRow(query, args...).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read video from sqlite store")
+		return nil, false
+	}
+
+	var video Video
+	if err := json.Unmarshal([]byte(data), &video); err != nil {
+		log.Error().Err(err).Msg("failed to decode video from sqlite store")
+		return nil, false
+	}
+	return &video, true
+}
+
+// queryVideos runs a query selecting the data of any number of videos
+func (s *InMemorySQLiteDB) queryVideos(query string, args ...interface{}) []*Video {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query videos in sqlite store")
+		return nil
+	}
+	defer rows.Close()
+
+	var videos []*Video
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			log.Error().Err(err).Msg("failed to read video from sqlite store")
+			return nil
+		}
+		var video Video
+		if err := json.Unmarshal([]byte(data), &video); err != nil {
+			log.Error().Err(err).Msg("failed to decode video from sqlite store")
+			return nil
+		}
+		videos = append(videos, &video)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("failed to query videos in sqlite store")
+		return nil
+	}
+	return videos
+}
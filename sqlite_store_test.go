@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSQLiteDB returns an empty SQLite store
+func newTestSQLiteDB(t *testing.T) *InMemorySQLiteDB {
+	t.Helper()
+
+	db, err := NewInMemorySQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteStoreMatchesInMemoryDB(t *testing.T) {
+	sqlite := newTestSQLiteDB(t)
+	memory := NewInMemoryDB()
+
+	rng := rand.New(rand.NewSource(1))
+	base := time.Now()
+	for i := 0; i < 1000; i++ {
+		video := newNamedVideo(fmt.Sprintf("video-%04d", i), fmt.Sprintf("Clip_%d%%_%s.mp4", i, []string{"Beach", "city", "forest"}[i%3]))
+		video.Size = rng.Int63n(1 << 30)
+		video.CreatedAt = base.Add(time.Duration(i) * time.Second)
+		require.NoError(t, sqlite.AddVideo(video))
+		require.NoError(t, memory.AddVideo(video))
+	}
+
+	queries := []SearchQuery{
+		{},
+		{Query: "beach"},
+		{Query: "BEACH", MinSize: 1 << 28, MaxSize: 1 << 29},
+		{Query: "1%_c"}, // LIKE wildcards are matched literally
+		{Query: "_", MaxSize: 1 << 20},
+		{MinSize: 1 << 29},
+		{Query: "missing"},
+	}
+	for _, query := range queries {
+		t.Run(fmt.Sprintf("%+v", query), func(t *testing.T) {
+			assert.Equal(t, videoIDs(memory.SearchVideos(query)), videoIDs(sqlite.SearchVideos(query)))
+		})
+	}
+
+	t.Run("All videos newest first", func(t *testing.T) {
+		videos := sqlite.GetAllVideos()
+		require.Len(t, videos, 1000)
+		assert.Equal(t, "video-0999", videos[0].ID)
+		assert.Equal(t, "video-0000", videos[999].ID)
+	})
+
+	t.Run("Updates and deletes", func(t *testing.T) {
+		video, exists := sqlite.GetVideoByID("video-0042")
+		require.True(t, exists)
+		video.Name = "renamed.mp4"
+		video.Tags = []string{"moved"}
+		require.NoError(t, sqlite.UpdateVideo(video))
+
+		renamed, exists := sqlite.GetVideoByName("renamed.mp4")
+		require.True(t, exists)
+		assert.Equal(t, []string{"moved"}, renamed.Tags)
+
+		assert.ErrorIs(t, sqlite.UpdateVideo(newTestVideo("missing", 1)), ErrVideoNotFound)
+
+		latest, exists := sqlite.GetLatestVideo()
+		require.True(t, exists)
+		assert.Equal(t, "video-0999", latest.ID)
+
+		assert.True(t, sqlite.DeleteVideo("video-0999"))
+		assert.False(t, sqlite.DeleteVideo("video-0999"))
+		latest, _ = sqlite.GetLatestVideo()
+		assert.Equal(t, "video-0998", latest.ID)
+	})
+}
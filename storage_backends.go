@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// localStorageBackend names primary storage under StoragePath
+const localStorageBackend = "local"
+
+// storageBackendHeader selects the storage backend of a single upload or
+// download
+const storageBackendHeader = "X-Storage-Backend"
+
+// parseStorageBackends opens the name=spec entries of StorageBackends
+func parseStorageBackends(entries []string) (map[string]FileStore, error) {
+	backends := make(map[string]FileStore, len(entries))
+	for _, entry := range entries {
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("storage backend %q must be name=spec", entry)
+		}
+		if name == localStorageBackend {
+			return nil, fmt.Errorf("storage backend name %q is reserved for primary storage", name)
+		}
+		store, err := newFileStore(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, fmt.Errorf("storage backend %s: %w", name, err)
+		}
+		backends[name] = store
+	}
+	return backends, nil
+}
+
+// storageBackend returns the file store of a named backend, nil for
+// primary storage
+func (s *Server) storageBackend(name string) (FileStore, bool) {
+	if name == "" || name == localStorageBackend {
+		return nil, true
+	}
+	store, exists := s.storageBackends[name]
+	return store, exists
+}
+
+// requestStorageBackend returns the backend named by the X-Storage-Backend
+// header, responding 403 unless the caller has the admin scope and 400 for
+// an unknown backend. The header is optional, without it name is empty.
+func (s *Server) requestStorageBackend(c *gin.Context) (name string, store FileStore, ok bool) {
+	name = strings.TrimSpace(c.GetHeader(storageBackendHeader))
+	if name == "" {
+		return "", nil, true
+	}
+
+	// As with video ACLs, there is no one to check when authentication is
+	// disabled
+	if principal := principalFrom(c); principal != nil && !slices.Contains(principal.Scopes, adminScope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storageBackendHeader + " requires the admin scope"})
+		return "", nil, false
+	}
+
+	store, exists := s.storageBackend(name)
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown storage backend %q", name)})
+		return "", nil, false
+	}
+	return name, store, true
+}
+
+// moveToStorageBackend moves a file staged on the local disk to store
+func moveToStorageBackend(store FileStore, filePath, key string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := store.Put(key, src); err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// serveFromStorageBackend writes a video file held by store, with range
+// support when the store's files are seekable
+func (s *Server) serveFromStorageBackend(c *gin.Context, store FileStore, videoID, name, contentType string) {
+	key := fileKey(videoID, name)
+	info, err := store.Stat(key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondNegotiated(c, http.StatusNotFound, gin.H{"error": "video file not found"})
+			return
+		}
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to stat video file in storage backend")
+		respondNegotiated(c, http.StatusInternalServerError, gin.H{"error": "failed to read video file"})
+		return
+	}
+
+	file, err := store.Open(key)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to open video file in storage backend")
+		respondNegotiated(c, http.StatusInternalServerError, gin.H{"error": "failed to read video file"})
+		return
+	}
+	defer file.Close()
+
+	s.recordDownloadEvent(c, videoID)
+	setVideoContentHeaders(c, contentType, inlineDisposition(name))
+
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime(), seeker)
+		return
+	}
+	c.Header("Content-Length", fmt.Sprintf("%d", info.Size()))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		getLogger(c).Debug().Err(err).Str("video_id", videoID).Msg("failed to stream video file from storage backend")
+	}
+}
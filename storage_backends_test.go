@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStorageBackends(t *testing.T) {
+	backends, err := parseStorageBackends([]string{"archive=local:/mnt/archive", " scratch = /tmp/scratch"})
+	require.NoError(t, err)
+	assert.Len(t, backends, 2)
+	assert.Contains(t, backends, "scratch")
+
+	for _, entries := range [][]string{{"archive"}, {"local=/tmp/other"}, {"s3=s3://bucket"}} {
+		_, err := parseStorageBackends(entries)
+		assert.Error(t, err, entries)
+	}
+}
+
+func TestStorageBackendHeader(t *testing.T) {
+	server := newTestServer(t)
+	archiveDir := t.TempDir()
+	backends, err := parseStorageBackends([]string{"archive=" + archiveDir})
+	require.NoError(t, err)
+	server.storageBackends = backends
+
+	server.authenticator = CompositeAuthenticator{
+		NewAPIKeyAuthenticator([]string{"viewer-key"}),
+		NewJWTAuthenticator("jwt-secret"),
+	}
+	admin := bearer(signHS256(t, "jwt-secret", map[string]interface{}{
+		"sub":   "ops",
+		"scope": "admin",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}))
+	viewer := map[string]string{apiKeyHeader: "viewer-key"}
+
+	upload := func(headers map[string]string, filename, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/videos", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	download := func(headers map[string]string, videoID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	with := func(headers map[string]string, backend string) map[string]string {
+		merged := map[string]string{storageBackendHeader: backend}
+		for name, value := range headers {
+			merged[name] = value
+		}
+		return merged
+	}
+
+	w := upload(with(admin, "archive"), "archived.mp4", "archived content")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var resp struct {
+		Video *Video `json:"video"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	video := resp.Video
+	assert.Equal(t, "archive", video.StorageBackend)
+
+	// The file is only in the selected backend
+	_, err = os.Stat(filepath.Join(archiveDir, fileKey(video.ID, video.Name)))
+	assert.NoError(t, err)
+	_, err = os.Stat(server.getFilePath(video.ID, video.Name))
+	assert.True(t, os.IsNotExist(err))
+
+	t.Run("Download from the same backend", func(t *testing.T) {
+		w := download(with(admin, "archive"), video.ID)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "archived content", w.Body.String())
+		assert.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("Download from the recorded backend", func(t *testing.T) {
+		w := download(viewer, video.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "archived content", w.Body.String())
+
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil)
+		req.Header.Set(apiKeyHeader, "viewer-key")
+		req.Header.Set("Range", "bytes=0-7")
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "archived", w.Body.String())
+	})
+
+	t.Run("Download from another backend", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, download(with(admin, localStorageBackend), video.ID).Code)
+	})
+
+	t.Run("Requires admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, upload(with(viewer, "archive"), "denied.mp4", "content").Code)
+		assert.Equal(t, http.StatusForbidden, download(with(viewer, "archive"), video.ID).Code)
+	})
+
+	t.Run("Unknown backend", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, upload(with(admin, "gcs"), "unknown.mp4", "content").Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+		for name, value := range admin {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		_, err := os.Stat(filepath.Join(archiveDir, fileKey(video.ID, video.Name)))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
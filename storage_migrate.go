@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageMigrationEntry is a video file a storage migration would move or
+// skip
+type StorageMigrationEntry struct {
+	ID                string `json:"id"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	DestinationExists bool   `json:"destination_exists"`
+	Reason            string `json:"reason,omitempty"` // why the file would be skipped
+}
+
+// StorageMigrationPlan is what migrating every video file to another
+// storage backend would do
+type StorageMigrationPlan struct {
+	WouldMigrate   []StorageMigrationEntry `json:"would_migrate"`
+	WouldSkip      []StorageMigrationEntry `json:"would_skip"`
+	EstimatedBytes int64                   `json:"estimated_bytes"` // size of the files to migrate
+}
+
+// fileLocation describes where store keeps key, the path for local stores
+func fileLocation(store FileStore, key string) string {
+	if local, ok := store.(*LocalFileStore); ok {
+		return filepath.Join(local.root, key)
+	}
+	return key
+}
+
+// planStorageMigration works out which video files would be moved from
+// primary storage to target without touching either
+func (s *Server) planStorageMigration(target FileStore) StorageMigrationPlan {
+	videos := s.db.GetAllVideos()
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].ID < videos[j].ID
+	})
+
+	plan := StorageMigrationPlan{
+		WouldMigrate: []StorageMigrationEntry{},
+		WouldSkip:    []StorageMigrationEntry{},
+	}
+	for _, video := range videos {
+		key := fileKey(video.ID, video.Name)
+		entry := StorageMigrationEntry{
+			ID:   video.ID,
+			From: s.getFilePath(video.ID, video.Name),
+			To:   fileLocation(target, key),
+		}
+
+		source, err := s.files.Stat(key)
+		if err != nil {
+			entry.Reason = "source file unavailable: " + err.Error()
+			plan.WouldSkip = append(plan.WouldSkip, entry)
+			continue
+		}
+
+		_, err = target.Stat(key)
+		switch {
+		case err == nil:
+			entry.DestinationExists = true
+		case !errors.Is(err, os.ErrNotExist):
+			entry.Reason = "destination unavailable: " + err.Error()
+			plan.WouldSkip = append(plan.WouldSkip, entry)
+			continue
+		}
+
+		plan.WouldMigrate = append(plan.WouldMigrate, entry)
+		plan.EstimatedBytes += source.Size()
+	}
+	return plan
+}
+
+// storageMigrateHandler previews moving every video file to another storage
+// backend. Only dry runs are supported, files are never moved.
+func (s *Server) storageMigrateHandler(c *gin.Context) {
+	var req struct {
+		Target string `json:"target" binding:"required"` // backend spec, see newFileStore
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return
+	}
+	if !dryRun {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "storage migration is not supported, use dry_run=true to preview it"})
+		return
+	}
+
+	target, err := newFileStore(req.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan := s.planStorageMigration(target)
+
+	getLogger(c).Info().
+		Str("target", req.Target).
+		Int("would_migrate", len(plan.WouldMigrate)).
+		Int("would_skip", len(plan.WouldSkip)).
+		Int64("estimated_bytes", plan.EstimatedBytes).
+		Msg("storage migration dry run")
+
+	s.respondSuccess(c, http.StatusOK, plan)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageMigrationDryRun(t *testing.T) {
+	server := newTestServer(t)
+	targetDir := t.TempDir()
+
+	fresh := uploadTestVideo(t, server, "fresh.mp4", []byte("12345"))
+	copied := uploadTestVideo(t, server, "copied.mp4", []byte("1234567"))
+	lost := uploadTestVideo(t, server, "lost.mp4", []byte("123"))
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, fileKey(copied.ID, copied.Name)), []byte("old"), 0644))
+	require.NoError(t, os.Remove(server.getFilePath(lost.ID, lost.Name)))
+
+	migrate := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/storage/migrate"+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := migrate("?dry_run=true", `{"target": "local:`+targetDir+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var plan StorageMigrationPlan
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &plan))
+
+	byID := make(map[string]StorageMigrationEntry)
+	for _, entry := range plan.WouldMigrate {
+		byID[entry.ID] = entry
+	}
+	require.Len(t, byID, 2)
+	assert.Equal(t, StorageMigrationEntry{
+		ID:   fresh.ID,
+		From: server.getFilePath(fresh.ID, fresh.Name),
+		To:   filepath.Join(targetDir, fileKey(fresh.ID, fresh.Name)),
+	}, byID[fresh.ID])
+	assert.True(t, byID[copied.ID].DestinationExists)
+	assert.Equal(t, int64(5+7), plan.EstimatedBytes)
+
+	require.Len(t, plan.WouldSkip, 1)
+	assert.Equal(t, lost.ID, plan.WouldSkip[0].ID)
+	assert.Contains(t, plan.WouldSkip[0].Reason, "source file unavailable")
+
+	// Nothing was moved
+	targetFiles, err := os.ReadDir(targetDir)
+	require.NoError(t, err)
+	assert.Len(t, targetFiles, 1)
+	for _, video := range []*Video{fresh, copied} {
+		_, err := os.Stat(server.getFilePath(video.ID, video.Name))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, http.StatusNotImplemented, migrate("", `{"target": "local:`+targetDir+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, migrate("?dry_run=true", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, migrate("?dry_run=true", `{"target": "s3://bucket"}`).Code)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrVideoNotFound is returned when updating a video that does not exist
+var ErrVideoNotFound = errors.New("video not found")
+
+// VideoStore is the video metadata store used by the server
+type VideoStore interface {
+	AddVideo(v *Video) error
+	UpdateVideo(v *Video) error
+	// UpdateVideoFunc applies update to a copy of the stored video and
+	// stores the result, with no other write to the video in between, and
+	// returns it. Nothing is stored when update fails. update must not use
+	// the store, and must replace rather than modify the video's slices and
+	// maps.
+	UpdateVideoFunc(id string, update func(*Video) error) (*Video, error)
+	GetVideoByID(id string) (*Video, bool)
+	GetVideoByName(name string) (*Video, bool)
+	GetLatestVideo() (*Video, bool)
+	GetAllVideos() []*Video
+	DeleteVideo(id string) bool
+	SearchVideos(query SearchQuery) []*Video
+}
+
+// videoRefGetter is implemented by stores that can lend out a stored video
+// without copying it
+type videoRefGetter interface {
+	GetVideoByIDRef(id string) (*Video, func(), bool)
+}
+
+// getVideoRef returns a video for read-only use, borrowing it without a copy
+// when the store supports it. release must be called once the caller is done
+// reading; it is never nil.
+func getVideoRef(db VideoStore, id string) (*Video, func(), bool) {
+	if getter, ok := db.(videoRefGetter); ok {
+		return getter.GetVideoByIDRef(id)
+	}
+
+	video, exists := db.GetVideoByID(id)
+	return video, func() {}, exists
+}
+
+// Files the metadata stores keep in StoragePath
+const (
+	jsonDatabaseFile   = "database.json"
+	boltDatabaseFile   = "videos.db"
+	sqliteDatabaseFile = "database.sqlite"
+)
+
+// newVideoStore creates the metadata store selected by Config.DBBackend
+func newVideoStore(config *Config) (VideoStore, error) {
+	switch config.DBBackend {
+	case "", "memory":
+		return NewInMemoryDB(), nil
+	case "json":
+		jsonPath := filepath.Join(config.StoragePath, jsonDatabaseFile)
+		switch config.PersistenceBackend {
+		case "", PersistenceJSON:
+			return NewPersistentInMemoryDB(jsonPath, config.DBLockTimeout)
+		case PersistenceSQLite:
+			return NewSQLitePersistentInMemoryDB(filepath.Join(config.StoragePath, sqliteDatabaseFile), jsonPath, config.DBLockTimeout)
+		default:
+			return nil, fmt.Errorf("unknown persistence backend: %s", config.PersistenceBackend)
+		}
+	case "bolt":
+		return NewBoltDBStore(filepath.Join(config.StoragePath, boltDatabaseFile), config.DBLockTimeout)
+	case "sqlite":
+		return NewInMemorySQLiteDB()
+	default:
+		return nil, fmt.Errorf("unknown database backend: %s", config.DBBackend)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operators accepted by SearchByTags
+const (
+	TagOperatorAnd = "AND"
+	TagOperatorOr  = "OR"
+)
+
+// normalizeTags splits comma separated tag values into a sorted list of
+// unique, lower-case tags
+func normalizeTags(values []string) []string {
+	seen := make(map[string]struct{})
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" {
+				continue
+			}
+			if _, exists := seen[tag]; exists {
+				continue
+			}
+			seen[tag] = struct{}{}
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// insertIntoTagIndex adds a video to the tag index
+func (db *InMemoryDB) insertIntoTagIndex(v *Video) {
+	for _, tag := range v.Tags {
+		ids, exists := db.tagIndex[tag]
+		if !exists {
+			ids = make(map[string]struct{})
+			db.tagIndex[tag] = ids
+		}
+		ids[v.ID] = struct{}{}
+	}
+}
+
+// removeFromTagIndex removes a video from the tag index, dropping tags that
+// no longer have any videos
+func (db *InMemoryDB) removeFromTagIndex(v *Video) {
+	for _, tag := range v.Tags {
+		ids := db.tagIndex[tag]
+		delete(ids, v.ID)
+		if len(ids) == 0 {
+			delete(db.tagIndex, tag)
+		}
+	}
+}
+
+// SearchByTags returns the videos tagged with all of tags ("AND") or with
+// any of them ("OR"). AND queries start from the smallest tag set and check
+// its IDs against the others, so they cost the size of the rarest tag rather
+// than the whole database.
+func (db *InMemoryDB) SearchByTags(tags []string, operator string) []*Video {
+	tags = normalizeTags(tags)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	sets := make([]map[string]struct{}, 0, len(tags))
+	for _, tag := range tags {
+		ids, exists := db.tagIndex[tag]
+		if !exists && operator == TagOperatorAnd {
+			return nil
+		}
+		if exists {
+			sets = append(sets, ids)
+		}
+	}
+
+	var ids []string
+	if operator == TagOperatorAnd {
+		sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	candidates:
+		for id := range sets[0] {
+			for _, set := range sets[1:] {
+				if _, exists := set[id]; !exists {
+					continue candidates
+				}
+			}
+			ids = append(ids, id)
+		}
+	} else {
+		union := make(map[string]struct{})
+		for _, set := range sets {
+			for id := range set {
+				if _, exists := union[id]; !exists {
+					union[id] = struct{}{}
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	videos := make([]*Video, 0, len(ids))
+	for _, id := range ids {
+		videoCopy := *db.videos[id]
+		videos = append(videos, &videoCopy)
+	}
+	return videos
+}
+
+// tagSearcher is implemented by stores with a tag index
+type tagSearcher interface {
+	SearchByTags(tags []string, operator string) []*Video
+}
+
+// searchVideosByTags runs a tag query, using the store's tag index when it
+// has one and scanning every video otherwise
+func searchVideosByTags(db VideoStore, tags []string, operator string) []*Video {
+	if searcher, ok := db.(tagSearcher); ok {
+		return searcher.SearchByTags(tags, operator)
+	}
+
+	tags = normalizeTags(tags)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var videos []*Video
+	for _, video := range db.GetAllVideos() {
+		if videoMatchesTags(video, tags, operator) {
+			videos = append(videos, video)
+		}
+	}
+	return videos
+}
+
+// videoMatchesTags reports whether a video matches a normalized tag query
+func videoMatchesTags(video *Video, tags []string, operator string) bool {
+	matched := 0
+	for _, tag := range tags {
+		i := sort.SearchStrings(video.Tags, tag)
+		if i < len(video.Tags) && video.Tags[i] == tag {
+			matched++
+		}
+	}
+
+	if operator == TagOperatorAnd {
+		return matched == len(tags)
+	}
+	return matched > 0
+}
+
+// searchVideosHandler finds videos by tag. Repeat tag= to query several
+// tags, combined according to tag_op (AND by default, or OR).
+func (s *Server) searchVideosHandler(c *gin.Context) {
+	tags := normalizeTags(c.QueryArray("tag"))
+	if len(tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one tag is required"})
+		return
+	}
+
+	operator := strings.ToUpper(c.DefaultQuery("tag_op", TagOperatorAnd))
+	if operator != TagOperatorAnd && operator != TagOperatorOr {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag_op must be AND or OR"})
+		return
+	}
+
+	videos := searchVideosByTags(s.db, tags, operator)
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success": true,
+		"videos":  videos,
+		"total":   len(videos),
+		"tags":    tags,
+		"tag_op":  operator,
+	})
+}
+
+// tagDifference returns the tags in a that are not in b, never nil
+func tagDifference(a, b []string) []string {
+	exclude := make(map[string]struct{}, len(b))
+	for _, tag := range b {
+		exclude[tag] = struct{}{}
+	}
+	diff := []string{}
+	for _, tag := range a {
+		if _, exists := exclude[tag]; !exists {
+			diff = append(diff, tag)
+		}
+	}
+	return diff
+}
+
+// notifyTagChanges fires video.tags_added and video.tags_removed for the
+// tags that differ between old and updated
+func (s *Server) notifyTagChanges(ctx context.Context, old, updated *Video) {
+	added := tagDifference(updated.Tags, old.Tags)
+	removed := tagDifference(old.Tags, updated.Tags)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	current := append([]string{}, updated.Tags...)
+	payload := VideoTagsChangedPayload{
+		SchemaVersion: WebhookPayloadSchemaVersion,
+		Timestamp:     updated.UpdatedAt.Unix(),
+		VideoID:       updated.ID,
+		TagsAdded:     added,
+		TagsRemoved:   removed,
+		CurrentTags:   current,
+		collectionID:  updated.CollectionID,
+	}
+	if len(added) > 0 {
+		payload.Event = EventVideoTagsAdded
+		s.webhookMgr.NotifyWebhooksContext(ctx, EventVideoTagsAdded, payload)
+		s.publishEvent(EventVideoTagsAdded, payload)
+	}
+	if len(removed) > 0 {
+		payload.Event = EventVideoTagsRemoved
+		s.webhookMgr.NotifyWebhooksContext(ctx, EventVideoTagsRemoved, payload)
+		s.publishEvent(EventVideoTagsRemoved, payload)
+	}
+}
+
+// updateVideoTagsHandler adds and removes tags on a single video, with the
+// same body as the tags of a batch update
+func (s *Server) updateVideoTagsHandler(c *gin.Context) {
+	var update VideoMetadataUpdate
+	if err := c.ShouldBindJSON(&update.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(update.Tags.Add) == 0 && len(update.Tags.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "add or remove is required"})
+		return
+	}
+
+	videoID := c.Param("id")
+	var video *Video
+	updated, err := s.db.UpdateVideoFunc(videoID, func(current *Video) error {
+		original := *current
+		video = &original
+		*current = *update.apply(&original, time.Now())
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrVideoNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+			return
+		}
+		getLogger(c).Error().Err(err).Str("video_id", videoID).Msg("failed to update tags")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tags"})
+		return
+	}
+
+	s.recordMetadataEvents(c, video, updated)
+	s.notifyTagChanges(c.Request.Context(), video, updated)
+
+	getLogger(c).Info().
+		Str("video_id", videoID).
+		Strs("tags", updated.Tags).
+		Msg("video tags updated")
+
+	s.respondSuccess(c, http.StatusOK, updated)
+}
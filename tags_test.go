@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTaggedVideo(id string, tags ...string) *Video {
+	video := newTestVideo(id, 1)
+	video.Tags = normalizeTags(tags)
+	return video
+}
+
+func videoIDs(videos []*Video) []string {
+	ids := make([]string, 0, len(videos))
+	for _, v := range videos {
+		ids = append(ids, v.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestNormalizeTags(t *testing.T) {
+	assert.Equal(t, []string{"2024", "production"}, normalizeTags([]string{" Production ,2024", "production", ""}))
+	assert.Empty(t, normalizeTags([]string{" , "}))
+}
+
+func TestSearchByTags(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newTaggedVideo("a", "production", "2024"))
+	db.AddVideo(newTaggedVideo("b", "production", "2023"))
+	db.AddVideo(newTaggedVideo("c", "staging", "2024"))
+	db.AddVideo(newTaggedVideo("d"))
+
+	assert.Equal(t, []string{"a"}, videoIDs(db.SearchByTags([]string{"production", "2024"}, TagOperatorAnd)))
+	assert.Equal(t, []string{"a", "b", "c"}, videoIDs(db.SearchByTags([]string{"production", "2024"}, TagOperatorOr)))
+	assert.Equal(t, []string{"a", "b"}, videoIDs(db.SearchByTags([]string{"PRODUCTION"}, TagOperatorAnd)))
+	assert.Empty(t, db.SearchByTags([]string{"production", "unknown"}, TagOperatorAnd))
+	assert.Equal(t, []string{"c"}, videoIDs(db.SearchByTags([]string{"staging", "unknown"}, TagOperatorOr)))
+	assert.Empty(t, db.SearchByTags(nil, TagOperatorOr))
+
+	// Updates and deletes keep the index in sync
+	updated := newTaggedVideo("b", "staging")
+	require.NoError(t, db.UpdateVideo(updated))
+	assert.Equal(t, []string{"a"}, videoIDs(db.SearchByTags([]string{"production"}, TagOperatorAnd)))
+	assert.Equal(t, []string{"b", "c"}, videoIDs(db.SearchByTags([]string{"staging"}, TagOperatorAnd)))
+
+	assert.True(t, db.DeleteVideo("c"))
+	assert.Equal(t, []string{"b"}, videoIDs(db.SearchByTags([]string{"staging"}, TagOperatorAnd)))
+	_, exists := db.tagIndex["2023"]
+	assert.False(t, exists, "tags without videos should be dropped from the index")
+}
+
+func TestSearchVideosByTagsFallback(t *testing.T) {
+	store := NewMockVideoStore()
+	store.AddVideo(newTaggedVideo("a", "production", "2024"))
+	store.AddVideo(newTaggedVideo("b", "production"))
+
+	assert.Equal(t, []string{"a"}, videoIDs(searchVideosByTags(store, []string{"production", "2024"}, TagOperatorAnd)))
+	assert.Equal(t, []string{"a", "b"}, videoIDs(searchVideosByTags(store, []string{"production", "2024"}, TagOperatorOr)))
+}
+
+func TestSearchVideosHandler(t *testing.T) {
+	server := newTestServer(t)
+
+	upload := func(filename, tags string) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("tags", tags)
+		part, err := form.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		part.Write([]byte("content"))
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/videos", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+	upload("both.mp4", "Production, 2024")
+	upload("prod.mp4", "production")
+
+	search := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/search?"+query, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		var response struct {
+			Videos []*Video `json:"videos"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		names := make([]string, 0, len(response.Videos))
+		for _, v := range response.Videos {
+			names = append(names, v.Name)
+		}
+		sort.Strings(names)
+		return w.Code, names
+	}
+
+	code, names := search("tag=production&tag=2024")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"both.mp4"}, names)
+
+	code, names = search("tag=production&tag=2024&tag_op=or")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"both.mp4", "prod.mp4"}, names)
+
+	code, _ = search("tag=production&tag_op=XOR")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = search("")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func patchTags(t *testing.T, server *Server, videoID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/videos/"+videoID+"/tags", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateVideoTagsHandler(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("a", "draft")))
+
+	w := patchTags(t, server, "a", `{"add":["Final"],"remove":["draft"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var video Video
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &video))
+	assert.Equal(t, []string{"final"}, video.Tags)
+
+	assert.Equal(t, http.StatusNotFound, patchTags(t, server, "missing", `{"add":["final"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, patchTags(t, server, "a", `{}`).Code)
+}
+
+func TestTagWebhooks(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("a", "draft")))
+	require.NoError(t, server.db.AddVideo(newTaggedVideo("b", "draft")))
+
+	added := newWebhookReceiver(t)
+	removed := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook(string(EventVideoTagsAdded), added.server.URL))
+	require.NoError(t, server.webhookMgr.AddWebhook(string(EventVideoTagsRemoved), removed.server.URL))
+
+	t.Run("Tags added", func(t *testing.T) {
+		w := patchTags(t, server, "a", `{"add":["Final","draft","2024"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		require.Equal(t, 1, added.count())
+		assert.Equal(t, 0, removed.count(), "nothing was removed")
+
+		payload := added.payloads[0]
+		assert.Equal(t, "video.tags_added", payload["event"])
+		assert.Equal(t, "a", payload["video_id"])
+		assert.Equal(t, []interface{}{"2024", "final"}, payload["tags_added"])
+		assert.Equal(t, []interface{}{}, payload["tags_removed"])
+		assert.Equal(t, []interface{}{"2024", "draft", "final"}, payload["current_tags"])
+	})
+
+	t.Run("Tags removed", func(t *testing.T) {
+		w := patchTags(t, server, "a", `{"remove":["DRAFT"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		assert.Equal(t, 1, added.count())
+		require.Equal(t, 1, removed.count())
+		assert.Equal(t, []interface{}{"draft"}, removed.payloads[0]["tags_removed"])
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		w := patchTags(t, server, "a", `{"add":["final"],"remove":["missing"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		assert.Equal(t, 1, added.count())
+		assert.Equal(t, 1, removed.count())
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		code, _ := batchUpdate(t, server, `{"ids":["a","b"],"updates":{"tags":{"add":["reviewed"],"remove":["draft"]}}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		// Only b had draft to remove
+		assert.Equal(t, 3, added.count())
+		require.Equal(t, 2, removed.count())
+		assert.Equal(t, "b", removed.payloads[1]["video_id"])
+		assert.Equal(t, []interface{}{"reviewed"}, removed.payloads[1]["tags_added"])
+	})
+}
+
+// newTagBenchmarkDB tags every video with one common tag and a tag shared by
+// a tenth of the videos
+func newTagBenchmarkDB(n int) *InMemoryDB {
+	db := NewInMemoryDB()
+	for i := 0; i < n; i++ {
+		db.AddVideo(newTaggedVideo(fmt.Sprintf("video-%d", i), "common", fmt.Sprintf("group-%d", i%10)))
+	}
+	return db
+}
+
+func BenchmarkTagSearchFullScan(b *testing.B) {
+	db := newTagBenchmarkDB(10000)
+	tags := []string{"common", "group-3"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var matches []*Video
+		for _, video := range db.GetAllVideos() {
+			if videoMatchesTags(video, tags, TagOperatorAnd) {
+				matches = append(matches, video)
+			}
+		}
+		_ = matches
+	}
+}
+
+func BenchmarkTagSearchIntersection(b *testing.B) {
+	db := newTagBenchmarkDB(10000)
+	tags := []string{"common", "group-3"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = db.SearchByTags(tags, TagOperatorAnd)
+	}
+}
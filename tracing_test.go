@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// traceHeaderReceiver records the traceparent header of each webhook
+func traceHeaderReceiver(t *testing.T) (*httptest.Server, chan string) {
+	t.Helper()
+
+	traceparents := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, traceparents
+}
+
+func TestWebhookTracePropagation(t *testing.T) {
+	server := newTestServer(t)
+	receiver, traceparents := traceHeaderReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", receiver.URL))
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "upload")
+	server.webhookMgr.NotifyWebhooksContext(ctx, "video.uploaded", map[string]string{"video_id": "abc"})
+	span.End()
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	traceparent := <-traceparents
+	assert.Equal(t, "00-"+spans[0].SpanContext.TraceID().String()+"-"+spans[0].SpanContext.SpanID().String()+"-01", traceparent)
+
+	// Webhooks without a trace context carry no header
+	server.webhookMgr.NotifyWebhooks("video.uploaded", map[string]string{"video_id": "def"})
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+	assert.Empty(t, <-traceparents)
+}
+
+func TestUploadContinuesCallerTrace(t *testing.T) {
+	server := newTestServer(t)
+	receiver, traceparents := traceHeaderReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", receiver.URL))
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	w := uploadWithHeaders(t, server, "traced.mp4", []byte("content"), map[string]string{"traceparent": traceparent})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+	assert.Equal(t, traceparent, <-traceparents)
+}
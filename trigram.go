@@ -0,0 +1,120 @@
+package main
+
+import "strings"
+
+// trigrams returns the distinct three-character substrings of s, which must
+// already be lower-cased
+func trigrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(runes)-2)
+	result := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if _, exists := seen[gram]; !exists {
+			seen[gram] = struct{}{}
+			result = append(result, gram)
+		}
+	}
+	return result
+}
+
+// insertIntoTrigramIndex adds a video's name to the trigram index
+func (db *InMemoryDB) insertIntoTrigramIndex(v *Video) {
+	for _, gram := range trigrams(strings.ToLower(v.Name)) {
+		ids, exists := db.trigramIndex[gram]
+		if !exists {
+			ids = make(map[string]struct{})
+			db.trigramIndex[gram] = ids
+		}
+		ids[v.ID] = struct{}{}
+	}
+}
+
+// removeFromTrigramIndex removes a video's name from the trigram index
+func (db *InMemoryDB) removeFromTrigramIndex(v *Video) {
+	for _, gram := range trigrams(strings.ToLower(v.Name)) {
+		ids := db.trigramIndex[gram]
+		delete(ids, v.ID)
+		if len(ids) == 0 {
+			delete(db.trigramIndex, gram)
+		}
+	}
+}
+
+// trigramCandidates returns the IDs of videos whose names contain every
+// trigram of needle, a superset of the names containing needle. ok is false
+// when needle is too short to use the index. The caller must hold the lock.
+func (db *InMemoryDB) trigramCandidates(needle string) (ids map[string]struct{}, ok bool) {
+	grams := trigrams(needle)
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	// Start from the rarest trigram and intersect the others into it
+	sets := make([]map[string]struct{}, 0, len(grams))
+	for _, gram := range grams {
+		set, exists := db.trigramIndex[gram]
+		if !exists {
+			return map[string]struct{}{}, true
+		}
+		sets = append(sets, set)
+	}
+	smallest := 0
+	for i, set := range sets {
+		if len(set) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+
+	ids = make(map[string]struct{}, len(sets[smallest]))
+candidates:
+	for id := range sets[smallest] {
+		for i, set := range sets {
+			if i == smallest {
+				continue
+			}
+			if _, exists := set[id]; !exists {
+				continue candidates
+			}
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, true
+}
+
+// SearchVideosByTrigram returns videos whose name contains q, ignoring case.
+// Queries of three or more characters are answered from the trigram index;
+// shorter ones fall back to a scan.
+func (db *InMemoryDB) SearchVideosByTrigram(q string) []*Video {
+	needle := strings.ToLower(q)
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	ids, ok := db.trigramCandidates(needle)
+	if !ok {
+		var videos []*Video
+		for _, video := range db.videos {
+			if strings.Contains(strings.ToLower(video.Name), needle) {
+				videoCopy := *video
+				videos = append(videos, &videoCopy)
+			}
+		}
+		return videos
+	}
+
+	// Sharing every trigram does not guarantee a substring match, so check
+	var videos []*Video
+	for id := range ids {
+		video := db.videos[id]
+		if strings.Contains(strings.ToLower(video.Name), needle) {
+			videoCopy := *video
+			videos = append(videos, &videoCopy)
+		}
+	}
+	return videos
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNamedVideo(id, name string) *Video {
+	video := newTestVideo(id, 1)
+	video.Name = name
+	return video
+}
+
+func TestTrigrams(t *testing.T) {
+	assert.Nil(t, trigrams("ab"))
+	assert.Equal(t, []string{"abc"}, trigrams("abc"))
+	assert.Equal(t, []string{"aaa"}, trigrams("aaaa"))
+	assert.Equal(t, []string{"hél", "éll", "llo"}, trigrams("héllo"))
+}
+
+func TestSearchVideosByTrigram(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newNamedVideo("a", "Holiday_Beach.mp4"))
+	db.AddVideo(newNamedVideo("b", "beach-volleyball.webm"))
+	db.AddVideo(newNamedVideo("c", "mountain.mp4"))
+	db.AddVideo(newNamedVideo("d", "x.mov"))
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"v", []string{"b", "d"}},          // single character, scanned
+		{"mp", []string{"a", "c"}},         // two characters, scanned
+		{"beach", []string{"a", "b"}},      // indexed, case-insensitive
+		{"BEACH.MP4", []string{"a"}},       // indexed
+		{"mountain.mp4", []string{"c"}},    // whole name
+		{"each-v", []string{"b"}},          // punctuation
+		{"hcaeb", []string{}},              // no such trigrams
+		{"ach.mp4.mp4", []string{}},        // every trigram known, no substring match
+		{"", []string{"a", "b", "c", "d"}}, // empty query matches everything
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.expected, videoIDs(db.SearchVideosByTrigram(tt.query)))
+			assert.Equal(t, tt.expected, videoIDs(db.SearchVideos(SearchQuery{Query: tt.query})))
+		})
+	}
+
+	// Size bounds still apply on top of the trigram index
+	assert.Equal(t, []string{"a", "b"}, videoIDs(db.SearchVideos(SearchQuery{Query: "beach", MinSize: 1, MaxSize: 1})))
+}
+
+func TestTrigramIndexMaintenance(t *testing.T) {
+	db := NewInMemoryDB()
+	db.AddVideo(newNamedVideo("a", "first.mp4"))
+
+	// Renames move the video to the new name's trigrams
+	require.NoError(t, db.UpdateVideo(newNamedVideo("a", "second.mp4")))
+	assert.Empty(t, db.SearchVideosByTrigram("first"))
+	assert.Equal(t, []string{"a"}, videoIDs(db.SearchVideosByTrigram("second")))
+
+	// Re-adding an ID replaces its old name
+	db.AddVideo(newNamedVideo("a", "third.mp4"))
+	assert.Empty(t, db.SearchVideosByTrigram("second"))
+	assert.Equal(t, []string{"a"}, videoIDs(db.SearchVideosByTrigram("third")))
+
+	assert.True(t, db.DeleteVideo("a"))
+	assert.Empty(t, db.SearchVideosByTrigram("third"))
+	assert.Empty(t, db.trigramIndex, "trigrams without videos should be dropped")
+}
+
+// newTrigramBenchmarkDB names videos from a small vocabulary so queries
+// match a realistic fraction of them
+func newTrigramBenchmarkDB(n int) *InMemoryDB {
+	words := []string{"holiday", "beach", "mountain", "family", "concert", "drone", "wedding", "sunset"}
+	db := NewInMemoryDB()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s_%s_%05d.mp4", words[i%len(words)], words[(i/len(words))%len(words)], i)
+		db.AddVideo(newNamedVideo(fmt.Sprintf("video-%d", i), name))
+	}
+	return db
+}
+
+func BenchmarkNameSearchLinear(b *testing.B) {
+	db := newTrigramBenchmarkDB(10000)
+	needle := "wedding_sunset"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var matches []*Video
+		for _, video := range db.GetAllVideos() {
+			if strings.Contains(strings.ToLower(video.Name), needle) {
+				matches = append(matches, video)
+			}
+		}
+		_ = matches
+	}
+}
+
+func BenchmarkNameSearchTrigram(b *testing.B) {
+	db := newTrigramBenchmarkDB(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = db.SearchVideosByTrigram("wedding_sunset")
+	}
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// tusVersion is the only TUS protocol version served
+	tusVersion = "1.0.0"
+
+	// tusUploadDir is the directory under StoragePath holding resumable
+	// uploads, an <id>.info record and <id>.part data file each
+	tusUploadDir = "tus"
+
+	// tusUploadPath is where the upload URLs returned in Location start
+	tusUploadPath = "/api/videos/tus/"
+
+	// tusContentType is the only content type PATCH requests may send
+	tusContentType = "application/offset+octet-stream"
+
+	// tusVideoIDHeader names the video a finished upload was stored as, on
+	// the response to the PATCH request that finished it
+	tusVideoIDHeader = "X-Video-ID"
+)
+
+var (
+	errUploadSessionNotFound = errors.New("upload not found")
+	errUploadSessionBusy     = errors.New("upload is already being written to")
+	errUploadOffsetMismatch  = errors.New("Upload-Offset does not match the upload's offset")
+	errUploadExceedsLength   = errors.New("data exceeds the upload length")
+)
+
+// UploadSession is a resumable upload in progress
+type UploadSession struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"` // declared size of the file
+	Offset int64  `json:"offset"` // bytes received so far
+
+	// Metadata is the decoded Upload-Metadata sent on creation. filename,
+	// filetype, tags and collection_id are used when the video is stored.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero never expires
+}
+
+// UploadSessionStore holds resumable uploads by ID. Each upload is kept on
+// disk, so uploads can be resumed after a restart, and expires once it has
+// received no data for the TTL.
+type UploadSessionStore struct {
+	dir      string
+	ttl      time.Duration // 0 never expires
+	sessions map[string]*UploadSession
+	busy     map[string]bool // uploads being appended to or finished
+	mutex    sync.Mutex
+}
+
+// NewUploadSessionStore loads the uploads saved in dir. The directory is
+// created with the first upload. Records without their data file are
+// dropped.
+func NewUploadSessionStore(dir string, ttl time.Duration) (*UploadSessionStore, error) {
+	us := &UploadSessionStore{
+		dir:      dir,
+		ttl:      ttl,
+		sessions: make(map[string]*UploadSession),
+		busy:     make(map[string]bool),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return us, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			continue
+		}
+		session, err := us.load(id)
+		if err != nil {
+			log.Warn().Err(err).Str("upload_id", id).Msg("dropping unreadable resumable upload")
+			us.removeFiles(id)
+			continue
+		}
+		us.sessions[id] = session
+	}
+	return us, nil
+}
+
+// load reads the record of an upload. The offset is taken from the data
+// file, which may be ahead of the record if the server stopped mid-write.
+func (us *UploadSessionStore) load(id string) (*UploadSession, error) {
+	data, err := os.ReadFile(us.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.ID != id {
+		return nil, fmt.Errorf("record is for upload %q", session.ID)
+	}
+
+	info, err := os.Stat(us.PartPath(id))
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > session.Length {
+		return nil, fmt.Errorf("data file holds %d of %d bytes", info.Size(), session.Length)
+	}
+	session.Offset = info.Size()
+	return &session, nil
+}
+
+// infoPath is the file an upload's record is saved to
+func (us *UploadSessionStore) infoPath(id string) string {
+	return filepath.Join(us.dir, id+".info")
+}
+
+// PartPath is the file holding the data an upload has received
+func (us *UploadSessionStore) PartPath(id string) string {
+	return filepath.Join(us.dir, id+".part")
+}
+
+// save writes the record of session
+func (us *UploadSessionStore) save(session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(us.infoPath(session.ID), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// removeFiles deletes the record and data of an upload
+func (us *UploadSessionStore) removeFiles(id string) {
+	os.Remove(us.PartPath(id))
+	os.Remove(us.infoPath(id))
+}
+
+// Create starts an upload of length bytes with an empty data file
+func (us *UploadSessionStore) Create(length int64, metadata map[string]string, now time.Time) (UploadSession, error) {
+	session := &UploadSession{
+		ID:        newUUIDv7().String(),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if us.ttl > 0 {
+		session.ExpiresAt = now.Add(us.ttl)
+	}
+
+	if err := os.MkdirAll(us.dir, 0755); err != nil {
+		return UploadSession{}, err
+	}
+	part, err := os.OpenFile(us.PartPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	part.Close()
+	if err := us.save(session); err != nil {
+		os.Remove(us.PartPath(session.ID))
+		return UploadSession{}, err
+	}
+
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	us.sessions[session.ID] = session
+	return *session, nil
+}
+
+// Get returns the upload with id unless it is unknown or has expired
+func (us *UploadSessionStore) Get(id string, now time.Time) (UploadSession, bool) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	session, exists := us.sessions[id]
+	if !exists || session.expired(now) {
+		return UploadSession{}, false
+	}
+	return *session, true
+}
+
+// expired reports whether the upload has received no data for the TTL
+func (session *UploadSession) expired(now time.Time) bool {
+	return !session.ExpiresAt.IsZero() && !now.Before(session.ExpiresAt)
+}
+
+// Append writes the data read from r to the upload with id, which must have
+// received exactly offset bytes. size is the length of the data, -1 when
+// unknown. Whatever was written is kept when reading r fails, so the client
+// can resume from the returned offset. When the upload is complete it stays
+// locked against further appends until the caller Removes it.
+func (us *UploadSessionStore) Append(id string, offset int64, r io.Reader, size int64, now time.Time) (UploadSession, error) {
+	us.mutex.Lock()
+	session, exists := us.sessions[id]
+	switch {
+	case !exists || session.expired(now):
+		us.mutex.Unlock()
+		return UploadSession{}, errUploadSessionNotFound
+	case us.busy[id]:
+		us.mutex.Unlock()
+		return UploadSession{}, errUploadSessionBusy
+	case offset != session.Offset:
+		current := *session
+		us.mutex.Unlock()
+		return current, errUploadOffsetMismatch
+	case size > session.Length-session.Offset:
+		current := *session
+		us.mutex.Unlock()
+		return current, errUploadExceedsLength
+	}
+	us.busy[id] = true
+	remaining := session.Length - session.Offset
+	us.mutex.Unlock()
+
+	written, err := appendToFile(us.PartPath(id), io.LimitReader(r, remaining))
+
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	session.Offset += written
+	session.UpdatedAt = now
+	if us.ttl > 0 {
+		session.ExpiresAt = now.Add(us.ttl)
+	}
+	if session.Offset < session.Length {
+		delete(us.busy, id)
+	}
+	// Cancelled meanwhile, see Remove
+	if us.sessions[id] != session {
+		return *session, errUploadSessionNotFound
+	}
+	if saveErr := us.save(session); err == nil {
+		err = saveErr
+	}
+	return *session, err
+}
+
+// appendToFile copies r to the end of the file at path
+func appendToFile(path string, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// Remove deletes the upload with id and its data, returning false when
+// there is no such upload
+func (us *UploadSessionStore) Remove(id string) bool {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if _, exists := us.sessions[id]; !exists {
+		return false
+	}
+	delete(us.sessions, id)
+	delete(us.busy, id)
+	us.removeFiles(id)
+	return true
+}
+
+// Expire removes the uploads that have expired by now, except those being
+// written to, and returns their IDs
+func (us *UploadSessionStore) Expire(now time.Time) []string {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	var expired []string
+	for id, session := range us.sessions {
+		if session.expired(now) && !us.busy[id] {
+			delete(us.sessions, id)
+			us.removeFiles(id)
+			expired = append(expired, id)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and its base64 encoded value, which may be left out
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, fmt.Errorf("Upload-Metadata repeats the key %q", key)
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %q is not base64 encoded", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// formatTusMetadata encodes metadata as an Upload-Metadata header
+func formatTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tusExtensions lists the TUS extensions served
+func (s *Server) tusExtensions() string {
+	if s.config.TusUploadExpiry > 0 {
+		return "creation,termination,expiration"
+	}
+	return "creation,termination"
+}
+
+// tusMiddleware rejects requests for another version of the TUS protocol
+// and answers every request with the version served
+func (s *Server) tusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported TUS version", "supported": tusVersion})
+			return
+		}
+		if s.uploadSessions == nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "resumable uploads are unavailable"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setTusExpiryHeader sends when the upload expires, if it does
+func setTusExpiryHeader(c *gin.Context, session UploadSession) {
+	if !session.ExpiresAt.IsZero() {
+		c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// tusOptionsHandler describes the TUS protocol support
+func (s *Server) tusOptionsHandler(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", s.tusExtensions())
+	c.Header("Tus-Max-Size", strconv.FormatInt(s.config.MaxFileSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// tusCreateHandler starts a resumable upload of Upload-Length bytes. The
+// Upload-Metadata header must include the filename. The upload URL is
+// returned in the Location header.
+func (s *Server) tusCreateHandler(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length must be a positive number of bytes"})
+		return
+	}
+	if length > s.config.MaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file too large, max size is %d bytes", s.config.MaxFileSize)})
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if metadata["filename"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Metadata must include a filename"})
+		return
+	}
+
+	// Rejected now rather than once the whole file has been sent
+	filename := sanitizeFilename(metadata["filename"])
+	if ext := normalizeExtension(filepath.Ext(filename)); !s.isExtensionAllowed(ext) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":     "file extension not allowed",
+			"extension": ext,
+			"allowed":   s.config.AllowedExtensions,
+		})
+		return
+	}
+
+	session, err := s.uploadSessions.Create(length, metadata, time.Now())
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("filename", filename).Msg("failed to create resumable upload")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("upload_id", session.ID).
+		Str("filename", filename).
+		Int64("length", length).
+		Msg("resumable upload created")
+
+	c.Header("Location", tusUploadPath+session.ID)
+	setTusExpiryHeader(c, session)
+	c.Status(http.StatusCreated)
+}
+
+// tusHeadHandler reports how much of an upload has been received, so the
+// client knows where to resume
+func (s *Server) tusHeadHandler(c *gin.Context) {
+	session, exists := s.uploadSessions.Get(c.Param("upload_id"), time.Now())
+	c.Header("Cache-Control", "no-store")
+	if !exists {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	if len(session.Metadata) > 0 {
+		c.Header("Upload-Metadata", formatTusMetadata(session.Metadata))
+	}
+	setTusExpiryHeader(c, session)
+	c.Status(http.StatusOK)
+}
+
+// tusPatchHandler appends the request body to an upload at Upload-Offset.
+// The request that completes the upload stores the video like a regular
+// upload and names it in X-Video-ID.
+func (s *Server) tusPatchHandler(c *gin.Context) {
+	uploadID := c.Param("upload_id")
+
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be a number of bytes"})
+		return
+	}
+
+	session, err := s.uploadSessions.Append(uploadID, offset, c.Request.Body, c.Request.ContentLength, time.Now())
+	switch {
+	case errors.Is(err, errUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errUploadSessionBusy):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errUploadOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": session.Offset})
+		return
+	case errors.Is(err, errUploadExceedsLength):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "remaining": session.Length - session.Offset})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	setTusExpiryHeader(c, session)
+	if err != nil {
+		getLogger(c).Error().Err(err).Str("upload_id", uploadID).Int64("offset", session.Offset).Msg("failed to append to resumable upload")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save upload data"})
+		return
+	}
+	if session.Offset < session.Length {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	video, ok := s.finishTusUpload(c, session)
+	if !ok {
+		return
+	}
+	c.Header(tusVideoIDHeader, video.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// finishTusUpload stores a complete upload as a video and removes it. It
+// writes an error response and returns false on failure, after which the
+// upload cannot be resumed.
+func (s *Server) finishTusUpload(c *gin.Context, session UploadSession) (*Video, bool) {
+	defer s.uploadSessions.Remove(session.ID)
+
+	source := &uploadSource{
+		filename:    session.Metadata["filename"],
+		contentType: session.Metadata["filetype"],
+		collection:  session.Metadata["collection_id"],
+		save: func(dst string) error {
+			return moveFile(s.uploadSessions.PartPath(session.ID), dst)
+		},
+		close: func() {},
+	}
+	if tags := session.Metadata["tags"]; tags != "" {
+		source.tags = []string{tags}
+	}
+	return s.storeUpload(c, source)
+}
+
+// tusDeleteHandler cancels an upload and deletes the data it received
+func (s *Server) tusDeleteHandler(c *gin.Context) {
+	uploadID := c.Param("upload_id")
+
+	if !s.uploadSessions.Remove(uploadID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUploadSessionNotFound.Error()})
+		return
+	}
+
+	getLogger(c).Info().Str("upload_id", uploadID).Msg("resumable upload cancelled")
+
+	c.Status(http.StatusNoContent)
+}
+
+// tusExpiryLoop removes expired resumable uploads every TusCleanupInterval
+// until shutdown
+func (s *Server) tusExpiryLoop() {
+	ticker := time.NewTicker(s.config.TusCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.tusExpiryStop:
+			return
+		case now := <-ticker.C:
+			if expired := s.uploadSessions.Expire(now); len(expired) > 0 {
+				s.logger.Info().Strs("upload_ids", expired).Msg("removed expired resumable uploads")
+			}
+		}
+	}
+}
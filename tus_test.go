@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tusRequest sends a TUS request to the server and returns the recorded
+// response
+func tusRequest(server *Server, method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", tusContentType)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+// createTusUpload starts an upload of length bytes and returns its URL
+func createTusUpload(t *testing.T, server *Server, filename string, length int) string {
+	t.Helper()
+
+	w := tusRequest(server, http.MethodPost, "/api/videos/tus", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)) + ",tags " + base64.StdEncoding.EncodeToString([]byte("Travel, beach")),
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	location := w.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, tusUploadPath), location)
+	return location
+}
+
+func TestTusUpload(t *testing.T) {
+	server := newTestServer(t)
+	server.config.TusUploadExpiry = time.Hour
+	server.uploadSessions.ttl = time.Hour
+
+	w := tusRequest(server, http.MethodOptions, "/api/videos/tus", nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, tusVersion, w.Header().Get("Tus-Version"))
+	assert.Contains(t, w.Header().Get("Tus-Extension"), "creation")
+
+	data := []byte("resumable video content")
+	location := createTusUpload(t, server, "holiday.mp4", len(data))
+
+	w = tusRequest(server, http.MethodPatch, location, data[:10], map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"))
+	assert.NotEmpty(t, w.Header().Get("Upload-Expires"))
+
+	// The client lost track of the offset and asks for it
+	w = tusRequest(server, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "23", w.Header().Get("Upload-Length"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	w = tusRequest(server, http.MethodPatch, location, data[5:], map[string]string{"Upload-Offset": "5"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = tusRequest(server, http.MethodPatch, location, data[10:], map[string]string{"Upload-Offset": "10"})
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	videoID := w.Header().Get(tusVideoIDHeader)
+	require.NotEmpty(t, videoID)
+
+	video, exists := server.db.GetVideoByID(videoID)
+	require.True(t, exists)
+	assert.Equal(t, "holiday.mp4", video.Name)
+	assert.Equal(t, int64(len(data)), video.Size)
+	assert.ElementsMatch(t, []string{"travel", "beach"}, video.Tags)
+	stored, err := os.ReadFile(server.getFilePath(video.ID, video.Name))
+	require.NoError(t, err)
+	assert.Equal(t, data, stored)
+
+	// The finished upload is gone with its files
+	w = tusRequest(server, http.MethodHead, location, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	entries, err := os.ReadDir(filepath.Join(server.config.StoragePath, tusUploadDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestTusUploadRejected(t *testing.T) {
+	server := newTestServer(t)
+
+	t.Run("Version", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/videos/tus", nil)
+		req.Header.Set("Upload-Length", "10")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, tusVersion, w.Header().Get("Tus-Version"))
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		w := tusRequest(server, http.MethodPost, "/api/videos/tus", nil, map[string]string{
+			"Upload-Length":   "20000000",
+			"Upload-Metadata": "filename YS5tcDQ=",
+		})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("No filename", func(t *testing.T) {
+		w := tusRequest(server, http.MethodPost, "/api/videos/tus", nil, map[string]string{"Upload-Length": "10"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Data past the length", func(t *testing.T) {
+		location := createTusUpload(t, server, "short.mp4", 4)
+		w := tusRequest(server, http.MethodPatch, location, []byte("too long"), map[string]string{"Upload-Offset": "0"})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		location := createTusUpload(t, server, "cancel.mp4", 4)
+		w := tusRequest(server, http.MethodDelete, location, nil, nil)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		w = tusRequest(server, http.MethodPatch, location, []byte("data"), map[string]string{"Upload-Offset": "0"})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestUploadSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	store, err := NewUploadSessionStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	session, err := store.Create(10, map[string]string{"filename": "a.mp4"}, now)
+	require.NoError(t, err)
+	session, err = store.Append(session.ID, 0, strings.NewReader("abcd"), -1, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), session.Offset)
+
+	t.Run("Reloaded", func(t *testing.T) {
+		// Data written after the record was saved still counts
+		part, err := os.OpenFile(store.PartPath(session.ID), os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = part.WriteString("ef")
+		require.NoError(t, err)
+		require.NoError(t, part.Close())
+
+		reloaded, err := NewUploadSessionStore(dir, time.Hour)
+		require.NoError(t, err)
+		loaded, exists := reloaded.Get(session.ID, now)
+		require.True(t, exists)
+		assert.Equal(t, int64(6), loaded.Offset)
+		assert.Equal(t, "a.mp4", loaded.Metadata["filename"])
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		later := now.Add(2 * time.Hour)
+		_, exists := store.Get(session.ID, later)
+		assert.False(t, exists)
+		assert.Equal(t, []string{session.ID}, store.Expire(later))
+		_, err := os.Stat(store.PartPath(session.ID))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := parseTusMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "world_domination_plan.pdf", "is_confidential": ""}, metadata)
+	assert.Equal(t, "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential", formatTusMetadata(metadata))
+
+	_, err = parseTusMetadata("filename !!")
+	assert.Error(t, err)
+	_, err = parseTusMetadata("a YQ==,a YQ==")
+	assert.Error(t, err)
+}
@@ -0,0 +1,243 @@
+//go:build unix
+
+package main
+
+// Graceful upgrades replace the running binary without dropping connections,
+// following the tableflip pattern. On SIGUSR2 the server starts its own
+// executable again, passing the listening socket as an inherited file
+// descriptor along with a pipe in each direction. Once the new process has
+// loaded its config and taken over the listener it asks for the database;
+// the old process then stops accepting, finishes in-flight requests, closes
+// its store and tells the new one the database files are free. The new
+// process opens them, builds its server and reports ready once it serves.
+// Both processes share the socket, so connections arriving in between wait
+// in its backlog instead of being refused.
+//
+// The new binary must be in place at the old one's path before the signal is
+// sent. If the new process exits or does not ask for the database in time,
+// the old one keeps serving. Once the database is handed over the old one
+// exits either way.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	// upgradeEnv is set for a process started by an upgrade, which inherits
+	// the listener as upgradeListenerFD, the pipe it writes to the parent as
+	// upgradeReadyFD and the one the parent writes to as upgradeReleaseFD
+	upgradeEnv        = "VIDEO_SERVER_UPGRADE"
+	upgradeListenerFD = 3
+	upgradeReadyFD    = 4
+	upgradeReleaseFD  = 5
+
+	// Messages the new process sends the old one, in this order
+	upgradeMsgDatabase byte = 'd' // asks for the database files
+	upgradeMsgReady    byte = 'r' // is serving
+
+	// upgradeReadyTimeout bounds how long the old process waits for each
+	// message of the new one
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+// upgradeSignals trigger a graceful upgrade
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// gracefulUpgrader hands a server's listener to a new process
+type gracefulUpgrader struct {
+	inherited net.Listener // listener passed by the parent, nil when not upgraded
+	ready     *os.File     // pipe our messages to the parent are written to
+	release   *os.File     // pipe the parent writes to once it closed its store
+	listener  net.Listener
+
+	// command builds the process started by Upgrade, the running executable
+	// with the same arguments by default
+	command func() (*exec.Cmd, error)
+}
+
+// newGracefulUpgrader creates an upgrader, taking over the listener passed
+// by the parent when this process was started by an upgrade
+func newGracefulUpgrader() (*gracefulUpgrader, error) {
+	u := &gracefulUpgrader{command: upgradeCommand}
+	if os.Getenv(upgradeEnv) == "" {
+		return u, nil
+	}
+	// Our own upgrades must not see it
+	os.Unsetenv(upgradeEnv)
+
+	file := os.NewFile(upgradeListenerFD, "listener")
+	listener, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inherit listener: %w", err)
+	}
+	u.inherited = listener
+	u.ready = os.NewFile(upgradeReadyFD, "upgrade-ready")
+	u.release = os.NewFile(upgradeReleaseFD, "upgrade-release")
+	return u, nil
+}
+
+// upgradeCommand starts the running executable again with the same
+// arguments and standard streams
+func upgradeCommand() (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd, nil
+}
+
+// Inherited reports whether this process was started by an upgrade
+func (u *gracefulUpgrader) Inherited() bool {
+	return u.inherited != nil
+}
+
+// AcquireDB asks the parent for the database files and waits until it has
+// finished its requests and closed its store. It does nothing when the
+// process was not started by an upgrade.
+func (u *gracefulUpgrader) AcquireDB() error {
+	if u.release == nil {
+		return nil
+	}
+	defer func() {
+		u.release.Close()
+		u.release = nil
+	}()
+
+	if _, err := u.ready.Write([]byte{upgradeMsgDatabase}); err != nil {
+		return err
+	}
+	if _, err := u.release.Read(make([]byte, 1)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("old process exited before releasing the database")
+		}
+		return err
+	}
+	return nil
+}
+
+// Ready tells the parent this process is serving, after which the parent
+// exits. It must follow AcquireDB and does nothing when the process was not
+// started by an upgrade.
+func (u *gracefulUpgrader) Ready() error {
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte{upgradeMsgReady})
+	if closeErr := u.ready.Close(); err == nil {
+		err = closeErr
+	}
+	u.ready = nil
+	return err
+}
+
+// Listen returns the inherited listener, or one opened by listen
+func (u *gracefulUpgrader) Listen(listen func() (net.Listener, error)) (net.Listener, error) {
+	if u.inherited != nil {
+		u.listener = u.inherited
+		return u.listener, nil
+	}
+	listener, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	u.listener = listener
+	return listener, nil
+}
+
+// Upgrade starts a new process with the listener. When it asks for the
+// database, release is called to stop serving and close the store, and
+// Upgrade waits until the new process is ready. An error returned before
+// release was called leaves the caller serving; after it the new process
+// failed to take over.
+func (u *gracefulUpgrader) Upgrade(release func()) error {
+	filer, ok := u.listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("listener %T cannot be passed to another process", u.listener)
+	}
+	listenerFile, err := filer.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	releaseR, releaseW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	defer releaseW.Close()
+
+	cmd, err := u.command()
+	if err != nil {
+		readyW.Close()
+		releaseR.Close()
+		return err
+	}
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW, releaseR} // fds 3, 4 and 5
+	err = cmd.Start()
+	readyW.Close()
+	releaseR.Close()
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		// Reaps the process should it exit before we do
+		cmd.Wait()
+		close(exited)
+	}()
+
+	if err := readUpgradeMessage(readyR, upgradeMsgDatabase); err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("new process did not ask for the database: %w", err)
+	}
+
+	// Closing a Unix listener removes its socket file, which the new
+	// process is going to accept on
+	if unixListener, ok := u.listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+	release()
+	if _, err := releaseW.Write([]byte{upgradeMsgDatabase}); err != nil {
+		return fmt.Errorf("failed to release the database: %w", err)
+	}
+
+	if err := readUpgradeMessage(readyR, upgradeMsgReady); err != nil {
+		return fmt.Errorf("new process did not become ready: %w", err)
+	}
+	return nil
+}
+
+// readUpgradeMessage reads the next message of the new process, failing
+// unless it is want
+func readUpgradeMessage(r *os.File, want byte) error {
+	r.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	msg := make([]byte, 1)
+	if _, err := r.Read(msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("new process exited")
+		}
+		return err
+	}
+	if msg[0] != want {
+		return fmt.Errorf("unexpected message %q", msg[0])
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// upgradeSignals is empty, graceful upgrades need Unix file descriptor passing
+var upgradeSignals []os.Signal
+
+// gracefulUpgrader is not supported on this platform
+type gracefulUpgrader struct{}
+
+// newGracefulUpgrader fails on this platform
+func newGracefulUpgrader() (*gracefulUpgrader, error) {
+	return nil, errors.New("graceful upgrades are not supported on this platform")
+}
+
+func (u *gracefulUpgrader) Inherited() bool { return false }
+
+func (u *gracefulUpgrader) AcquireDB() error { return nil }
+
+func (u *gracefulUpgrader) Ready() error { return nil }
+
+func (u *gracefulUpgrader) Listen(listen func() (net.Listener, error)) (net.Listener, error) {
+	return listen()
+}
+
+func (u *gracefulUpgrader) Upgrade(release func()) error {
+	return errors.New("graceful upgrades are not supported on this platform")
+}
@@ -0,0 +1,118 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGracefulUpgradeHandoff(t *testing.T) {
+	upgrader, err := newGracefulUpgrader()
+	require.NoError(t, err)
+	require.False(t, upgrader.Inherited())
+
+	// The new process is this test binary running TestGracefulUpgradeChild
+	upgrader.command = func() (*exec.Cmd, error) {
+		return exec.Command(os.Args[0], "-test.run=^TestGracefulUpgradeChild$"), nil
+	}
+
+	listener, err := upgrader.Listen(func() (net.Listener, error) {
+		return net.Listen("tcp", "127.0.0.1:0")
+	})
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "parent")
+	})}
+	go srv.Serve(listener)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 10 * time.Second}
+	get := func() string {
+		resp, err := client.Get("http://" + listener.Addr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	assert.Equal(t, "parent", get())
+
+	// The parent stops accepting when the child asks for the database, the
+	// same address is then served by the child
+	released := false
+	require.NoError(t, upgrader.Upgrade(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, srv.Shutdown(ctx))
+		released = true
+	}))
+	assert.True(t, released)
+	assert.Equal(t, "child", get())
+}
+
+func TestGracefulUpgradeChildFails(t *testing.T) {
+	if os.Getenv(upgradeEnv) != "" {
+		// As the new process, fail before asking for the database
+		os.Exit(1)
+	}
+
+	upgrader, err := newGracefulUpgrader()
+	require.NoError(t, err)
+	upgrader.command = func() (*exec.Cmd, error) {
+		return exec.Command(os.Args[0], "-test.run=^TestGracefulUpgradeChildFails$"), nil
+	}
+	_, err = upgrader.Listen(func() (net.Listener, error) {
+		return net.Listen("tcp", "127.0.0.1:0")
+	})
+	require.NoError(t, err)
+	defer upgrader.listener.Close()
+
+	err = upgrader.Upgrade(func() {
+		t.Error("the database must not be released to a failed process")
+	})
+	assert.ErrorContains(t, err, "new process exited")
+}
+
+// TestGracefulUpgradeChild is the process started by TestGracefulUpgradeHandoff.
+// It serves a single request on the inherited listener.
+func TestGracefulUpgradeChild(t *testing.T) {
+	if os.Getenv(upgradeEnv) == "" {
+		t.Skip("only runs as the process started by TestGracefulUpgradeHandoff")
+	}
+
+	upgrader, err := newGracefulUpgrader()
+	require.NoError(t, err)
+	require.True(t, upgrader.Inherited())
+	listener, err := upgrader.Listen(nil)
+	require.NoError(t, err)
+	require.NoError(t, upgrader.AcquireDB())
+	require.NoError(t, upgrader.Ready())
+
+	served := make(chan struct{})
+	var once sync.Once
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "child")
+		once.Do(func() { close(served) })
+	})}
+	go srv.Serve(listener)
+
+	select {
+	case <-served:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no request reached the new process")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reasons an upload check rejects an upload
+const (
+	UploadCheckFileTooLarge          = "FILE_TOO_LARGE"
+	UploadCheckQuotaExceeded         = "QUOTA_EXCEEDED"
+	UploadCheckContentTypeNotAllowed = "CONTENT_TYPE_NOT_ALLOWED"
+	UploadCheckExtensionNotAllowed   = "EXTENSION_NOT_ALLOWED"
+)
+
+// UploadCheckReason explains why an upload would be rejected
+type UploadCheckReason struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// UploadCheckResult is the response of POST /api/videos/upload-check
+type UploadCheckResult struct {
+	Allowed bool                `json:"allowed"`
+	Reasons []UploadCheckReason `json:"reasons"`
+	// QuotaRemainingBytes is left out when no tenant quota applies
+	QuotaRemainingBytes *int64 `json:"quota_remaining_bytes,omitempty"`
+}
+
+// isContentTypeAllowed reports whether uploads of contentType are accepted.
+// AllowedContentTypes entries may end in /* to accept a whole type.
+func (s *Server) isContentTypeAllowed(contentType string) bool {
+	if len(s.config.AllowedContentTypes) == 0 {
+		return true
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	contentType = strings.ToLower(contentType)
+	for _, allowed := range s.config.AllowedContentTypes {
+		if allowed == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantUsage returns the bytes stored by a tenant's videos
+func (s *Server) tenantUsage(tenantID string) int64 {
+	var used int64
+	for _, video := range s.db.GetAllVideos() {
+		if video.TenantID == tenantID {
+			used += video.Size
+		}
+	}
+	return used
+}
+
+// tenantQuotaRemaining returns how many more bytes a tenant may store, false
+// when no quota applies
+func (s *Server) tenantQuotaRemaining(tenantID string) (int64, bool) {
+	if s.config.TenantQuotaBytes <= 0 || tenantID == "" {
+		return 0, false
+	}
+	remaining := s.config.TenantQuotaBytes - s.tenantUsage(tenantID)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// uploadCheckHandler tells a client whether an upload would be accepted,
+// without reading or storing a file. It doesn't require authentication,
+// the tenant quota is only checked for callers that authenticate.
+func (s *Server) uploadCheckHandler(c *gin.Context) {
+	var req struct {
+		SizeBytes   *int64 `json:"size_bytes" binding:"required"`
+		ContentType string `json:"content_type"`
+		Filename    string `json:"filename"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.SizeBytes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size_bytes must not be negative"})
+		return
+	}
+	size := *req.SizeBytes
+
+	var tenantID string
+	if s.authenticator != nil {
+		principal, err := s.authenticator.Authenticate(c)
+		switch {
+		case err == nil:
+			tenantID = principal.TenantID
+		case !errors.Is(err, errNoCredentials):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	result := UploadCheckResult{Reasons: []UploadCheckReason{}}
+	if size > s.config.MaxFileSize {
+		result.Reasons = append(result.Reasons, UploadCheckReason{
+			Code:   UploadCheckFileTooLarge,
+			Detail: fmt.Sprintf("%d bytes is over the maximum file size of %d bytes", size, s.config.MaxFileSize),
+		})
+	}
+	if remaining, ok := s.tenantQuotaRemaining(tenantID); ok {
+		result.QuotaRemainingBytes = &remaining
+		if size > remaining {
+			result.Reasons = append(result.Reasons, UploadCheckReason{
+				Code:   UploadCheckQuotaExceeded,
+				Detail: fmt.Sprintf("%d bytes is over the %d bytes left of tenant %s's quota", size, remaining, tenantID),
+			})
+		}
+	}
+	if req.ContentType != "" && !s.isContentTypeAllowed(req.ContentType) {
+		result.Reasons = append(result.Reasons, UploadCheckReason{
+			Code:   UploadCheckContentTypeNotAllowed,
+			Detail: fmt.Sprintf("content type %s is not allowed, allowed: %s", req.ContentType, strings.Join(s.config.AllowedContentTypes, ", ")),
+		})
+	}
+	if req.Filename != "" {
+		if ext := normalizeExtension(filepath.Ext(sanitizeFilename(req.Filename))); !s.isExtensionAllowed(ext) {
+			result.Reasons = append(result.Reasons, UploadCheckReason{
+				Code:   UploadCheckExtensionNotAllowed,
+				Detail: fmt.Sprintf("extension %q is not allowed, allowed: %s", ext, strings.Join(s.config.AllowedExtensions, ", ")),
+			})
+		}
+	}
+	result.Allowed = len(result.Reasons) == 0
+
+	s.respondSuccess(c, http.StatusOK, result)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkUpload(t *testing.T, server *Server, headers map[string]string, body string) UploadCheckResult {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/upload-check", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var result UploadCheckResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	return result
+}
+
+// reasonCodes returns the codes of the reasons an upload was rejected
+func reasonCodes(result UploadCheckResult) []string {
+	codes := []string{}
+	for _, reason := range result.Reasons {
+		codes = append(codes, reason.Code)
+	}
+	return codes
+}
+
+func TestUploadCheck(t *testing.T) {
+	server := newTestServer(t)
+	server.config.MaxFileSize = 1000
+	server.config.AllowedExtensions = []string{".mp4", ".webm"}
+	server.config.AllowedContentTypes = []string{"video/*"}
+
+	t.Run("Allowed", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 1000, "content_type": "video/mp4", "filename": "movie.mp4"}`)
+		assert.True(t, result.Allowed)
+		assert.Empty(t, result.Reasons)
+		assert.Nil(t, result.QuotaRemainingBytes, "no quota without a tenant")
+	})
+
+	t.Run("File too large", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 1001, "content_type": "video/mp4", "filename": "movie.mp4"}`)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, []string{UploadCheckFileTooLarge}, reasonCodes(result))
+	})
+
+	t.Run("Content type not allowed", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 10, "content_type": "application/pdf", "filename": "movie.mp4"}`)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, []string{UploadCheckContentTypeNotAllowed}, reasonCodes(result))
+	})
+
+	t.Run("Extension not allowed", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 10, "content_type": "video/x-msvideo", "filename": "movie.AVI"}`)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, []string{UploadCheckExtensionNotAllowed}, reasonCodes(result))
+	})
+
+	t.Run("Every reason", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 5000, "content_type": "text/plain", "filename": "notes.txt"}`)
+		assert.Equal(t, []string{UploadCheckFileTooLarge, UploadCheckContentTypeNotAllowed, UploadCheckExtensionNotAllowed}, reasonCodes(result))
+	})
+
+	t.Run("Bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/videos/upload-check", `{"filename": "movie.mp4"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, postJSON(server, "/api/videos/upload-check", `{"size_bytes": -1}`).Code)
+	})
+}
+
+func TestUploadCheckQuota(t *testing.T) {
+	server := newTestServer(t)
+	server.config.TenantQuotaBytes = 100
+	server.authenticator = NewJWTAuthenticator("jwt-secret")
+	tenant := func(id string) map[string]string {
+		return bearer(signHS256(t, "jwt-secret", map[string]interface{}{
+			"sub":       "uploader",
+			"tenant_id": id,
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		}))
+	}
+
+	used := newTestVideo("used", 60)
+	used.TenantID = "acme"
+	require.NoError(t, server.db.AddVideo(used))
+	require.NoError(t, server.db.AddVideo(newTestVideo("other", 90)))
+
+	t.Run("Quota exceeded", func(t *testing.T) {
+		result := checkUpload(t, server, tenant("acme"), `{"size_bytes": 50, "filename": "movie.mp4"}`)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, []string{UploadCheckQuotaExceeded}, reasonCodes(result))
+		require.NotNil(t, result.QuotaRemainingBytes)
+		assert.Equal(t, int64(40), *result.QuotaRemainingBytes)
+	})
+
+	t.Run("Within quota", func(t *testing.T) {
+		result := checkUpload(t, server, tenant("acme"), `{"size_bytes": 40, "filename": "movie.mp4"}`)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, int64(40), *result.QuotaRemainingBytes)
+	})
+
+	t.Run("Without authentication", func(t *testing.T) {
+		result := checkUpload(t, server, nil, `{"size_bytes": 500, "filename": "movie.mp4"}`)
+		assert.True(t, result.Allowed)
+		assert.Nil(t, result.QuotaRemainingBytes)
+
+		w := postJSON(server, "/api/videos/upload-check", `{"size_bytes": 500}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Invalid credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/videos/upload-check", bytes.NewBufferString(`{"size_bytes": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer not-a-token")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Upload enforces the quota", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "big.mp4")
+		require.NoError(t, err)
+		part.Write(bytes.Repeat([]byte("x"), 50))
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/videos", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		for name, value := range tenant("acme") {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+		assert.Len(t, server.db.GetAllVideos(), 2)
+	})
+}
+
+func TestUploadContentTypeAllowlist(t *testing.T) {
+	server := newTestServer(t)
+	server.config.AllowedContentTypes = []string{"video/*"}
+
+	upload := func(contentType string) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="clip.mp4"`)
+		header.Set("Content-Type", contentType)
+		part, err := form.CreatePart(header)
+		require.NoError(t, err)
+		part.Write([]byte("content"))
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/videos", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, upload("video/mp4"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, upload("application/pdf"))
+}
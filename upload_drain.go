@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadDrainer tracks the uploads in flight, so shutdown can let them
+// finish before the HTTP server is stopped
+type uploadDrainer struct {
+	mutex    sync.Mutex
+	inFlight int
+	idle     chan struct{} // closed while no upload is in flight
+}
+
+func newUploadDrainer() *uploadDrainer {
+	idle := make(chan struct{})
+	close(idle)
+	return &uploadDrainer{idle: idle}
+}
+
+// Start records an upload beginning
+func (d *uploadDrainer) Start() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.inFlight == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.inFlight++
+}
+
+// Done records an upload finishing
+func (d *uploadDrainer) Done() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.inFlight--
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// InFlight returns the number of uploads in progress
+func (d *uploadDrainer) InFlight() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.inFlight
+}
+
+// Wait blocks until no upload is in flight or the context is done
+func (d *uploadDrainer) Wait(ctx context.Context) error {
+	d.mutex.Lock()
+	idle := d.idle
+	d.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// uploadDrainMiddleware tracks uploads in the drainer and rejects new ones
+// with 503 once shutdown has begun
+func (s *Server) uploadDrainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Counting the upload before checking shuttingDown means shutdown
+		// either rejects it here or waits for it
+		s.uploadDrainer.Start()
+		defer s.uploadDrainer.Done()
+
+		if s.shuttingDown.Load() {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownDrainsUploads(t *testing.T) {
+	server := newTestServer(t)
+	server.config.ShutdownTimeout = 10 * time.Second
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: server.router}
+	go srv.Serve(listener)
+
+	// The upload body is written through a pipe, so it stays in flight
+	// until the test finishes writing it
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	req, err := http.NewRequest(http.MethodPost, "http://"+listener.Addr().String()+"/api/videos", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	uploadDone := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			uploadDone <- nil
+			return
+		}
+		resp.Body.Close()
+		uploadDone <- resp
+	}()
+
+	part, err := writer.CreateFormFile("file", "slow.mp4")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("the first half"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return server.uploadDrainer.InFlight() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		server.shutdown(srv)
+		close(shutdownDone)
+	}()
+	require.Eventually(t, server.shuttingDown.Load, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("New uploads are rejected", func(t *testing.T) {
+		var buf bytes.Buffer
+		rejected := multipart.NewWriter(&buf)
+		part, _ := rejected.CreateFormFile("file", "late.mp4")
+		part.Write([]byte("too late"))
+		rejected.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/videos", &buf)
+		req.Header.Set("Content-Type", rejected.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown finished before the upload")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = part.Write([]byte(" and the rest"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, bodyWriter.Close())
+
+	resp := <-uploadDone
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	select {
+	case <-shutdownDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	assert.Len(t, server.db.GetAllVideos(), 1)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "time"
+
+// cleanupUploadJobs removes finished upload jobs from progressMap once they
+// are older than their TTL: UploadJobTTL for completed and cancelled jobs,
+// FailedJobTTL for failed ones so they can still be investigated. Jobs
+// still uploading are kept. It returns how many jobs were removed.
+func (s *Server) cleanupUploadJobs(now time.Time) int {
+	removed := 0
+	// Range takes no lock over the whole map, so uploads starting or
+	// finishing meanwhile are not held up
+	s.progressMap.Range(func(key, value interface{}) bool {
+		progress := value.(*uploadProgress)
+
+		var ttl time.Duration
+		switch progress.status.Load() {
+		case uploadStatusCompleted, uploadStatusCancelled:
+			ttl = s.config.UploadJobTTL
+		case uploadStatusFailed:
+			ttl = s.config.FailedJobTTL
+		default:
+			return true
+		}
+
+		if now.Sub(time.Unix(0, progress.updatedAt.Load())) > ttl {
+			// Left alone if the session ID was reused meanwhile
+			if s.progressMap.CompareAndDelete(key, progress) {
+				removed++
+			}
+		}
+		return true
+	})
+	return removed
+}
+
+// uploadJobCleanupLoop runs cleanupUploadJobs every
+// UploadJobCleanupInterval until shutdown
+func (s *Server) uploadJobCleanupLoop() {
+	ticker := time.NewTicker(s.config.UploadJobCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.uploadJobStop:
+			return
+		case now := <-ticker.C:
+			if removed := s.cleanupUploadJobs(now); removed > 0 {
+				s.logger.Debug().Int("removed", removed).Msg("cleaned up upload jobs")
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeUploadJob adds a finished upload job last updated age ago
+func storeUploadJob(server *Server, id, status string, age time.Duration) {
+	progress := newUploadProgress(context.Background())
+	if status != uploadStatusUploading {
+		progress.finish(status)
+	}
+	progress.updatedAt.Store(time.Now().Add(-age).UnixNano())
+	server.progressMap.Store(id, progress)
+}
+
+func uploadJobIDs(server *Server) []string {
+	var ids []string
+	server.progressMap.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	return ids
+}
+
+func TestUploadJobCleanup(t *testing.T) {
+	config := &Config{
+		ServerPort:               "0",
+		StoragePath:              t.TempDir(),
+		MaxFileSize:              1024,
+		UploadJobTTL:             time.Hour,
+		FailedJobTTL:             7 * 24 * time.Hour,
+		UploadJobCleanupInterval: 10 * time.Millisecond,
+	}
+	server := NewServer(config, NewInMemoryDB())
+	defer close(server.uploadJobStop)
+
+	storeUploadJob(server, "old-completed", uploadStatusCompleted, 2*time.Hour)
+	storeUploadJob(server, "old-cancelled", uploadStatusCancelled, 2*time.Hour)
+	storeUploadJob(server, "old-failed", uploadStatusFailed, 8*24*time.Hour)
+	storeUploadJob(server, "recent-completed", uploadStatusCompleted, time.Minute)
+	storeUploadJob(server, "kept-failed", uploadStatusFailed, 2*time.Hour)
+	storeUploadJob(server, "still-uploading", uploadStatusUploading, 30*24*time.Hour)
+
+	require.Eventually(t, func() bool {
+		return len(uploadJobIDs(server)) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"recent-completed", "kept-failed", "still-uploading"}, uploadJobIDs(server))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uploadSessionHeader = "X-Upload-Session-ID"
+
+	// maxFormFieldSize caps how much of a streamed "tags" or
+	// "collection_id" field is read
+	maxFormFieldSize = 4096
+)
+
+// Upload job statuses reported by the progress endpoint
+const (
+	uploadStatusUploading = "uploading"
+	uploadStatusCompleted = "completed"
+	uploadStatusCancelled = "cancelled"
+	uploadStatusFailed    = "failed"
+)
+
+// errUploadTooLarge is returned when a streamed upload exceeds MaxFileSize
+var errUploadTooLarge = errors.New("upload exceeds the maximum file size")
+
+// uploadSource is the file part of an upload request
+type uploadSource struct {
+	filename    string
+	contentType string
+	tags        []string               // raw "tags" form values, see normalizeTags
+	collection  string                 // "collection_id" form value
+	save        func(dst string) error // writes the file contents to dst
+	close       func()                 // called once the request is handled
+
+	// checksumHeader declares checksums of the whole file, see
+	// parseUploadChecksums. nil declares none.
+	checksumHeader http.Header
+}
+
+// uploadProgress tracks a streamed upload. The upload session ID doubles as
+// its job ID for cancellation.
+type uploadProgress struct {
+	bytesReceived atomic.Int64
+	complete      atomic.Bool  // set once the file part has been read
+	status        atomic.Value // one of the uploadStatus constants
+	updatedAt     atomic.Int64 // Unix nanoseconds of the last status change
+
+	// ctx is cancelled by cancel to abort the upload
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newUploadProgress starts tracking an upload, which is aborted when parent
+// is done or the upload is cancelled
+func newUploadProgress(parent context.Context) *uploadProgress {
+	progress := &uploadProgress{}
+	progress.ctx, progress.cancel = context.WithCancel(parent)
+	progress.status.Store(uploadStatusUploading)
+	progress.updatedAt.Store(time.Now().UnixNano())
+	return progress
+}
+
+// finish moves an in-progress upload to status. It returns false when the
+// upload was cancelled first.
+func (p *uploadProgress) finish(status string) bool {
+	if !p.status.CompareAndSwap(uploadStatusUploading, status) {
+		return false
+	}
+	p.updatedAt.Store(time.Now().UnixNano())
+	return true
+}
+
+// progressWriter counts bytes as they are written to disk, and stops
+// writing once the upload is cancelled
+type progressWriter struct {
+	w        io.Writer
+	progress *uploadProgress
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if err := pw.progress.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(p)
+	pw.progress.bytesReceived.Add(int64(n))
+	return n, err
+}
+
+// isChunkedRequest reports whether the request body uses chunked encoding
+func isChunkedRequest(r *http.Request) bool {
+	for _, encoding := range r.TransferEncoding {
+		if encoding == "chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedUploadSource reads the whole multipart form before the upload is
+// processed. It writes an error response and returns nil on failure.
+func (s *Server) bufferedUploadSource(c *gin.Context) *uploadSource {
+	// Parse multipart form
+	form, err := c.MultipartForm()
+	if err != nil {
+		getLogger(c).Error().Err(err).Msg("failed to parse multipart form")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form data"})
+		return nil
+	}
+
+	// Get file from form
+	files := form.File["file"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+		return nil
+	}
+
+	file := files[0]
+
+	// Validate file size
+	if file.Size > s.config.MaxFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file too large, max size is %d bytes", s.config.MaxFileSize)})
+		return nil
+	}
+
+	return &uploadSource{
+		filename:    file.Filename,
+		contentType: file.Header.Get("Content-Type"),
+		tags:        form.Value["tags"],
+		collection:  c.PostForm("collection_id"),
+		save: func(dst string) error {
+			return c.SaveUploadedFile(file, dst)
+		},
+		close:          func() {},
+		checksumHeader: c.Request.Header,
+	}
+}
+
+// streamingUploadSource reads the multipart body part by part, so the file
+// is written to disk as it arrives. Progress is published under the
+// X-Upload-Session-ID header when one is sent. It writes an error response
+// and returns nil on failure.
+func (s *Server) streamingUploadSource(c *gin.Context) *uploadSource {
+	_, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form data"})
+		return nil
+	}
+
+	reader := multipart.NewReader(c.Request.Body, params["boundary"])
+
+	// Skip ahead to the file part. Only fields sent before the file are seen.
+	var part *multipart.Part
+	var tags []string
+	var collection string
+	for {
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+			return nil
+		}
+		if err != nil {
+			getLogger(c).Error().Err(err).Msg("failed to read multipart stream")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form data"})
+			return nil
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			break
+		}
+		if part.FormName() == "tags" || part.FormName() == "collection_id" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid form data"})
+				return nil
+			}
+			if part.FormName() == "tags" {
+				tags = append(tags, string(value))
+			} else {
+				collection = string(value)
+			}
+		}
+	}
+
+	progress := newUploadProgress(c.Request.Context())
+	sessionID := c.GetHeader(uploadSessionHeader)
+	if sessionID != "" {
+		s.progressMap.Store(sessionID, progress)
+	}
+
+	return &uploadSource{
+		filename:    part.FileName(),
+		contentType: part.Header.Get("Content-Type"),
+		tags:        tags,
+		collection:  collection,
+		save: func(dst string) error {
+			out, err := os.Create(dst)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+
+			// Read one byte past the limit to detect oversized uploads
+			limited := io.LimitReader(part, s.config.MaxFileSize+1)
+			written, err := io.Copy(&progressWriter{w: out, progress: progress}, limited)
+			progress.complete.Store(true)
+			if err == nil && written > s.config.MaxFileSize {
+				err = errUploadTooLarge
+			}
+			if err == nil {
+				err = out.Close()
+			}
+
+			if err != nil {
+				progress.finish(uploadStatusFailed)
+				return err
+			}
+			// A cancellation after the last write still discards the file
+			if !progress.finish(uploadStatusCompleted) {
+				return context.Canceled
+			}
+			return nil
+		},
+		close: func() {
+			progress.cancel()
+			// Rejected before the file was saved, see cleanupUploadJobs
+			progress.finish(uploadStatusFailed)
+		},
+		checksumHeader: c.Request.Header,
+	}
+}
+
+// uploadProgressHandler reports how many bytes of a streamed upload have
+// been received so far
+func (s *Server) uploadProgressHandler(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	value, exists := s.progressMap.Load(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		return
+	}
+	progress := value.(*uploadProgress)
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"session_id":     sessionID,
+		"bytes_received": progress.bytesReceived.Load(),
+		"complete":       progress.complete.Load(),
+		"status":         progress.status.Load(),
+	})
+}
+
+// cancelUploadHandler aborts an in-progress streamed upload, identified by
+// its X-Upload-Session-ID. The partially written file is deleted.
+func (s *Server) cancelUploadHandler(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	value, exists := s.progressMap.Load(jobID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload job not found"})
+		return
+	}
+	progress := value.(*uploadProgress)
+
+	if !progress.finish(uploadStatusCancelled) && progress.status.Load() != uploadStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "upload already finished",
+			"status": progress.status.Load(),
+		})
+		return
+	}
+	progress.cancel()
+
+	getLogger(c).Info().Str("job_id", jobID).Msg("upload cancelled")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{"cancelled": true})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getUploadProgress(t *testing.T, baseURL, sessionID string) (int, map[string]interface{}) {
+	resp, err := http.Get(baseURL + "/api/upload/progress/" + sessionID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestChunkedUploadProgress(t *testing.T) {
+	server := newTestServer(t)
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	// Stream the multipart body through a pipe so the client sends it chunked
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/videos", pipeReader)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set(uploadSessionHeader, "session-1")
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		done <- result{resp, err}
+	}()
+
+	part, err := form.CreateFormFile("file", "streamed.mp4")
+	require.NoError(t, err)
+	chunk := bytes.Repeat([]byte("v"), 64*1024)
+	_, err = part.Write(chunk)
+	require.NoError(t, err)
+
+	// Progress is visible while the upload is still open
+	require.Eventually(t, func() bool {
+		status, body := getUploadProgress(t, ts.URL, "session-1")
+		return status == http.StatusOK && body["bytes_received"] == float64(len(chunk))
+	}, 2*time.Second, 10*time.Millisecond)
+	_, body := getUploadProgress(t, ts.URL, "session-1")
+	assert.Equal(t, false, body["complete"])
+
+	_, err = part.Write(chunk)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+	require.NoError(t, pipeWriter.Close())
+
+	res := <-done
+	require.NoError(t, res.err)
+	defer res.resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.resp.StatusCode)
+
+	var uploaded struct {
+		Video Video `json:"video"`
+	}
+	require.NoError(t, json.NewDecoder(res.resp.Body).Decode(&uploaded))
+	assert.Equal(t, "streamed.mp4", uploaded.Video.Name)
+	assert.Equal(t, int64(2*len(chunk)), uploaded.Video.Size)
+
+	status, body := getUploadProgress(t, ts.URL, "session-1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(2*len(chunk)), body["bytes_received"])
+	assert.Equal(t, true, body["complete"])
+}
+
+func TestChunkedUploadTooLarge(t *testing.T) {
+	server := newTestServer(t)
+	server.config.MaxFileSize = 1024
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("file", "big.mp4")
+	require.NoError(t, err)
+	part.Write(bytes.Repeat([]byte("v"), 2048))
+	form.Close()
+
+	// Hide the length so the body is sent chunked
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/videos", io.MultiReader(&buf))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, server.db.GetAllVideos())
+}
+
+func TestUploadProgressUnknownSession(t *testing.T) {
+	server := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/upload/progress/missing", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCancelUpload(t *testing.T) {
+	server := newTestServer(t)
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	cancelJob := func(jobID string) int {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/upload/jobs/"+jobID, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/videos", pipeReader)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set(uploadSessionHeader, "slow-upload")
+
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			resp = nil
+		}
+		done <- resp
+	}()
+
+	part, err := form.CreateFormFile("file", "slow.mp4")
+	require.NoError(t, err)
+	chunk := bytes.Repeat([]byte("v"), 64*1024)
+	_, err = part.Write(chunk)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, body := getUploadProgress(t, ts.URL, "slow-upload")
+		return status == http.StatusOK && body["bytes_received"] == float64(len(chunk))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, http.StatusOK, cancelJob("slow-upload"))
+
+	// The next chunk hits the cancelled writer and the upload is rejected.
+	// Keep sending until the server stops reading the rest of the body.
+	go func() {
+		for {
+			if _, err := part.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	resp := <-done
+	pipeWriter.CloseWithError(io.ErrClosedPipe)
+	require.NotNil(t, resp)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	_, body := getUploadProgress(t, ts.URL, "slow-upload")
+	assert.Equal(t, uploadStatusCancelled, body["status"])
+	assert.Equal(t, http.StatusOK, cancelJob("slow-upload"), "cancelling twice is harmless")
+
+	entries, err := os.ReadDir(server.config.StoragePath)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), "slow.mp4")
+	}
+	assert.Empty(t, server.db.GetAllVideos())
+
+	finished := newUploadProgress(context.Background())
+	finished.finish(uploadStatusCompleted)
+	server.progressMap.Store("finished-upload", finished)
+	assert.Equal(t, http.StatusConflict, cancelJob("finished-upload"))
+	assert.Equal(t, http.StatusNotFound, cancelJob("missing"))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// videoEventsFile is where the video event history is appended in
+// StoragePath
+const videoEventsFile = "video_events.jsonl"
+
+// downloadEventSampleRate records one in this many downloads, so popular
+// videos don't flood the history
+const downloadEventSampleRate = 10
+
+// Video event types
+const (
+	VideoEventUploaded    = "upload"
+	VideoEventDownloaded  = "download"
+	VideoEventUpdated     = "update"
+	VideoEventTagsChanged = "tags_changed"
+	VideoEventDeleted     = "delete"
+	VideoEventPurged      = "purge"
+)
+
+// VideoEvent is an entry in a video's audit trail
+type VideoEvent struct {
+	VideoID   string          `json:"video_id"`
+	EventType string          `json:"event_type"`
+	ActorKey  string          `json:"actor_key,omitempty"` // ID of the caller's Principal
+	ClientIP  string          `json:"client_ip,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	OldValue  json.RawMessage `json:"old_value,omitempty"`
+	NewValue  json.RawMessage `json:"new_value,omitempty"`
+}
+
+// VideoEventStore keeps the event history of every video. Events are held
+// in memory and appended to a JSON lines file by a single writer goroutine,
+// so concurrent handlers never interleave their writes.
+type VideoEventStore struct {
+	events map[string][]VideoEvent // video ID -> events, oldest first
+	mutex  sync.RWMutex
+
+	writes chan VideoEvent // nil for a store that is not saved
+	closed bool
+	done   chan struct{}
+}
+
+// NewVideoEventStore creates an event store appending to path, loading the
+// events already there. An empty path keeps events in memory only.
+func NewVideoEventStore(path string) (*VideoEventStore, error) {
+	es := &VideoEventStore{events: make(map[string][]VideoEvent), done: make(chan struct{})}
+	if path == "" {
+		close(es.done)
+		return es, nil
+	}
+
+	if err := es.load(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	es.writes = make(chan VideoEvent, 256)
+	go es.writeLoop(file)
+	return es, nil
+}
+
+// load reads the events already in path. A truncated last line, left by a
+// crash mid-write, is skipped.
+func (es *VideoEventStore) load(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event VideoEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		es.events[event.VideoID] = append(es.events[event.VideoID], event)
+	}
+	return scanner.Err()
+}
+
+// writeLoop appends events to file until the store is closed
+func (es *VideoEventStore) writeLoop(file *os.File) {
+	defer close(es.done)
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for event := range es.writes {
+		if err := encoder.Encode(event); err != nil {
+			log.Error().Err(err).Str("video_id", event.VideoID).Msg("failed to write video event")
+		}
+	}
+}
+
+// Append records an event
+func (es *VideoEventStore) Append(event VideoEvent) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.closed {
+		return
+	}
+	es.events[event.VideoID] = append(es.events[event.VideoID], event)
+	if es.writes != nil {
+		es.writes <- event
+	}
+}
+
+// List returns a video's events, oldest first. A non-empty eventType
+// filters by type.
+func (es *VideoEventStore) List(videoID, eventType string) []VideoEvent {
+	es.mutex.RLock()
+	defer es.mutex.RUnlock()
+
+	events := make([]VideoEvent, 0, len(es.events[videoID]))
+	for _, event := range es.events[videoID] {
+		if eventType == "" || event.EventType == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Close stops recording events and waits for those already recorded to be
+// written
+func (es *VideoEventStore) Close() {
+	es.mutex.Lock()
+	if !es.closed {
+		es.closed = true
+		if es.writes != nil {
+			close(es.writes)
+		}
+	}
+	es.mutex.Unlock()
+
+	<-es.done
+}
+
+// recordVideoEvent adds an event to a video's history. oldValue and
+// newValue are encoded as JSON, nil leaves them out.
+func (s *Server) recordVideoEvent(c *gin.Context, videoID, eventType string, oldValue, newValue interface{}) {
+	event := VideoEvent{
+		VideoID:   videoID,
+		EventType: eventType,
+		Timestamp: time.Now(),
+		OldValue:  marshalEventValue(oldValue),
+		NewValue:  marshalEventValue(newValue),
+	}
+	if c != nil {
+		event.ClientIP = c.ClientIP()
+		if principal := principalFrom(c); principal != nil {
+			event.ActorKey = principal.ID
+		}
+	}
+	s.videoEvents.Append(event)
+}
+
+// marshalEventValue encodes an event's old or new value, nil for none
+func marshalEventValue(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// recordDownloadEvent counts a download and samples it into the video's
+// history
+func (s *Server) recordDownloadEvent(c *gin.Context, videoID string) {
+	if counter, ok := s.db.(downloadCounter); ok {
+		counter.IncrementDownloadCount(videoID)
+	}
+	if s.downloadCount.Add(1)%downloadEventSampleRate == 1 {
+		s.recordVideoEvent(c, videoID, VideoEventDownloaded, nil, nil)
+	}
+}
+
+// getVideoEventsHandler returns a page of a video's event history, oldest
+// first, optionally filtered by event_type
+func (s *Server) getVideoEventsHandler(c *gin.Context) {
+	videoID := c.Param("id")
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	// Deleted videos keep their history, so only unknown IDs are rejected
+	events := s.videoEvents.List(videoID, c.Query("event_type"))
+	if _, exists := s.db.GetVideoByID(videoID); !exists && len(s.videoEvents.List(videoID, "")) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "video not found"})
+		return
+	}
+
+	start := (page - 1) * limit
+	if start > len(events) {
+		start = len(events)
+	}
+	end := start + limit
+	if end > len(events) {
+		end = len(events)
+	}
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"video_id": videoID,
+		"events":   events[start:end],
+		"total":    len(events),
+		"page":     page,
+		"limit":    limit,
+	})
+}
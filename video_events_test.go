@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type videoEventsResponse struct {
+	Events []VideoEvent `json:"events"`
+	Total  int          `json:"total"`
+}
+
+func getVideoEvents(t *testing.T, server *Server, videoID, query string) (int, videoEventsResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID+"/events"+query, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var resp videoEventsResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func TestVideoEvents(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "events.mp4", []byte("event history"))
+
+	code, _ := batchUpdate(t, server, fmt.Sprintf(`{"ids": [%q], "updates": {"tags": {"add": ["review"]}}}`, video.ID))
+	require.Equal(t, http.StatusOK, code)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+video.ID, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("History outlives the video", func(t *testing.T) {
+		code, resp := getVideoEvents(t, server, video.ID, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 3, resp.Total)
+
+		types := []string{resp.Events[0].EventType, resp.Events[1].EventType, resp.Events[2].EventType}
+		assert.Equal(t, []string{VideoEventUploaded, VideoEventTagsChanged, VideoEventDeleted}, types)
+		assert.JSONEq(t, `["review"]`, string(resp.Events[1].NewValue))
+		assert.Empty(t, resp.Events[2].NewValue)
+	})
+
+	t.Run("Filter by event type", func(t *testing.T) {
+		_, resp := getVideoEvents(t, server, video.ID, "?event_type="+VideoEventTagsChanged)
+		require.Len(t, resp.Events, 1)
+		assert.Equal(t, VideoEventTagsChanged, resp.Events[0].EventType)
+	})
+
+	t.Run("Unknown video", func(t *testing.T) {
+		code, _ := getVideoEvents(t, server, "missing", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func TestVideoEventsSampleDownloads(t *testing.T) {
+	server := newTestServer(t)
+	video := uploadTestVideo(t, server, "popular.mp4", []byte("downloaded often"))
+
+	for i := 0; i < 2*downloadEventSampleRate; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	_, resp := getVideoEvents(t, server, video.ID, "?event_type="+VideoEventDownloaded)
+	assert.Equal(t, 2, resp.Total)
+}
+
+func TestVideoEventStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), videoEventsFile)
+
+	store, err := NewVideoEventStore(path)
+	require.NoError(t, err)
+	store.Append(VideoEvent{VideoID: "a", EventType: VideoEventUploaded})
+	store.Append(VideoEvent{VideoID: "a", EventType: VideoEventDeleted})
+	store.Close()
+
+	reopened, err := NewVideoEventStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	events := reopened.List("a", "")
+	require.Len(t, events, 2)
+	assert.Equal(t, VideoEventDeleted, events[1].EventType)
+}
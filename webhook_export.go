@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookExportVersion is the version of the webhook export format
+const webhookExportVersion = "1"
+
+// Modes of a webhook import
+const (
+	WebhookImportMerge   = "merge"
+	WebhookImportReplace = "replace"
+)
+
+// WebhookExport is the full list of webhook registrations, in a form that
+// can be kept in version control and imported again
+type WebhookExport struct {
+	Version  string               `json:"version"`
+	Webhooks []WebhookExportEntry `json:"webhooks"`
+}
+
+// WebhookExportEntry is one webhook registration, with the fields of
+// POST /api/webhooks other than event and url under options
+type WebhookExportEntry struct {
+	Event   string                `json:"event"`
+	URL     string                `json:"url"`
+	Filter  *webhookFilterRequest `json:"filter,omitempty"`
+	Options WebhookExportOptions  `json:"options"`
+}
+
+// WebhookExportOptions are the delivery options of an exported webhook
+type WebhookExportOptions struct {
+	AcceptEncoding  string          `json:"accept_encoding,omitempty"`
+	CollectionID    string          `json:"collection_id,omitempty"`
+	PayloadTemplate string          `json:"payload_template,omitempty"`
+	MetadataSchema  json.RawMessage `json:"metadata_schema,omitempty"`
+}
+
+// WebhookImportError reports an entry of an import that was rejected
+type WebhookImportError struct {
+	Index int    `json:"index"`
+	Event string `json:"event"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// exportEntry describes record as an export entry
+func exportEntry(event string, record WebhookRecord) WebhookExportEntry {
+	entry := WebhookExportEntry{
+		Event: event,
+		URL:   record.URL,
+		Options: WebhookExportOptions{
+			CollectionID:    record.CollectionID,
+			PayloadTemplate: record.PayloadTemplate,
+			MetadataSchema:  record.MetadataSchema,
+		},
+	}
+	if record.Compress {
+		entry.Options.AcceptEncoding = "gzip"
+	}
+	if record.Filter != nil {
+		matches := record.Filter.Matches
+		entry.Filter = &webhookFilterRequest{JSONPath: record.Filter.JSONPath, Matches: &matches}
+	}
+	return entry
+}
+
+// ExportWebhooks returns every webhook registration, sorted by event,
+// collection and URL
+func (wm *WebhookManager) ExportWebhooks() []WebhookExportEntry {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	entries := []WebhookExportEntry{}
+	for event, records := range wm.webhooks {
+		for _, record := range records {
+			entries = append(entries, exportEntry(event, record))
+		}
+	}
+	for event, collections := range wm.collectionWebhooks {
+		for _, records := range collections {
+			for _, record := range records {
+				entries = append(entries, exportEntry(event, record))
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		if a.Options.CollectionID != b.Options.CollectionID {
+			return a.Options.CollectionID < b.Options.CollectionID
+		}
+		return a.URL < b.URL
+	})
+	return entries
+}
+
+// webhookImport is a validated webhook registration ready to be added
+type webhookImport struct {
+	event  string
+	record WebhookRecord
+}
+
+// webhookKey identifies a registration by event, collection and URL
+type webhookKey struct {
+	event, collectionID, url string
+}
+
+// registeredLocked reports whether a webhook is registered. The caller must
+// hold the lock.
+func (wm *WebhookManager) registeredLocked(key webhookKey) bool {
+	records := wm.webhooks[key.event]
+	if key.collectionID != "" {
+		records = wm.collectionWebhooks[key.event][key.collectionID]
+	}
+	for _, record := range records {
+		if record.URL == key.url {
+			return true
+		}
+	}
+	return false
+}
+
+// registrationsLocked returns the key of every registered webhook. The
+// caller must hold the lock.
+func (wm *WebhookManager) registrationsLocked() map[webhookKey]bool {
+	keys := make(map[webhookKey]bool)
+	for event, records := range wm.webhooks {
+		for _, record := range records {
+			keys[webhookKey{event, "", record.URL}] = true
+		}
+	}
+	for event, collections := range wm.collectionWebhooks {
+		for collectionID, records := range collections {
+			for _, record := range records {
+				keys[webhookKey{event, collectionID, record.URL}] = true
+			}
+		}
+	}
+	return keys
+}
+
+// ImportWebhooks adds the webhooks, skipping those already registered. With
+// replace every existing webhook is removed first. Either all webhooks are
+// imported or, when a limit is reached, none and the registrations are left
+// as they were.
+func (wm *WebhookManager) ImportWebhooks(imports []webhookImport, replace bool) (imported, skipped int, err error) {
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
+
+	before := wm.registrationsLocked()
+	savedWebhooks := make(map[string][]WebhookRecord, len(wm.webhooks))
+	for event, records := range wm.webhooks {
+		savedWebhooks[event] = append([]WebhookRecord(nil), records...)
+	}
+	savedCollections := make(map[string]map[string][]WebhookRecord, len(wm.collectionWebhooks))
+	for event, collections := range wm.collectionWebhooks {
+		savedCollections[event] = make(map[string][]WebhookRecord, len(collections))
+		for collectionID, records := range collections {
+			savedCollections[event][collectionID] = append([]WebhookRecord(nil), records...)
+		}
+	}
+
+	if replace {
+		wm.webhooks = make(map[string][]WebhookRecord)
+		wm.collectionWebhooks = make(map[string]map[string][]WebhookRecord)
+	}
+
+	for _, imp := range imports {
+		key := webhookKey{imp.event, imp.record.CollectionID, imp.record.URL}
+		if wm.registeredLocked(key) {
+			skipped++
+			continue
+		}
+		if _, err := wm.addWebhookRecordLocked(imp.event, imp.record); err != nil {
+			wm.webhooks, wm.collectionWebhooks = savedWebhooks, savedCollections
+			return 0, 0, err
+		}
+		imported++
+	}
+
+	// Announced once the import can no longer be rolled back
+	after := wm.registrationsLocked()
+	for key := range before {
+		if !after[key] {
+			wm.broadcaster.Broadcast(WebhookChangeEvent{Event: "removed", EventType: key.event, URL: key.url, CollectionID: key.collectionID})
+		}
+	}
+	for key := range after {
+		if !before[key] {
+			wm.broadcaster.Broadcast(WebhookChangeEvent{Event: "added", EventType: key.event, URL: key.url, CollectionID: key.collectionID})
+		}
+	}
+	return imported, skipped, nil
+}
+
+// exportWebhooksHandler returns every webhook registration in the import
+// format
+func (s *Server) exportWebhooksHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, WebhookExport{
+		Version:  webhookExportVersion,
+		Webhooks: s.webhookMgr.ExportWebhooks(),
+	})
+}
+
+// validateWebhookImport checks an entry the way addWebhookHandler checks a
+// registration and returns it ready to add
+func (s *Server) validateWebhookImport(entry WebhookExportEntry) (webhookImport, error) {
+	if entry.Event == "" {
+		return webhookImport{}, errors.New("event is required")
+	}
+	if err := s.validateWebhookURL(entry.URL); err != nil {
+		return webhookImport{}, err
+	}
+	switch entry.Options.AcceptEncoding {
+	case "", "gzip", "identity":
+	default:
+		return webhookImport{}, fmt.Errorf("accept_encoding must be gzip or identity, got %q", entry.Options.AcceptEncoding)
+	}
+
+	filter, err := entry.Filter.compile()
+	if err != nil {
+		return webhookImport{}, err
+	}
+	record := WebhookRecord{
+		URL:             entry.URL,
+		Compress:        entry.Options.AcceptEncoding == "gzip",
+		CollectionID:    entry.Options.CollectionID,
+		Filter:          filter,
+		PayloadTemplate: entry.Options.PayloadTemplate,
+	}
+	if record.PayloadTemplate != "" {
+		if record.payloadTemplate, err = parseWebhookTemplate(record.PayloadTemplate); err != nil {
+			return webhookImport{}, err
+		}
+	}
+	if record.metadataSchema, err = parseMetadataSchema(entry.Options.MetadataSchema); err != nil {
+		return webhookImport{}, err
+	}
+	if record.metadataSchema != nil {
+		record.MetadataSchema = entry.Options.MetadataSchema
+	}
+	return webhookImport{event: entry.Event, record: record}, nil
+}
+
+// importWebhooksHandler registers the webhooks of an export. With
+// ?mode=replace the existing webhooks are removed first, the default merge
+// keeps them and skips webhooks already registered. Nothing changes unless
+// every entry is valid.
+func (s *Server) importWebhooksHandler(c *gin.Context) {
+	mode := c.DefaultQuery("mode", WebhookImportMerge)
+	if mode != WebhookImportMerge && mode != WebhookImportReplace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be merge or replace"})
+		return
+	}
+
+	var req WebhookExport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Version != webhookExportVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported export version %q, expected %q", req.Version, webhookExportVersion)})
+		return
+	}
+
+	imports := make([]webhookImport, 0, len(req.Webhooks))
+	importErrors := []WebhookImportError{}
+	for i, entry := range req.Webhooks {
+		imp, err := s.validateWebhookImport(entry)
+		if err != nil {
+			importErrors = append(importErrors, WebhookImportError{Index: i, Event: entry.Event, URL: entry.URL, Error: err.Error()})
+			continue
+		}
+		imports = append(imports, imp)
+	}
+	if len(importErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "invalid webhooks, nothing was imported",
+			"imported": 0,
+			"skipped":  0,
+			"errors":   importErrors,
+		})
+		return
+	}
+
+	imported, skipped, err := s.webhookMgr.ImportWebhooks(imports, mode == WebhookImportReplace)
+	if err != nil {
+		if errors.Is(err, ErrWebhookLimitReached) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		getLogger(c).Error().Err(err).Msg("failed to import webhooks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import webhooks"})
+		return
+	}
+
+	getLogger(c).Info().
+		Str("mode", mode).
+		Int("imported", imported).
+		Int("skipped", skipped).
+		Msg("webhooks imported")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"imported": imported,
+		"skipped":  skipped,
+		"errors":   importErrors,
+	})
+}
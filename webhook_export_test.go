@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookImportResponse struct {
+	Imported int                  `json:"imported"`
+	Skipped  int                  `json:"skipped"`
+	Errors   []WebhookImportError `json:"errors"`
+}
+
+func exportWebhooks(t *testing.T, server *Server) WebhookExport {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks/export", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var export WebhookExport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	return export
+}
+
+func importWebhooks(t *testing.T, server *Server, mode string, body interface{}) (int, webhookImportResponse) {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks/import?mode="+mode, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var resp webhookImportResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// exportedURLs returns the event and URL of each exported webhook
+func exportedURLs(export WebhookExport) []string {
+	var urls []string
+	for _, entry := range export.Webhooks {
+		urls = append(urls, entry.Event+" "+entry.URL)
+	}
+	return urls
+}
+
+func TestWebhookExport(t *testing.T) {
+	server := newTestServer(t)
+
+	filter, err := NewWebhookFilter(`$.video.tags[?(@=='production')]`, false)
+	require.NoError(t, err)
+	require.NoError(t, server.webhookMgr.AddWebhookRecord("video.uploaded", WebhookRecord{URL: "https://b.example.com/hook", Compress: true, Filter: filter}))
+	require.NoError(t, server.webhookMgr.AddWebhookRecord("video.uploaded", WebhookRecord{URL: "https://a.example.com/hook", CollectionID: "movies", PayloadTemplate: `{"id": {{json .video.id}}}`}))
+	require.NoError(t, server.webhookMgr.AddWebhook("video.deleted", "https://a.example.com/hook"))
+
+	export := exportWebhooks(t, server)
+	assert.Equal(t, "1", export.Version)
+	assert.Equal(t, []string{
+		"video.deleted https://a.example.com/hook",
+		"video.uploaded https://b.example.com/hook",
+		"video.uploaded https://a.example.com/hook",
+	}, exportedURLs(export), "sorted by event, collection and URL")
+
+	compressed := export.Webhooks[1]
+	assert.Equal(t, "gzip", compressed.Options.AcceptEncoding)
+	require.NotNil(t, compressed.Filter)
+	assert.Equal(t, `$.video.tags[?(@=='production')]`, compressed.Filter.JSONPath)
+	assert.False(t, *compressed.Filter.Matches)
+
+	scoped := export.Webhooks[2]
+	assert.Equal(t, "movies", scoped.Options.CollectionID)
+	assert.Equal(t, `{"id": {{json .video.id}}}`, scoped.Options.PayloadTemplate)
+
+	t.Run("Round trip", func(t *testing.T) {
+		other := newTestServer(t)
+		code, resp := importWebhooks(t, other, WebhookImportReplace, export)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 3, resp.Imported)
+		assert.Equal(t, export.Webhooks, exportWebhooks(t, other).Webhooks)
+	})
+}
+
+func TestWebhookImportMerge(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", "https://existing.example.com/hook"))
+
+	code, resp := importWebhooks(t, server, WebhookImportMerge, WebhookExport{
+		Version: "1",
+		Webhooks: []WebhookExportEntry{
+			// Conflicts with the existing registration, which is kept as is
+			{Event: "video.uploaded", URL: "https://existing.example.com/hook", Options: WebhookExportOptions{AcceptEncoding: "gzip"}},
+			{Event: "video.uploaded", URL: "https://new.example.com/hook"},
+			{Event: "video.deleted", URL: "https://existing.example.com/hook"},
+		},
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 1, resp.Skipped)
+	assert.NotNil(t, resp.Errors)
+	assert.Empty(t, resp.Errors)
+
+	export := exportWebhooks(t, server)
+	assert.Equal(t, []string{
+		"video.deleted https://existing.example.com/hook",
+		"video.uploaded https://existing.example.com/hook",
+		"video.uploaded https://new.example.com/hook",
+	}, exportedURLs(export))
+	assert.Empty(t, export.Webhooks[1].Options.AcceptEncoding)
+}
+
+func TestWebhookImportReplace(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", "https://kept.example.com/hook"))
+	require.NoError(t, server.webhookMgr.AddWebhook("video.deleted", "https://removed.example.com/hook"))
+	require.NoError(t, server.webhookMgr.AddWebhookRecord("video.uploaded", WebhookRecord{URL: "https://removed.example.com/hook", CollectionID: "movies"}))
+
+	code, resp := importWebhooks(t, server, WebhookImportReplace, WebhookExport{
+		Version: "1",
+		Webhooks: []WebhookExportEntry{
+			{Event: "video.uploaded", URL: "https://kept.example.com/hook", Options: WebhookExportOptions{AcceptEncoding: "gzip"}},
+			{Event: "video.uploaded", URL: "https://new.example.com/hook"},
+			{Event: "video.uploaded", URL: "https://new.example.com/hook"},
+		},
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 1, resp.Skipped, "duplicates in the document are skipped")
+
+	export := exportWebhooks(t, server)
+	assert.Equal(t, []string{
+		"video.uploaded https://kept.example.com/hook",
+		"video.uploaded https://new.example.com/hook",
+	}, exportedURLs(export))
+	assert.Equal(t, "gzip", export.Webhooks[0].Options.AcceptEncoding, "replaced webhooks take the imported options")
+	assert.Empty(t, server.webhookMgr.GetAllCollectionWebhooks())
+}
+
+func TestWebhookImportAllOrNothing(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", "https://existing.example.com/hook"))
+	before := exportWebhooks(t, server)
+
+	t.Run("Invalid entries", func(t *testing.T) {
+		code, resp := importWebhooks(t, server, WebhookImportReplace, WebhookExport{
+			Version: "1",
+			Webhooks: []WebhookExportEntry{
+				{Event: "video.uploaded", URL: "https://valid.example.com/hook"},
+				{Event: "video.uploaded", URL: "http://10.0.0.1/hook"},
+				{Event: "video.uploaded", URL: "https://filtered.example.com/hook", Filter: &webhookFilterRequest{JSONPath: "$.video.tags[?(@=="}},
+				{Event: "", URL: "https://valid.example.com/hook"},
+				{Event: "video.uploaded", URL: "https://templated.example.com/hook", Options: WebhookExportOptions{PayloadTemplate: "{{.video"}},
+			},
+		})
+		require.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, 0, resp.Imported)
+		require.Len(t, resp.Errors, 4)
+		assert.Equal(t, []int{1, 2, 3, 4}, []int{resp.Errors[0].Index, resp.Errors[1].Index, resp.Errors[2].Index, resp.Errors[3].Index})
+		assert.Contains(t, resp.Errors[0].Error, "private address")
+		assert.Equal(t, before, exportWebhooks(t, server))
+	})
+
+	t.Run("Webhook limit", func(t *testing.T) {
+		server.config.MaxTotalWebhooks = 2
+		defer func() { server.config.MaxTotalWebhooks = 0 }()
+
+		code, _ := importWebhooks(t, server, WebhookImportMerge, WebhookExport{
+			Version: "1",
+			Webhooks: []WebhookExportEntry{
+				{Event: "video.uploaded", URL: "https://first.example.com/hook"},
+				{Event: "video.uploaded", URL: "https://second.example.com/hook"},
+			},
+		})
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, before, exportWebhooks(t, server))
+	})
+
+	t.Run("Bad requests", func(t *testing.T) {
+		code, _ := importWebhooks(t, server, "upsert", WebhookExport{Version: "1"})
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = importWebhooks(t, server, WebhookImportMerge, WebhookExport{Version: "2"})
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
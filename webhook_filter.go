@@ -0,0 +1,634 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WebhookFilter decides from the payload whether a webhook is delivered.
+// The webhook is delivered when JSONPath finds something and Matches is
+// true, or finds nothing and Matches is false.
+type WebhookFilter struct {
+	JSONPath string `json:"jsonpath"`
+	Matches  bool   `json:"matches"`
+
+	path *jsonPath
+}
+
+// NewWebhookFilter compiles a filter, failing if the JSONPath is invalid
+func NewWebhookFilter(expr string, matches bool) (*WebhookFilter, error) {
+	path, err := compileJSONPath(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jsonpath: %w", err)
+	}
+	return &WebhookFilter{JSONPath: expr, Matches: matches, path: path}, nil
+}
+
+// Allows reports whether a payload, decoded from JSON, should be delivered
+func (f *WebhookFilter) Allows(payload interface{}) bool {
+	return (len(f.path.Evaluate(payload)) > 0) == f.Matches
+}
+
+// AllowsJSON is Allows for an encoded payload. Payloads wrapped in the v2
+// envelope are filtered on the inner payload, so the same paths work for
+// every schema version.
+func (f *WebhookFilter) AllowsJSON(payload []byte) bool {
+	var decoded interface{}
+	if err := json.Unmarshal(unwrapWebhookPayload(payload), &decoded); err != nil {
+		return !f.Matches
+	}
+	return f.Allows(decoded)
+}
+
+// webhookFilterRequest is the "filter" of webhook requests
+type webhookFilterRequest struct {
+	JSONPath string `json:"jsonpath"`
+	Matches  *bool  `json:"matches"` // defaults to true
+}
+
+// compile returns the request's filter, nil when none was sent
+func (r *webhookFilterRequest) compile() (*WebhookFilter, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if strings.TrimSpace(r.JSONPath) == "" {
+		return nil, errors.New("filter.jsonpath is required")
+	}
+	matches := true
+	if r.Matches != nil {
+		matches = *r.Matches
+	}
+	return NewWebhookFilter(r.JSONPath, matches)
+}
+
+// jsonPath is a compiled JSONPath expression. It supports the common
+// subset: $ or @ roots, .name and ['name'] children, [n] indexes, * and
+// [*] wildcards, .. recursive descent and [?(...)] filters comparing @
+// paths with ==, !=, <, <=, >, >= against literals, combined with &&, ||,
+// ! and parentheses.
+type jsonPath struct {
+	steps []jsonPathStep
+}
+
+// jsonPathStep maps the nodes matched so far to the next nodes
+type jsonPathStep func(nodes []interface{}) []interface{}
+
+// compileJSONPath parses an expression starting at $
+func compileJSONPath(expr string) (*jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, errors.New("expression must start with $")
+	}
+	steps, err := parseJSONPathSteps(expr[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &jsonPath{steps: steps}, nil
+}
+
+// Evaluate returns the nodes of root the path matches
+func (p *jsonPath) Evaluate(root interface{}) []interface{} {
+	nodes := []interface{}{root}
+	for _, step := range p.steps {
+		nodes = step(nodes)
+	}
+	return nodes
+}
+
+// parseJSONPathSteps parses the segments following a path's root
+func parseJSONPathSteps(s string) ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, ".."):
+			steps = append(steps, jsonPathDescendants)
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				continue
+			}
+			step, rest, err := parseJSONPathDotSelector(s)
+			if err != nil {
+				return nil, err
+			}
+			steps, s = append(steps, step), rest
+		case s[0] == '.':
+			step, rest, err := parseJSONPathDotSelector(s[1:])
+			if err != nil {
+				return nil, err
+			}
+			steps, s = append(steps, step), rest
+		case s[0] == '[':
+			end, err := jsonPathClosingBracket(s)
+			if err != nil {
+				return nil, err
+			}
+			step, err := parseJSONPathBracket(strings.TrimSpace(s[1:end]))
+			if err != nil {
+				return nil, err
+			}
+			steps, s = append(steps, step), s[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", s)
+		}
+	}
+	return steps, nil
+}
+
+// isJSONPathNameChar reports whether c may appear in a dotted member name
+func isJSONPathNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseJSONPathDotSelector parses the name or * following a dot
+func parseJSONPathDotSelector(s string) (jsonPathStep, string, error) {
+	if strings.HasPrefix(s, "*") {
+		return jsonPathWildcard, s[1:], nil
+	}
+	end := 0
+	for end < len(s) && isJSONPathNameChar(s[end]) {
+		end++
+	}
+	if end == 0 {
+		return nil, "", fmt.Errorf("expected a member name at %q", s)
+	}
+	return jsonPathChildren([]string{s[:end]}), s[end:], nil
+}
+
+// jsonPathClosingBracket returns the index of the ] closing the [ at the
+// start of s, skipping quoted strings and nested brackets
+func jsonPathClosingBracket(s string) (int, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\'', '"':
+			end, err := jsonPathQuoteEnd(s, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+			if depth == 0 {
+				if c != ']' {
+					return 0, fmt.Errorf("mismatched brackets in %q", s)
+				}
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unclosed bracket in %q", s)
+}
+
+// jsonPathQuoteEnd returns the index of the quote closing the string that
+// starts at s[start]
+func jsonPathQuoteEnd(s string, start int) (int, error) {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case s[start]:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string in %q", s)
+}
+
+// unquoteJSONPathString decodes a single or double quoted string
+func unquoteJSONPathString(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// parseJSONPathBracket parses the contents of a [...] selector
+func parseJSONPathBracket(s string) (jsonPathStep, error) {
+	switch {
+	case s == "*":
+		return jsonPathWildcard, nil
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		filter, err := parseJSONPathFilter(s[2 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		return jsonPathFilterStep(filter), nil
+	case strings.HasPrefix(s, "'") || strings.HasPrefix(s, `"`):
+		var names []string
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			if len(part) < 2 || (part[0] != '\'' && part[0] != '"') || part[len(part)-1] != part[0] {
+				return nil, fmt.Errorf("invalid member name %q", part)
+			}
+			name, err := unquoteJSONPathString(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid member name %q", part)
+			}
+			names = append(names, name)
+		}
+		return jsonPathChildren(names), nil
+	default:
+		var indexes []int
+		for _, part := range strings.Split(s, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q", part)
+			}
+			indexes = append(indexes, index)
+		}
+		return jsonPathIndexes(indexes), nil
+	}
+}
+
+// jsonPathChildren selects the named members of objects
+func jsonPathChildren(names []string) jsonPathStep {
+	return func(nodes []interface{}) []interface{} {
+		var matched []interface{}
+		for _, node := range nodes {
+			if object, ok := node.(map[string]interface{}); ok {
+				for _, name := range names {
+					if value, exists := object[name]; exists {
+						matched = append(matched, value)
+					}
+				}
+			}
+		}
+		return matched
+	}
+}
+
+// jsonPathIndexes selects array elements, negative indexes counting from
+// the end
+func jsonPathIndexes(indexes []int) jsonPathStep {
+	return func(nodes []interface{}) []interface{} {
+		var matched []interface{}
+		for _, node := range nodes {
+			if array, ok := node.([]interface{}); ok {
+				for _, index := range indexes {
+					if index < 0 {
+						index += len(array)
+					}
+					if index >= 0 && index < len(array) {
+						matched = append(matched, array[index])
+					}
+				}
+			}
+		}
+		return matched
+	}
+}
+
+// jsonPathMembers returns an array's elements or an object's values, the
+// latter ordered by key
+func jsonPathMembers(node interface{}) []interface{} {
+	switch value := node.(type) {
+	case []interface{}:
+		return value
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		members := make([]interface{}, len(keys))
+		for i, key := range keys {
+			members[i] = value[key]
+		}
+		return members
+	}
+	return nil
+}
+
+// jsonPathWildcard selects every member
+func jsonPathWildcard(nodes []interface{}) []interface{} {
+	var matched []interface{}
+	for _, node := range nodes {
+		matched = append(matched, jsonPathMembers(node)...)
+	}
+	return matched
+}
+
+// jsonPathDescendants selects each node and everything nested in it, for
+// .. followed by a selector
+func jsonPathDescendants(nodes []interface{}) []interface{} {
+	var matched []interface{}
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		matched = append(matched, node)
+		for _, member := range jsonPathMembers(node) {
+			walk(member)
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+	return matched
+}
+
+// jsonPathFilterStep selects the members for which filter holds
+func jsonPathFilterStep(filter jsonPathPredicate) jsonPathStep {
+	return func(nodes []interface{}) []interface{} {
+		var matched []interface{}
+		for _, member := range jsonPathWildcard(nodes) {
+			if filter(member) {
+				matched = append(matched, member)
+			}
+		}
+		return matched
+	}
+}
+
+// jsonPathPredicate is a compiled [?(...)] expression, given the node @
+type jsonPathPredicate func(node interface{}) bool
+
+// jsonPathOperand evaluates one side of a comparison for the node @
+type jsonPathOperand func(node interface{}) []interface{}
+
+// jsonPathToken is a lexical token of a filter expression
+type jsonPathToken struct {
+	kind    string // "path", "literal", "op", "&&", "||", "!", "(" or ")"
+	text    string
+	literal interface{}
+	path    *jsonPath
+}
+
+// parseJSONPathFilter compiles the expression inside [?(...)]
+func parseJSONPathFilter(expr string) (jsonPathPredicate, error) {
+	tokens, err := tokenizeJSONPathFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &jsonPathFilterParser{tokens: tokens}
+	predicate, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return predicate, nil
+}
+
+// tokenizeJSONPathFilter splits a filter expression into tokens
+func tokenizeJSONPathFilter(s string) ([]jsonPathToken, error) {
+	var tokens []jsonPathToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, jsonPathToken{kind: string(c), text: string(c)})
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, jsonPathToken{kind: s[i : i+2], text: s[i : i+2]})
+			i += 2
+		case strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, jsonPathToken{kind: "op", text: s[i : i+2]})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, jsonPathToken{kind: "op", text: string(c)})
+			i++
+		case c == '!':
+			tokens = append(tokens, jsonPathToken{kind: "!", text: "!"})
+			i++
+		case c == '\'' || c == '"':
+			end, err := jsonPathQuoteEnd(s, i)
+			if err != nil {
+				return nil, err
+			}
+			value, err := unquoteJSONPathString(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:end+1])
+			}
+			tokens = append(tokens, jsonPathToken{kind: "literal", text: s[i : end+1], literal: value})
+			i = end + 1
+		case c == '@':
+			end := i + 1
+			for end < len(s) {
+				if s[end] == '[' {
+					closing, err := jsonPathClosingBracket(s[end:])
+					if err != nil {
+						return nil, err
+					}
+					end += closing + 1
+				} else if s[end] == '.' || s[end] == '*' || isJSONPathNameChar(s[end]) {
+					end++
+				} else {
+					break
+				}
+			}
+			steps, err := parseJSONPathSteps(s[i+1 : end])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, jsonPathToken{kind: "path", text: s[i:end], path: &jsonPath{steps: steps}})
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0 {
+				end++
+			}
+			value, err := strconv.ParseFloat(s[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", s[i:end])
+			}
+			tokens = append(tokens, jsonPathToken{kind: "literal", text: s[i:end], literal: value})
+			i = end
+		default:
+			end := i
+			for end < len(s) && s[end] >= 'a' && s[end] <= 'z' {
+				end++
+			}
+			literals := map[string]interface{}{"true": true, "false": false, "null": nil}
+			value, ok := literals[s[i:end]]
+			if !ok {
+				return nil, fmt.Errorf("unexpected %q in filter", s[i:])
+			}
+			tokens = append(tokens, jsonPathToken{kind: "literal", text: s[i:end], literal: value})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// jsonPathFilterParser is a recursive descent parser over filter tokens.
+// && binds tighter than ||.
+type jsonPathFilterParser struct {
+	tokens []jsonPathToken
+	pos    int
+}
+
+// next returns the next token's kind without consuming it, "" at the end
+func (p *jsonPathFilterParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].kind
+}
+
+func (p *jsonPathFilterParser) parseOr() (jsonPathPredicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(node interface{}) bool { return l(node) || right(node) }
+	}
+	return left, nil
+}
+
+func (p *jsonPathFilterParser) parseAnd() (jsonPathPredicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(node interface{}) bool { return l(node) && right(node) }
+	}
+	return left, nil
+}
+
+func (p *jsonPathFilterParser) parseUnary() (jsonPathPredicate, error) {
+	switch p.next() {
+	case "!":
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(node interface{}) bool { return !inner(node) }, nil
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing ) in filter")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses "operand op operand", or a lone @ path that
+// holds when the path exists
+func (p *jsonPathFilterParser) parseComparison() (jsonPathPredicate, error) {
+	left, leftIsPath, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != "op" {
+		if !leftIsPath {
+			return nil, errors.New("filter must compare or test an @ path")
+		}
+		return func(node interface{}) bool { return len(left(node)) > 0 }, nil
+	}
+
+	op := p.tokens[p.pos].text
+	p.pos++
+	right, _, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(node interface{}) bool {
+		for _, a := range left(node) {
+			for _, b := range right(node) {
+				if compareJSONPathValues(op, a, b) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// parseOperand parses an @ path or a literal
+func (p *jsonPathFilterParser) parseOperand() (jsonPathOperand, bool, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, false, errors.New("incomplete filter")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.kind {
+	case "path":
+		return func(node interface{}) []interface{} {
+			return token.path.Evaluate(node)
+		}, true, nil
+	case "literal":
+		return func(interface{}) []interface{} {
+			return []interface{}{token.literal}
+		}, false, nil
+	}
+	return nil, false, fmt.Errorf("unexpected %q in filter", token.text)
+}
+
+// compareJSONPathValues applies a comparison operator to two decoded JSON
+// values. Ordering only applies to two numbers or two strings.
+func compareJSONPathValues(op string, a, b interface{}) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(a, b)
+	case "!=":
+		return !reflect.DeepEqual(a, b)
+	}
+
+	var cmp int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(x, y)
+	default:
+		return false
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
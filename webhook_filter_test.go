@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONPathFilter(t *testing.T) {
+	payload := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"event": "video.uploaded",
+		"video": {
+			"name": "launch.mp4",
+			"size": 2048,
+			"tags": ["marketing", "production"],
+			"custom_metadata": {"team name": "growth"}
+		}
+	}`), &payload))
+
+	tests := []struct {
+		expr    string
+		matches bool
+	}{
+		{`$.video.tags[?(@=='production')]`, true},
+		{`$.video.tags[?(@ == "staging")]`, false},
+		{`$.video[?(@ > 1024)]`, true},
+		{`$..name`, true},
+		{`$.video.tags[1]`, true},
+		{`$.video.tags[-1]`, true},
+		{`$.video.tags[5]`, false},
+		{`$.video.custom_metadata['team name']`, true},
+		{`$[?(@.size >= 4096 || @.name == 'launch.mp4')]`, true},
+		{`$[?(@.size >= 4096 && @.name == 'launch.mp4')]`, false},
+		{`$[?(@.tags && !(@.size < 100))]`, true},
+		{`$.video.duration`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := NewWebhookFilter(tt.expr, true)
+			require.NoError(t, err)
+			assert.Equal(t, tt.matches, filter.Allows(payload))
+
+			inverted, err := NewWebhookFilter(tt.expr, false)
+			require.NoError(t, err)
+			assert.Equal(t, !tt.matches, inverted.Allows(payload))
+		})
+	}
+
+	t.Run("Invalid expressions", func(t *testing.T) {
+		for _, expr := range []string{
+			`video.tags`,
+			`$.video.tags[`,
+			`$.video.tags[?(@ == 'unterminated)]`,
+			`$.video.tags[?(== 'production')]`,
+			`$.video.tags[?('production')]`,
+			`$.video.tags[x]`,
+			`$.`,
+		} {
+			_, err := NewWebhookFilter(expr, true)
+			assert.Error(t, err, expr)
+		}
+	})
+}
+
+func TestWebhookFilterDelivery(t *testing.T) {
+	server := newTestServer(t)
+	receiver := newWebhookReceiver(t)
+
+	filter, err := NewWebhookFilter(`$.video.tags[?(@=='production')]`, true)
+	require.NoError(t, err)
+	require.NoError(t, server.webhookMgr.AddWebhookRecord("video.uploaded", WebhookRecord{URL: receiver.server.URL, Filter: filter}))
+
+	production := &Video{ID: "a", Name: "a.mp4", Tags: []string{"production"}}
+	draft := &Video{ID: "b", Name: "b.mp4", Tags: []string{"draft"}}
+	for _, video := range []*Video{production, draft} {
+		payload, err := videoWebhookPayload("video.uploaded", video)
+		require.NoError(t, err)
+		server.webhookMgr.NotifyWebhooks("video.uploaded", payload)
+	}
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+	require.Equal(t, 1, receiver.count())
+	assert.Equal(t, "a", receiver.payloads[0]["video"].(map[string]interface{})["id"])
+
+	t.Run("Filters see the inner v2 payload", func(t *testing.T) {
+		server.config.WebhookSchemaVersion = "2"
+		defer func() { server.config.WebhookSchemaVersion = "" }()
+
+		payload, err := videoWebhookPayload("video.uploaded", production)
+		require.NoError(t, err)
+		server.webhookMgr.NotifyWebhooks("video.uploaded", payload)
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+		assert.Equal(t, 2, receiver.count())
+	})
+}
+
+func TestWebhookFilterRequests(t *testing.T) {
+	server := newTestServer(t)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Invalid filter is rejected", func(t *testing.T) {
+		w := post("/api/webhooks", `{"event": "video.uploaded", "url": "https://example.com/hook", "filter": {"jsonpath": "$.video.tags[?(@=="}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid jsonpath")
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		var resp struct {
+			WouldDeliver bool                   `json:"would_deliver"`
+			Payload      map[string]interface{} `json:"payload"`
+		}
+
+		w := post("/api/webhooks/test?dry_run=true", `{"event": "video.uploaded", "filter": {"jsonpath": "$.video.tags[?(@=='sample')]"}}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, resp.WouldDeliver)
+		assert.Equal(t, "video.uploaded", resp.Payload["event"])
+
+		w = post("/api/webhooks/test?dry_run=true", `{"event": "video.uploaded", "filter": {"jsonpath": "$.video.tags[?(@=='sample')]", "matches": false}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.False(t, resp.WouldDeliver)
+
+		w = post("/api/webhooks/test?dry_run=true", `{"event": "video.billed", "payload": {"usage": {"minutes": 90}}, "filter": {"jsonpath": "$[?(@.minutes > 60)]"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, resp.WouldDeliver)
+	})
+
+	t.Run("Test delivery", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+
+		// A literal loopback address would be refused, the test server
+		// resolves every host name to a public address
+		url := strings.Replace(receiver.server.URL, "127.0.0.1", "localhost", 1)
+		w := post("/api/webhooks/test", `{"event": "video.uploaded", "url": "`+url+`"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, 1, receiver.count())
+	})
+}
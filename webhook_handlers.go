@@ -1,7 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,8 +15,13 @@ import (
 // addWebhookHandler adds a new webhook URL for an event
 func (s *Server) addWebhookHandler(c *gin.Context) {
 	var req struct {
-		Event string `json:"event" binding:"required"`
-		URL   string `json:"url" binding:"required,url"`
+		Event           string                `json:"event" binding:"required"`
+		URL             string                `json:"url" binding:"required,url"`
+		AcceptEncoding  string                `json:"accept_encoding" binding:"omitempty,oneof=gzip identity"`
+		CollectionID    *string               `json:"collection_id"`
+		Filter          *webhookFilterRequest `json:"filter"`
+		PayloadTemplate string                `json:"payload_template"`
+		MetadataSchema  json.RawMessage       `json:"metadata_schema"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -18,48 +29,124 @@ func (s *Server) addWebhookHandler(c *gin.Context) {
 		return
 	}
 
-	s.webhookMgr.AddWebhook(req.Event, req.URL)
+	filter, err := req.Filter.compile()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	s.logger.Info().
+	record := WebhookRecord{URL: req.URL, Compress: req.AcceptEncoding == "gzip", Filter: filter, PayloadTemplate: req.PayloadTemplate}
+	if req.PayloadTemplate != "" {
+		if record.payloadTemplate, err = parseWebhookTemplate(req.PayloadTemplate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if record.metadataSchema, err = parseMetadataSchema(req.MetadataSchema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if record.metadataSchema != nil {
+		record.MetadataSchema = req.MetadataSchema
+	}
+
+	if req.CollectionID != nil && *req.CollectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collection_id must not be empty"})
+		return
+	}
+
+	if err := s.validateWebhookURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.CollectionID != nil {
+		record.CollectionID = *req.CollectionID
+	}
+	if err := s.webhookMgr.AddWebhookRecord(req.Event, record); err != nil {
+		if errors.Is(err, ErrWebhookLimitReached) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		getLogger(c).Error().Err(err).Str("event", req.Event).Msg("failed to add webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add webhook"})
+		return
+	}
+
+	getLogger(c).Info().
 		Str("event", req.Event).
 		Str("url", req.URL).
+		Bool("compress", record.Compress).
+		Str("collection_id", record.CollectionID).
 		Msg("webhook added")
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-		"message": "webhook added successfully",
-		"event":   req.Event,
-		"url":     req.URL,
-	})
+	response := gin.H{
+		"success":  true,
+		"message":  "webhook added successfully",
+		"event":    req.Event,
+		"url":      req.URL,
+		"compress": record.Compress,
+	}
+	if record.CollectionID != "" {
+		response["collection_id"] = record.CollectionID
+	}
+	if record.Filter != nil {
+		response["filter"] = record.Filter
+	}
+	if record.PayloadTemplate != "" {
+		response["payload_template"] = record.PayloadTemplate
+	}
+	if record.MetadataSchema != nil {
+		response["metadata_schema"] = record.MetadataSchema
+	}
+	s.respondSuccess(c, http.StatusCreated, response)
 }
 
-// getWebhooksHandler returns all registered webhooks
+// getWebhooksHandler returns all registered webhooks. With
+// ?include_last_error=true it adds the last failed delivery to each URL.
 func (s *Server) getWebhooksHandler(c *gin.Context) {
 	event := c.Query("event")
 
+	var response gin.H
 	if event != "" {
 		// Return webhooks for specific event
 		urls := s.webhookMgr.GetWebhooks(event)
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"event":   event,
-			"urls":    urls,
-		})
+		response = gin.H{
+			"success":             true,
+			"event":               event,
+			"urls":                urls,
+			"collection_webhooks": s.webhookMgr.GetCollectionWebhooks(event),
+		}
 	} else {
 		// Return all webhooks
 		allWebhooks := s.webhookMgr.GetAllWebhooks()
-		c.JSON(http.StatusOK, gin.H{
-			"success":  true,
-			"webhooks": allWebhooks,
-		})
+		response = gin.H{
+			"success":             true,
+			"webhooks":            allWebhooks,
+			"collection_webhooks": s.webhookMgr.GetAllCollectionWebhooks(),
+		}
+	}
+
+	// The last failed delivery to each URL, for debugging subscribers
+	if c.Query("include_last_error") == "true" {
+		response["last_errors"] = s.webhookMgr.LastErrors()
 	}
+
+	s.respondSuccess(c, http.StatusOK, response)
+}
+
+// getWebhookURLsHandler lists each registered webhook URL once with the
+// events it receives, for auditing over-subscribed endpoints
+func (s *Server) getWebhookURLsHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, s.webhookMgr.GetWebhookURLs())
 }
 
 // removeWebhookHandler removes a webhook URL for an event
 func (s *Server) removeWebhookHandler(c *gin.Context) {
 	var req struct {
-		Event string `json:"event" binding:"required"`
-		URL   string `json:"url" binding:"required,url"`
+		Event        string `json:"event" binding:"required"`
+		URL          string `json:"url" binding:"required,url"`
+		CollectionID string `json:"collection_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -67,17 +154,198 @@ func (s *Server) removeWebhookHandler(c *gin.Context) {
 		return
 	}
 
-	s.webhookMgr.RemoveWebhook(req.Event, req.URL)
+	if req.CollectionID != "" {
+		s.webhookMgr.RemoveCollectionWebhook(req.Event, req.CollectionID, req.URL)
+	} else {
+		s.webhookMgr.RemoveWebhook(req.Event, req.URL)
+	}
 
-	s.logger.Info().
+	getLogger(c).Info().
 		Str("event", req.Event).
 		Str("url", req.URL).
 		Msg("webhook removed")
 
-	c.JSON(http.StatusOK, gin.H{
+	s.respondSuccess(c, http.StatusOK, gin.H{
 		"success": true,
 		"message": "webhook removed successfully",
 		"event":   req.Event,
 		"url":     req.URL,
 	})
 }
+
+// receiveWebhookHandler accepts signed webhook notifications from another
+// vid-server instance and applies them locally
+func (s *Server) receiveWebhookHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+		return
+	}
+
+	if !validWebhookSignature(s.config.IncomingWebhookSecret, body, c.GetHeader(webhookSignatureHeader)) {
+		getLogger(c).Warn().Str("client_ip", c.ClientIP()).Msg("rejected incoming webhook with invalid signature")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid webhook signature"})
+		return
+	}
+
+	var payload struct {
+		Event     EventType `json:"event"`
+		Video     *Video    `json:"video"`
+		VideoID   string    `json:"video_id"`
+		SourceURL string    `json:"source_url"` // base URL of the sending instance
+	}
+	// Senders may use either payload envelope
+	if err := json.Unmarshal(unwrapWebhookPayload(body), &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook payload"})
+		return
+	}
+
+	switch payload.Event {
+	case EventVideoUploaded:
+		if payload.Video == nil || payload.Video.ID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "video is required"})
+			return
+		}
+		if !isValidVideoID(payload.Video.ID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid video ID"})
+			return
+		}
+		if _, exists := s.db.GetVideoByID(payload.Video.ID); !exists {
+			go s.fetchAndStore(payload.SourceURL, payload.Video)
+		}
+
+	case EventVideoDeleted:
+		if video, exists := s.db.GetVideoByID(payload.VideoID); exists {
+			s.db.DeleteVideo(video.ID)
+			if err := os.Remove(s.getFilePath(video.ID, video.Name)); err != nil {
+				getLogger(c).Error().Err(err).Str("video_id", video.ID).Msg("failed to delete replicated video file")
+			}
+		}
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported event"})
+		return
+	}
+
+	getLogger(c).Info().Str("event", string(payload.Event)).Msg("incoming webhook processed")
+
+	s.respondSuccess(c, http.StatusOK, gin.H{
+		"success": true,
+		"event":   payload.Event,
+	})
+}
+
+// webhookChangelogHandler serves the webhook payload schema changelog
+func (s *Server) webhookChangelogHandler(c *gin.Context) {
+	c.Header("X-Webhook-Schema-Version", WebhookPayloadSchemaVersion)
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(webhookSchemaChangelog))
+}
+
+// sampleWebhookVideo is the video test payloads are built from
+func sampleWebhookVideo() *Video {
+	now := time.Now()
+	id := newVideoID()
+	return &Video{
+		ID:          id,
+		Name:        "sample.mp4",
+		Size:        1024 * 1024,
+		ContentType: "video/mp4",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		URL:         "/api/videos/" + id,
+		Tags:        []string{"sample"},
+	}
+}
+
+// testWebhookHandler sends a test payload for an event to a URL, skipping
+// it if the filter does not allow it. The payload is built from a sample
+// video unless one is given. With ?dry_run=true nothing is sent, the
+// response only shows whether the filter allows the payload and how the
+// payload template renders it.
+func (s *Server) testWebhookHandler(c *gin.Context) {
+	var req struct {
+		Event           string                `json:"event" binding:"required"`
+		URL             string                `json:"url"`
+		Filter          *webhookFilterRequest `json:"filter"`
+		Payload         json.RawMessage       `json:"payload"`
+		PayloadTemplate string                `json:"payload_template"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return
+	}
+
+	filter, err := req.Filter.compile()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := WebhookRecord{URL: req.URL, PayloadTemplate: req.PayloadTemplate}
+	if req.PayloadTemplate != "" {
+		if record.payloadTemplate, err = parseWebhookTemplate(req.PayloadTemplate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var payload interface{} = req.Payload
+	if len(req.Payload) == 0 {
+		if payload, err = videoWebhookPayload(EventType(req.Event), sampleWebhookVideo()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no sample payload for this event, send a payload"})
+			return
+		}
+	}
+	payloadBytes, err := encodeWebhookPayload(s.config.WebhookSchemaVersion, req.Event, payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	allowed := filter == nil || filter.AllowsJSON(payloadBytes)
+	response := gin.H{
+		"event":         req.Event,
+		"payload":       json.RawMessage(payloadBytes),
+		"dry_run":       dryRun,
+		"would_deliver": allowed,
+	}
+	if record.payloadTemplate != nil {
+		rendered, err := renderWebhookTemplate(record.payloadTemplate, payloadBytes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		response["rendered"] = string(rendered)
+	}
+	if dryRun || !allowed {
+		s.respondSuccess(c, http.StatusOK, response)
+		return
+	}
+
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required unless dry_run is set"})
+		return
+	}
+	if err := s.validateWebhookURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	delivery := s.webhookMgr.sendWebhookNotification(c.Request.Context(), record, payloadBytes)
+	delivery.Event = req.Event
+
+	getLogger(c).Info().
+		Str("event", req.Event).
+		Str("url", req.URL).
+		Int("status", delivery.StatusCode).
+		Msg("test webhook sent")
+
+	response["delivery"] = delivery
+	s.respondSuccess(c, http.StatusOK, response)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookHealthConcurrency is how many webhook URLs are checked at once
+	webhookHealthConcurrency = 10
+
+	// defaultWebhookHealthCheckTimeout applies when WebhookHealthCheckTimeout
+	// is not set
+	defaultWebhookHealthCheckTimeout = 2 * time.Second
+)
+
+// WebhookHealthReport lists which registered webhook URLs answered a HEAD
+// request
+type WebhookHealthReport struct {
+	Reachable   []string          `json:"reachable"`
+	Unreachable []string          `json:"unreachable"`
+	Errors      map[string]string `json:"errors"` // unreachable URL -> reason
+}
+
+// registeredURLs returns every URL registered for any event or collection,
+// once each
+func (wm *WebhookManager) registeredURLs() []string {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	seen := make(map[string]struct{})
+	var urls []string
+	add := func(records []WebhookRecord) {
+		for _, record := range records {
+			if _, exists := seen[record.URL]; !exists {
+				seen[record.URL] = struct{}{}
+				urls = append(urls, record.URL)
+			}
+		}
+	}
+
+	for _, records := range wm.webhooks {
+		add(records)
+	}
+	for _, collections := range wm.collectionWebhooks {
+		for _, records := range collections {
+			add(records)
+		}
+	}
+
+	sort.Strings(urls)
+	return urls
+}
+
+// CheckWebhooks sends a HEAD request to every registered webhook URL. Any
+// response counts as reachable, since receivers often only accept POST;
+// HEAD keeps the check from being mistaken for a delivery.
+func (wm *WebhookManager) CheckWebhooks(timeout time.Duration) WebhookHealthReport {
+	urls := wm.registeredURLs()
+	client := &http.Client{Timeout: timeout}
+
+	errs := make([]error, len(urls))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < webhookHealthConcurrency && i < len(urls); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				resp, err := client.Head(urls[index])
+				if err != nil {
+					errs[index] = err
+					continue
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	for i := range urls {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := WebhookHealthReport{
+		Reachable:   []string{},
+		Unreachable: []string{},
+		Errors:      make(map[string]string),
+	}
+	for i, url := range urls {
+		if errs[i] != nil {
+			report.Unreachable = append(report.Unreachable, url)
+			report.Errors[url] = errs[i].Error()
+			continue
+		}
+		report.Reachable = append(report.Reachable, url)
+	}
+	return report
+}
+
+// webhookHealthHandler reports which registered webhook targets are
+// reachable, so dead targets are noticed before deliveries fail
+func (s *Server) webhookHealthHandler(c *gin.Context) {
+	timeout := s.config.WebhookHealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultWebhookHealthCheckTimeout
+	}
+
+	report := s.webhookMgr.CheckWebhooks(timeout)
+	if len(report.Unreachable) > 0 {
+		getLogger(c).Warn().
+			Strs("unreachable", report.Unreachable).
+			Msg("webhook targets unreachable")
+	}
+
+	c.JSON(http.StatusOK, report)
+}
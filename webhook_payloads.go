@@ -0,0 +1,194 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// WebhookPayloadSchemaVersion is the version of the payload structs below.
+// Bump it and update CHANGELOG.webhooks.md whenever a payload changes.
+const WebhookPayloadSchemaVersion = "1.0"
+
+// webhookSchemaChangelog lists what changed between payload schema versions
+//
+//go:embed CHANGELOG.webhooks.md
+var webhookSchemaChangelog string
+
+// VideoUploadedPayload is sent for video.uploaded
+type VideoUploadedPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	Video         *Video    `json:"video"`
+}
+
+func (p VideoUploadedPayload) webhookCollectionID() string {
+	if p.Video == nil {
+		return ""
+	}
+	return p.Video.CollectionID
+}
+
+// VideoDeletedPayload is sent for video.deleted
+type VideoDeletedPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	Filename      string    `json:"filename"`
+}
+
+// VideoExpiredPayload is sent for video.expired
+type VideoExpiredPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	Filename      string    `json:"filename"`
+	ExpiredAt     time.Time `json:"expired_at"`
+}
+
+// VideoPurgedPayload is sent for video.purged when a retention policy
+// deletes a video
+type VideoPurgedPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	Filename      string    `json:"filename"`
+	ContentType   string    `json:"content_type"`
+	Reason        string    `json:"reason"` // "max_age" or "max_size"
+	Policy        string    `json:"policy"` // content type pattern of the policy
+}
+
+// DiskWarningPayload is sent for disk.warning
+type DiskWarningPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	StoragePath   string    `json:"storage_path"`
+	FreeBytes     uint64    `json:"free_bytes"`
+	TotalBytes    uint64    `json:"total_bytes"`
+	UsedPercent   float64   `json:"used_percent"`
+}
+
+// StorageFileMissingPayload is sent for storage.file_missing when a video's
+// file is missing and could not be restored from backup
+type StorageFileMissingPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	Filename      string    `json:"filename"`
+	Error         string    `json:"error"`
+}
+
+// CommentAddedPayload is sent for video.comment_added
+type CommentAddedPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	Comment       *Comment  `json:"comment"`
+}
+
+// VideoTagsChangedPayload is sent for video.tags_added and
+// video.tags_removed. Both events carry the full change, so a subscriber to
+// one of them still sees the whole picture.
+type VideoTagsChangedPayload struct {
+	SchemaVersion string    `json:"schema_version"`
+	Event         EventType `json:"event"`
+	Timestamp     int64     `json:"timestamp"`
+	VideoID       string    `json:"video_id"`
+	TagsAdded     []string  `json:"tags_added"`
+	TagsRemoved   []string  `json:"tags_removed"`
+	CurrentTags   []string  `json:"current_tags"`
+
+	collectionID string
+}
+
+func (p VideoTagsChangedPayload) webhookCollectionID() string {
+	return p.collectionID
+}
+
+// VideoBilledPayload is sent for video.billed with the storage used by a
+// video since it was last billed
+type VideoBilledPayload struct {
+	SchemaVersion   string    `json:"schema_version"`
+	Event           EventType `json:"event"`
+	Timestamp       int64     `json:"timestamp"`
+	VideoID         string    `json:"video_id"`
+	Filename        string    `json:"filename"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	StorageMinutes  float64   `json:"storage_minutes"` // video-minutes stored over the period
+	PeriodStart     time.Time `json:"period_start"`
+	PeriodEnd       time.Time `json:"period_end"`
+}
+
+// webhookEnvelopeV2 wraps a payload when WebhookSchemaVersion is "2"
+type webhookEnvelopeV2 struct {
+	V       int             `json:"v"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// videoWebhookPayload builds the payload sent to subscribers for a video event
+func videoWebhookPayload(event EventType, video *Video) (interface{}, error) {
+	now := time.Now()
+
+	switch event {
+	case EventVideoUploaded:
+		return VideoUploadedPayload{
+			SchemaVersion: WebhookPayloadSchemaVersion,
+			Event:         event,
+			Timestamp:     now.Unix(),
+			Video:         video,
+		}, nil
+	case EventVideoDeleted:
+		return VideoDeletedPayload{
+			SchemaVersion: WebhookPayloadSchemaVersion,
+			Event:         event,
+			Timestamp:     now.Unix(),
+			VideoID:       video.ID,
+			Filename:      video.Name,
+		}, nil
+	case EventVideoExpired:
+		return VideoExpiredPayload{
+			SchemaVersion: WebhookPayloadSchemaVersion,
+			Event:         event,
+			Timestamp:     now.Unix(),
+			VideoID:       video.ID,
+			Filename:      video.Name,
+			ExpiredAt:     now,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event: %s", event)
+	}
+}
+
+// encodeWebhookPayload serializes a payload, wrapping it in the v2 envelope
+// when configured
+func encodeWebhookPayload(schemaVersion, event string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if schemaVersion != "2" {
+		return data, nil
+	}
+
+	return json.Marshal(webhookEnvelopeV2{V: 2, Event: event, Payload: data})
+}
+
+// unwrapWebhookPayload returns the inner payload of a v2 envelope, or body
+// unchanged if it is not one
+func unwrapWebhookPayload(body []byte) []byte {
+	var envelope webhookEnvelopeV2
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.V != 2 || len(envelope.Payload) == 0 {
+		return body
+	}
+	return envelope.Payload
+}
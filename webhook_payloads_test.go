@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// payloadKeys encodes payload and returns its sorted top-level keys
+func payloadKeys(t *testing.T, schemaVersion, event string, payload interface{}) (map[string]interface{}, []string) {
+	t.Helper()
+
+	data, err := encodeWebhookPayload(schemaVersion, event, payload)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	keys := make([]string, 0, len(decoded))
+	for key := range decoded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return decoded, keys
+}
+
+func TestWebhookPayloadSchemas(t *testing.T) {
+	video := newTestVideo("schema", 42)
+
+	tests := []struct {
+		event string
+		keys  []string
+	}{
+		{"video.uploaded", []string{"event", "schema_version", "timestamp", "video"}},
+		{"video.deleted", []string{"event", "filename", "schema_version", "timestamp", "video_id"}},
+		{"video.expired", []string{"event", "expired_at", "filename", "schema_version", "timestamp", "video_id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			payload, err := videoWebhookPayload(EventType(tt.event), video)
+			require.NoError(t, err)
+
+			decoded, keys := payloadKeys(t, "1", tt.event, payload)
+			assert.Equal(t, tt.keys, keys)
+			assert.Equal(t, WebhookPayloadSchemaVersion, decoded["schema_version"])
+			assert.Equal(t, tt.event, decoded["event"])
+		})
+	}
+
+	t.Run("disk.warning", func(t *testing.T) {
+		payload := DiskWarningPayload{
+			SchemaVersion: WebhookPayloadSchemaVersion,
+			Event:         "disk.warning",
+			StoragePath:   "/storage",
+			FreeBytes:     10,
+			TotalBytes:    100,
+			UsedPercent:   90,
+		}
+
+		decoded, keys := payloadKeys(t, "1", "disk.warning", payload)
+		assert.Equal(t, []string{"event", "free_bytes", "schema_version", "storage_path", "timestamp", "total_bytes", "used_percent"}, keys)
+		assert.Equal(t, float64(90), decoded["used_percent"])
+	})
+
+	t.Run("unsupported event", func(t *testing.T) {
+		_, err := videoWebhookPayload("video.exploded", video)
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookPayloadEnvelopeV2(t *testing.T) {
+	payload, err := videoWebhookPayload("video.deleted", newTestVideo("enveloped", 1))
+	require.NoError(t, err)
+
+	decoded, keys := payloadKeys(t, "2", "video.deleted", payload)
+	assert.Equal(t, []string{"event", "payload", "v"}, keys)
+	assert.Equal(t, float64(2), decoded["v"])
+	assert.Equal(t, "video.deleted", decoded["event"])
+
+	inner := decoded["payload"].(map[string]interface{})
+	assert.Equal(t, "enveloped", inner["video_id"])
+	assert.Equal(t, WebhookPayloadSchemaVersion, inner["schema_version"])
+
+	// Unwrapping yields the flat payload, flat payloads pass through
+	data, err := encodeWebhookPayload("2", "video.deleted", payload)
+	require.NoError(t, err)
+	flat, err := encodeWebhookPayload("1", "video.deleted", payload)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(flat), string(unwrapWebhookPayload(data)))
+	assert.Equal(t, flat, unwrapWebhookPayload(flat))
+}
+
+func TestWebhookDeliveryUsesConfiguredSchema(t *testing.T) {
+	server := newTestServer(t)
+	server.config.WebhookSchemaVersion = "2"
+
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.uploaded", receiver.server.URL))
+
+	video := uploadTestVideo(t, server, "envelope.mp4", []byte("content"))
+	require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+	require.Equal(t, 1, receiver.count())
+	payload := receiver.payloads[0]
+	assert.Equal(t, float64(2), payload["v"])
+	assert.Equal(t, "video.uploaded", payload["event"])
+	assert.Equal(t, video.ID, payload["payload"].(map[string]interface{})["video"].(map[string]interface{})["id"])
+}
+
+func TestReceiveWebhookEnvelopeV2(t *testing.T) {
+	const secret = "shared-secret"
+
+	server := newTestServer(t)
+	server.config.IncomingWebhookSecret = secret
+	video := uploadTestVideo(t, server, "remote-delete.mp4", []byte("content"))
+
+	payload, err := videoWebhookPayload("video.deleted", video)
+	require.NoError(t, err)
+	body, err := encodeWebhookPayload("2", "video.deleted", payload)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks/receive", bytes.NewReader(body))
+	req.Header.Set(webhookSignatureHeader, computeWebhookSignature(secret, body))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	_, exists := server.db.GetVideoByID(video.ID)
+	assert.False(t, exists)
+}
+
+func TestWebhookChangelog(t *testing.T) {
+	server := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks/changelog", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, WebhookPayloadSchemaVersion, w.Header().Get("X-Webhook-Schema-Version"))
+	assert.Contains(t, w.Body.String(), "## Schema "+WebhookPayloadSchemaVersion)
+}
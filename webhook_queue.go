@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookQueueSampleInterval is how often the queue gauges are refreshed
+const webhookQueueSampleInterval = time.Second
+
+// WebhookQueueStats is a sample of the rate limit queues, taken every
+// webhookQueueSampleInterval
+type WebhookQueueStats struct {
+	Depth       int            `json:"depth"`
+	Capacity    int            `json:"capacity"`
+	Utilization float64        `json:"utilization"` // depth / capacity, 0 without queues
+	Events      map[string]int `json:"events"`      // depth per event
+	SampledAt   time.Time      `json:"sampled_at"`
+}
+
+// queuedLocked adjusts the number of queued deliveries of an event. The
+// caller must hold limiterMutex.
+func (wm *WebhookManager) queuedLocked(event string, delta int) {
+	wm.queuedEvents[event] += delta
+	if wm.queuedEvents[event] <= 0 {
+		delete(wm.queuedEvents, event)
+	}
+}
+
+// sampleQueue refreshes the queue gauges from the rate limit queues
+func (wm *WebhookManager) sampleQueue() WebhookQueueStats {
+	stats := WebhookQueueStats{Events: make(map[string]int), SampledAt: time.Now()}
+
+	wm.limiterMutex.Lock()
+	for _, limiter := range wm.limiters {
+		stats.Depth += len(limiter.queue)
+		stats.Capacity += cap(limiter.queue)
+	}
+	for event, depth := range wm.queuedEvents {
+		stats.Events[event] = depth
+	}
+	wm.limiterMutex.Unlock()
+
+	if stats.Capacity > 0 {
+		stats.Utilization = float64(stats.Depth) / float64(stats.Capacity)
+	}
+
+	wm.queueStatsMutex.Lock()
+	wm.queueStats = stats
+	wm.queueStatsMutex.Unlock()
+	return stats
+}
+
+// QueueStats returns the latest queue sample
+func (wm *WebhookManager) QueueStats() WebhookQueueStats {
+	wm.queueStatsMutex.Lock()
+	defer wm.queueStatsMutex.Unlock()
+
+	stats := wm.queueStats
+	stats.Events = make(map[string]int, len(wm.queueStats.Events))
+	for event, depth := range wm.queueStats.Events {
+		stats.Events[event] = depth
+	}
+	return stats
+}
+
+// webhookQueueLoop samples the webhook queues until shutdown
+func (s *Server) webhookQueueLoop() {
+	ticker := time.NewTicker(webhookQueueSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.webhookQueueStop:
+			return
+		case <-ticker.C:
+			stats := s.webhookMgr.sampleQueue()
+			if stats.Utilization >= 0.8 {
+				s.logger.Warn().
+					Int("depth", stats.Depth).
+					Int("capacity", stats.Capacity).
+					Msg("webhook queues are nearly full")
+			}
+		}
+	}
+}
+
+// webhookQueueHandler returns the latest queue sample
+func (s *Server) webhookQueueHandler(c *gin.Context) {
+	s.respondSuccess(c, http.StatusOK, s.webhookMgr.QueueStats())
+}
+
+// metricsHandler serves the webhook queue gauges, segment cache counters
+// and message queue publishing metrics in the Prometheus text exposition
+// format
+func (s *Server) metricsHandler(c *gin.Context) {
+	stats := s.webhookMgr.QueueStats()
+
+	var b strings.Builder
+	writeGauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	writeCounter := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+
+	writeGauge("webhook_queue_depth", "Webhook deliveries waiting behind per-URL rate limits.")
+	fmt.Fprintf(&b, "webhook_queue_depth %d\n", stats.Depth)
+	writeGauge("webhook_queue_capacity", "Webhook deliveries the rate limit queues can hold.")
+	fmt.Fprintf(&b, "webhook_queue_capacity %d\n", stats.Capacity)
+
+	writeGauge("webhook_event_queue_depth", "Queued webhook deliveries by event.")
+	events := make([]string, 0, len(stats.Events))
+	for event := range stats.Events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		fmt.Fprintf(&b, "webhook_event_queue_depth{event=%q} %d\n", event, stats.Events[event])
+	}
+
+	if s.segmentCache != nil {
+		writeCounter("segment_cache_hits_total", "Range requests served from the segment cache.")
+		fmt.Fprintf(&b, "segment_cache_hits_total %d\n", s.segmentCache.Hits())
+		writeCounter("segment_cache_misses_total", "Cachable range requests read from disk.")
+		fmt.Fprintf(&b, "segment_cache_misses_total %d\n", s.segmentCache.Misses())
+	}
+
+	if async, ok := s.publisher.(*AsyncPublisher); ok {
+		writeGauge("message_queue_publish_queue_depth", "Video events waiting to be published to the message queue.")
+		fmt.Fprintf(&b, "message_queue_publish_queue_depth %d\n", async.Depth())
+		writeCounter("message_queue_events_dropped_total", "Video events dropped because the publish queue was full.")
+		fmt.Fprintf(&b, "message_queue_events_dropped_total %d\n", async.Dropped())
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
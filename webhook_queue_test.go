@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookQueueGauges(t *testing.T) {
+	server := newTestServer(t)
+	server.config.WebhookMaxRatePerURL = 1
+	server.config.WebhookBurstPerURL = 1
+	server.config.WebhookQueueSize = 10
+
+	receiver := newWebhookReceiver(t)
+	require.NoError(t, server.webhookMgr.AddWebhook(string(EventVideoUploaded), receiver.server.URL))
+
+	// The limiter sends the first delivery and holds the second until the
+	// next token a second later, leaving eight of ten queued
+	for i := 0; i < 10; i++ {
+		server.webhookMgr.NotifyWebhooks(EventVideoUploaded, map[string]int{"n": i})
+	}
+	var stats WebhookQueueStats
+	require.Eventually(t, func() bool {
+		stats = server.webhookMgr.sampleQueue()
+		return stats.Depth == 8
+	}, 500*time.Millisecond, 5*time.Millisecond)
+
+	assert.Equal(t, 10, stats.Capacity)
+	assert.InDelta(t, 0.8, stats.Utilization, 0.01)
+	assert.Equal(t, map[string]int{"video.uploaded": 8}, stats.Events)
+
+	t.Run("JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/webhooks/queue", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp WebhookQueueStats
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 8, resp.Depth)
+		assert.Equal(t, 10, resp.Capacity)
+		assert.Equal(t, 8, resp.Events["video.uploaded"])
+	})
+
+	t.Run("Prometheus", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body := w.Body.String()
+		assert.Contains(t, body, "# TYPE webhook_queue_depth gauge\nwebhook_queue_depth 8\n")
+		assert.Contains(t, body, "webhook_queue_capacity 10\n")
+		assert.Contains(t, body, `webhook_event_queue_depth{event="video.uploaded"} 8`+"\n")
+	})
+}
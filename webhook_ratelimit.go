@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultWebhookQueueSize applies when WebhookQueueSize is not set
+const defaultWebhookQueueSize = 100
+
+// webhookDispatch is one delivery waiting for its URL's rate limit
+type webhookDispatch struct {
+	ctx          context.Context // carries the trace context of the triggering request
+	record       WebhookRecord
+	event        string
+	payload      []byte
+	isRedelivery bool
+}
+
+// webhookLimiter is a token bucket for one webhook URL. Deliveries wait in
+// queue and are sent by the limiter's goroutine as tokens become available,
+// so they go out in order.
+type webhookLimiter struct {
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	tokens float64
+	last   time.Time
+
+	queue chan webhookDispatch
+}
+
+// take blocks until a token is available and consumes it. Only the
+// limiter's goroutine calls it.
+func (l *webhookLimiter) take() {
+	for {
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			return
+		}
+		time.Sleep(time.Duration((1 - l.tokens) / l.rate * float64(time.Second)))
+	}
+}
+
+// limiterFor returns the rate limiter of a URL, starting it on first use
+func (wm *WebhookManager) limiterFor(url string) *webhookLimiter {
+	wm.limiterMutex.Lock()
+	defer wm.limiterMutex.Unlock()
+
+	if limiter, exists := wm.limiters[url]; exists {
+		return limiter
+	}
+
+	burst := wm.config.WebhookBurstPerURL
+	if burst < 1 {
+		burst = 1
+	}
+	queueSize := wm.config.WebhookQueueSize
+	if queueSize < 1 {
+		queueSize = defaultWebhookQueueSize
+	}
+
+	limiter := &webhookLimiter{
+		rate:   float64(wm.config.WebhookMaxRatePerURL),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		queue:  make(chan webhookDispatch, queueSize),
+	}
+	wm.limiters[url] = limiter
+	go wm.runLimiter(limiter)
+	return limiter
+}
+
+// runLimiter sends a URL's queued deliveries at its configured rate
+func (wm *WebhookManager) runLimiter(limiter *webhookLimiter) {
+	for dispatch := range limiter.queue {
+		wm.limiterMutex.Lock()
+		wm.queuedLocked(dispatch.event, -1)
+		wm.limiterMutex.Unlock()
+
+		limiter.take()
+		go func(dispatch webhookDispatch) {
+			defer wm.inFlight.Done()
+			wm.send(dispatch)
+		}(dispatch)
+	}
+}
+
+// enqueueRateLimited queues a delivery behind its URL's rate limit. It is
+// dropped, and recorded as failed, when the URL's queue is full.
+func (wm *WebhookManager) enqueueRateLimited(dispatch webhookDispatch) {
+	wm.inFlight.Add(1)
+
+	// Counted before it is queued, so the limiter never takes it off first
+	limiter := wm.limiterFor(dispatch.record.URL)
+	wm.limiterMutex.Lock()
+	wm.queuedLocked(dispatch.event, 1)
+	wm.limiterMutex.Unlock()
+
+	select {
+	case limiter.queue <- dispatch:
+	default:
+		wm.limiterMutex.Lock()
+		wm.queuedLocked(dispatch.event, -1)
+		wm.limiterMutex.Unlock()
+		wm.inFlight.Done()
+		log.Warn().
+			Str("url", dispatch.record.URL).
+			Str("event", dispatch.event).
+			Msg("webhook rate limit queue full, dropping delivery")
+		wm.recordDelivery(WebhookDelivery{
+			URL:          dispatch.record.URL,
+			Event:        dispatch.event,
+			Error:        "rate limit queue full",
+			IsRedelivery: dispatch.isRedelivery,
+			DeliveredAt:  time.Now(),
+			Result:       &WebhookDeliveryResult{Error: "rate limit queue full"}, // never attempted
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookChangeBuffer is how many changes a stream may fall behind by
+	// before it is disconnected
+	webhookChangeBuffer = 64
+
+	// webhookStreamKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't close it
+	webhookStreamKeepAlive = 30 * time.Second
+)
+
+// WebhookChangeEvent describes a webhook being added or removed
+type WebhookChangeEvent struct {
+	Event        string `json:"event"`      // "added" or "removed"
+	EventType    string `json:"event_type"` // the webhook's event, e.g. "video.uploaded"
+	URL          string `json:"url"`
+	CollectionID string `json:"collection_id,omitempty"`
+}
+
+// webhookBroadcaster fans webhook changes out to stream subscribers
+type webhookBroadcaster struct {
+	subscribers map[chan WebhookChangeEvent]struct{}
+	mutex       sync.Mutex
+}
+
+func newWebhookBroadcaster() *webhookBroadcaster {
+	return &webhookBroadcaster{subscribers: make(map[chan WebhookChangeEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving every change from now on and a
+// function ending the subscription. The channel is closed when the
+// subscription ends, including when the subscriber falls too far behind.
+func (b *webhookBroadcaster) Subscribe() (<-chan WebhookChangeEvent, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	changes := make(chan WebhookChangeEvent, webhookChangeBuffer)
+	b.subscribers[changes] = struct{}{}
+	return changes, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.removeLocked(changes)
+	}
+}
+
+// Broadcast sends change to every subscriber without blocking. Subscribers
+// whose buffer is full are dropped, so they reconnect and start from a
+// fresh snapshot instead of silently missing a change.
+func (b *webhookBroadcaster) Broadcast(change WebhookChangeEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for changes := range b.subscribers {
+		select {
+		case changes <- change:
+		default:
+			b.removeLocked(changes)
+		}
+	}
+}
+
+// removeLocked ends a subscription. The caller must hold the lock.
+func (b *webhookBroadcaster) removeLocked(changes chan WebhookChangeEvent) {
+	if _, exists := b.subscribers[changes]; exists {
+		delete(b.subscribers, changes)
+		close(changes)
+	}
+}
+
+// webhookSnapshot is the first message of a webhook stream
+type webhookSnapshot struct {
+	Event              string                         `json:"event"` // always "snapshot"
+	Webhooks           map[string][]string            `json:"webhooks"`
+	CollectionWebhooks map[string]map[string][]string `json:"collection_webhooks"`
+}
+
+// SubscribeChanges returns the current webhooks along with a subscription
+// to every change after them, see webhookBroadcaster.Subscribe
+func (wm *WebhookManager) SubscribeChanges() (webhookSnapshot, <-chan WebhookChangeEvent, func()) {
+	// Changes are broadcast with the write lock held, so none can slip in
+	// between the snapshot and the subscription
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	snapshot := webhookSnapshot{
+		Event:              "snapshot",
+		Webhooks:           make(map[string][]string),
+		CollectionWebhooks: make(map[string]map[string][]string),
+	}
+	for event, records := range wm.webhooks {
+		snapshot.Webhooks[event] = webhookURLs(records)
+	}
+	for event, collections := range wm.collectionWebhooks {
+		snapshot.CollectionWebhooks[event] = make(map[string][]string)
+		for collectionID, records := range collections {
+			snapshot.CollectionWebhooks[event][collectionID] = webhookURLs(records)
+		}
+	}
+
+	changes, cancel := wm.broadcaster.Subscribe()
+	return snapshot, changes, cancel
+}
+
+// webhookStreamHandler streams the webhook subscriptions as server-sent
+// events: a snapshot first, then every webhook added or removed
+func (s *Server) webhookStreamHandler(c *gin.Context) {
+	snapshot, changes, cancel := s.webhookMgr.SubscribeChanges()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	if err := writeServerSentEvent(c, snapshot); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(webhookStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				getLogger(c).Warn().Msg("webhook stream fell behind, disconnecting")
+				return
+			}
+			if err := writeServerSentEvent(c, change); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeServerSentEvent sends message as the JSON data of an event
+func writeServerSentEvent(c *gin.Context, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
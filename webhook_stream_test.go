@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readServerSentEvents decodes the data of each event on a stream until it
+// is closed
+func readServerSentEvents(body io.Reader) <-chan map[string]interface{} {
+	events := make(chan map[string]interface{})
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var event map[string]interface{}
+				if json.Unmarshal([]byte(data), &event) == nil {
+					events <- event
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func TestWebhookStream(t *testing.T) {
+	server := newTestServer(t)
+	require.NoError(t, server.webhookMgr.AddWebhook("video.deleted", "https://example.com/existing"))
+
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/webhooks/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := readServerSentEvents(resp.Body)
+	next := func() map[string]interface{} {
+		select {
+		case event, ok := <-events:
+			require.True(t, ok, "stream closed")
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event received")
+			return nil
+		}
+	}
+
+	snapshot := next()
+	assert.Equal(t, "snapshot", snapshot["event"])
+	assert.Equal(t, map[string]interface{}{"video.deleted": []interface{}{"https://example.com/existing"}}, snapshot["webhooks"])
+
+	w := postJSON(server, "/api/webhooks", `{"event": "video.uploaded", "url": "https://example.com/new"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, map[string]interface{}{"event": "added", "event_type": "video.uploaded", "url": "https://example.com/new"}, next())
+
+	server.webhookMgr.RemoveWebhook("video.deleted", "https://example.com/existing")
+	assert.Equal(t, map[string]interface{}{"event": "removed", "event_type": "video.deleted", "url": "https://example.com/existing"}, next())
+}
+
+func TestWebhookBroadcasterDropsSlowSubscribers(t *testing.T) {
+	broadcaster := newWebhookBroadcaster()
+	changes, cancel := broadcaster.Subscribe()
+	defer cancel()
+
+	for i := 0; i <= webhookChangeBuffer; i++ {
+		broadcaster.Broadcast(WebhookChangeEvent{Event: "added"})
+	}
+
+	received := 0
+	for range changes {
+		received++
+	}
+	assert.Equal(t, webhookChangeBuffer, received, "channel is closed once the buffer overflows")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+// maxWebhookTemplateOutput bounds the size of a rendered payload template
+const maxWebhookTemplateOutput = 64 * 1024
+
+// errWebhookTemplateTooLarge is returned when a template renders more than
+// maxWebhookTemplateOutput bytes
+var errWebhookTemplateTooLarge = fmt.Errorf("rendered payload template exceeds %d bytes", maxWebhookTemplateOutput)
+
+// webhookTemplateFuncs are available to payload templates. json encodes a
+// value, so strings can be embedded in JSON output with proper escaping.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseWebhookTemplate compiles a webhook's payload template
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("payload").Funcs(webhookTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// limitedBuffer is a buffer refusing writes beyond its limit
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		return 0, errWebhookTemplateTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// renderWebhookTemplate renders tmpl with the decoded payload as its data.
+// Payloads wrapped in the v2 envelope are rendered from the inner payload,
+// so the same template works for every schema version.
+func renderWebhookTemplate(tmpl *template.Template, payload []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(unwrapWebhookPayload(payload), &data); err != nil {
+		return nil, err
+	}
+
+	out := &limitedBuffer{limit: maxWebhookTemplateOutput}
+	if err := tmpl.Execute(out, data); err != nil {
+		// text/template wraps errors from the writer
+		if errors.Is(err, errWebhookTemplateTooLarge) {
+			return nil, errWebhookTemplateTooLarge
+		}
+		return nil, err
+	}
+	return out.buf.Bytes(), nil
+}
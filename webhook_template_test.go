@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPayloadTemplate(t *testing.T) {
+	server := newTestServer(t)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Slack message", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		url := strings.Replace(receiver.server.URL, "127.0.0.1", "localhost", 1)
+		w := post("/api/webhooks", `{"event": "video.uploaded", "url": "`+url+`", "payload_template": "{\"text\": {{json (printf \"New video: %s\" .video.name)}}}"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		uploadTestVideo(t, server, "launch \"final\".mp4", []byte("content"))
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		require.Equal(t, 1, receiver.count())
+		assert.Equal(t, map[string]interface{}{"text": `New video: launch "final".mp4`}, receiver.payloads[0])
+	})
+
+	t.Run("Empty template sends the JSON payload", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		require.NoError(t, server.webhookMgr.AddWebhook("video.deleted", receiver.server.URL))
+
+		payload, err := videoWebhookPayload("video.deleted", &Video{ID: "a", Name: "a.mp4"})
+		require.NoError(t, err)
+		server.webhookMgr.NotifyWebhooks("video.deleted", payload)
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		require.Equal(t, 1, receiver.count())
+		assert.Equal(t, "video.deleted", receiver.payloads[0]["event"])
+	})
+
+	t.Run("Invalid template is rejected", func(t *testing.T) {
+		w := post("/api/webhooks", `{"event": "video.uploaded", "url": "https://example.com/hook", "payload_template": "{{.video.name"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid payload template")
+	})
+
+	t.Run("Output is limited", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		tmpl, err := parseWebhookTemplate(`{{printf "%70000s" .filename}}`)
+		require.NoError(t, err)
+		require.NoError(t, server.webhookMgr.AddWebhookRecord("video.expired", WebhookRecord{
+			URL:             receiver.server.URL,
+			PayloadTemplate: `{{printf "%70000s" .filename}}`,
+			payloadTemplate: tmpl,
+		}))
+
+		payload, err := videoWebhookPayload("video.expired", &Video{ID: "b", Name: "b.mp4"})
+		require.NoError(t, err)
+		server.webhookMgr.NotifyWebhooks("video.expired", payload)
+		require.NoError(t, server.webhookMgr.Wait(context.Background()))
+
+		assert.Equal(t, 0, receiver.count())
+		log := server.webhookMgr.GetDeliveryLog()
+		require.NotEmpty(t, log)
+		last := log[len(log)-1]
+		require.NotNil(t, last.Result)
+		assert.Contains(t, last.Result.Error, "exceeds")
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// validateWebhookURL checks that a webhook target is safe to call from the
+// server, so subscribers cannot point deliveries at internal services. Every
+// address the host resolves to must be public.
+func (s *Server) validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if s.config.WebhookRequireHTTPS {
+			return fmt.Errorf("webhook URL must use https")
+		}
+	default:
+		return fmt.Errorf("webhook URL scheme must be http or https, got %q", u.Scheme)
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("webhook URL must not contain credentials")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("webhook URL has no host")
+	}
+
+	if len(s.config.WebhookAllowedPorts) > 0 {
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		}
+		if !portAllowed(port, s.config.WebhookAllowedPorts) {
+			return fmt.Errorf("webhook URL port %s is not allowed", port)
+		}
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = s.lookupHost(host)
+		if err != nil || len(ips) == 0 {
+			return fmt.Errorf("webhook URL host %s could not be resolved", host)
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("webhook URL host %s resolves to a private address", host)
+		}
+	}
+
+	return nil
+}
+
+// publicDialer refuses connections to private addresses. The check runs on
+// the address actually dialed, so a host that passed validateWebhookURL
+// can't be pointed at an internal service by resolving differently later.
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("refusing to connect to private address %s", host)
+			}
+			return nil
+		},
+	}
+}
+
+// portAllowed reports whether port is one of allowed
+func portAllowed(port string, allowed []int) bool {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, p := range allowed {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateIP reports whether ip is a loopback, private (RFC 1918 / RFC 4193),
+// link-local or unspecified address
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
@@ -2,103 +2,551 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
+// ErrWebhookLimitReached is returned by AddWebhook when a subscription limit is hit
+var ErrWebhookLimitReached = errors.New("webhook limit reached")
+
+// maxDeliveryLogEntries bounds the in-memory delivery log
+const maxDeliveryLogEntries = 1000
+
+// maxWebhookErrorBody bounds how much of a failed delivery's response body
+// is kept
+const maxWebhookErrorBody = 512
+
+// WebhookDelivery records the outcome of a single webhook delivery attempt
+type WebhookDelivery struct {
+	Event        string    `json:"event"`
+	URL          string    `json:"url"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	IsRedelivery bool      `json:"is_redelivery"`
+	DeliveredAt  time.Time `json:"delivered_at"`
+
+	// Result details a failed delivery, nil when it succeeded
+	Result *WebhookDeliveryResult `json:"result,omitempty"`
+}
+
+// WebhookDeliveryResult holds what a subscriber needs to debug a failed
+// delivery
+type WebhookDeliveryResult struct {
+	StatusCode      int                 `json:"status_code,omitempty"`
+	ResponseBody    string              `json:"response_body,omitempty"` // the first maxWebhookErrorBody bytes
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	LatencyMs       int64               `json:"latency_ms"`
+	Attempt         int                 `json:"attempt"`
+	Error           string              `json:"error,omitempty"`
+}
+
+// redactedWebhookHeaders are not kept from a subscriber's response
+var redactedWebhookHeaders = []string{"Set-Cookie", "Authorization"}
+
+// newWebhookDeliveryResult describes a failed delivery started at start.
+// resp is nil when no response was received. The caller must not have read
+// resp.Body yet.
+func newWebhookDeliveryResult(start time.Time, resp *http.Response, err error) *WebhookDeliveryResult {
+	result := &WebhookDeliveryResult{
+		LatencyMs: time.Since(start).Milliseconds(),
+		Attempt:   1, // deliveries are not retried
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if resp != nil {
+		result.StatusCode = resp.StatusCode
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBody))
+		result.ResponseBody = string(body)
+
+		headers := resp.Header.Clone()
+		for _, name := range redactedWebhookHeaders {
+			if values := headers.Values(name); len(values) > 0 {
+				headers[http.CanonicalHeaderKey(name)] = []string{"[REDACTED]"}
+			}
+		}
+		result.ResponseHeaders = headers
+	}
+	return result
+}
+
+// WebhookRecord is a registered webhook URL and its delivery options
+type WebhookRecord struct {
+	URL      string
+	Compress bool // gzip the payload, requested with "accept_encoding": "gzip"
+
+	// CollectionID limits the webhook to videos in one collection when set
+	CollectionID string
+
+	// Filter skips payloads it does not allow, nil delivers every payload
+	Filter *WebhookFilter
+
+	// PayloadTemplate is a text/template rendered with the payload and sent
+	// in its place, empty sends the JSON payload. payloadTemplate is its
+	// compiled form, see parseWebhookTemplate.
+	PayloadTemplate string
+	payloadTemplate *template.Template
+
+	// MetadataSchema is a JSON Schema the custom metadata of the videos the
+	// webhook receives events for must match, empty for none.
+	// metadataSchema is its compiled form, see parseMetadataSchema.
+	MetadataSchema json.RawMessage
+	metadataSchema *MetadataSchema
+}
+
+// collectionScopedPayload is implemented by payloads about a single video,
+// so NotifyWebhooks can also reach the webhooks of the video's collection
+type collectionScopedPayload interface {
+	webhookCollectionID() string
+}
+
 // WebhookManager manages webhook subscriptions and notifications
 type WebhookManager struct {
-	webhooks map[string][]string // event -> urls mapping
+	webhooks map[string][]WebhookRecord // event -> registered webhooks
 	mutex    sync.RWMutex
+	config   *Config
+
+	// collectionWebhooks holds webhooks registered for a single collection
+	collectionWebhooks map[string]map[string][]WebhookRecord // event -> collection -> webhooks
+
+	// inFlight tracks deliveries that have been started but not finished
+	inFlight sync.WaitGroup
+
+	// deliveryLog holds the most recent deliveries, oldest first
+	deliveryLog   []WebhookDelivery
+	deliveryMutex sync.Mutex
+
+	// limiters rate limit deliveries per URL when WebhookMaxRatePerURL is set
+	limiters     map[string]*webhookLimiter
+	limiterMutex sync.Mutex
+
+	// queuedEvents counts the queued deliveries of each event, guarded by
+	// limiterMutex. queueStats is the latest sample of the queues.
+	queuedEvents    map[string]int
+	queueStats      WebhookQueueStats
+	queueStatsMutex sync.Mutex
+
+	// broadcaster announces added and removed webhooks to
+	// GET /api/webhooks/stream
+	broadcaster *webhookBroadcaster
 }
 
 // NewWebhookManager creates a new webhook manager
-func NewWebhookManager() *WebhookManager {
+func NewWebhookManager(config *Config) *WebhookManager {
 	return &WebhookManager{
-		webhooks: make(map[string][]string),
+		webhooks:           make(map[string][]WebhookRecord),
+		collectionWebhooks: make(map[string]map[string][]WebhookRecord),
+		config:             config,
+		limiters:           make(map[string]*webhookLimiter),
+		queuedEvents:       make(map[string]int),
+		broadcaster:        newWebhookBroadcaster(),
 	}
 }
 
-// AddWebhook adds a webhook URL for a specific event
-func (wm *WebhookManager) AddWebhook(event, url string) {
+// AddWebhook adds a webhook URL for a specific event. It returns an error
+// wrapping ErrWebhookLimitReached if the per-event or total limit is reached.
+func (wm *WebhookManager) AddWebhook(event, url string) error {
+	return wm.AddWebhookRecord(event, WebhookRecord{URL: url})
+}
+
+// AddWebhookRecord adds a webhook with delivery options for a specific
+// event, scoped to record.CollectionID when set. Registering a URL again
+// updates its options.
+func (wm *WebhookManager) AddWebhookRecord(event string, record WebhookRecord) error {
 	wm.mutex.Lock()
 	defer wm.mutex.Unlock()
+
+	added, err := wm.addWebhookRecordLocked(event, record)
+	if added {
+		wm.broadcaster.Broadcast(WebhookChangeEvent{Event: "added", EventType: event, URL: record.URL, CollectionID: record.CollectionID})
+	}
+	return err
+}
+
+// addWebhookRecordLocked adds or updates a webhook, reporting whether it was
+// new. The caller must hold the lock and announce the change.
+func (wm *WebhookManager) addWebhookRecordLocked(event string, record WebhookRecord) (bool, error) {
+	records := wm.webhooks[event]
+	if record.CollectionID != "" {
+		records = wm.collectionWebhooks[event][record.CollectionID]
+	}
 	
 	// Check if URL already exists for this event
-	for _, existingURL := range wm.webhooks[event] {
-		if existingURL == url {
-			return // URL already exists, don't add duplicate
+	for i, existing := range records {
+		if existing.URL == record.URL {
+			records[i] = record // don't add a duplicate
+			return false, nil
 		}
 	}
-	
-	wm.webhooks[event] = append(wm.webhooks[event], url)
+
+	if limit := wm.config.MaxWebhooksPerEvent; limit > 0 && len(records) >= limit {
+		return false, fmt.Errorf("%w: event %s already has the maximum of %d webhooks", ErrWebhookLimitReached, event, limit)
+	}
+
+	if limit := wm.config.MaxTotalWebhooks; limit > 0 {
+		total := 0
+		for _, records := range wm.webhooks {
+			total += len(records)
+		}
+		for _, collections := range wm.collectionWebhooks {
+			for _, records := range collections {
+				total += len(records)
+			}
+		}
+		if total >= limit {
+			return false, fmt.Errorf("%w: the server already has the maximum of %d webhooks", ErrWebhookLimitReached, limit)
+		}
+	}
+
+	if limit := wm.config.MaxEventsPerURL; limit > 0 {
+		events := wm.urlEventsLocked(record.URL)
+		if !events[event] && len(events) >= limit {
+			return false, fmt.Errorf("%w: %s is already registered for the maximum of %d events", ErrWebhookLimitReached, record.URL, limit)
+		}
+	}
+
+	if record.CollectionID == "" {
+		wm.webhooks[event] = append(records, record)
+		return true, nil
+	}
+	if wm.collectionWebhooks[event] == nil {
+		wm.collectionWebhooks[event] = make(map[string][]WebhookRecord)
+	}
+	wm.collectionWebhooks[event][record.CollectionID] = append(records, record)
+	return true, nil
 }
 
-// RemoveWebhook removes a webhook URL for a specific event
+// RemoveWebhook removes a webhook URL for a specific event. It does not
+// touch collection webhooks, see RemoveCollectionWebhook.
 func (wm *WebhookManager) RemoveWebhook(event, url string) {
 	wm.mutex.Lock()
 	defer wm.mutex.Unlock()
 	
-	urls := wm.webhooks[event]
-	newUrls := make([]string, 0, len(urls))
+	records := wm.webhooks[event]
+	newRecords := make([]WebhookRecord, 0, len(records))
 	
-	for _, existingURL := range urls {
-		if existingURL != url {
-			newUrls = append(newUrls, existingURL)
+	for _, existing := range records {
+		if existing.URL != url {
+			newRecords = append(newRecords, existing)
 		}
 	}
 	
-	wm.webhooks[event] = newUrls
+	wm.webhooks[event] = newRecords
+	if len(newRecords) < len(records) {
+		wm.broadcaster.Broadcast(WebhookChangeEvent{Event: "removed", EventType: event, URL: url})
+	}
+}
+
+// RemoveCollectionWebhook removes a webhook URL registered for a collection
+func (wm *WebhookManager) RemoveCollectionWebhook(event, collectionID, url string) {
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
+
+	collections := wm.collectionWebhooks[event]
+	newRecords := make([]WebhookRecord, 0, len(collections[collectionID]))
+	for _, existing := range collections[collectionID] {
+		if existing.URL != url {
+			newRecords = append(newRecords, existing)
+		}
+	}
+	if len(newRecords) < len(collections[collectionID]) {
+		wm.broadcaster.Broadcast(WebhookChangeEvent{Event: "removed", EventType: event, URL: url, CollectionID: collectionID})
+	}
+
+	if len(newRecords) > 0 {
+		collections[collectionID] = newRecords
+		return
+	}
+	delete(collections, collectionID)
+	if len(collections) == 0 {
+		delete(wm.collectionWebhooks, event)
+	}
+}
+
+// NotifyWebhooks sends notification to all registered webhooks for an event.
+// Payloads about a video in a collection also go to that collection's
+// webhooks.
+func (wm *WebhookManager) NotifyWebhooks(event EventType, payload interface{}) {
+	wm.NotifyWebhooksContext(context.Background(), event, payload)
+}
+
+// NotifyWebhooksContext is NotifyWebhooks for an event caused by a request.
+// The trace context in ctx is sent along with each delivery, so the
+// subscriber's spans link to the request's; cancelling ctx doesn't stop
+// the deliveries.
+func (wm *WebhookManager) NotifyWebhooksContext(ctx context.Context, event EventType, payload interface{}) {
+	records := wm.getWebhookRecords(string(event))
+	if scoped, ok := payload.(collectionScopedPayload); ok {
+		if collectionID := scoped.webhookCollectionID(); collectionID != "" {
+			records = appendMissingWebhooks(records, wm.getCollectionWebhookRecords(string(event), collectionID))
+		}
+	}
+	wm.deliver(ctx, string(event), records, payload, false)
 }
 
-// NotifyWebhooks sends notification to all registered webhooks for an event
-func (wm *WebhookManager) NotifyWebhooks(event string, payload interface{}) {
+// getCollectionWebhookRecords returns a copy of the webhooks registered for
+// an event in one collection
+func (wm *WebhookManager) getCollectionWebhookRecords(event, collectionID string) []WebhookRecord {
 	wm.mutex.RLock()
-	urls := wm.webhooks[event]
-	wm.mutex.RUnlock()
-	
-	payloadBytes, err := json.Marshal(payload)
+	defer wm.mutex.RUnlock()
+
+	records := make([]WebhookRecord, len(wm.collectionWebhooks[event][collectionID]))
+	copy(records, wm.collectionWebhooks[event][collectionID])
+	return records
+}
+
+// appendMissingWebhooks appends the records whose URL is not already in
+// records, so a URL registered twice is only notified once
+func appendMissingWebhooks(records, extra []WebhookRecord) []WebhookRecord {
+	for _, candidate := range extra {
+		duplicate := false
+		for _, existing := range records {
+			if existing.URL == candidate.URL {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			records = append(records, candidate)
+		}
+	}
+	return records
+}
+
+// getWebhookRecords returns a copy of the webhooks registered for an event
+func (wm *WebhookManager) getWebhookRecords(event string) []WebhookRecord {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	records := make([]WebhookRecord, len(wm.webhooks[event]))
+	copy(records, wm.webhooks[event])
+	return records
+}
+
+// RedeliverWebhook re-sends an event payload. If url is empty it goes to every
+// URL registered for the event, otherwise only to url, which must be registered.
+// It returns the URLs the payload was sent to.
+func (wm *WebhookManager) RedeliverWebhook(event, url string, payload interface{}) ([]string, error) {
+	records := wm.getWebhookRecords(event)
+
+	if url != "" {
+		var selected []WebhookRecord
+		for _, existing := range records {
+			if existing.URL == url {
+				selected = append(selected, existing)
+				break
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("url is not registered for event %s", event)
+		}
+		records = selected
+	}
+
+	wm.deliver(context.Background(), event, records, payload, true)
+	return webhookURLs(records), nil
+}
+
+// deliver marshals the payload once and posts it to each webhook concurrently
+func (wm *WebhookManager) deliver(ctx context.Context, event string, records []WebhookRecord, payload interface{}, isRedelivery bool) {
+	payloadBytes, err := encodeWebhookPayload(wm.config.WebhookSchemaVersion, event, payload)
 	if err != nil {
 		log.Error().Err(err).Str("event", event).Msg("failed to marshal webhook payload")
 		return
 	}
 	
+	// Deliveries outlive the request that triggered them
+	ctx = context.WithoutCancel(ctx)
+
 	// Send notifications concurrently
-	for _, url := range urls {
-		go wm.sendWebhookNotification(url, payloadBytes)
+	for _, record := range records {
+		if record.Filter != nil && !record.Filter.AllowsJSON(payloadBytes) {
+			log.Debug().Str("event", event).Str("url", record.URL).Msg("webhook filtered out")
+			continue
+		}
+
+		dispatch := webhookDispatch{ctx: ctx, record: record, event: event, payload: payloadBytes, isRedelivery: isRedelivery}
+		if wm.config.WebhookMaxRatePerURL > 0 {
+			wm.enqueueRateLimited(dispatch)
+			continue
+		}
+
+		wm.inFlight.Add(1)
+		go func() {
+			defer wm.inFlight.Done()
+			wm.send(dispatch)
+		}()
 	}
 }
 
-// sendWebhookNotification sends a single webhook notification
-func (wm *WebhookManager) sendWebhookNotification(url string, payload []byte) {
+// send delivers a webhook and records the outcome
+func (wm *WebhookManager) send(dispatch webhookDispatch) {
+	delivery := wm.sendWebhookNotification(dispatch.ctx, dispatch.record, dispatch.payload)
+	delivery.Event = dispatch.event
+	delivery.IsRedelivery = dispatch.isRedelivery
+	wm.recordDelivery(delivery)
+}
+
+// Wait blocks until all in-flight webhook deliveries have completed or the
+// context is done
+func (wm *WebhookManager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wm.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendWebhookNotification sends a single webhook notification, gzipping the
+// payload for webhooks registered with compression. The trace context in
+// ctx is injected as traceparent and tracestate headers.
+func (wm *WebhookManager) sendWebhookNotification(ctx context.Context, record WebhookRecord, payload []byte) WebhookDelivery {
+	url := record.URL
+	start := time.Now()
+	delivery := WebhookDelivery{URL: url, DeliveredAt: start}
 	client := &http.Client{}
+
+	body := payload
+	if record.payloadTemplate != nil {
+		rendered, err := renderWebhookTemplate(record.payloadTemplate, payload)
+		if err != nil {
+			log.Error().Err(err).Str("url", url).Msg("failed to render webhook payload template")
+			delivery.Error = err.Error()
+			delivery.Result = newWebhookDeliveryResult(start, nil, err)
+			return delivery
+		}
+		body = rendered
+	}
+	if record.Compress {
+		compressed, err := gzipBytes(body)
+		if err != nil {
+			log.Error().Err(err).Str("url", url).Msg("failed to compress webhook payload")
+			delivery.Error = err.Error()
+			delivery.Result = newWebhookDeliveryResult(start, nil, err)
+			return delivery
+		}
+		body = compressed
+	}
 	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		log.Error().Err(err).Str("url", url).Msg("failed to create webhook request")
-		return
+		delivery.Error = err.Error()
+		delivery.Result = newWebhookDeliveryResult(start, nil, err)
+		return delivery
 	}
 	
 	req.Header.Set("Content-Type", "application/json")
+	if record.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Error().Err(err).Str("url", url).Msg("failed to send webhook notification")
-		return
+		delivery.Error = err.Error()
+		delivery.Result = newWebhookDeliveryResult(start, nil, err)
+		return delivery
 	}
 	defer resp.Body.Close()
 	
+	delivery.StatusCode = resp.StatusCode
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		delivery.Result = newWebhookDeliveryResult(start, resp, nil)
 		log.Warn().
 			Str("url", url).
 			Int("status", resp.StatusCode).
+			Str("response_body", delivery.Result.ResponseBody).
+			Int64("latency_ms", delivery.Result.LatencyMs).
 			Msg("webhook notification returned non-success status")
 	} else {
 		log.Info().Str("url", url).Msg("webhook notification sent successfully")
 	}
+
+	return delivery
+}
+
+// gzipBytes returns data compressed with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recordDelivery appends to the delivery log, discarding the oldest entries
+func (wm *WebhookManager) recordDelivery(delivery WebhookDelivery) {
+	wm.deliveryMutex.Lock()
+	defer wm.deliveryMutex.Unlock()
+
+	wm.deliveryLog = append(wm.deliveryLog, delivery)
+	if len(wm.deliveryLog) > maxDeliveryLogEntries {
+		wm.deliveryLog = wm.deliveryLog[len(wm.deliveryLog)-maxDeliveryLogEntries:]
+	}
+
+	if delivery.IsRedelivery {
+		log.Info().
+			Str("event", delivery.Event).
+			Str("url", delivery.URL).
+			Bool("is_redelivery", true).
+			Msg("webhook redelivered")
+	}
+}
+
+// GetDeliveryLog returns a copy of the recent delivery log, oldest first
+func (wm *WebhookManager) GetDeliveryLog() []WebhookDelivery {
+	wm.deliveryMutex.Lock()
+	defer wm.deliveryMutex.Unlock()
+
+	deliveries := make([]WebhookDelivery, len(wm.deliveryLog))
+	copy(deliveries, wm.deliveryLog)
+	return deliveries
+}
+
+// LastErrors returns the most recent failed delivery to each URL in the
+// delivery log
+func (wm *WebhookManager) LastErrors() map[string]WebhookDelivery {
+	wm.deliveryMutex.Lock()
+	defer wm.deliveryMutex.Unlock()
+
+	lastErrors := make(map[string]WebhookDelivery)
+	for _, delivery := range wm.deliveryLog {
+		if delivery.Result != nil {
+			lastErrors[delivery.URL] = delivery
+		}
+	}
+	return lastErrors
 }
 
 // GetWebhooks returns all registered webhooks for an event
@@ -106,9 +554,15 @@ func (wm *WebhookManager) GetWebhooks(event string) []string {
 	wm.mutex.RLock()
 	defer wm.mutex.RUnlock()
 	
-	urls := make([]string, len(wm.webhooks[event]))
-	copy(urls, wm.webhooks[event])
-	
+	return webhookURLs(wm.webhooks[event])
+}
+
+// webhookURLs returns the URLs of records
+func webhookURLs(records []WebhookRecord) []string {
+	urls := make([]string, 0, len(records))
+	for _, record := range records {
+		urls = append(urls, record.URL)
+	}
 	return urls
 }
 
@@ -118,11 +572,129 @@ func (wm *WebhookManager) GetAllWebhooks() map[string][]string {
 	defer wm.mutex.RUnlock()
 	
 	allWebhooks := make(map[string][]string)
-	for event, urls := range wm.webhooks {
-		eventUrls := make([]string, len(urls))
-		copy(eventUrls, urls)
-		allWebhooks[event] = eventUrls
+	for event, records := range wm.webhooks {
+		allWebhooks[event] = webhookURLs(records)
 	}
 	
 	return allWebhooks
-}
\ No newline at end of file
+}
+
+// WebhookURLSummary lists the events a webhook URL is registered for
+type WebhookURLSummary struct {
+	URL             string   `json:"url"`
+	Events          []string `json:"events"`
+	RegisteredCount int      `json:"registered_count"` // registrations, counting each collection separately
+}
+
+// urlEventsLocked returns the events url is registered for, including
+// collection webhooks. The caller must hold the lock.
+func (wm *WebhookManager) urlEventsLocked(url string) map[string]bool {
+	events := make(map[string]bool)
+	for event, records := range wm.webhooks {
+		for _, record := range records {
+			if record.URL == url {
+				events[event] = true
+			}
+		}
+	}
+	for event, collections := range wm.collectionWebhooks {
+		for _, records := range collections {
+			for _, record := range records {
+				if record.URL == url {
+					events[event] = true
+				}
+			}
+		}
+	}
+	return events
+}
+
+// GetWebhookURLs returns every registered webhook URL once, with the events
+// it is registered for, sorted by URL
+func (wm *WebhookManager) GetWebhookURLs() []WebhookURLSummary {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	summaries := make(map[string]*WebhookURLSummary)
+	seen := make(map[[2]string]bool) // (URL, event) pairs already listed
+	add := func(event string, records []WebhookRecord) {
+		for _, record := range records {
+			summary, exists := summaries[record.URL]
+			if !exists {
+				summary = &WebhookURLSummary{URL: record.URL}
+				summaries[record.URL] = summary
+			}
+			if key := [2]string{record.URL, event}; !seen[key] {
+				seen[key] = true
+				summary.Events = append(summary.Events, event)
+			}
+			summary.RegisteredCount++
+		}
+	}
+	for event, records := range wm.webhooks {
+		add(event, records)
+	}
+	for event, collections := range wm.collectionWebhooks {
+		for _, records := range collections {
+			add(event, records)
+		}
+	}
+
+	urls := make([]WebhookURLSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		sort.Strings(summary.Events)
+		urls = append(urls, *summary)
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].URL < urls[j].URL
+	})
+	return urls
+}
+
+// GetCollectionWebhooks returns the webhooks registered for each collection
+// for an event
+func (wm *WebhookManager) GetCollectionWebhooks(event string) map[string][]string {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	collectionWebhooks := make(map[string][]string)
+	for collectionID, records := range wm.collectionWebhooks[event] {
+		collectionWebhooks[collectionID] = webhookURLs(records)
+	}
+	return collectionWebhooks
+}
+
+// GetAllCollectionWebhooks returns all webhooks registered for a collection,
+// by event and then collection
+func (wm *WebhookManager) GetAllCollectionWebhooks() map[string]map[string][]string {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+
+	allWebhooks := make(map[string]map[string][]string)
+	for event, collections := range wm.collectionWebhooks {
+		allWebhooks[event] = make(map[string][]string)
+		for collectionID, records := range collections {
+			allWebhooks[event][collectionID] = webhookURLs(records)
+		}
+	}
+	return allWebhooks
+}
+
+// webhookSignatureHeader carries the HMAC of a webhook body as "sha256=<hex>"
+const webhookSignatureHeader = "X-VidServer-Signature"
+
+// computeWebhookSignature returns the signature header value for a payload
+func computeWebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validWebhookSignature checks a signature header value in constant time
+func validWebhookSignature(secret string, payload []byte, signature string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected := computeWebhookSignature(secret, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}